@@ -1,62 +1,256 @@
 package main
 
 import (
+	"flag"
+	"fmt"
+	"image"
 	"log"
+	"os"
 	"runtime"
 
 	"github.com/go-gl/glfw/v3.3/glfw"
-)
 
-const (
-	width  = 500
-	height = 500
+	"github.com/nicky-ayoub/imagination/internal/pkg/config"
+	"github.com/nicky-ayoub/imagination/internal/pkg/fit"
+	"github.com/nicky-ayoub/imagination/internal/pkg/glview"
+	"github.com/nicky-ayoub/imagination/internal/pkg/imagefs"
+	"github.com/nicky-ayoub/imagination/internal/pkg/imageio"
+	"github.com/nicky-ayoub/imagination/internal/pkg/imageops"
+	"github.com/nicky-ayoub/imagination/internal/pkg/keymap"
+	"github.com/nicky-ayoub/imagination/internal/pkg/keymap/glfwkeys"
+	"github.com/nicky-ayoub/imagination/internal/pkg/playlist"
+	"github.com/nicky-ayoub/imagination/internal/pkg/viewer"
 )
 
+/** How long the event loop waits for an event before checking the pending key chords, in seconds. */
+const EVENT_TIMEOUT = 0.1
+
 func init() {
 	// This is needed to arrange that main() runs on main thread.
 	// See documentation for functions that are only allowed to be called from the main thread.
 	runtime.LockOSThread()
 }
 
-func keyCallback(window *glfw.Window, key glfw.Key, scancode int, action glfw.Action, mods glfw.ModifierKey) {
-	// When a user presses the escape key, we set the WindowShouldClose property to true,
-	// which closes the application
-	if key == glfw.KeyEscape && action == glfw.Press {
-		window.SetShouldClose(true)
+// renderer displays the images of the viewer in a GLFW window.
+type renderer struct {
+	window *glfw.Window
+	gl     *glview.Renderer
+	view   viewer.View
+	pan    image.Point // Offset of the zoomed image, in framebuffer pixels
+	dirty  bool        // The window must be drawn again
+}
+
+func (r *renderer) Load(index int, path string, item playlist.Item) error {
+	img, _, err := imageio.Load(path)
+	if err != nil {
+		return err
+	}
+	if item.Crop != nil {
+		c := image.Rect(item.Crop.X, item.Crop.Y, item.Crop.X+item.Crop.W, item.Crop.Y+item.Crop.H).Intersect(img.Bounds())
+		if !c.Empty() {
+			img = imageops.Crop(img, c)
+		}
+	}
+	r.gl.SetImage(img)
+	r.pan, r.dirty = image.Point{}, true
+
+	title := path
+	if item.Caption != "" {
+		title = item.Caption
+	}
+	r.window.SetTitle(fmt.Sprintf("GLFW Image Viewer - %s", title))
+	return nil
+}
+
+func (r *renderer) SetView(v viewer.View) {
+	// The pan offset follows the zoom, the whole image being shown again without zoom
+	if v.Zoom > 1 && r.view.Zoom > 1 {
+		r.pan = r.pan.Mul(v.Zoom).Div(r.view.Zoom)
+	} else {
+		r.pan = image.Point{}
 	}
+	r.view, r.dirty = v, true
+}
+
+func (r *renderer) Center() image.Point {
+	w, h := r.window.GetSize()
+	return image.Pt(w/2, h/2)
+}
+
+// scale returns the number of framebuffer pixels per window coordinate, above 1 on HiDPI screens.
+func (r *renderer) scale() float64 {
+	w, _ := r.window.GetSize()
+	fw, _ := r.window.GetFramebufferSize()
+	if w <= 0 {
+		return 1
+	}
+	return float64(fw) / float64(w)
+}
+
+// drag moves the zoomed image by a window distance.
+func (r *renderer) drag(dx float64, dy float64) {
+	if r.view.Zoom > 1 {
+		s := r.scale()
+		r.pan = r.pan.Add(image.Pt(int(dx*s), int(dy*s)))
+		r.dirty = true
+	}
+}
+
+// draw draws the image in the framebuffer and shows it.
+func (r *renderer) draw() {
+	fw, fh := r.window.GetFramebufferSize()
+	view := r.view
+	s := r.scale()
+	view.Focus = image.Pt(int(float64(view.Focus.X)*s), int(float64(view.Focus.Y)*s))
+	r.gl.Draw(image.Pt(fw, fh), view, r.pan)
+	r.window.SwapBuffers()
+	r.dirty = false
 }
 
 func main() {
-	err := glfw.Init()
+	var playlistPath, keymapPath, background string
+	var software bool
+	var list *playlist.Playlist
+	var err error
+
+	cfg := config.MustLoad()
+	config.AddFlag()
+	flag.IntVar(&cfg.Window.Width, "width", cfg.Window.Width, "Window width in pixels")
+	flag.IntVar(&cfg.Window.Height, "height", cfg.Window.Height, "Window height in pixels")
+	flag.BoolVar(&cfg.Window.Fullscreen, "fullscreen", cfg.Window.Fullscreen, "Cover the primary monitor")
+	flag.StringVar(&playlistPath, "playlist", "", "Show a playlist (.m3u, .txt, .json or .yaml) instead of the directory images")
+	flag.StringVar(&keymapPath, "keymap", cfg.Keymap, "Key bindings file")
+	flag.StringVar(&background, "background", cfg.Background, "Color around the image")
+	flag.BoolVar(&software, "software", false, "Render with the Mesa software rasterizer")
+	flag.Parse()
+
+	color, err := fit.ParseColor(background)
 	if err != nil {
-		panic(err)
+		log.Fatal(err)
+	}
+	if playlistPath != "" {
+		if list, err = playlist.Load(playlistPath); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		root := cfg.Assets
+		if len(flag.Args()) > 0 {
+			root = flag.Args()[0]
+		}
+		list = playlist.FromPaths(root, imagefs.AllFilesByExt(root, cfg.Extensions))
+	}
+	keys, err := keymap.Load(keymapPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if software {
+		// Read by Mesa when the context is created
+		os.Setenv("LIBGL_ALWAYS_SOFTWARE", "1")
 	}
-	defer glfw.Terminate()
 
-	window := initGlfw("Testing")
+	err = glfw.Init()
 	if err != nil {
 		panic(err)
 	}
+	defer glfw.Terminate()
 
-	window.MakeContextCurrent()
+	window := initGlfw("GLFW Image Viewer", cfg.Window.Width, cfg.Window.Height)
+	r := &renderer{window: window}
+	if r.gl, err = glview.New(); err != nil {
+		log.Fatal(err)
+	}
+	defer r.gl.Delete()
+	r.gl.SetBackground(color)
+
+	v := viewer.New(list, r)
+	if v.Len() == 0 {
+		log.Fatal("No image to display")
+	}
+	actions := v.Actions()
+	actions.Register(keymap.ACTION_QUIT, "", func(keymap.Trigger) { window.SetShouldClose(true) })
+	windowX, windowY := 0, 0 // Window position before going fullscreen
+	actions.Register(keymap.ACTION_FULLSCREEN, "", func(keymap.Trigger) {
+		if window.GetMonitor() != nil {
+			window.SetMonitor(nil, windowX, windowY, cfg.Window.Width, cfg.Window.Height, 0)
+			return
+		}
+		windowX, windowY = window.GetPos()
+		cfg.Window.Width, cfg.Window.Height = window.GetSize()
+		monitor := glfw.GetPrimaryMonitor()
+		mode := monitor.GetVideoMode()
+		window.SetMonitor(monitor, 0, 0, mode.Width, mode.Height, mode.RefreshRate)
+	})
+	if cfg.Window.Fullscreen {
+		actions.Run(keymap.ACTION_FULLSCREEN, keymap.Trigger{})
+	}
+
+	// Run the action bound to a key or mouse button
+	feed := func(stroke keymap.Stroke, ok bool, mouse bool) {
+		if !ok {
+			return
+		}
+		x, y := window.GetCursorPos()
+		if action, found := keys.Feed(stroke); found {
+			actions.Run(action, keymap.Trigger{Stroke: stroke, Mouse: mouse, At: image.Pt(int(x), int(y))})
+		}
+	}
+	window.SetKeyCallback(func(_ *glfw.Window, key glfw.Key, scancode int, action glfw.Action, mods glfw.ModifierKey) {
+		stroke, ok := glfwkeys.Key(key, scancode, action, mods)
+		feed(stroke, ok, false)
+	})
+	window.SetMouseButtonCallback(func(_ *glfw.Window, button glfw.MouseButton, action glfw.Action, mods glfw.ModifierKey) {
+		stroke, ok := glfwkeys.Button(button, action, mods)
+		feed(stroke, ok, true)
+	})
+	window.SetScrollCallback(func(w *glfw.Window, _ float64, yoff float64) {
+		stroke, ok := glfwkeys.Wheel(w, yoff)
+		feed(stroke, ok, true)
+	})
+	// Dragging with the left button pans the zoomed image
+	cursorX, cursorY := window.GetCursorPos()
+	window.SetCursorPosCallback(func(w *glfw.Window, x float64, y float64) {
+		if w.GetMouseButton(glfw.MouseButtonLeft) == glfw.Press {
+			r.drag(x-cursorX, y-cursorY)
+		}
+		cursorX, cursorY = x, y
+	})
+	window.SetFramebufferSizeCallback(func(*glfw.Window, int, int) {
+		// Zoom has been reset when resizing the window
+		v.ResetZoom()
+		// Draw while the window is being resized, the event loop being blocked on some systems
+		r.draw()
+	})
+	window.SetRefreshCallback(func(*glfw.Window) { r.draw() })
+
+	if err = v.Show(0); err != nil {
+		log.Fatal(err)
+	}
 
 	for !window.ShouldClose() {
-		// Do OpenGL stuff.
-		window.SwapBuffers()
-		glfw.PollEvents()
+		if r.dirty {
+			r.draw()
+		}
+		glfw.WaitEventsTimeout(EVENT_TIMEOUT)
+		// A key waiting for the end of a chord that never came runs its own action
+		if action, ok := keys.Expire(); ok {
+			actions.Run(action, keymap.Trigger{})
+		}
 	}
 }
 
 // initGlfw initializes glfw and returns a Window to use.
-func initGlfw(title string) *glfw.Window {
+func initGlfw(title string, width int, height int) *glfw.Window {
 	if err := glfw.Init(); err != nil {
 		panic(err)
 	}
-	glfw.WindowHint(glfw.Resizable, glfw.False)
-	glfw.WindowHint(glfw.ContextVersionMajor, 4)
-	glfw.WindowHint(glfw.ContextVersionMinor, 1)
+	glfw.WindowHint(glfw.Resizable, glfw.True)
+	glfw.WindowHint(glfw.ContextVersionMajor, 3)
+	glfw.WindowHint(glfw.ContextVersionMinor, 3)
 	glfw.WindowHint(glfw.OpenGLProfile, glfw.OpenGLCoreProfile)
 	glfw.WindowHint(glfw.OpenGLForwardCompatible, glfw.True)
+	// Size the window in screen coordinates of the monitor scale, the framebuffer gets every HiDPI pixel
+	glfw.WindowHint(glfw.ScaleToMonitor, glfw.True)
+	glfw.WindowHint(glfw.CocoaRetinaFramebuffer, glfw.True)
 
 	window, err := glfw.CreateWindow(width, height, title, nil, nil)
 	if err != nil {
@@ -64,6 +258,7 @@ func initGlfw(title string) *glfw.Window {
 		panic(err)
 	}
 	window.MakeContextCurrent()
+	glfw.SwapInterval(1)
 
 	return window
 }
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/nicky-ayoub/imagination/internal/pkg/exif"
+	"github.com/nicky-ayoub/imagination/internal/pkg/fit"
+	"github.com/nicky-ayoub/imagination/internal/pkg/imagefs"
+	"github.com/nicky-ayoub/imagination/internal/pkg/imageio"
+	"github.com/nicky-ayoub/imagination/internal/pkg/imageops"
+	"github.com/nicky-ayoub/imagination/internal/pkg/resize"
+	"github.com/nicky-ayoub/imagination/internal/pkg/watermark"
+)
+
+// conversion holds the settings shared by every converted file.
+type conversion struct {
+	size       resize.Spec
+	filter     resize.Filter
+	enlarge    bool
+	format     string // Output format, empty to keep the source one
+	quality    int
+	metadata   bool // Copy the EXIF metadata of JPEG files to JPEG outputs
+	autoOrient bool // Apply the EXIF orientation to the pixels
+	overwrite  bool
+	background color.RGBA       // Color transparent images are flattened onto for JPEG outputs
+	stamp      *watermark.Stamp // Watermark and caption bars, nil for none
+}
+
+// conversionJob is a file to convert and the file to write.
+type conversionJob struct {
+	source string
+	target string
+}
+
+// convertMain writes resized copies of images, in any format imageio can write, mirroring the source directories.
+func convertMain(args []string) int {
+	c, err := newCLI("convert", "-out directory path...",
+		"Writes resized copies of the images, keeping the directory tree of the sources, optionally with a watermark and caption bars. "+
+			"EXIF metadata are only kept by JPEG outputs of JPEG sources.", args, OUTPUT_NONE)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return EXIT_USAGE
+	}
+	flags := c.flags
+	out := flags.String("out", "", "Output directory, where the source directory tree is mirrored (required)")
+	size := flags.String("size", "", "Target size: EDGE for the longest edge, WIDTHxHEIGHT to fit in a box, WIDTHxHEIGHT! for an exact size or PERCENT%")
+	filterName := flags.String("filter", resize.LANCZOS.Name, "Resampling filter: lanczos, catmullrom, bilinear or nearest")
+	enlarge := flags.Bool("enlarge", false, "Enlarge images smaller than the target size")
+	format := flags.String("format", "", "Output format: jpeg, png, gif, bmp or tiff (default: the source format when it can be written, else jpeg)")
+	quality := flags.Int("quality", imageio.DEFAULT_QUALITY, "JPEG quality, from 1 to 100")
+	background := flags.String("background", "white", "Color transparent images are flattened onto for JPEG outputs, #rrggbb or a name")
+	strip := flags.Bool("strip", false, "Do not copy the EXIF metadata and the modification date")
+	autoOrient := flags.Bool("auto-orient", true, "Apply the EXIF orientation to the pixels")
+	overwrite := flags.Bool("overwrite", false, "Replace existing output files")
+	workers := flags.Int("workers", runtime.NumCPU(), "Number of images converted at the same time")
+	quiet := flags.Bool("quiet", false, "Only report errors")
+	w := &c.cfg.Watermark
+	flags.StringVar(&w.Image, "watermark", w.Image, "PNG watermark drawn on the images")
+	flags.StringVar(&w.Text, "watermark-text", w.Text, "Text watermark, used when there is no PNG watermark")
+	flags.StringVar(&w.Position, "watermark-position", w.Position, "Watermark place: bottom-right, bottom-left, top-right, top-left or center")
+	flags.Float64Var(&w.Margin, "watermark-margin", w.Margin, "Distance from the watermark to the edges, fraction of the shorter image edge")
+	flags.Float64Var(&w.Opacity, "watermark-opacity", w.Opacity, "Watermark opacity, from 0 to 1")
+	flags.Float64Var(&w.Size, "watermark-size", w.Size, "Watermark width, fraction of the image width")
+	flags.StringVar(&w.Caption, "caption", w.Caption, "Template of a caption bar below the images, like \"{date} — {camera}\"")
+	flags.StringVar(&w.CaptionTop, "caption-top", w.CaptionTop, "Template of a caption bar above the images")
+	flags.Float64Var(&w.CaptionHeight, "caption-height", w.CaptionHeight, "Caption bar height, fraction of the image height")
+	flags.Parse(args)
+	if flags.NArg() == 0 || *out == "" {
+		flags.Usage()
+		return EXIT_USAGE
+	}
+
+	conv := conversion{
+		enlarge:    *enlarge,
+		quality:    *quality,
+		metadata:   !*strip,
+		autoOrient: *autoOrient,
+		overwrite:  *overwrite,
+	}
+	if conv.size, err = resize.ParseSpec(*size); err != nil {
+		fmt.Fprintln(os.Stderr, "convert:", err)
+		return EXIT_USAGE
+	}
+	if conv.filter, err = resize.ParseFilter(*filterName); err != nil {
+		fmt.Fprintln(os.Stderr, "convert:", err)
+		return EXIT_USAGE
+	}
+	if *format != "" {
+		conv.format = imageio.Format("." + strings.TrimPrefix(strings.ToLower(*format), "."))
+		if conv.format == "" {
+			fmt.Fprintf(os.Stderr, "convert: unknown format %q (want jpeg, png, gif, bmp or tiff)\n", *format)
+			return EXIT_USAGE
+		}
+	}
+	if conv.background, err = fit.ParseColor(*background); err != nil || conv.background.A != 255 {
+		fmt.Fprintf(os.Stderr, "convert: invalid background %q, want an opaque color\n", *background)
+		return EXIT_USAGE
+	}
+	if conv.stamp, err = watermark.New(watermark.Settings(c.cfg.Watermark)); err != nil {
+		fmt.Fprintln(os.Stderr, "convert:", err)
+		return EXIT_USAGE
+	}
+	if conv.quality < 1 || conv.quality > 100 {
+		fmt.Fprintln(os.Stderr, "convert: the quality must be between 1 and 100")
+		return EXIT_USAGE
+	}
+
+	// Select the files, relative to their source directory
+	var jobs []conversionJob
+	ok := true
+	for _, root := range flags.Args() {
+		err := imagefs.Walk(root, c.exts(), func(f imagefs.File) error {
+			rel, err := filepath.Rel(root, f.Path)
+			if err != nil || rel == "." {
+				rel = filepath.Base(f.Path)
+			}
+			jobs = append(jobs, conversionJob{f.Path, conv.target(*out, rel)})
+			return nil
+		}, func(path string, err error) error {
+			fmt.Fprintln(os.Stderr, "convert:", err)
+			ok = false
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "convert:", err)
+			ok = false
+		}
+	}
+
+	// Two sources writing the same file, like a.jpg and a.png converted to
+	// JPEG, would race: refuse before writing anything
+	sources := make(map[string]string)
+	for _, job := range jobs {
+		if source, found := sources[job.target]; found {
+			fmt.Fprintf(os.Stderr, "convert: %s and %s would both be written to %s\n", source, job.source, job.target)
+			continue
+		}
+		sources[job.target] = job.source
+	}
+	if len(sources) < len(jobs) {
+		return EXIT_FAILURE
+	}
+
+	// Convert on every core
+	var mutex sync.Mutex
+	done, failed, skipped := 0, 0, 0
+	parallel(len(jobs), *workers, func(i int) {
+		job := jobs[i]
+		written, err := conv.convert(job)
+		mutex.Lock()
+		defer mutex.Unlock()
+		done++
+		switch {
+		case err != nil:
+			failed++
+			fmt.Fprintf(os.Stderr, "[%d/%d] %s: %v\n", done, len(jobs), job.source, err)
+		case !written:
+			skipped++
+			if !*quiet {
+				fmt.Fprintf(os.Stderr, "[%d/%d] %s: exists, skipped\n", done, len(jobs), job.target)
+			}
+		case !*quiet:
+			fmt.Fprintf(os.Stderr, "[%d/%d] %s\n", done, len(jobs), job.target)
+		}
+	})
+
+	fmt.Printf("%d converted, %d skipped, %d failed\n", len(jobs)-skipped-failed, skipped, failed)
+	return status(ok && failed == 0)
+}
+
+// target returns the output path of a source file, rel being its path relative to its source directory.
+func (c *conversion) target(out string, rel string) string {
+	format := c.format
+	if format == "" {
+		format = imageio.Format(rel)
+	}
+	if format == "" {
+		format = "jpeg"
+	}
+	return filepath.Join(out, strings.TrimSuffix(rel, filepath.Ext(rel))+imageio.Extension(format))
+}
+
+// convert writes the converted copy of a file. It reports false when the target exists and is kept.
+func (c *conversion) convert(job conversionJob) (bool, error) {
+	if !c.overwrite {
+		if _, err := os.Stat(job.target); err == nil {
+			return false, nil
+		}
+	}
+	if same(job.source, job.target) {
+		return false, fmt.Errorf("the output file is the source file")
+	}
+
+	img, _, err := imageio.Load(job.source)
+	if err != nil {
+		return false, err
+	}
+	if c.autoOrient {
+		if t := imageops.Orientation(job.source); !t.IsIdentity() {
+			img = imageops.Apply(img, t)
+		}
+	}
+	img = resize.Resize(img, c.size.Size(img.Bounds().Size(), c.enlarge), c.filter)
+	if c.stamp != nil {
+		img = c.stamp.Apply(img, watermark.Fields(job.source, img.Bounds().Size()))
+	}
+
+	format := imageio.Format(job.target)
+	if format == "jpeg" {
+		img = flatten(img, c.background)
+	}
+	var buffer bytes.Buffer
+	if err = imageio.Encode(&buffer, img, format, c.quality); err != nil {
+		return false, err
+	}
+	data := buffer.Bytes()
+	if c.metadata && format == "jpeg" {
+		if segment, err := exif.Segment(job.source); err == nil {
+			if c.autoOrient {
+				exif.SetOrientation(segment, 1)
+			}
+			if with, err := exif.Insert(data, segment); err == nil {
+				data = with
+			}
+		}
+	}
+
+	if err = os.MkdirAll(filepath.Dir(job.target), 0755); err != nil {
+		return false, err
+	}
+	if err = os.WriteFile(job.target, data, 0644); err != nil {
+		os.Remove(job.target)
+		return false, err
+	}
+	if c.metadata {
+		if info, err := os.Stat(job.source); err == nil {
+			os.Chtimes(job.target, info.ModTime(), info.ModTime())
+		}
+	}
+	return true, nil
+}
+
+// flatten draws a transparent image onto an opaque background, as JPEG has
+// no alpha channel. Opaque images are returned as they are.
+func flatten(img image.Image, background color.RGBA) image.Image {
+	if o, ok := img.(interface{ Opaque() bool }); ok && o.Opaque() {
+		return img
+	}
+	flat := image.NewRGBA(img.Bounds())
+	draw.Draw(flat, flat.Rect, image.NewUniform(background), image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Rect, img, img.Bounds().Min, draw.Over)
+	return flat
+}
+
+// same tells whether two paths name the same existing file.
+func same(a string, b string) bool {
+	ai, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	bi, err := os.Stat(b)
+	return err == nil && os.SameFile(ai, bi)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sort"
+
+	"github.com/nicky-ayoub/imagination/internal/pkg/imagefs"
+)
+
+// duplicates is a set of files with the same content.
+type duplicates struct {
+	Size   int64    `json:"size"`
+	SHA256 string   `json:"sha256"`
+	Paths  []string `json:"paths"`
+}
+
+// dupesMain lists the image files having the same content.
+func dupesMain(args []string) int {
+	c, err := newCLI("dupes", "[path...]",
+		"Finds identical image files, comparing their sizes then their SHA-256 hashes. Groups are separated by blank lines, "+
+			"with -omit-first the first file of each group is kept out so the others can be piped to rm.", args, OUTPUT_PATHS)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return EXIT_USAGE
+	}
+	omitFirst := c.flags.Bool("omit-first", false, "Only list the copies, not the first file of each group")
+	workers := c.flags.Int("workers", runtime.NumCPU(), "Number of files hashed at the same time")
+	c.flags.Parse(args)
+
+	files, ok := c.find()
+
+	// Only files sharing their size can be identical
+	bySize := map[int64][]imagefs.File{}
+	for _, f := range files {
+		bySize[f.Size] = append(bySize[f.Size], f)
+	}
+	var candidates []imagefs.File
+	for _, same := range bySize {
+		if len(same) > 1 {
+			candidates = append(candidates, same...)
+		}
+	}
+	hashes := make([]string, len(candidates))
+	errs := make([]error, len(candidates))
+	parallel(len(candidates), *workers, func(i int) {
+		hashes[i], errs[i] = hashFile(candidates[i].Path)
+	})
+
+	byHash := map[string]*duplicates{}
+	for i, f := range candidates {
+		if errs[i] != nil {
+			fmt.Fprintln(os.Stderr, "dupes:", errs[i])
+			ok = false
+			continue
+		}
+		d := byHash[hashes[i]]
+		if d == nil {
+			d = &duplicates{Size: f.Size, SHA256: hashes[i]}
+			byHash[hashes[i]] = d
+		}
+		d.Paths = append(d.Paths, f.Path)
+	}
+	groups := []*duplicates{}
+	for _, d := range byHash {
+		if len(d.Paths) > 1 {
+			sort.Strings(d.Paths)
+			groups = append(groups, d)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Paths[0] < groups[j].Paths[0] })
+
+	if c.json {
+		if err := c.printJSON(groups); err != nil {
+			fmt.Fprintln(os.Stderr, "dupes:", err)
+			return EXIT_FAILURE
+		}
+		return status(ok)
+	}
+	for i, d := range groups {
+		if i > 0 && !c.null && !*omitFirst {
+			fmt.Println()
+		}
+		paths := d.Paths
+		if *omitFirst {
+			paths = paths[1:]
+		}
+		for _, path := range paths {
+			c.printPath(path)
+		}
+	}
+	return status(ok)
+}
+
+// hashFile returns the SHA-256 hash of a file content.
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("%s: %w", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
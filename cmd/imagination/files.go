@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/nicky-ayoub/imagination/internal/pkg/imagefs"
+)
+
+// extensionSummary counts the files of an extension.
+type extensionSummary struct {
+	Files int   `json:"files"`
+	Size  int64 `json:"size"`
+}
+
+// scanSummary describes the images of a directory.
+type scanSummary struct {
+	Root        string                       `json:"root"`
+	Files       int                          `json:"files"`
+	Size        int64                        `json:"size"`
+	Directories int                          `json:"directories"` // Directories holding images
+	Extensions  map[string]*extensionSummary `json:"extensions"`
+}
+
+// scanMain summarizes the images of each directory argument.
+func scanMain(args []string) int {
+	c, err := newCLI("scan", "[directory...]",
+		"Summarizes the images of each directory: number of files, total size and directories, per extension.", args, OUTPUT_JSON)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return EXIT_USAGE
+	}
+	c.flags.Parse(args)
+
+	ok := true
+	summaries := []*scanSummary{}
+	for _, root := range c.roots() {
+		s := &scanSummary{Root: root, Extensions: map[string]*extensionSummary{}}
+		directories := map[string]bool{}
+		err := imagefs.Walk(root, c.exts(), func(f imagefs.File) error {
+			ext := strings.ToLower(filepath.Ext(f.Path))
+			e := s.Extensions[ext]
+			if e == nil {
+				e = &extensionSummary{}
+				s.Extensions[ext] = e
+			}
+			e.Files++
+			e.Size += f.Size
+			s.Files++
+			s.Size += f.Size
+			directories[filepath.Dir(f.Path)] = true
+			return nil
+		}, func(path string, err error) error {
+			fmt.Fprintln(os.Stderr, "scan:", err)
+			ok = false
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "scan:", err)
+			ok = false
+			continue
+		}
+		s.Directories = len(directories)
+		summaries = append(summaries, s)
+	}
+
+	if c.json {
+		if err := c.printJSON(summaries); err != nil {
+			fmt.Fprintln(os.Stderr, "scan:", err)
+			return EXIT_FAILURE
+		}
+		return status(ok)
+	}
+	for _, s := range summaries {
+		fmt.Printf("%s: %d images, %s in %d directories\n", s.Root, s.Files, imagefs.FormatSize(s.Size), s.Directories)
+		exts := make([]string, 0, len(s.Extensions))
+		for ext := range s.Extensions {
+			exts = append(exts, ext)
+		}
+		sort.Strings(exts)
+		for _, ext := range exts {
+			e := s.Extensions[ext]
+			fmt.Printf("  %-6s %8d %10s\n", ext, e.Files, imagefs.FormatSize(e.Size))
+		}
+	}
+	return status(ok)
+}
+
+// countMain prints the number of images of the path arguments.
+func countMain(args []string) int {
+	c, err := newCLI("count", "[path...]", "Prints the number of images found in the paths.", args, OUTPUT_JSON)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return EXIT_USAGE
+	}
+	byExt := c.flags.Bool("by-ext", false, "Print the count of each extension")
+	c.flags.Parse(args)
+
+	files, ok := c.find()
+	counts := map[string]int{}
+	for _, f := range files {
+		counts[strings.ToLower(filepath.Ext(f.Path))]++
+	}
+	switch {
+	case c.json:
+		err = c.printJSON(struct {
+			Total      int            `json:"total"`
+			Extensions map[string]int `json:"extensions"`
+		}{len(files), counts})
+	case *byExt:
+		exts := make([]string, 0, len(counts))
+		for ext := range counts {
+			exts = append(exts, ext)
+		}
+		sort.Strings(exts)
+		for _, ext := range exts {
+			fmt.Printf("%s\t%d\n", ext, counts[ext])
+		}
+	default:
+		fmt.Println(len(files))
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "count:", err)
+		return EXIT_FAILURE
+	}
+	return status(ok)
+}
+
+// listMain prints the images of the path arguments.
+func listMain(args []string) int {
+	c, err := newCLI("list", "[path...]", "Lists the images found in the paths, one per line.", args, OUTPUT_PATHS)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return EXIT_USAGE
+	}
+	long := c.flags.Bool("long", false, "Print the size and modification date before each path")
+	order := c.flags.String("sort", "name", "Order: name, size or date")
+	reverse := c.flags.Bool("reverse", false, "Reverse the order")
+	c.flags.Parse(args)
+
+	var less func(a, b imagefs.File) bool
+	switch *order {
+	case "name":
+		less = func(a, b imagefs.File) bool { return a.Path < b.Path }
+	case "size":
+		less = func(a, b imagefs.File) bool { return a.Size < b.Size }
+	case "date":
+		less = func(a, b imagefs.File) bool { return a.ModTime.Before(b.ModTime) }
+	default:
+		fmt.Fprintf(os.Stderr, "list: unknown order %q (want name, size or date)\n", *order)
+		return EXIT_USAGE
+	}
+
+	files, ok := c.find()
+	sort.SliceStable(files, func(i, j int) bool {
+		if *reverse {
+			return less(files[j], files[i])
+		}
+		return less(files[i], files[j])
+	})
+	if c.json {
+		if files == nil {
+			files = []imagefs.File{}
+		}
+		if err := c.printJSON(files); err != nil {
+			fmt.Fprintln(os.Stderr, "list:", err)
+			return EXIT_FAILURE
+		}
+		return status(ok)
+	}
+	for _, f := range files {
+		if *long {
+			fmt.Printf("%10s  %s  ", imagefs.FormatSize(f.Size), f.ModTime.Format("2006-01-02 15:04"))
+		}
+		c.printPath(f.Path)
+	}
+	return status(ok)
+}
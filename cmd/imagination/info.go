@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+
+	"github.com/nicky-ayoub/imagination/internal/pkg/picinfo"
+)
+
+// infoMain describes the images of the path arguments.
+func infoMain(args []string) int {
+	c, err := newCLI("info", "path...",
+		"Describes images: format, dimensions, color model, bit depth, file size, ICC profile, EXIF summary and SHA-256 hash.", args, OUTPUT_JSON)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return EXIT_USAGE
+	}
+	workers := c.flags.Int("workers", runtime.NumCPU(), "Number of images read at the same time")
+	c.flags.Parse(args)
+	if c.flags.NArg() == 0 {
+		c.flags.Usage()
+		return EXIT_USAGE
+	}
+
+	files, ok := c.find()
+	infos := make([]*picinfo.Info, len(files))
+	errs := make([]error, len(files))
+	parallel(len(files), *workers, func(i int) {
+		infos[i], errs[i] = picinfo.Inspect(files[i].Path)
+	})
+
+	described := []*picinfo.Info{}
+	for i, info := range infos {
+		if errs[i] != nil {
+			fmt.Fprintln(os.Stderr, "info:", errs[i])
+			ok = false
+			continue
+		}
+		described = append(described, info)
+	}
+	if c.json {
+		if err := c.printJSON(described); err != nil {
+			fmt.Fprintln(os.Stderr, "info:", err)
+			return EXIT_FAILURE
+		}
+		return status(ok)
+	}
+	for i, info := range described {
+		if i > 0 {
+			fmt.Println()
+		}
+		info.WriteText(os.Stdout)
+	}
+	return status(ok)
+}
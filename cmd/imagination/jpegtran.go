@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/nicky-ayoub/imagination/internal/pkg/imageops"
+)
+
+// jpegtranMain flips and rotates image files in place, losslessly for JPEG files when jpegtran is installed.
+func jpegtranMain(args []string) int {
+	flags := flag.NewFlagSet("jpegtran", flag.ExitOnError)
+	rotate := flags.Int("rotate", 0, "Rotate clockwise by 90, 180 or 270 degrees")
+	flip := flags.String("flip", "", "Flip horizontal or vertical")
+	transpose := flags.Bool("transpose", false, "Flip across the top left to bottom right diagonal")
+	transverse := flags.Bool("transverse", false, "Flip across the top right to bottom left diagonal")
+	auto := flags.Bool("auto", false, "Apply the EXIF orientation to the pixels and reset it")
+	tagOnly := flags.Bool("tag-only", false, "Only rewrite the EXIF orientation tag of JPEG files")
+	executable := flags.String("jpegtran", "", "jpegtran executable (default: jpegtran from $PATH)")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: imagination jpegtran [options] file...")
+		fmt.Fprintln(flags.Output(), "Flips and rotates images as they are displayed, losslessly on the JPEG blocks when jpegtran is installed and the image size allows it, else by rewriting the EXIF orientation tag.")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return EXIT_USAGE
+	}
+
+	// Compose the requested operations in the jpegtran order
+	t := imageops.IDENTITY
+	switch *flip {
+	case "":
+	case "horizontal":
+		t = t.Then(imageops.FLIP_HORIZONTAL)
+	case "vertical":
+		t = t.Then(imageops.FLIP_VERTICAL)
+	default:
+		fmt.Fprintf(os.Stderr, "jpegtran: unknown flip %q (want horizontal or vertical)\n", *flip)
+		return EXIT_USAGE
+	}
+	switch *rotate {
+	case 0, 90, 180, 270:
+		t = t.Then(imageops.Transform{Turns: *rotate / 90})
+	default:
+		fmt.Fprintf(os.Stderr, "jpegtran: unknown rotation %d (want 90, 180 or 270)\n", *rotate)
+		return EXIT_USAGE
+	}
+	if *transpose {
+		t = t.Then(imageops.TRANSPOSE)
+	}
+	if *transverse {
+		t = t.Then(imageops.TRANSVERSE)
+	}
+
+	options := imageops.Options{TagOnly: *tagOnly, Jpegtran: *executable}
+	status := EXIT_OK
+	for _, path := range flags.Args() {
+		var method imageops.Method
+		var err error
+		if *auto {
+			method, err = imageops.Persist(path, imageops.Orientation(path).Then(t), options)
+		} else {
+			method, err = imageops.TransformFile(path, t, options)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			status = EXIT_FAILURE
+			continue
+		}
+		if *auto {
+			fmt.Printf("%s: orientation applied (%s)\n", path, method)
+		} else {
+			fmt.Printf("%s: %s (%s)\n", path, t, method)
+		}
+	}
+	return status
+}
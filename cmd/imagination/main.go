@@ -0,0 +1,225 @@
+// Command imagination gathers the command-line tools of the suite, as
+// subcommands sharing their flags and conventions:
+//
+//   - paths can be image files or directories, searched recursively for the
+//     configured extensions (-ext), hidden directories being skipped,
+//   - -json writes machine-readable output, -null separates the paths listed
+//     by list, dupes and thumbs by NUL bytes for xargs -0,
+//   - the exit status is 0 on success, 1 when some files could not be
+//     processed and 2 on usage errors.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/nicky-ayoub/imagination/internal/pkg/config"
+	"github.com/nicky-ayoub/imagination/internal/pkg/imagefs"
+)
+
+// Exit statuses of the commands.
+const (
+	EXIT_OK      = 0
+	EXIT_FAILURE = 1 // Some files could not be processed
+	EXIT_USAGE   = 2
+)
+
+// Output flags declared by newCLI.
+const (
+	OUTPUT_NONE  = iota
+	OUTPUT_JSON  // -json
+	OUTPUT_PATHS // -json and -null, for the commands listing paths
+)
+
+// command is a subcommand, run with the arguments following its name.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) int
+}
+
+var commands []command
+
+func init() {
+	// Set here rather than in the declaration, helpMain reading the list would make an initialization cycle
+	commands = []command{
+		{"scan", "Summarize the images of directories: counts and sizes per extension", scanMain},
+		{"count", "Count the images of directories", countMain},
+		{"list", "List the images of directories", listMain},
+		{"info", "Describe image files: format, dimensions, color model, metadata", infoMain},
+		{"stats", "Report on a library: formats, cameras, dates, sizes and resolutions", statsMain},
+		{"dupes", "Find identical image files", dupesMain},
+		{"thumbs", "Create the cached thumbnails of images", thumbsMain},
+		{"convert", "Write resized copies of images in another format", convertMain},
+		{"jpegtran", "Flip and rotate images, losslessly for JPEG files", jpegtranMain},
+		{"serve", "Serve a directory as a web gallery", serveMain},
+		{"help", "Show the help of a command", helpMain},
+	}
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(EXIT_USAGE)
+	}
+	name := os.Args[1]
+	switch name {
+	case "-h", "-help", "--help":
+		usage()
+		os.Exit(EXIT_OK)
+	}
+	for _, c := range commands {
+		if c.name == name {
+			os.Exit(c.run(os.Args[2:]))
+		}
+	}
+	fmt.Fprintf(os.Stderr, "imagination: unknown command %q\n\n", name)
+	usage()
+	os.Exit(EXIT_USAGE)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: imagination command [options] [arguments]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands:")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, `Run "imagination help command" for the options of a command.`)
+}
+
+// helpMain shows the usage of a command.
+func helpMain(args []string) int {
+	if len(args) == 0 {
+		usage()
+		return EXIT_OK
+	}
+	for _, c := range commands {
+		if c.name == args[0] && c.name != "help" {
+			return c.run([]string{"-h"})
+		}
+	}
+	fmt.Fprintf(os.Stderr, "imagination: unknown command %q\n", args[0])
+	return EXIT_USAGE
+}
+
+// cli holds the configuration and the flags shared by the commands.
+type cli struct {
+	flags *flag.FlagSet
+	cfg   *config.Config
+	ext   string
+	json  bool
+	null  bool
+}
+
+// newCLI loads the configuration and declares the shared flags: -config and
+// -ext, and the output flags of OUTPUT_JSON or OUTPUT_PATHS.
+func newCLI(name string, arguments string, description string, args []string, output int) (*cli, error) {
+	cfg, err := config.Load(args)
+	if err != nil {
+		return nil, fmt.Errorf("configuration: %w", err)
+	}
+	c := &cli{flags: flag.NewFlagSet(name, flag.ExitOnError), cfg: cfg}
+	c.flags.String("config", "", "Configuration file (.toml or .yaml)")
+	c.flags.StringVar(&c.ext, "ext", strings.Join(cfg.Extensions, ","), "comma separated file extensions looked for in directories")
+	if output >= OUTPUT_JSON {
+		c.flags.BoolVar(&c.json, "json", false, "Write JSON")
+	}
+	if output == OUTPUT_PATHS {
+		c.flags.BoolVar(&c.null, "null", false, "End listed paths with a NUL byte rather than a new line, for xargs -0")
+	}
+	c.flags.Usage = func() {
+		fmt.Fprintf(c.flags.Output(), "Usage: imagination %s [options] %s\n", name, arguments)
+		fmt.Fprintln(c.flags.Output(), description)
+		fmt.Fprintln(c.flags.Output())
+		fmt.Fprintln(c.flags.Output(), "Options:")
+		c.flags.PrintDefaults()
+	}
+	return c, nil
+}
+
+// exts returns the extensions given by -ext.
+func (c *cli) exts() []string {
+	var exts []string
+	for _, e := range strings.Split(c.ext, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			exts = append(exts, e)
+		}
+	}
+	return exts
+}
+
+// roots returns the path arguments, the current directory when there is none.
+func (c *cli) roots() []string {
+	if c.flags.NArg() == 0 {
+		return []string{"."}
+	}
+	return c.flags.Args()
+}
+
+// find returns the image files of the path arguments, reporting the unreadable ones.
+func (c *cli) find() (files []imagefs.File, ok bool) {
+	files, errs := imagefs.Find(c.roots(), c.exts())
+	for _, err := range errs {
+		fmt.Fprintln(os.Stderr, c.flags.Name()+":", err)
+	}
+	return files, len(errs) == 0
+}
+
+// printPath writes a listed path, ended as -null asks.
+func (c *cli) printPath(path string) {
+	if c.null {
+		fmt.Print(path, "\x00")
+	} else {
+		fmt.Println(path)
+	}
+}
+
+// printJSON writes v as indented JSON on the standard output.
+func (c *cli) printJSON(v interface{}) error {
+	return c.writeJSON(os.Stdout, v)
+}
+
+// writeJSON writes v as indented JSON.
+func (c *cli) writeJSON(w io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// status returns the exit status telling whether everything went fine.
+func status(ok bool) int {
+	if ok {
+		return EXIT_OK
+	}
+	return EXIT_FAILURE
+}
+
+// parallel calls fn for the indexes from 0 to n-1 on several goroutines.
+func parallel(n int, workers int, fn func(i int)) {
+	if workers < 1 {
+		workers = 1
+	}
+	queue := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+}
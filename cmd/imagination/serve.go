@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/nicky-ayoub/imagination/internal/pkg/catalog"
+	"github.com/nicky-ayoub/imagination/internal/pkg/gallery"
+	"github.com/nicky-ayoub/imagination/internal/pkg/iiif"
+	"github.com/nicky-ayoub/imagination/internal/pkg/rendition"
+	"github.com/nicky-ayoub/imagination/internal/pkg/restapi"
+	"github.com/nicky-ayoub/imagination/internal/pkg/thumbcache"
+)
+
+// serveMain serves a directory as a web gallery and a JSON API until the process is stopped.
+func serveMain(args []string) int {
+	c, err := newCLI("serve", "[directory]",
+		"Serves the images of a directory as a web gallery: folder pages with thumbnail grids, "+
+			"a full-screen lightbox browsed with the arrow keys, and the original files. "+
+			"The JSON API of api/openapi.yaml is served under /api/, and resized copies of the images "+
+			"under /img/, like /img/path/to/image.jpg?w=800&h=600&fit=cover&fmt=png. "+
+			"IIIF viewers find the IIIF Image API 3.0 service under /iiif/, like /iiif/path%2Fto%2Fimage.jpg/info.json.", args, OUTPUT_NONE)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return EXIT_USAGE
+	}
+	addr := c.flags.String("addr", "localhost:8080", "Address to listen on, host:port")
+	dir := c.flags.String("cache", thumbcache.DefaultDir(), "Thumbnail cache directory")
+	size := c.flags.Int("thumb-size", thumbcache.DEFAULT_SIZE, "Longest edge of the thumbnails")
+	renditions := c.flags.String("img-cache", rendition.DefaultDir(), "Directory of the cache of the /img/ and /iiif/ images")
+	renditionsSize := c.flags.Int64("img-cache-size", rendition.DEFAULT_CACHE_SIZE>>20, "Size of the cache of the /img/ and /iiif/ images, in MB")
+	maxEdge := c.flags.Int("img-max", rendition.DEFAULT_MAX_EDGE, "Largest width and height of the /img/ and /iiif/ images")
+	c.flags.Parse(args)
+	if c.flags.NArg() > 1 {
+		c.flags.Usage()
+		return EXIT_USAGE
+	}
+	if *size <= 0 || *renditionsSize <= 0 || *maxEdge <= 0 {
+		fmt.Fprintln(os.Stderr, "serve: the sizes must be positive")
+		return EXIT_USAGE
+	}
+
+	root := c.roots()[0]
+	cache := thumbcache.New(*dir, *size)
+	g, err := gallery.New(root, c.exts(), cache)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "serve:", err)
+		return EXIT_FAILURE
+	}
+	library := catalog.New(root, c.exts())
+	if err := library.Scan(); err != nil {
+		fmt.Fprintln(os.Stderr, "serve:", err)
+		return EXIT_FAILURE
+	}
+	renditionCache, err := rendition.NewCache(*renditions, *renditionsSize<<20)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "serve:", err)
+		return EXIT_FAILURE
+	}
+	limits := rendition.DefaultLimits()
+	limits.MaxWidth, limits.MaxHeight = *maxEdge, *maxEdge
+
+	mux := http.NewServeMux()
+	mux.Handle("/api/", restapi.New(library, cache))
+	mux.Handle("/img/", http.StripPrefix("/img", rendition.NewHandler(root, c.exts(), renditionCache, limits)))
+	mux.Handle("/iiif/", iiif.New("/iiif", root, c.exts(), renditionCache, limits))
+	mux.Handle("/", g)
+
+	server := &http.Server{
+		Addr:              *addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	fmt.Fprintf(os.Stderr, "Serving %d images of %s on http://%s/\n", library.Len(), root, *addr)
+	if err := server.ListenAndServe(); err != nil {
+		fmt.Fprintln(os.Stderr, "serve:", err)
+		return EXIT_FAILURE
+	}
+	return EXIT_OK
+}
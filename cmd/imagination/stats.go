@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/nicky-ayoub/imagination/internal/pkg/librarystats"
+)
+
+// statsMain summarizes the images of the path arguments as a text, JSON or HTML report.
+func statsMain(args []string) int {
+	c, err := newCLI("stats", "[path...]",
+		"Summarizes a library: counts by format, extension, camera, capture year and month and resolution, sizes, "+
+			"largest files and folders with the most images. The report is text, JSON with -json, or an HTML page with charts with -html.", args, OUTPUT_JSON)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return EXIT_USAGE
+	}
+	html := c.flags.Bool("html", false, "Write a self-contained HTML report")
+	output := c.flags.String("o", "", "Write the report to a file rather than to the standard output")
+	top := c.flags.Int("top", librarystats.DEFAULT_TOP, "Number of largest files and folders listed")
+	workers := c.flags.Int("workers", runtime.NumCPU(), "Number of images read at the same time")
+	c.flags.Parse(args)
+	if *html && c.json {
+		fmt.Fprintln(os.Stderr, "stats: -html and -json can not be used together")
+		return EXIT_USAGE
+	}
+
+	files, ok := c.find()
+	collector := librarystats.NewCollector(strings.Join(c.roots(), ", "), *top)
+	parallel(len(files), *workers, func(i int) {
+		collector.Add(librarystats.Read(files[i].Path, files[i].Size))
+	})
+	report := collector.Report()
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "stats:", err)
+			return EXIT_FAILURE
+		}
+		defer f.Close()
+		w = f
+	}
+	switch {
+	case c.json:
+		err = c.writeJSON(w, report)
+	case *html:
+		err = report.WriteHTML(w)
+	default:
+		err = report.WriteText(w)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "stats:", err)
+		return EXIT_FAILURE
+	}
+	return status(ok)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+
+	"github.com/nicky-ayoub/imagination/internal/pkg/thumbcache"
+)
+
+// thumbnail is a cached thumbnail of an image file.
+type thumbnail struct {
+	Path      string `json:"path"`
+	Thumbnail string `json:"thumbnail"`
+	Created   bool   `json:"created"`
+}
+
+// thumbsMain creates the cached thumbnails of the images of the path arguments.
+func thumbsMain(args []string) int {
+	c, err := newCLI("thumbs", "[path...]",
+		"Creates the thumbnails of the images in the thumbnail cache, and prints their paths. Existing thumbnails are kept unless -force is given.", args, OUTPUT_PATHS)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return EXIT_USAGE
+	}
+	size := c.flags.Int("size", thumbcache.DEFAULT_SIZE, "Longest edge of the thumbnails")
+	dir := c.flags.String("cache", thumbcache.DefaultDir(), "Thumbnail cache directory")
+	force := c.flags.Bool("force", false, "Create the thumbnails again even when they are cached")
+	clean := c.flags.Bool("clean", false, "Remove every cached thumbnail first")
+	workers := c.flags.Int("workers", runtime.NumCPU(), "Number of thumbnails created at the same time")
+	quiet := c.flags.Bool("quiet", false, "Do not report the progress on the standard error")
+	c.flags.Parse(args)
+	if *size <= 0 {
+		fmt.Fprintln(os.Stderr, "thumbs: the size must be positive")
+		return EXIT_USAGE
+	}
+
+	cache := thumbcache.New(*dir, *size)
+	if *clean {
+		if err := cache.Clean(); err != nil {
+			fmt.Fprintln(os.Stderr, "thumbs:", err)
+			return EXIT_FAILURE
+		}
+	}
+	files, ok := c.find()
+	thumbnails := make([]thumbnail, len(files))
+	errs := make([]error, len(files))
+	var mutex sync.Mutex
+	done := 0
+	parallel(len(files), *workers, func(i int) {
+		t := thumbnail{Path: files[i].Path}
+		t.Thumbnail, t.Created, errs[i] = cache.Make(t.Path, *force)
+		thumbnails[i] = t
+		if !*quiet {
+			mutex.Lock()
+			done++
+			fmt.Fprintf(os.Stderr, "[%d/%d] %s\n", done, len(files), t.Path)
+			mutex.Unlock()
+		}
+	})
+
+	made := []thumbnail{}
+	for i, t := range thumbnails {
+		if errs[i] != nil {
+			fmt.Fprintln(os.Stderr, "thumbs:", errs[i])
+			ok = false
+			continue
+		}
+		made = append(made, t)
+	}
+	if c.json {
+		if err := c.printJSON(made); err != nil {
+			fmt.Fprintln(os.Stderr, "thumbs:", err)
+			return EXIT_FAILURE
+		}
+		return status(ok)
+	}
+	for _, t := range made {
+		c.printPath(t.Thumbnail)
+	}
+	return status(ok)
+}
@@ -1,41 +1,260 @@
 package main
 
 import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
 	"fmt"
-	"image"
-	_ "image/jpeg"
-	"image/png"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/nicky-ayoub/imagination/internal/pkg/config"
+	"github.com/nicky-ayoub/imagination/internal/pkg/imagefs"
+	"github.com/nicky-ayoub/imagination/internal/pkg/imageio"
+	"github.com/nicky-ayoub/imagination/internal/pkg/imagestats"
+	"github.com/nicky-ayoub/imagination/internal/pkg/picinfo"
 )
 
+// result is the description of a file, or why it could not be read.
+type result struct {
+	Path string `json:"path"`
+	*picinfo.Info
+	Stats *imagestats.Stats `json:"stats,omitempty"`
+	Flags []string          `json:"flags,omitempty"` // Problems found by the statistics, see imagestats.Stats.Flags
+	Error string            `json:"error,omitempty"`
+}
+
+// measure tells whether and how the pixels are measured.
+type measure struct {
+	enabled    bool
+	colors     int
+	thresholds imagestats.Thresholds
+}
+
+var usage = `Usage: picdat [options] path...
+
+Describes image files: format, dimensions, color model, bit depth, file size,
+ICC profile, EXIF summary and SHA-256 hash. Paths can be files, glob patterns
+like "photos/*.jpg" or directories, searched for the configured extensions.
+
+With -stats the pixels are decoded and measured too: channel statistics,
+dominant colors, sharpness and clipping. Images less sharp than -blur-threshold
+or with more clipped pixels than -clip-threshold are flagged blurry,
+underexposed or overexposed; -flagged lists only those.
+
+Options:
+`
+
 func main() {
-	// Read image from file that already exists
-	existingImageFile, err := os.Open("../../assets/test.jpg")
+	cfg := config.MustLoad()
+	config.AddFlag()
+	output := flag.String("output", "text", "Output format: text, json or csv")
+	flag.StringVar(output, "o", "text", "Output format: text, json or csv")
+	extPtr := flag.String("ext", strings.Join(cfg.Extensions, ","), "comma separated file extensions looked for in directories")
+	var m measure
+	m.thresholds = imagestats.DefaultThresholds()
+	flag.BoolVar(&m.enabled, "stats", false, "Measure the pixels: statistics, palette, sharpness and clipping")
+	flag.IntVar(&m.colors, "colors", 5, "Number of dominant colors looked for with -stats")
+	flag.Float64Var(&m.thresholds.Blur, "blur-threshold", m.thresholds.Blur, "Sharpness (variance of the Laplacian) below which images are flagged blurry")
+	flag.Float64Var(&m.thresholds.Clip, "clip-threshold", m.thresholds.Clip, "Percentage of black or blown pixels above which images are flagged badly exposed")
+	flagged := flag.Bool("flagged", false, "Only report the images flagged by -stats")
+	workers := flag.Int("workers", runtime.NumCPU(), "Number of images read at the same time")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	switch *output {
+	case "text", "json", "csv":
+	default:
+		fmt.Fprintf(os.Stderr, "picdat: unknown output format %q (want text, json or csv)\n", *output)
+		os.Exit(2)
+	}
+
+	if *flagged {
+		m.enabled = true
+	}
+	if *workers < 1 {
+		*workers = 1
+	}
+
+	// Inspect the files on every core, keeping their order
+	paths := expand(flag.Args(), strings.Split(*extPtr, ","))
+	results := make([]result, len(paths))
+	queue := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < *workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				results[i] = inspect(paths[i], m)
+			}
+		}()
+	}
+	for i := range paths {
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+
+	status := 0
+	kept := results[:0]
+	for _, r := range results {
+		if r.Error != "" {
+			fmt.Fprintln(os.Stderr, "picdat:", r.Error)
+			status = 1
+		} else if *flagged && len(r.Flags) == 0 {
+			continue
+		}
+		kept = append(kept, r)
+	}
+	results = kept
+
+	var err error
+	switch *output {
+	case "text":
+		printText(results)
+	case "json":
+		err = printJSON(results)
+	case "csv":
+		err = printCSV(results, m.enabled)
+	}
 	if err != nil {
-		// Handle error
+		fmt.Fprintln(os.Stderr, "picdat:", err)
+		status = 1
 	}
-	defer existingImageFile.Close()
+	os.Exit(status)
+}
 
-	// Calling the generic image.Decode() will tell give us the data
-	// and type of image it is as a string. We expect "jpg"
-	imageData, imageType, err := image.Decode(existingImageFile)
+// inspect describes a file, and measures its pixels when enabled.
+func inspect(path string, m measure) result {
+	info, err := picinfo.Inspect(path)
 	if err != nil {
-		// Handle error
+		return result{Path: path, Error: err.Error()}
 	}
-	fmt.Println(imageData)
-	fmt.Println(imageType)
+	r := result{Path: path, Info: info}
+	if m.enabled {
+		img, _, err := imageio.Load(path)
+		if err != nil {
+			return result{Path: path, Error: err.Error()}
+		}
+		r.Stats = imagestats.Compute(img, m.colors)
+		r.Flags = r.Stats.Flags(m.thresholds)
+	}
+	return r
+}
 
-	// We only need this because we already read from the file
-	// We have to reset the file pointer back to beginning
-	existingImageFile.Seek(0, 0)
+// expand replaces glob patterns by the files they match and directories by
+// the image files they hold. Patterns matching nothing are kept, so they are
+// reported as missing files.
+func expand(args []string, exts []string) (paths []string) {
+	for _, arg := range args {
+		matches, err := filepath.Glob(arg)
+		if err != nil || len(matches) == 0 {
+			paths = append(paths, arg)
+			continue
+		}
+		for _, match := range matches {
+			if info, err := os.Stat(match); err == nil && info.IsDir() {
+				for _, file := range imagefs.FilesByExt(match, exts) {
+					paths = append(paths, filepath.Join(match, file))
+				}
+				continue
+			}
+			paths = append(paths, match)
+		}
+	}
+	return paths
+}
 
-	// Alternatively, since we know it is a png already
-	// we can call png.Decode() directly
-	loadedImage, err := png.Decode(existingImageFile)
-	if err != nil {
-		// Handle error
-		panic(err)
+func printText(results []result) {
+	printed := 0
+	for _, r := range results {
+		if r.Info == nil {
+			continue
+		}
+		if printed++; printed > 1 {
+			fmt.Println()
+		}
+		r.Info.WriteText(os.Stdout)
+		if s := r.Stats; s != nil {
+			for _, c := range []struct {
+				name string
+				c    imagestats.Channel
+			}{{"Red", s.Red}, {"Green", s.Green}, {"Blue", s.Blue}, {"Luma", s.Luma}} {
+				fmt.Printf("  %-13s mean %5.1f  stddev %5.1f  min %3d  max %3d\n", c.name+":", c.c.Mean, c.c.StdDev, c.c.Min, c.c.Max)
+			}
+			fmt.Printf("  Palette:      %s\n", palette(s.Palette, ", "))
+			fmt.Printf("  Sharpness:    %.1f\n", s.Sharpness)
+			fmt.Printf("  Clipping:     %.2f%% shadows, %.2f%% highlights\n", s.Shadows, s.Highlights)
+			if len(r.Flags) > 0 {
+				fmt.Printf("  Flags:        %s\n", strings.Join(r.Flags, ", "))
+			}
+		}
+	}
+}
 
+// palette formats swatches as "#rrggbb 12.3%" separated by sep.
+func palette(swatches []imagestats.Swatch, sep string) string {
+	texts := make([]string, len(swatches))
+	for i, s := range swatches {
+		texts[i] = fmt.Sprintf("%s %.1f%%", s.Color, s.Share)
 	}
-	fmt.Println(loadedImage)
+	return strings.Join(texts, sep)
+}
+
+func printJSON(results []result) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if results == nil {
+		results = []result{}
+	}
+	return encoder.Encode(results)
+}
+
+func printCSV(results []result, stats bool) error {
+	w := csv.NewWriter(os.Stdout)
+	header := []string{"path", "format", "width", "height", "color_model", "bit_depth", "size", "icc_profile", "exif", "orientation", "sha256"}
+	if stats {
+		for _, c := range []string{"red", "green", "blue", "luma"} {
+			header = append(header, c+"_mean", c+"_stddev", c+"_min", c+"_max")
+		}
+		header = append(header, "palette", "sharpness", "shadows", "highlights", "flags")
+	}
+	w.Write(append(header, "error"))
+	for _, r := range results {
+		if r.Info == nil {
+			record := make([]string, len(header)+1)
+			record[0], record[len(header)] = r.Path, r.Error
+			w.Write(record)
+			continue
+		}
+		record := []string{
+			r.Path, r.Format, strconv.Itoa(r.Width), strconv.Itoa(r.Height), r.ColorModel,
+			strconv.Itoa(r.BitDepth), strconv.FormatInt(r.Size, 10), r.ICCProfile, r.Exif,
+			strconv.Itoa(r.Orientation), r.SHA256,
+		}
+		if s := r.Stats; stats && s != nil {
+			for _, c := range []imagestats.Channel{s.Red, s.Green, s.Blue, s.Luma} {
+				record = append(record, decimal(c.Mean), decimal(c.StdDev), strconv.Itoa(c.Min), strconv.Itoa(c.Max))
+			}
+			record = append(record, palette(s.Palette, " "), decimal(s.Sharpness), decimal(s.Shadows), decimal(s.Highlights), strings.Join(r.Flags, " "))
+		}
+		w.Write(append(record, ""))
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func decimal(f float64) string {
+	return strconv.FormatFloat(f, 'f', 2, 64)
 }
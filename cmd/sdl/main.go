@@ -1,58 +1,100 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"image"
+	"image/draw"
+	"log"
 	"math/rand"
 	"os"
 	"time"
 
+	"github.com/nicky-ayoub/imagination/internal/pkg/config"
 	"github.com/nicky-ayoub/imagination/internal/pkg/imagefs"
+	"github.com/nicky-ayoub/imagination/internal/pkg/keymap"
+	"github.com/nicky-ayoub/imagination/internal/pkg/keymap/sdlkeys"
+	"github.com/nicky-ayoub/imagination/internal/pkg/overlay"
+	"github.com/nicky-ayoub/imagination/internal/pkg/playlist"
 	"github.com/veandco/go-sdl2/img"
 	"github.com/veandco/go-sdl2/sdl"
 )
 
-const (
-	SCREEN_WIDTH  int32 = 1440
-	SCREEN_HEIGHT int32 = 900
-)
-
 type Game struct {
-	image *sdl.Surface
-	paths []string
-	root  string
-	name  string
+	cfg     *config.Config
+	keys    *keymap.Keymap
+	image   *sdl.Surface
+	list    *playlist.Playlist
+	paths   []string
+	index   int
+	name    string
+	srcRect *sdl.Rect // Playlist crop rectangle, nil to show the whole image
+	shownAt uint32
+	showFor uint32
+	paused  bool
+	help    *sdl.Surface // Key bindings panel, nil when hidden
 }
 
-func NewGame() *Game {
-	g := &Game{}
+func NewGame(cfg *config.Config, list *playlist.Playlist, random bool, keys *keymap.Keymap) *Game {
+	g := &Game{cfg: cfg, list: list, keys: keys}
 	seed := time.Now().Unix()
 	rand.Seed(seed)
 	fmt.Println("Seed : ", seed)
-	g.root = "../../assets"
-	g.paths = imagefs.AllJpgFiles(g.root)
+	// Shuffle once so previous and next walk the same random order
+	if random {
+		rand.Shuffle(len(g.list.Items), func(i, j int) { g.list.Items[i], g.list.Items[j] = g.list.Items[j], g.list.Items[i] })
+	}
+	g.paths = g.list.Paths()
 	fmt.Println(g.paths)
 	return g
 }
 
-func GetName(g *Game) {
-	randomIndex := rand.Intn(len(g.paths))
-	g.name = g.paths[randomIndex]
+func GetName(g *Game, index int) {
+	g.index = (index + len(g.paths)) % len(g.paths)
+	g.name = g.paths[g.index]
 }
 
-func OpenImage(g *Game) (err error) {
-	GetName(g)
-	g.image, err = img.Load(g.root + "/" + g.name)
+func OpenImage(g *Game, index int) (err error) {
+	GetName(g, index)
+	if g.image != nil {
+		g.image.Free()
+	}
+	g.image, err = img.Load(g.name)
 	return err
 }
 
-func DrawImage(g *Game, window *sdl.Window) (err error) {
-	err = OpenImage(g)
+func ShowImage(g *Game, window *sdl.Window, index int) (err error) {
+	err = OpenImage(g, index)
 	if err != nil {
 		return err
 	}
 
-	window.SetSize(g.image.W, g.image.H) // Must be called before GetSurface()
+	item := g.list.Items[g.index]
+	g.srcRect = nil
+	if item.Crop != nil {
+		g.srcRect = &sdl.Rect{X: int32(item.Crop.X), Y: int32(item.Crop.Y), W: int32(item.Crop.W), H: int32(item.Crop.H)}
+	}
+	g.shownAt = sdl.GetTicks()
+	// Images stay displayed for the slideshow interval unless the playlist tells otherwise
+	g.showFor = uint32(time.Duration(g.cfg.Slideshow.Interval) / time.Millisecond)
+	if item.Duration > 0 {
+		g.showFor = uint32(time.Duration(item.Duration) / time.Millisecond)
+	}
+	if item.Caption != "" {
+		window.SetTitle(item.Caption)
+	} else {
+		window.SetTitle(g.name)
+	}
+
+	w, h := g.image.W, g.image.H
+	if g.srcRect != nil {
+		w, h = g.srcRect.W, g.srcRect.H
+	}
+	window.SetSize(w, h) // Must be called before GetSurface()
+	return DrawImage(g, window)
+}
 
+func DrawImage(g *Game, window *sdl.Window) (err error) {
 	var surface *sdl.Surface
 	if surface, err = window.GetSurface(); err != nil {
 		return err
@@ -60,7 +102,12 @@ func DrawImage(g *Game, window *sdl.Window) (err error) {
 
 	surface.FillRect(&surface.ClipRect, 0)
 	// Draw the BMP image on the first half of the window
-	g.image.Blit(nil, surface, &g.image.ClipRect)
+	g.image.Blit(g.srcRect, surface, &sdl.Rect{W: surface.W, H: surface.H})
+
+	// Center the help over the image
+	if g.help != nil {
+		g.help.Blit(nil, surface, &sdl.Rect{X: (surface.W - g.help.W) / 2, Y: (surface.H - g.help.H) / 2})
+	}
 
 	// Update the window surface with what we have drawn
 	window.UpdateSurface()
@@ -70,54 +117,160 @@ func DrawImage(g *Game, window *sdl.Window) (err error) {
 func run(g *Game) (err error) {
 
 	var event sdl.Event
-	var tick uint32
 
 	if err = sdl.Init(sdl.INIT_EVERYTHING); err != nil {
 		os.Exit(1)
 	}
 	defer sdl.Quit()
 
-	window, err := sdl.CreateWindow("SDL2 Power", sdl.WINDOWPOS_CENTERED, sdl.WINDOWPOS_CENTERED, SCREEN_WIDTH, SCREEN_HEIGHT, sdl.WINDOW_SHOWN)
+	var flags uint32 = sdl.WINDOW_SHOWN
+	if g.cfg.Window.Fullscreen {
+		flags |= sdl.WINDOW_FULLSCREEN_DESKTOP
+	}
+	window, err := sdl.CreateWindow("SDL2 Power", sdl.WINDOWPOS_CENTERED, sdl.WINDOWPOS_CENTERED, int32(g.cfg.Window.Width), int32(g.cfg.Window.Height), flags)
 	if err != nil {
 		panic(err)
 	}
 	defer window.Destroy()
 
-	DrawImage(g, window)
 	running := true
+	show := func(index int) {
+		if err := ShowImage(g, window, index); err != nil {
+			log.Println(err)
+		}
+	}
+	actions := keymap.NewRegistry()
+	actions.Register(keymap.ACTION_NEXT, "", func(keymap.Trigger) { show(g.index + 1) })
+	actions.Register(keymap.ACTION_PREV, "", func(keymap.Trigger) { show(g.index - 1) })
+	actions.Register(keymap.ACTION_FIRST, "", func(keymap.Trigger) { show(0) })
+	actions.Register(keymap.ACTION_LAST, "", func(keymap.Trigger) { show(len(g.paths) - 1) })
+	actions.Register(keymap.ACTION_PAUSE, "", func(keymap.Trigger) {
+		g.paused = !g.paused
+		g.shownAt = sdl.GetTicks() // Resuming shows the image for a whole interval
+	})
+	actions.Register(keymap.ACTION_HELP, "", func(keymap.Trigger) {
+		if g.help != nil {
+			g.help.Free()
+			g.help = nil
+		} else if help, err := helpSurface(g.keys.Help(actions)); err != nil {
+			log.Println(err)
+		} else {
+			g.help = help
+		}
+		DrawImage(g, window)
+	})
+	actions.Register(keymap.ACTION_QUIT, "", func(keymap.Trigger) { running = false })
+
+	show(0)
 	for running {
-		event = sdl.WaitEventTimeout(1000) // wait here until an event is in the event queue
+		event = sdl.WaitEventTimeout(100) // wait here until an event is in the event queue
+		if action, ok := g.keys.Expire(); ok {
+			actions.Run(action, keymap.Trigger{})
+		}
 		if event == nil {
-			fmt.Println("WaitEventTimeout timed out")
-			tick = tick + 1
-			if tick%5 == 0 {
-				//g.image.Free() // is this needed?
-				DrawImage(g, window)
+			if !g.paused && g.showFor > 0 && sdl.GetTicks()-g.shownAt >= g.showFor {
+				show(g.index + 1)
 			}
 			continue
 		}
+		var stroke keymap.Stroke
+		var ok, mouse bool
 		switch t := event.(type) {
 		case *sdl.QuitEvent:
 			running = false
 		case *sdl.KeyboardEvent:
-			keyCode := t.Keysym.Sym
-			if keyCode == sdl.K_ESCAPE {
-				running = false
-				continue
+			if t.Type == sdl.KEYDOWN {
+				stroke, ok = sdlkeys.Key(t)
+			}
+		case *sdl.MouseButtonEvent:
+			if t.Type == sdl.MOUSEBUTTONDOWN {
+				stroke, ok = sdlkeys.Button(t)
+				mouse = true
 			}
-			if keyCode == sdl.K_SPACE {
-				//g.image.Free() // is this needed?
+		case *sdl.MouseWheelEvent:
+			stroke, ok = sdlkeys.Wheel(t)
+			mouse = true
+		case *sdl.WindowEvent:
+			if t.Event == sdl.WINDOWEVENT_EXPOSED {
 				DrawImage(g, window)
 			}
 		}
-
+		if !ok {
+			continue
+		}
+		if action, found := g.keys.Feed(stroke); found {
+			x, y, _ := sdl.GetMouseState()
+			actions.Run(action, keymap.Trigger{Stroke: stroke, Mouse: mouse, At: image.Pt(int(x), int(y))})
+		}
 	}
 
 	return
 }
 
+// helpSurface renders the key bindings on a surface that can be blitted on the window.
+func helpSurface(lines []string) (*sdl.Surface, error) {
+	panel := overlay.Text(lines, overlay.DefaultStyle())
+	surface, err := sdl.CreateRGBSurfaceWithFormat(0, int32(panel.Rect.Dx()), int32(panel.Rect.Dy()), 32, sdl.PIXELFORMAT_ABGR8888)
+	if err != nil {
+		return nil, err
+	}
+	// The window surface has no alpha channel, the panel is blended on it
+	surface.SetBlendMode(sdl.BLENDMODE_BLEND)
+	nrgba := image.NewNRGBA(panel.Rect)
+	draw.Draw(nrgba, nrgba.Rect, panel, image.Point{}, draw.Src)
+	surface.Lock()
+	pixels := surface.Pixels()
+	for y := 0; y < panel.Rect.Dy(); y++ {
+		copy(pixels[y*int(surface.Pitch):], nrgba.Pix[y*nrgba.Stride:y*nrgba.Stride+4*panel.Rect.Dx()])
+	}
+	surface.Unlock()
+	return surface, nil
+}
+
 func main() {
-	g := NewGame()
+	var playlistPath, savePath, keymapPath string
+	var list *playlist.Playlist
+	var interval time.Duration
+	var err error
+
+	cfg := config.MustLoad()
+	config.AddFlag()
+	flag.IntVar(&cfg.Window.Width, "width", cfg.Window.Width, "Window width in pixels")
+	flag.IntVar(&cfg.Window.Height, "height", cfg.Window.Height, "Window height in pixels")
+	flag.BoolVar(&cfg.Window.Fullscreen, "fullscreen", cfg.Window.Fullscreen, "Cover the whole desktop")
+	flag.DurationVar(&interval, "interval", time.Duration(cfg.Slideshow.Interval), "Time each image is displayed (0 waits for the space key)")
+	flag.StringVar(&playlistPath, "playlist", "", "Show a playlist (.m3u, .txt, .json or .yaml) in order instead of random directory images")
+	flag.StringVar(&savePath, "save-playlist", "", "Save the images to show as a playlist")
+	flag.StringVar(&keymapPath, "keymap", cfg.Keymap, "Key bindings file")
+	flag.Parse()
+	cfg.Slideshow.Interval = config.Duration(interval)
+
+	if playlistPath != "" {
+		if list, err = playlist.Load(playlistPath); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		root := cfg.Assets
+		if len(flag.Args()) > 0 {
+			root = flag.Args()[0]
+		}
+		list = playlist.FromPaths(root, imagefs.AllFilesByExt(root, cfg.Extensions))
+	}
+	if savePath != "" {
+		if err = list.Save(savePath); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	keys, err := keymap.Load(keymapPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	g := NewGame(cfg, list, playlistPath == "", keys)
+	if len(g.paths) == 0 {
+		log.Fatal("No image to display")
+	}
 	fmt.Println(g)
 	if err := run(g); err != nil {
 		os.Exit(1)
@@ -3,66 +3,186 @@ package main
 import (
 	"flag"
 	"fmt"
+	"image"
 	"log"
-	"math/rand"
 	"os"
+	"path/filepath"
 	"time"
 
+	"github.com/nicky-ayoub/imagination/internal/pkg/adjust"
+	"github.com/nicky-ayoub/imagination/internal/pkg/config"
+	"github.com/nicky-ayoub/imagination/internal/pkg/crop"
+	"github.com/nicky-ayoub/imagination/internal/pkg/exif"
+	"github.com/nicky-ayoub/imagination/internal/pkg/fit"
 	"github.com/nicky-ayoub/imagination/internal/pkg/imagefs"
+	"github.com/nicky-ayoub/imagination/internal/pkg/imageio"
+	"github.com/nicky-ayoub/imagination/internal/pkg/imageops"
+	"github.com/nicky-ayoub/imagination/internal/pkg/keymap"
+	"github.com/nicky-ayoub/imagination/internal/pkg/keymap/sdlkeys"
+	"github.com/nicky-ayoub/imagination/internal/pkg/overlay"
+	"github.com/nicky-ayoub/imagination/internal/pkg/playlist"
+	"github.com/nicky-ayoub/imagination/internal/pkg/remote"
+	"github.com/nicky-ayoub/imagination/internal/pkg/sidecar"
+	"github.com/nicky-ayoub/imagination/internal/pkg/viewer"
 	"github.com/nicky-ayoub/imagination/internal/pkg/viewport"
+	"github.com/nicky-ayoub/imagination/internal/pkg/watermark"
 	"github.com/veandco/go-sdl2/img"
 	"github.com/veandco/go-sdl2/sdl"
 )
 
+/** Directory created next to deleted images to receive them. */
+const TRASH_DIRECTORY = ".trash"
+
+/** The crop rectangle moves or grows by this fraction of the image width. */
+const CROP_STEPS = 100
+
 type Game struct {
-	image *sdl.Surface
-	paths []string
-	root  string
-	name  string
-	title string
-	seed  int64
+	keys     *keymap.Keymap
+	viewer   *viewer.Viewer
+	size     image.Point // Size of the current image
+	name     string
+	title    string
+	seed     int64
+	collect  string           // Playlist the current image is appended to when pressing 'a'
+	crop     image.Rectangle  // Crop rectangle of the current image, in image pixels
+	cropping bool             // Whether the crop rectangle is displayed and drawn with the mouse
+	stamp    *watermark.Stamp // Watermark and caption bars added to exported images, nil for none
+	remote   *remote.Server   // Remote control endpoint, nil for none
+	source   string           // Playlist file shown, empty for a directory
 }
 
-func NewGame(dir string, randomize bool) *Game {
+func NewGame(list *playlist.Playlist, randomize bool) *Game {
 	g := &Game{}
 	g.title = "SDL Image Viewer - "
 	g.seed = time.Now().UTC().UnixNano()
-	g.root = dir
-	g.paths = imagefs.AllJpgFiles(g.root)
+	g.viewer = viewer.New(list, &renderer{g: g})
 	if randomize {
-		rand.Seed(g.seed)
-		rand.Shuffle(len(g.paths), func(i, j int) { g.paths[i], g.paths[j] = g.paths[j], g.paths[i] })
+		g.viewer.Shuffle(g.seed)
 	}
 
 	return g
 }
-func setImage(g *Game, i int) (err error) {
 
-	// Try to load the image before creating the viewport
-	g.name = g.paths[i]
-	if g.image, err = img.Load(g.root + "/" + g.name); err != nil {
-		log.Fatal(err)
+// renderer displays the images of the viewer through the viewport package.
+type renderer struct {
+	g    *Game
+	view viewer.View // View the viewport displays
+}
+
+func (r *renderer) Load(index int, path string, item playlist.Item) error {
+	if err := setImage(r.g, index, path, item); err != nil {
 		return err
 	}
+	// The flipping mode and rotation of the previous image are still set, the zoom was reset
+	r.view = viewer.View{Zoom: 1, Flip: -1, Turns: -1}
+	return nil
+}
+
+func (r *renderer) SetView(v viewer.View) {
+	if v.Flip != r.view.Flip {
+		viewport.SetFlippingMode(viewport.TViewportFlippingModeID(v.Flip))
+	}
+	if v.Turns != r.view.Turns {
+		viewport.SetRotation(viewport.TViewportRotationID(v.Turns))
+	}
+	if v.Scale && !r.view.Scale {
+		viewport.ScaleImage()
+	}
+	if v.Zoom != r.view.Zoom {
+		viewport.SetZoomedArea(int32(v.Focus.X), int32(v.Focus.Y), int32(v.Zoom))
+	}
+	r.view = v
+}
+
+func (r *renderer) Center() image.Point {
+	x, y := viewport.ScreenCenter()
+	return image.Pt(int(x), int(y))
+}
+
+func setImage(g *Game, index int, path string, item playlist.Item) (err error) {
+
+	// Images too large for a texture are decoded in the background by the viewport, the others are loaded before creating it
+	config, _, err := imageio.Config(path)
+	tiled := err == nil && viewport.IsTooLarge(int32(config.Width), int32(config.Height))
+	var surface *sdl.Surface
+	if tiled {
+		g.size = image.Pt(config.Width, config.Height)
+	} else {
+		if surface, err = img.Load(path); err != nil {
+			return err
+		}
+		g.size = image.Pt(int(surface.W), int(surface.H))
+	}
+	g.name = path
+
+	// Prefer the playlist caption to the file name in the window title
+	title := g.name
+	if item.Caption != "" {
+		title = item.Caption
+	}
+
+	viewport.SetHudInfo(imageInfo(g, index))
 
 	// Initialize modules (no need to display an error message if a module initialization fails because the module already did)
-	if err = viewport.Initialize(g.title+g.name, g.image); err != nil {
-		log.Fatal(err)
+	if tiled {
+		err = viewport.InitializeTiled(g.title+title, path)
+	} else {
+		err = viewport.Initialize(g.title+title, surface)
+		surface.Free()
+	}
+	if err != nil {
 		return err
 	} // TODO set initial viewport size and window decorations according to parameters saved on previous program exit ?
-	g.image.Free()
+
+	// Display the image with its saved adjustments and crop
+	g.crop = image.Rectangle{}
+	if s, err := sidecar.Load(g.name); err != nil {
+		log.Println(err)
+	} else {
+		if s.Adjust != nil {
+			if err = viewport.SetAdjustment(*s.Adjust); err != nil {
+				log.Println(err)
+			}
+		}
+		if s.Crop != nil {
+			g.crop = s.Crop.Rectangle().Intersect(viewport.ImageBounds())
+		}
+	}
+	if g.cropping {
+		viewport.SetCropRectangle(g.crop)
+	} else {
+		viewport.SetCropRectangle(image.Rectangle{})
+	}
 
 	return
 }
+
+// imageInfo returns the heads-up display lines describing the image i, once loaded.
+func imageInfo(g *Game, i int) (lines []string) {
+	lines = append(lines, fmt.Sprintf("%d/%d  %s", i+1, g.viewer.Len(), g.name))
+	size := ""
+	if info, err := os.Stat(g.name); err == nil {
+		size = imagefs.FormatSize(info.Size())
+	}
+	lines = append(lines, fmt.Sprintf("%dx%d  %s", g.size.X, g.size.Y, size))
+	if e, err := exif.Read(g.name); err == nil {
+		if summary := e.Summary(); summary != "" {
+			lines = append(lines, summary)
+		}
+	}
+	return lines
+}
+
 func run(g *Game) (err error) {
 
 	var Frame_Starting_Time = uint32(0)
 	var Elapsed_Time uint32
 	var Mouse_X int32
 	var Mouse_Y int32
-	var Zoom_Factor = int32(1)
-	Flipping_Mode := viewport.TViewportFlippingModeID(viewport.FLIPPING_MODE_ID_NORMAL)
-	var Index = 0
+	var Help_Shown = false
+	var Dragging = false
+	var Crop_Preset = crop.PRESET_FREE
+	var Crop_From image.Point
 
 	// Initialize SDL before everything else, so other SDL libraries can be safely initialized
 	if err = sdl.Init(sdl.INIT_EVERYTHING); err != nil {
@@ -77,13 +197,247 @@ func run(g *Game) (err error) {
 	}
 	defer img.Quit()
 
-	if err = setImage(g, Index); err != nil {
+	if err = g.viewer.Show(0); err != nil {
 		log.Fatal(err)
 		return err
 	}
+	rate := func(rating int) keymap.Handler {
+		return func(keymap.Trigger) {
+			s, err := sidecar.Load(g.name)
+			if err == nil {
+				s.Rating = rating
+				err = s.Save(g.name)
+			}
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			fmt.Printf("Rated %s %d/5\n", g.name, rating)
+		}
+	}
 
 	// Process incoming SDL events
+	// Navigation, zoom, flipping and rotation are done by the viewer, the other actions are added to its own
 	running := true
+	actions := g.viewer.Actions()
+	actions.Register(keymap.ACTION_SAVE_ORIENTATION, "", func(keymap.Trigger) {
+		method, err := imageops.Persist(g.name, g.viewer.View().Transform(), imageops.Options{})
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		fmt.Printf("Saved the orientation of %s (%s)\n", g.name, method)
+		// Load the image as written
+		if err = g.viewer.Show(g.viewer.Index()); err != nil {
+			log.Fatal(err)
+		}
+	})
+	actions.Register(keymap.ACTION_DELETE, "", func(keymap.Trigger) {
+		if err := trash(g.name); err != nil {
+			log.Println(err)
+			return
+		}
+		fmt.Println("Moved", g.name, "to the trash")
+		switch err := g.viewer.Remove(); err {
+		case nil:
+		case viewer.ErrEmpty:
+			fmt.Println("No image left...")
+			running = false
+		default:
+			log.Fatal(err)
+		}
+	})
+	actions.Register(keymap.ACTION_COLLECT, "", func(keymap.Trigger) {
+		// Collect the current image in a playlist
+		if err := playlist.Append(g.collect, g.name); err != nil {
+			log.Println(err)
+		} else {
+			fmt.Println("Added", g.name, "to", g.collect)
+		}
+	})
+	actions.Register(keymap.ACTION_FULLSCREEN, "", func(keymap.Trigger) { viewport.ToggleFullscreen() })
+	actions.Register(keymap.ACTION_QUIT, "", func(keymap.Trigger) {
+		fmt.Println("Application quit...")
+		running = false
+	})
+	actions.Register(keymap.ACTION_HELP, "", func(keymap.Trigger) {
+		Help_Shown = !Help_Shown
+		var panel image.Image
+		if Help_Shown {
+			panel = overlay.Text(g.keys.Help(actions), overlay.DefaultStyle())
+		}
+		if err := viewport.SetOverlay(viewport.OVERLAY_ID_HELP, panel, viewport.ANCHOR_ID_CENTER); err != nil {
+			log.Println(err)
+		}
+	})
+	actions.Register(keymap.ACTION_HUD, "", func(keymap.Trigger) { viewport.ToggleHud() })
+	actions.Register(keymap.ACTION_HISTOGRAM, "", func(keymap.Trigger) { viewport.ToggleHistogram() })
+	actions.Register(keymap.ACTION_INSPECT, "", func(keymap.Trigger) {
+		if viewport.ToggleInspector() {
+			Mouse_X, Mouse_Y, _ = sdl.GetMouseState()
+			viewport.InspectPixel(Mouse_X, Mouse_Y)
+		}
+	})
+	// Color adjustments, applied to the displayed image only until they are saved or exported
+	adjustment := func(change func(r *adjust.Recipe)) keymap.Handler {
+		return func(keymap.Trigger) {
+			r := viewport.Adjustment()
+			change(&r)
+			r.Clamp()
+			if err := viewport.SetAdjustment(r); err != nil {
+				log.Println(err)
+			}
+			// Zoom has been reset when redrawing the image
+			g.viewer.ResetZoom()
+		}
+	}
+	actions.Register(keymap.ACTION_BRIGHTNESS_UP, "", adjustment(func(r *adjust.Recipe) { r.Brightness += adjust.BRIGHTNESS_STEP }))
+	actions.Register(keymap.ACTION_BRIGHTNESS_DOWN, "", adjustment(func(r *adjust.Recipe) { r.Brightness -= adjust.BRIGHTNESS_STEP }))
+	actions.Register(keymap.ACTION_CONTRAST_UP, "", adjustment(func(r *adjust.Recipe) { r.Contrast += adjust.CONTRAST_STEP }))
+	actions.Register(keymap.ACTION_CONTRAST_DOWN, "", adjustment(func(r *adjust.Recipe) { r.Contrast -= adjust.CONTRAST_STEP }))
+	actions.Register(keymap.ACTION_GAMMA_UP, "", adjustment(func(r *adjust.Recipe) { r.Gamma = r.GammaValue() * adjust.GAMMA_STEP }))
+	actions.Register(keymap.ACTION_GAMMA_DOWN, "", adjustment(func(r *adjust.Recipe) { r.Gamma = r.GammaValue() / adjust.GAMMA_STEP }))
+	actions.Register(keymap.ACTION_SATURATION_UP, "", adjustment(func(r *adjust.Recipe) { r.Saturation += adjust.SATURATION_STEP }))
+	actions.Register(keymap.ACTION_SATURATION_DOWN, "", adjustment(func(r *adjust.Recipe) { r.Saturation -= adjust.SATURATION_STEP }))
+	actions.Register(keymap.ACTION_EXPOSURE_UP, "", adjustment(func(r *adjust.Recipe) { r.Exposure += adjust.EXPOSURE_STEP }))
+	actions.Register(keymap.ACTION_EXPOSURE_DOWN, "", adjustment(func(r *adjust.Recipe) { r.Exposure -= adjust.EXPOSURE_STEP }))
+	actions.Register(keymap.ACTION_GRAYSCALE, "", adjustment(func(r *adjust.Recipe) { r.Grayscale = !r.Grayscale }))
+	actions.Register(keymap.ACTION_INVERT, "", adjustment(func(r *adjust.Recipe) { r.Invert = !r.Invert }))
+	actions.Register(keymap.ACTION_RESET, "", adjustment(func(r *adjust.Recipe) { *r = adjust.Recipe{} }))
+	actions.Register(keymap.ACTION_SAVE, "", func(keymap.Trigger) {
+		if err := saveEdits(g.name, viewport.Adjustment(), g.crop); err != nil {
+			log.Println(err)
+		} else {
+			fmt.Println("Saved the adjustments and crop of", g.name)
+		}
+	})
+	actions.Register(keymap.ACTION_EXPORT, "", func(keymap.Trigger) {
+		// Export at full resolution, only the crop rectangle when there is one
+		source := viewport.SourceImage()
+		if source == nil {
+			log.Println("The image is not decoded yet")
+			return
+		}
+		if !g.crop.Empty() {
+			source = imageops.Crop(source, g.crop)
+		}
+		path := imageio.FreePath(g.name, "edited", exportExtension(g.name))
+		edited := viewport.Adjustment().Apply(source)
+		if g.stamp != nil {
+			edited = g.stamp.Apply(edited, watermark.Fields(g.name, edited.Bounds().Size()))
+		}
+		if err := imageio.Save(path, edited, 0); err != nil {
+			log.Println(err)
+		} else {
+			fmt.Println("Exported", path)
+		}
+	})
+	// Crop rectangle, drawn with the mouse once cropping is started
+	setCrop := func(area image.Rectangle) {
+		g.crop = area
+		viewport.SetCropRectangle(area)
+		if area.Empty() {
+			fmt.Println("No crop")
+		} else {
+			fmt.Printf("Crop %dx%d at (%d, %d), %s\n", area.Dx(), area.Dy(), area.Min.X, area.Min.Y, Crop_Preset)
+		}
+	}
+	ratio := func() image.Point {
+		return Crop_Preset.Ratio(viewport.Rotation() == viewport.ROTATION_ID_90 || viewport.Rotation() == viewport.ROTATION_ID_270)
+	}
+	nudge := func(dx, dy int) keymap.Handler {
+		return func(keymap.Trigger) {
+			if g.crop.Empty() {
+				return
+			}
+			step := max(1, viewport.ImageBounds().Dx()/CROP_STEPS)
+			setCrop(crop.Move(g.crop, dx*step, dy*step, viewport.ImageBounds()))
+		}
+	}
+	resize := func(direction int) keymap.Handler {
+		return func(keymap.Trigger) {
+			if g.crop.Empty() {
+				return
+			}
+			step := max(1, viewport.ImageBounds().Dx()/CROP_STEPS)
+			setCrop(crop.Grow(g.crop, direction*step, ratio(), viewport.ImageBounds()))
+		}
+	}
+	actions.Register(keymap.ACTION_CROP, "", func(keymap.Trigger) {
+		g.cropping = !g.cropping
+		Dragging = false
+		if g.cropping {
+			viewport.SetCropRectangle(g.crop)
+			fmt.Println("Cropping, ratio", Crop_Preset)
+		} else {
+			viewport.SetCropRectangle(image.Rectangle{})
+		}
+	})
+	actions.Register(keymap.ACTION_CROP_PRESET, "", func(keymap.Trigger) {
+		Crop_Preset = Crop_Preset.Next()
+		fmt.Println("Crop ratio", Crop_Preset)
+		if !g.crop.Empty() {
+			setCrop(crop.Fit(g.crop, ratio(), viewport.ImageBounds()))
+		}
+	})
+	actions.Register(keymap.ACTION_CROP_CLEAR, "", func(keymap.Trigger) { setCrop(image.Rectangle{}) })
+	actions.Register(keymap.ACTION_CROP_LEFT, "", nudge(-1, 0))
+	actions.Register(keymap.ACTION_CROP_RIGHT, "", nudge(1, 0))
+	actions.Register(keymap.ACTION_CROP_UP, "", nudge(0, -1))
+	actions.Register(keymap.ACTION_CROP_DOWN, "", nudge(0, 1))
+	actions.Register(keymap.ACTION_CROP_GROW, "", resize(1))
+	actions.Register(keymap.ACTION_CROP_SHRINK, "", resize(-1))
+	for rating, name := range []string{keymap.ACTION_RATE_0, keymap.ACTION_RATE_1, keymap.ACTION_RATE_2, keymap.ACTION_RATE_3, keymap.ACTION_RATE_4, keymap.ACTION_RATE_5} {
+		actions.Register(name, "", rate(rating))
+	}
+
+	// Run a remote command as the matching key would
+	command := func(c *remote.Command) error {
+		switch c.Name {
+		case remote.COMMAND_GOTO:
+			index, err := c.Target(g.viewer.Paths())
+			if err != nil {
+				return err
+			}
+			return g.viewer.Show(index)
+		case remote.COMMAND_PAUSE:
+			return remote.ErrUnsupported
+		case remote.COMMAND_LOAD:
+			list, err := playlist.Load(c.Path)
+			if err != nil {
+				return err
+			}
+			if err = g.viewer.SetList(list); err != nil {
+				return fmt.Errorf("%s: %w", c.Path, err)
+			}
+			g.source = c.Path
+		case remote.COMMAND_ACTION:
+			if !actions.Run(c.Action, keymap.Trigger{}) {
+				return fmt.Errorf("unknown action %q", c.Action)
+			}
+		default:
+			actions.Run(c.KeymapAction(), keymap.Trigger{})
+		}
+		return nil
+	}
+	state := func() remote.State {
+		if g.viewer.Len() == 0 {
+			return remote.State{}
+		}
+		return remote.State{Image: g.viewer.Path(), Title: g.viewer.Title(), Index: g.viewer.Index(), Count: g.viewer.Len(), Playlist: g.source, Zoom: float64(g.viewer.View().Zoom)}
+	}
+
+	// Run the action bound to a key or mouse button
+	feed := func(stroke keymap.Stroke, ok bool, mouse bool) {
+		if !ok {
+			return
+		}
+		Mouse_X, Mouse_Y, _ = sdl.GetMouseState()
+		if action, found := g.keys.Feed(stroke); found {
+			actions.Run(action, keymap.Trigger{Stroke: stroke, Mouse: mouse, At: image.Pt(int(Mouse_X), int(Mouse_Y))})
+		}
+	}
+
 	for running {
 		// Keep the time corresponding to the frame rendering beginning
 		Frame_Starting_Time = sdl.GetTicks()
@@ -100,86 +454,37 @@ func run(g *Game) (err error) {
 				if t.Event == sdl.WINDOWEVENT_SIZE_CHANGED {
 					//fmt.Printf("Window size change to (%d, %d) %d %t\n", t.Data1, t.Data2, t.Event, t.Event == sdl.WINDOWEVENT_SIZE_CHANGED)
 					viewport.SetDimensions(t.Data1, t.Data2)
-					Zoom_Factor = 1 // Zoom has been reset when resizing the window
+					g.viewer.ResetZoom() // Zoom has been reset when resizing the window
 				}
 			case *sdl.MouseWheelEvent:
-				// Wheel is rotated toward the user, increment the zoom factor
-				if t.Y > 0 {
-					if Zoom_Factor < viewport.VIEWPORT_MAXIMUM_ZOOM_FACTOR {
-						Zoom_Factor *= 2
-					}
-				} else { // Wheel is rotated away from the user, decrement the zoom factor
-					if Zoom_Factor > 1 {
-						Zoom_Factor /= 2
+				stroke, ok := sdlkeys.Wheel(t)
+				feed(stroke, ok, true)
+
+			case *sdl.MouseButtonEvent:
+				// The left button draws the crop rectangle while cropping
+				if g.cropping && t.Button == sdl.BUTTON_LEFT {
+					if t.Type == sdl.MOUSEBUTTONDOWN {
+						Dragging = true
+						Crop_From = viewport.WindowToImageClamped(t.X, t.Y)
+					} else {
+						Dragging = false
 					}
+					continue
+				}
+				if t.Type == sdl.MOUSEBUTTONDOWN {
+					stroke, ok := sdlkeys.Button(t)
+					feed(stroke, ok, true)
 				}
-				// Start zooming area from the mouse coordinates
-				Mouse_X, Mouse_Y, _ = sdl.GetMouseState()
-				viewport.SetZoomedArea(Mouse_X, Mouse_Y, Zoom_Factor)
 
 			case *sdl.KeyboardEvent:
 				if t.Type == sdl.KEYDOWN {
-					// Toggle image flipping
-					switch t.Keysym.Sym {
-					case sdl.K_f:
-						// Set next available flipping mode
-						Flipping_Mode++
-						if Flipping_Mode >= viewport.FLIPPING_MODE_IDS_COUNT {
-							Flipping_Mode = viewport.FLIPPING_MODE_ID_NORMAL
-						}
-						viewport.SetFlippingMode(Flipping_Mode)
-
-						// Zoom has been reset when flipping the image
-						Zoom_Factor = 1
-					case sdl.K_ESCAPE:
-						fmt.Println("Application quit...")
-						running = false
-					case sdl.K_q:
-						fmt.Println("Application quit...")
-						running = false
-					case sdl.K_s:
-						// Scale image to fit viewport
-						viewport.ScaleImage()
-						// Reset zoom
-						Zoom_Factor = 1
-					case sdl.K_RIGHT:
-						fallthrough
-					case sdl.K_SPACE:
-						Index++
-						if Index == len(g.paths) {
-							Index = 0
-						}
-						if err = setImage(g, Index); err != nil {
-							log.Fatal(err)
-							return err
-						}
-					case sdl.K_LEFT:
-						Index--
-						if Index < 0 {
-							Index = len(g.paths) - 1
-						}
-						if err = setImage(g, Index); err != nil {
-							log.Fatal(err)
-							return err
-						}
-					case sdl.K_UP:
-						if Zoom_Factor < viewport.VIEWPORT_MAXIMUM_ZOOM_FACTOR {
-							Zoom_Factor *= 2
-						}
-						Mouse_X, Mouse_Y = viewport.ScreenCenter()
-						viewport.SetZoomedArea(Mouse_X, Mouse_Y, Zoom_Factor)
-					case sdl.K_DOWN:
-						if Zoom_Factor > 1 {
-							Zoom_Factor /= 2
-						}
-						Mouse_X, Mouse_Y = viewport.ScreenCenter()
-						viewport.SetZoomedArea(Mouse_X, Mouse_Y, Zoom_Factor)
-					}
+					stroke, ok := sdlkeys.Key(t)
+					feed(stroke, ok, false)
 				}
 			case *sdl.MouseMotionEvent:
 				if t.Type == sdl.MOUSEMOTION {
 					// Do not recompute everything when the image is not zoomed
-					if Zoom_Factor > 1 {
+					if Zoom_Factor := int32(g.viewer.View().Zoom); Zoom_Factor > 1 {
 						// Successively zoom to the current zoom level to make sure the internal ViewportSetZoomedArea() data are consistent
 						i := int32(1)
 						for i <= Zoom_Factor {
@@ -187,11 +492,32 @@ func run(g *Game) (err error) {
 							i <<= 1
 						}
 					}
+					// Report the pixel under the mouse once the view is updated
+					viewport.InspectPixel(t.X, t.Y)
+					if Dragging {
+						setCrop(crop.Drag(Crop_From, viewport.WindowToImageClamped(t.X, t.Y), ratio(), viewport.ImageBounds()))
+					}
 				}
 			default:
 				//fmt.Printf("[%d ms] Unknown\ttype:%d\n", t.GetTimestamp(), t.GetType())
 			}
 		}
+		// A key waiting for the end of a chord that never came runs its own action
+		if action, ok := g.keys.Expire(); ok {
+			actions.Run(action, keymap.Trigger{})
+		}
+		if g.remote != nil {
+			for waiting := running; waiting; {
+				select {
+				case c := <-g.remote.Commands():
+					c.Done(command(c))
+					waiting = running
+				default:
+					waiting = false
+				}
+			}
+			g.remote.Publish(state())
+		}
 		viewport.DrawImage()
 
 		// Wait enough time to get a 60Hz refresh rate
@@ -203,15 +529,91 @@ func run(g *Game) (err error) {
 	return
 }
 
+// saveEdits keeps the color adjustments and the crop rectangle in the image sidecar, or removes them when there is none.
+func saveEdits(name string, r adjust.Recipe, area image.Rectangle) error {
+	s, err := sidecar.Load(name)
+	if err != nil {
+		return err
+	}
+	s.Adjust = nil
+	if !r.IsZero() {
+		s.Adjust = &r
+	}
+	s.Crop = crop.FromRectangle(area)
+	return s.Save(name)
+}
+
+// exportExtension keeps the image format when it can be written, JPEG otherwise.
+func exportExtension(name string) string {
+	if imageio.Format(name) == "" {
+		return ".jpg"
+	}
+	return filepath.Ext(name)
+}
+
+// trash moves an image and its sidecar to the .trash directory next to it.
+func trash(path string) (err error) {
+	dir := filepath.Join(filepath.Dir(path), TRASH_DIRECTORY)
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if err = os.Rename(path, filepath.Join(dir, filepath.Base(path))); err != nil {
+		return err
+	}
+	if side := sidecar.Path(path); fileExists(side) {
+		err = os.Rename(side, filepath.Join(dir, filepath.Base(side)))
+	}
+	return err
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
 func main() {
-	const usage = `Usage of viewport:
--r, --randomize mize the file list
+	const usage = `Usage of viewport: viewport [options] [directory]
+-r, --randomize randomize the file list
+-p, --playlist FILE display the images of a playlist (.m3u, .txt, .json or .yaml) instead of a directory
+--save-playlist FILE save the file list as a playlist before displaying it
+--collect FILE playlist the current image is appended to by the collect action (default collected.m3u)
+--keymap FILE key bindings file (default ~/.config/imagination/keymap.conf)
+--hud show the image information panel at startup (toggled with 'i')
+--hud-position POSITION information panel place: center, top-left, top-right, bottom-left or bottom-right
+--hud-opacity OPACITY information panel background opacity, from 0 to 1
+--width PIXELS, --height PIXELS initial window size
+--fullscreen cover the whole desktop
+--background COLOR color around the image (#rrggbb)
+--remote ADDRESS accept remote control commands on a TCP address (localhost:7070) or a Unix socket path
+--config FILE configuration file (.toml or .yaml)
 -h, --help prints help information 
 `
+	cfg := config.MustLoad()
+	// The viewer shows the current directory unless a configuration sets another one
 	dir := "."
+	if cfg.Assets != config.Default().Assets {
+		dir = cfg.Assets
+	}
 	var randomize bool
+	var playlistPath, savePath, collectPath, background, keymapPath, remoteAddress string
+	width, height := cfg.Window.Width, cfg.Window.Height
+	fullscreen := cfg.Window.Fullscreen
+	config.AddFlag()
 	flag.BoolVar(&randomize, "randomize", false, "Randomize the file list")
 	flag.BoolVar(&randomize, "r", false, "Randomize the file list")
+	flag.StringVar(&playlistPath, "playlist", "", "Playlist to display")
+	flag.StringVar(&playlistPath, "p", "", "Playlist to display")
+	flag.StringVar(&savePath, "save-playlist", "", "Save the file list as a playlist")
+	flag.StringVar(&collectPath, "collect", "collected.m3u", "Playlist the current image is appended to")
+	flag.IntVar(&width, "width", width, "Initial window width")
+	flag.IntVar(&height, "height", height, "Initial window height")
+	flag.BoolVar(&fullscreen, "fullscreen", fullscreen, "Cover the whole desktop")
+	flag.StringVar(&background, "background", cfg.Background, "Color around the image")
+	flag.StringVar(&keymapPath, "keymap", cfg.Keymap, "Key bindings file")
+	flag.BoolVar(&cfg.Hud.Visible, "hud", cfg.Hud.Visible, "Show the image information panel")
+	flag.StringVar(&cfg.Hud.Position, "hud-position", cfg.Hud.Position, "Information panel place")
+	flag.Float64Var(&cfg.Hud.Opacity, "hud-opacity", cfg.Hud.Opacity, "Information panel background opacity")
+	flag.StringVar(&remoteAddress, "remote", cfg.Remote, "Remote control address")
 	flag.Usage = func() { fmt.Print(usage) }
 	flag.Parse()
 	if randomize {
@@ -221,8 +623,58 @@ func main() {
 	if len(flag.Args()) > 0 {
 		dir = flag.Args()[0]
 	}
-	g := NewGame(dir, randomize)
-	if err := run(g); err != nil {
+
+	color, err := fit.ParseColor(background)
+	if err != nil {
+		log.Fatal(err)
+	}
+	viewport.SetBackgroundColor(sdl.Color{R: color.R, G: color.G, B: color.B, A: color.A})
+	viewport.SetWindowSize(int32(width), int32(height))
+	viewport.SetFullscreen(fullscreen)
+	anchor, ok := viewport.AnchorFromName(cfg.Hud.Position)
+	if !ok {
+		log.Fatalf("unknown HUD position %q", cfg.Hud.Position)
+	}
+	viewport.SetHudStyle(anchor, cfg.Hud.Opacity)
+	viewport.ShowHud(cfg.Hud.Visible)
+
+	var list *playlist.Playlist
+	if playlistPath != "" {
+		if list, err = playlist.Load(playlistPath); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		list = playlist.FromPaths(dir, imagefs.AllFilesByExt(dir, cfg.Extensions))
+	}
+
+	g := NewGame(list, randomize)
+	g.collect = collectPath
+	if g.keys, err = keymap.Load(keymapPath); err != nil {
+		log.Fatal(err)
+	}
+	if g.stamp, err = watermark.New(watermark.Settings(cfg.Watermark)); err != nil {
+		log.Fatal(err)
+	}
+	if g.viewer.Len() == 0 {
+		log.Fatal("No image to display")
+	}
+	if savePath != "" {
+		if err = list.Save(savePath); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if remoteAddress != "" {
+		if g.remote, err = remote.Listen(remoteAddress); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("Remote control on", g.remote.Addr())
+	}
+	g.source = playlistPath
+	err = run(g)
+	if g.remote != nil {
+		g.remote.Close()
+	}
+	if err != nil {
 		os.Exit(1)
 	}
 
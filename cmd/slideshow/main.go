@@ -1,43 +1,124 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"image"
+	"image/color"
 	_ "image/jpeg"
 	_ "image/png"
 	"log"
 
-	"math/rand"
+	"math"
 	"time"
 
 	"github.com/hajimehoshi/ebiten/v2"
 	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"golang.org/x/image/draw"
 
+	"github.com/nicky-ayoub/imagination/internal/pkg/config"
+	"github.com/nicky-ayoub/imagination/internal/pkg/fit"
 	"github.com/nicky-ayoub/imagination/internal/pkg/imagefs"
+	"github.com/nicky-ayoub/imagination/internal/pkg/imageops"
+	"github.com/nicky-ayoub/imagination/internal/pkg/keymap"
+	"github.com/nicky-ayoub/imagination/internal/pkg/keymap/ebitenkeys"
+	"github.com/nicky-ayoub/imagination/internal/pkg/overlay"
+	"github.com/nicky-ayoub/imagination/internal/pkg/playlist"
+	"github.com/nicky-ayoub/imagination/internal/pkg/remote"
+	"github.com/nicky-ayoub/imagination/internal/pkg/slidesync"
+	"github.com/nicky-ayoub/imagination/internal/pkg/viewer"
 )
 
-func getName(g *Game) {
-	randomIndex := rand.Intn(len(g.paths))
-	g.name = g.paths[randomIndex]
+// Filtering qualities, from the fastest to the smoothest.
+const (
+	FILTER_NEAREST = "nearest" // GPU nearest neighbour, crisp pixels
+	FILTER_LINEAR  = "linear"  // GPU bilinear filtering
+	FILTER_HIGH    = "high"    // CPU Catmull-Rom resampling each time the displayed size changes
+)
+
+/** How long a fade transition between two images lasts. */
+const FADE_DURATION = 500 * time.Millisecond
+
+// Display settings
+type Display struct {
+	width      int
+	height     int
+	fullscreen bool
+	mode       fit.Mode
+	upscale    bool
+	letterbox  color.RGBA
+	filter     string
+	interval   time.Duration // Default time an image stays displayed, 0 waits for the space key
 }
 
-func openImage(g *Game) (err error) {
-	getName(g)
-	g.img, _, err = ebitenutil.NewImageFromFile(g.root + "/" + g.name)
+// Picture is an image ready to be displayed
+type Picture struct {
+	img       *ebiten.Image
+	src       image.Image
+	view      viewer.View        // How the viewer displays it
+	scaled    *ebiten.Image      // img transformed and resampled on the CPU to the displayed size (high quality filter only)
+	scaledFor imageops.Transform // Transform scaled was made with
+}
 
-	return err
+// renderer displays the images of the viewer in the game.
+type renderer struct {
+	g *Game
 }
 
-func NewGame() *Game {
-	var err error
-	g := &Game{}
+func (r *renderer) Load(index int, path string, item playlist.Item) (err error) {
+	g := r.g
+	pic := &Picture{}
+	if pic.img, pic.src, err = ebitenutil.NewImageFromFile(path); err != nil {
+		return err
+	}
+	// Only show the playlist crop rectangle when there is one
+	if item.Crop != nil {
+		r := image.Rect(item.Crop.X, item.Crop.Y, item.Crop.X+item.Crop.W, item.Crop.Y+item.Crop.H).Intersect(pic.src.Bounds())
+		if !r.Empty() {
+			pic.img = pic.img.SubImage(r).(*ebiten.Image)
+			pic.src = imageops.Crop(pic.src, r)
+		}
+	}
+
+	g.previous = nil
+	if item.Transition == playlist.TRANSITION_FADE {
+		g.previous = g.current
+	}
+	g.current = pic
+	g.shownAt = time.Now()
+	g.duration = g.display.interval
+	if item.Duration > 0 {
+		g.duration = time.Duration(item.Duration)
+	}
+	g.titleSet = false
+
+	return nil
+}
+
+func (r *renderer) SetView(v viewer.View) {
+	r.g.current.view = v
+}
+
+func (r *renderer) Center() image.Point {
+	return r.g.size.Div(2)
+}
+
+func NewGame(display Display, list *playlist.Playlist, random bool, keys *keymap.Keymap) *Game {
+	g := &Game{display: display, keys: keys}
+	g.size = image.Pt(display.width, display.height)
+	g.viewer = viewer.New(list, &renderer{g: g})
 	seed := time.Now().Unix()
-	rand.Seed(seed)
 	fmt.Println("Seed : ", seed)
-	g.root = "../../assets"
-	g.paths = imagefs.AllJpgFiles(g.root)
+	if random {
+		g.viewer.Shuffle(seed)
+	}
+	if g.viewer.Len() == 0 {
+		log.Fatal("No image to display")
+	}
+	g.registerActions()
 
-	err = openImage(g)
-	if err != nil {
+	if err := g.viewer.Show(0); err != nil {
 		fmt.Println("Fatal in init()")
 		log.Fatal(err)
 	}
@@ -45,57 +126,342 @@ func NewGame() *Game {
 }
 
 type Game struct {
-	img   *ebiten.Image
-	name  string
-	paths []string
-	root  string
+	viewer   *viewer.Viewer
+	current  *Picture
+	previous *Picture // Picture faded out during a transition
+	display  Display
+	size     image.Point // Screen size, in device pixels
+	shownAt  time.Time
+	duration time.Duration
+	titleSet bool
+	paused   bool
+	keys     *keymap.Keymap
+	actions  *keymap.Registry
+	help     *ebiten.Image       // Key bindings panel, nil when hidden
+	remote   *remote.Server      // Remote control endpoint, nil for none
+	playlist string              // Playlist file shown, empty for a directory
+	leader   *slidesync.Leader   // Sends the position of the show to other slideshows, nil for none
+	follower *slidesync.Follower // Receives the position of the show from a leader, nil for none
 }
 
-func TryNextImage(g *Game) (bool, bool) {
+/** Returned by Update to stop the game loop. */
+var errQuit = errors.New("quit")
+
+// registerActions adds what only the slideshow does to the viewer actions.
+func (g *Game) registerActions() {
+	g.actions = g.viewer.Actions()
+	g.actions.Register(keymap.ACTION_PAUSE, "", func(keymap.Trigger) {
+		g.paused = !g.paused
+		g.shownAt = time.Now() // Resuming shows the image for a whole interval
+	})
+	g.actions.Register(keymap.ACTION_FULLSCREEN, "", func(keymap.Trigger) { ebiten.SetFullscreen(!ebiten.IsFullscreen()) })
+	g.actions.Register(keymap.ACTION_HELP, "", func(keymap.Trigger) {
+		if g.help != nil {
+			g.help.Dispose()
+			g.help = nil
+			return
+		}
+		g.help = ebiten.NewImageFromImage(overlay.Text(g.keys.Help(g.actions), overlay.DefaultStyle()))
+	})
+}
 
-	if ebiten.IsKeyPressed(ebiten.KeySpace) {
-		return true, false
+// runCommand runs a remote command as the matching key would.
+func (g *Game) runCommand(c *remote.Command) error {
+	switch c.Name {
+	case remote.COMMAND_GOTO:
+		index, err := c.Target(g.viewer.Paths())
+		if err != nil {
+			return err
+		}
+		return g.viewer.Show(index)
+	case remote.COMMAND_PAUSE:
+		if c.Paused == nil || *c.Paused != g.paused {
+			g.actions.Run(keymap.ACTION_PAUSE, keymap.Trigger{})
+		}
+	case remote.COMMAND_LOAD:
+		list, err := playlist.Load(c.Path)
+		if err != nil {
+			return err
+		}
+		// Keep showing the current images when the first one of the playlist can not be opened
+		if err = g.viewer.SetList(list); err != nil {
+			return fmt.Errorf("%s: %w", c.Path, err)
+		}
+		g.playlist = c.Path
+	case remote.COMMAND_ACTION:
+		if !g.actions.Run(c.Action, keymap.Trigger{}) {
+			return fmt.Errorf("unknown action %q", c.Action)
+		}
+	default:
+		g.actions.Run(c.KeymapAction(), keymap.Trigger{})
 	}
+	return nil
+}
 
-	if ebiten.IsKeyPressed(ebiten.KeyEscape) || ebiten.IsKeyPressed(ebiten.KeyQ) {
-		return true, true
+// state returns what the slideshow shows, published to the remote control clients.
+func (g *Game) state() remote.State {
+	return remote.State{
+		Image:    g.viewer.Path(),
+		Title:    g.viewer.Title(),
+		Index:    g.viewer.Index(),
+		Count:    g.viewer.Len(),
+		Playlist: g.playlist,
+		Paused:   g.paused,
+		Zoom:     float64(g.viewer.View().Zoom),
 	}
-	return false, false
 }
 
 func (g *Game) Update() (err error) {
-
-	go_to_next, quit := TryNextImage(g)
-	if quit {
-		log.Fatal("Exiting...")
-		return err
+	if g.remote != nil {
+		for waiting := true; waiting; {
+			select {
+			case c := <-g.remote.Commands():
+				c.Done(g.runCommand(c))
+			default:
+				waiting = false
+			}
+		}
 	}
-	if go_to_next {
-		err = openImage(g) // Generate a new image
-		if err != nil {
+	x, y := ebiten.CursorPosition()
+	for _, stroke := range ebitenkeys.Strokes() {
+		if action, ok := g.keys.Feed(stroke); ok {
+			g.actions.Run(action, keymap.Trigger{Stroke: stroke, Mouse: stroke.IsMouse(), At: image.Pt(x, y)})
+		}
+	}
+	if action, ok := g.keys.Expire(); ok {
+		g.actions.Run(action, keymap.Trigger{})
+	}
+	if g.viewer.Quitting() {
+		return errQuit
+	}
+
+	// Advance automatically once the image has been displayed long enough, as the leader does when following one
+	following := g.follower != nil && g.follow()
+	if !following && !g.paused && g.duration > 0 && time.Since(g.shownAt) >= g.duration {
+		if err = g.viewer.Next(); err != nil {
 			fmt.Println("Fatal in init()")
 			log.Fatal(err)
 		}
 	}
+	if g.remote != nil {
+		g.remote.Publish(g.state())
+	}
+	if g.leader != nil {
+		g.leader.Publish(slidesync.State{Index: g.viewer.Index(), Count: g.viewer.Len(), ShownAt: g.shownAt.UnixNano(), Duration: g.duration, Paused: g.paused})
+	}
 	return nil
 }
 
+// follow shows the image the leader shows, since the same moment, and tells
+// whether the leader was heard from. Images are taken in order, from the
+// first again when the leader has more.
+func (g *Game) follow() bool {
+	s, ok := g.follower.State()
+	if !ok {
+		return false
+	}
+	index, shownAt := s.At(g.follower.Now())
+	if index %= g.viewer.Len(); index != g.viewer.Index() {
+		if err := g.viewer.Show(index); err != nil {
+			log.Fatal(err)
+		}
+	}
+	g.shownAt = g.follower.Local(shownAt) // Fades run in step too
+	g.paused = s.Paused
+	return true
+}
+
 func (g *Game) Draw(screen *ebiten.Image) {
-	ebiten.SetWindowSize(g.img.Bounds().Dx(), g.img.Bounds().Dy())
-	ebiten.SetWindowTitle("Showing - " + g.name)
-	screen.DrawImage(g.img, nil)
+	if !g.titleSet {
+		ebiten.SetWindowTitle("Showing - " + g.viewer.Title())
+		g.titleSet = true
+	}
+	screen.Fill(g.display.letterbox)
+
+	if g.previous == nil {
+		g.current.draw(screen, g.display, 1)
+	} else {
+		// Cross-fade from the previous picture
+		progress := float64(time.Since(g.shownAt)) / float64(FADE_DURATION)
+		if progress >= 1 {
+			g.previous = nil
+			progress = 1
+		} else {
+			g.previous.draw(screen, g.display, 1-progress)
+		}
+		g.current.draw(screen, g.display, progress)
+	}
+
+	if g.help != nil {
+		w, h := g.help.Size()
+		op := &ebiten.DrawImageOptions{}
+		op.GeoM.Translate(float64(screen.Bounds().Dx()-w)/2, float64(screen.Bounds().Dy()-h)/2)
+		screen.DrawImage(g.help, op)
+	}
+}
+
+func (p *Picture) draw(screen *ebiten.Image, display Display, alpha float64) {
+	t := p.view.Transform()
+	iw, ih := p.img.Size()
+	w, h := iw, ih // Displayed size, once turned
+	if t.SwapsSize() {
+		w, h = h, w
+	}
+	dst := fit.Rect(w, h, screen.Bounds().Dx(), screen.Bounds().Dy(), display.mode, display.upscale || p.view.Scale)
+	if dst.Empty() {
+		return
+	}
+	zoom := float64(p.view.Zoom)
+	if zoom > 1 {
+		// Enlarge around the point the zoom was aimed at
+		f := p.view.Focus
+		dst = image.Rect(
+			f.X+int(float64(dst.Min.X-f.X)*zoom), f.Y+int(float64(dst.Min.Y-f.Y)*zoom),
+			f.X+int(float64(dst.Max.X-f.X)*zoom), f.Y+int(float64(dst.Max.Y-f.Y)*zoom))
+	}
+
+	op := &ebiten.DrawImageOptions{}
+	op.ColorM.Scale(1, 1, 1, alpha)
+	switch {
+	case display.filter == FILTER_HIGH && zoom <= 1:
+		// Transform and resample once per displayed size, then draw the result pixel for pixel
+		if p.scaled == nil || p.scaled.Bounds().Size() != dst.Size() || p.scaledFor != t {
+			if p.scaled != nil {
+				p.scaled.Dispose()
+			}
+			src := p.src
+			if !t.IsIdentity() {
+				src = imageops.Apply(p.src, t)
+			}
+			rgba := image.NewRGBA(image.Rect(0, 0, dst.Dx(), dst.Dy()))
+			draw.CatmullRom.Scale(rgba, rgba.Bounds(), src, src.Bounds(), draw.Src, nil)
+			p.scaled, p.scaledFor = ebiten.NewImageFromImage(rgba), t
+		}
+		op.GeoM.Translate(float64(dst.Min.X), float64(dst.Min.Y))
+		screen.DrawImage(p.scaled, op)
+		return
+	case display.filter != FILTER_NEAREST:
+		// Zoomed pictures are too large to be resampled on the CPU each time
+		op.Filter = ebiten.FilterLinear
+	default:
+		op.Filter = ebiten.FilterNearest
+	}
+	op.GeoM = orient(iw, ih, t)
+	op.GeoM.Scale(fit.Scale(w, h, dst))
+	op.GeoM.Translate(float64(dst.Min.X), float64(dst.Min.Y))
+	screen.DrawImage(p.img, op)
+}
+
+// orient returns the geometry drawing an image flipped and turned as a
+// transform tells, its top left corner staying at the origin.
+func orient(width int, height int, t imageops.Transform) (m ebiten.GeoM) {
+	m.Translate(-float64(width)/2, -float64(height)/2)
+	if t.Mirror {
+		m.Scale(-1, 1)
+	}
+	m.Rotate(float64(t.Turns) * math.Pi / 2)
+	if t.SwapsSize() {
+		width, height = height, width
+	}
+	m.Translate(float64(width)/2, float64(height)/2)
+	return m
 }
 
 func (g *Game) Layout(outsideWidth, outsideHeight int) (screenWidth, screenHeight int) {
-	return g.img.Bounds().Dx(), g.img.Bounds().Dy()
+	// Render at the device resolution so HiDPI screens get every pixel of the image
+	scale := ebiten.DeviceScaleFactor()
+	g.size = image.Pt(int(float64(outsideWidth)*scale), int(float64(outsideHeight)*scale))
+	return g.size.X, g.size.Y
 }
 
 func main() {
-	g := NewGame()
-	ebiten.SetWindowSize(g.img.Bounds().Dx(), g.img.Bounds().Dy())
-	ebiten.SetWindowTitle("Showing - " + g.name)
-	fmt.Println(g.img.Bounds())
-	if err := ebiten.RunGame(g); err != nil {
+	var display Display
+	var mode, letterbox, playlistPath, savePath, keymapPath, remoteAddress, leadAddress, followAddress string
+	var list *playlist.Playlist
+	var err error
+
+	cfg := config.MustLoad()
+	config.AddFlag()
+	flag.IntVar(&display.width, "width", cfg.Window.Width, "Window width in pixels")
+	flag.IntVar(&display.height, "height", cfg.Window.Height, "Window height in pixels")
+	flag.BoolVar(&display.fullscreen, "fullscreen", cfg.Window.Fullscreen, "Start in fullscreen mode")
+	flag.StringVar(&mode, "fit", cfg.Slideshow.Fit, "Scaling mode: fit, fill or stretch")
+	flag.BoolVar(&display.upscale, "upscale", cfg.Slideshow.Upscale, "Enlarge images smaller than the window")
+	flag.StringVar(&letterbox, "letterbox", cfg.Slideshow.Letterbox, "Letterbox color (#rrggbb, black, white or gray)")
+	flag.StringVar(&display.filter, "filter", cfg.Slideshow.Filter, "Filtering quality: nearest, linear or high")
+	flag.DurationVar(&display.interval, "interval", time.Duration(cfg.Slideshow.Interval), "Time each image is displayed (0, the default, waits for the space key)")
+	flag.StringVar(&playlistPath, "playlist", "", "Show a playlist (.m3u, .txt, .json or .yaml) in order instead of random directory images")
+	flag.StringVar(&savePath, "save-playlist", "", "Save the images to show as a playlist")
+	flag.StringVar(&keymapPath, "keymap", cfg.Keymap, "Key bindings file")
+	flag.StringVar(&remoteAddress, "remote", cfg.Remote, "Accept remote control commands on a TCP address (localhost:7070) or a Unix socket path")
+	flag.StringVar(&leadAddress, "sync-lead", "", "Lead the slideshows following this one, listening on a TCP address (:7080)")
+	flag.StringVar(&followAddress, "sync-follow", "", "Follow the slideshow leading at a TCP address (screen1.local:7080), showing the images in order")
+	flag.Parse()
+
+	if display.mode, err = fit.ParseMode(mode); err != nil {
+		log.Fatal(err)
+	}
+	if display.letterbox, err = fit.ParseColor(letterbox); err != nil {
+		log.Fatal(err)
+	}
+	switch display.filter {
+	case FILTER_NEAREST, FILTER_LINEAR, FILTER_HIGH:
+	default:
+		log.Fatalf("unknown filter %q (want nearest, linear or high)", display.filter)
+	}
+
+	if playlistPath != "" {
+		if list, err = playlist.Load(playlistPath); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		root := cfg.Assets
+		if len(flag.Args()) > 0 {
+			root = flag.Args()[0]
+		}
+		list = playlist.FromPaths(root, imagefs.AllFilesByExt(root, cfg.Extensions))
+	}
+	if savePath != "" {
+		if err = list.Save(savePath); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	keys, err := keymap.Load(keymapPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if leadAddress != "" && followAddress != "" {
+		log.Fatal("a slideshow can not both lead and follow")
+	}
+
+	g := NewGame(display, list, playlistPath == "" && followAddress == "", keys)
+	g.playlist = playlistPath
+	if remoteAddress != "" {
+		if g.remote, err = remote.Listen(remoteAddress); err != nil {
+			log.Fatal(err)
+		}
+		defer g.remote.Close()
+		fmt.Println("Remote control on", g.remote.Addr())
+	}
+	if leadAddress != "" {
+		if g.leader, err = slidesync.Lead(leadAddress); err != nil {
+			log.Fatal(err)
+		}
+		defer g.leader.Close()
+		fmt.Println("Leading on", g.leader.Addr())
+	}
+	if followAddress != "" {
+		g.follower = slidesync.Follow(followAddress)
+		defer g.follower.Close()
+	}
+	ebiten.SetWindowSize(display.width, display.height)
+	ebiten.SetWindowResizable(true)
+	ebiten.SetFullscreen(display.fullscreen)
+	ebiten.SetWindowTitle("Showing - " + g.viewer.Title())
+	fmt.Println(g.current.img.Bounds())
+	if err := ebiten.RunGame(g); err != nil && err != errQuit {
 		log.Fatal(err)
 	}
 }
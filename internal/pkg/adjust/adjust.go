@@ -0,0 +1,157 @@
+// Package adjust applies color adjustments (brightness, contrast, gamma,
+// saturation, exposure, grayscale and invert) to images on the CPU.
+//
+// A Recipe is small enough to be kept in the image sidecar, so adjustments are
+// non-destructive until they are exported to a new file.
+package adjust
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"strings"
+)
+
+// Value ranges and the step the viewers use for each key press.
+const (
+	BRIGHTNESS_STEP = 0.05    // Added to the channels, from -1 to 1
+	CONTRAST_STEP   = 0.05    // From -1 (flat gray) to 1 (maximum), 0 is neutral
+	GAMMA_STEP      = 1.1     // Gamma is multiplied or divided by this step, from 0.1 to 10
+	SATURATION_STEP = 0.1     // From -1 (grayscale) to 1 (twice as saturated)
+	EXPOSURE_STEP   = 1.0 / 3 // In stops, from -5 to 5
+)
+
+// Recipe lists the adjustments to apply. The zero value leaves images unchanged.
+type Recipe struct {
+	Brightness float64 `json:"brightness,omitempty"`
+	Contrast   float64 `json:"contrast,omitempty"`
+	Gamma      float64 `json:"gamma,omitempty"` // 0 means 1, the neutral gamma
+	Saturation float64 `json:"saturation,omitempty"`
+	Exposure   float64 `json:"exposure,omitempty"`
+	Grayscale  bool    `json:"grayscale,omitempty"`
+	Invert     bool    `json:"invert,omitempty"`
+}
+
+// IsZero tells whether the recipe leaves images unchanged.
+func (r Recipe) IsZero() bool {
+	r.Clamp()
+	return r == Recipe{}
+}
+
+// Clamp brings every value back in its range and rounds away the steps accumulated error.
+func (r *Recipe) Clamp() {
+	round := func(v float64) float64 { return math.Round(v*1000) / 1000 }
+	r.Brightness = round(math.Max(-1, math.Min(1, r.Brightness)))
+	r.Contrast = round(math.Max(-1, math.Min(1, r.Contrast)))
+	r.Saturation = round(math.Max(-1, math.Min(1, r.Saturation)))
+	r.Exposure = round(math.Max(-5, math.Min(5, r.Exposure)))
+	if r.Gamma != 0 {
+		r.Gamma = round(math.Max(0.1, math.Min(10, r.Gamma)))
+	}
+	if r.Gamma == 1 {
+		r.Gamma = 0
+	}
+}
+
+// GammaValue returns the gamma to apply, 1 when it is not set.
+func (r Recipe) GammaValue() float64 {
+	if r.Gamma == 0 {
+		return 1
+	}
+	return r.Gamma
+}
+
+// String describes the recipe on one line, like "brightness +0.10  gamma 1.21  grayscale".
+func (r Recipe) String() string {
+	var parts []string
+	for _, v := range []struct {
+		name  string
+		value float64
+	}{
+		{"brightness", r.Brightness},
+		{"contrast", r.Contrast},
+		{"saturation", r.Saturation},
+		{"exposure", r.Exposure},
+	} {
+		if v.value != 0 {
+			parts = append(parts, fmt.Sprintf("%s %+.2f", v.name, v.value))
+		}
+	}
+	if r.Gamma != 0 {
+		parts = append(parts, fmt.Sprintf("gamma %.2f", r.Gamma))
+	}
+	if r.Grayscale {
+		parts = append(parts, "grayscale")
+	}
+	if r.Invert {
+		parts = append(parts, "invert")
+	}
+	if len(parts) == 0 {
+		return "no adjustment"
+	}
+	return strings.Join(parts, "  ")
+}
+
+// table returns the channel transfer function: exposure, brightness, contrast, then gamma.
+func (r Recipe) table() (lut [256]uint8) {
+	exposure := math.Pow(2, r.Exposure)
+	c := math.Max(-0.95, math.Min(0.95, r.Contrast))
+	contrast := (1 + c) / (1 - c)
+	gamma := 1 / r.GammaValue()
+	for i := range lut {
+		v := float64(i) / 255 * exposure
+		v += r.Brightness
+		v = (v-0.5)*contrast + 0.5
+		v = math.Max(0, math.Min(1, v))
+		v = math.Pow(v, gamma)
+		lut[i] = uint8(math.Round(v * 255))
+	}
+	return lut
+}
+
+// Apply returns a copy of src with the recipe applied. src is returned as is when the recipe is neutral.
+func (r Recipe) Apply(src image.Image) image.Image {
+	r.Clamp()
+	if r.IsZero() {
+		return src
+	}
+	bounds := src.Bounds()
+	dst := image.NewNRGBA(bounds)
+	draw.Draw(dst, bounds, src, bounds.Min, draw.Src)
+	r.ApplyTo(dst)
+	return dst
+}
+
+// ApplyTo applies the recipe to img in place.
+func (r Recipe) ApplyTo(img *image.NRGBA) {
+	r.Clamp()
+	lut := r.table()
+	saturation := 1 + r.Saturation
+	if r.Grayscale {
+		saturation = 0
+	}
+
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row := img.Pix[img.PixOffset(bounds.Min.X, y):img.PixOffset(bounds.Max.X, y)]
+		for i := 0; i < len(row); i += 4 {
+			red, green, blue := lut[row[i]], lut[row[i+1]], lut[row[i+2]]
+			if saturation != 1 {
+				// Move away from (or toward) the Rec. 709 luma of the pixel
+				luma := 0.2126*float64(red) + 0.7152*float64(green) + 0.0722*float64(blue)
+				red = channel(luma + (float64(red)-luma)*saturation)
+				green = channel(luma + (float64(green)-luma)*saturation)
+				blue = channel(luma + (float64(blue)-luma)*saturation)
+			}
+			if r.Invert {
+				red, green, blue = 255-red, 255-green, 255-blue
+			}
+			row[i], row[i+1], row[i+2] = red, green, blue
+		}
+	}
+}
+
+func channel(v float64) uint8 {
+	return uint8(math.Round(math.Max(0, math.Min(255, v))))
+}
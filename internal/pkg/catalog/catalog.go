@@ -0,0 +1,462 @@
+// Package catalog keeps an in-memory index of the images of a directory
+// tree: file details, dimensions, camera and capture date read from the
+// image headers, and the rating and tags of the sidecars. It can be queried
+// by folder, text, tags, rating and format, sorted and paged.
+//
+// Images are known by their path relative to the root, slash separated.
+// Scan refreshes the index, only reading again the headers of the files
+// whose size or modification date changed.
+package catalog
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nicky-ayoub/imagination/internal/pkg/imagefs"
+	"github.com/nicky-ayoub/imagination/internal/pkg/librarystats"
+	"github.com/nicky-ayoub/imagination/internal/pkg/sidecar"
+)
+
+/** Highest rating, as given by the viewers. */
+const MAX_RATING = 5
+
+// Sort orders of a query.
+const (
+	SORT_NAME     = "name"     // Path
+	SORT_DATE     = "date"     // Capture date, the modification date when unknown
+	SORT_MODIFIED = "modified" // Modification date
+	SORT_SIZE     = "size"     // File size
+	SORT_RATING   = "rating"
+)
+
+// Image is a catalogued image.
+type Image struct {
+	Path     string     `json:"path"`   // Relative to the root, slash separated
+	Folder   string     `json:"folder"` // Empty for the root
+	Name     string     `json:"name"`
+	Size     int64      `json:"size"`
+	Modified time.Time  `json:"modified"`
+	Format   string     `json:"format"` // Decoded format, librarystats.UNKNOWN when the file can not be decoded
+	Width    int        `json:"width"`
+	Height   int        `json:"height"`
+	Camera   string     `json:"camera,omitempty"`
+	Taken    *time.Time `json:"taken,omitempty"` // Capture date
+	Rating   int        `json:"rating"`
+	Tags     []string   `json:"tags"`
+}
+
+// date returns the capture date, or the modification date when it is unknown.
+func (i *Image) date() time.Time {
+	if i.Taken != nil {
+		return *i.Taken
+	}
+	return i.Modified
+}
+
+// Folder is a folder holding images, directly or in its subfolders.
+type Folder struct {
+	Path   string `json:"path"` // Empty for the root
+	Name   string `json:"name"`
+	Images int    `json:"images"` // Images directly in the folder
+	Total  int    `json:"total"`  // Images in the folder and its subfolders
+}
+
+// Catalog is the index of the images of a root. It is safe for concurrent use.
+type Catalog struct {
+	mutex      sync.RWMutex
+	root       string
+	exts       []string
+	images     map[string]*Image
+	scanned    time.Time
+	generation uint64            // Number of updates so far
+	updated    map[string]uint64 // Generation of the last update of each image
+}
+
+// New returns an empty catalog of the images of root having one of the extensions, see Scan.
+func New(root string, exts []string) *Catalog {
+	return &Catalog{root: root, exts: exts, images: map[string]*Image{}, updated: map[string]uint64{}}
+}
+
+// Root returns the directory of the catalog.
+func (c *Catalog) Root() string {
+	return c.root
+}
+
+// Scanned returns when the last scan ended.
+func (c *Catalog) Scanned() time.Time {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.scanned
+}
+
+// Scan walks the root and updates the catalog: new and changed files are
+// read, files gone are forgotten. Unreadable directories are skipped.
+func (c *Catalog) Scan() error {
+	var files []imagefs.File
+	err := imagefs.Walk(c.root, c.exts, func(f imagefs.File) error {
+		files = append(files, f)
+		return nil
+	}, nil)
+	if err != nil {
+		return err
+	}
+
+	c.mutex.RLock()
+	generation := c.generation
+	images := make([]*Image, len(files))
+	changed := make([]bool, len(files))
+	for i, f := range files {
+		rel, err := c.relative(f.Path)
+		if err != nil {
+			c.mutex.RUnlock()
+			return err
+		}
+		if old := c.images[rel]; old != nil && old.Size == f.Size && old.Modified.Equal(f.ModTime) {
+			image := *old
+			images[i] = &image
+		} else {
+			images[i] = &Image{Path: rel, Folder: folderOf(rel), Name: path.Base(rel), Size: f.Size, Modified: f.ModTime}
+			changed[i] = true
+		}
+	}
+	c.mutex.RUnlock()
+
+	// Reading the files is the slow part, done without holding the lock.
+	// The sidecars are always read again, the viewers changing them without touching the images.
+	queue := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				if changed[i] {
+					readHeader(images[i], files[i].Path)
+				}
+				readSidecar(images[i], files[i].Path)
+			}
+		}()
+	}
+	for i := range files {
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+
+	index := make(map[string]*Image, len(images))
+	for _, image := range images {
+		index[image.Path] = image
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	// Updates made while reading may be missing from the sidecars read, keep them
+	for rel, updated := range c.updated {
+		image, current := index[rel], c.images[rel]
+		switch {
+		case image == nil:
+			delete(c.updated, rel)
+		case updated > generation && current != nil:
+			image.Rating, image.Tags = current.Rating, current.Tags
+		}
+	}
+	c.images = index
+	c.scanned = time.Now()
+	return nil
+}
+
+// readHeader fills an image from the header and the EXIF metadata of its file.
+func readHeader(image *Image, file string) {
+	e := librarystats.Read(file, image.Size)
+	image.Format, image.Width, image.Height, image.Camera = e.Format, e.Width, e.Height, strings.TrimSpace(e.Camera)
+	if !e.Date.IsZero() {
+		image.Taken = &e.Date
+	}
+}
+
+// readSidecar fills the rating and the tags of an image from its sidecar.
+func readSidecar(image *Image, file string) {
+	image.Rating, image.Tags = 0, []string{}
+	if s, err := sidecar.Load(file); err == nil {
+		image.Rating = s.Rating
+		if s.Tags != nil {
+			image.Tags = s.Tags
+		}
+	}
+}
+
+// relative returns the catalog path of a file of the root.
+func (c *Catalog) relative(file string) (string, error) {
+	rel, err := filepath.Rel(c.root, file)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// folderOf returns the folder of a catalog path, empty for the root.
+func folderOf(rel string) string {
+	if dir := path.Dir(rel); dir != "." {
+		return dir
+	}
+	return ""
+}
+
+// Clean returns the catalog path of a slash separated path, which can not go above the root.
+func Clean(rel string) string {
+	return strings.TrimPrefix(path.Clean("/"+rel), "/")
+}
+
+// Len returns the number of images.
+func (c *Catalog) Len() int {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return len(c.images)
+}
+
+// Image returns a catalogued image.
+func (c *Catalog) Image(rel string) (Image, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	image := c.images[Clean(rel)]
+	if image == nil {
+		return Image{}, false
+	}
+	return *image, true
+}
+
+// File returns the file of a catalogued image.
+func (c *Catalog) File(rel string) (string, bool) {
+	rel = Clean(rel)
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	if c.images[rel] == nil {
+		return "", false
+	}
+	return filepath.Join(c.root, filepath.FromSlash(rel)), true
+}
+
+// Folder returns a folder and its subfolders, sorted by name. ok is false
+// when the folder holds no image, directly or in a subfolder.
+func (c *Catalog) Folder(rel string) (folder Folder, subfolders []Folder, ok bool) {
+	rel = Clean(rel)
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	folder = Folder{Path: rel, Name: path.Base("/" + rel)}
+	if rel == "" {
+		folder.Name = filepath.Base(c.root)
+	}
+	children := map[string]*Folder{}
+	for _, image := range c.images {
+		if !within(image.Folder, rel) {
+			continue
+		}
+		folder.Total++
+		if image.Folder == rel {
+			folder.Images++
+			continue
+		}
+		// The child of the folder leading to the image
+		child := strings.TrimPrefix(strings.TrimPrefix(image.Folder, rel), "/")
+		if i := strings.IndexByte(child, '/'); i >= 0 {
+			child = child[:i]
+		}
+		f := children[child]
+		if f == nil {
+			f = &Folder{Path: path.Join(rel, child), Name: child}
+			children[child] = f
+		}
+		f.Total++
+		if image.Folder == f.Path {
+			f.Images++
+		}
+	}
+	for _, f := range children {
+		subfolders = append(subfolders, *f)
+	}
+	sort.Slice(subfolders, func(i, j int) bool { return subfolders[i].Name < subfolders[j].Name })
+	return folder, subfolders, folder.Total > 0
+}
+
+// within tells whether a folder is dir or one of its subfolders.
+func within(folder string, dir string) bool {
+	return dir == "" || folder == dir || strings.HasPrefix(folder, dir+"/")
+}
+
+// Query selects, sorts and pages images.
+type Query struct {
+	Folder     string   // Folder of the images, the root when empty
+	Recursive  bool     // Include the images of the subfolders
+	Text       string   // Looked for in the path, camera and tags, ignoring case
+	Tags       []string // Tags the images must all have
+	MinRating  int
+	Format     string // Decoded format, like "jpeg"
+	Sort       string // One of the SORT_ orders, SORT_NAME when empty
+	Descending bool
+	Offset     int
+	Limit      int // No limit when not positive
+}
+
+// Query returns a page of the images matching q, and their total number.
+func (c *Catalog) Query(q Query) (images []Image, total int, err error) {
+	less, err := order(q.Sort)
+	if err != nil {
+		return nil, 0, err
+	}
+	folder := Clean(q.Folder)
+	text := strings.ToLower(q.Text)
+
+	c.mutex.RLock()
+	matches := []*Image{}
+	for _, image := range c.images {
+		if q.Recursive && !within(image.Folder, folder) || !q.Recursive && image.Folder != folder {
+			continue
+		}
+		if image.Rating < q.MinRating || q.Format != "" && !strings.EqualFold(image.Format, q.Format) {
+			continue
+		}
+		if !hasTags(image, q.Tags) || text != "" && !contains(image, text) {
+			continue
+		}
+		matches = append(matches, image)
+	}
+	c.mutex.RUnlock()
+
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if q.Descending {
+			a, b = b, a
+		}
+		if less(a, b) {
+			return true
+		}
+		if less(b, a) {
+			return false
+		}
+		return a.Path < b.Path
+	})
+	total = len(matches)
+	if q.Offset > len(matches) {
+		q.Offset = len(matches)
+	}
+	matches = matches[q.Offset:]
+	if q.Limit > 0 && len(matches) > q.Limit {
+		matches = matches[:q.Limit]
+	}
+	images = make([]Image, len(matches))
+	for i, image := range matches {
+		images[i] = *image
+	}
+	return images, total, nil
+}
+
+// order returns the comparison of a sort order.
+func order(name string) (func(a, b *Image) bool, error) {
+	switch name {
+	case "", SORT_NAME:
+		return func(a, b *Image) bool { return a.Path < b.Path }, nil
+	case SORT_DATE:
+		return func(a, b *Image) bool { return a.date().Before(b.date()) }, nil
+	case SORT_MODIFIED:
+		return func(a, b *Image) bool { return a.Modified.Before(b.Modified) }, nil
+	case SORT_SIZE:
+		return func(a, b *Image) bool { return a.Size < b.Size }, nil
+	case SORT_RATING:
+		return func(a, b *Image) bool { return a.Rating < b.Rating }, nil
+	}
+	return nil, fmt.Errorf("unknown sort order %q (want name, date, modified, size or rating)", name)
+}
+
+func hasTags(image *Image, tags []string) bool {
+	for _, t := range tags {
+		found := false
+		for _, u := range image.Tags {
+			if u == t {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(image *Image, text string) bool {
+	if strings.Contains(strings.ToLower(image.Path), text) || strings.Contains(strings.ToLower(image.Camera), text) {
+		return true
+	}
+	for _, t := range image.Tags {
+		if strings.Contains(strings.ToLower(t), text) {
+			return true
+		}
+	}
+	return false
+}
+
+// Changes are the updates of an image: nil fields are left as they are.
+type Changes struct {
+	Rating     *int      `json:"rating,omitempty"`
+	Tags       *[]string `json:"tags,omitempty"` // Replace the tags
+	AddTags    []string  `json:"add_tags,omitempty"`
+	RemoveTags []string  `json:"remove_tags,omitempty"`
+}
+
+// Update changes the rating and the tags of an image, saving them in its sidecar.
+func (c *Catalog) Update(rel string, changes Changes) (Image, error) {
+	if changes.Rating != nil && (*changes.Rating < 0 || *changes.Rating > MAX_RATING) {
+		return Image{}, fmt.Errorf("rating %d out of range 0-%d", *changes.Rating, MAX_RATING)
+	}
+	file, ok := c.File(rel)
+	if !ok {
+		return Image{}, fmt.Errorf("%s: no such image", rel)
+	}
+
+	// Held while writing, so concurrent updates of the sidecar do not overwrite each other
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	s, err := sidecar.Load(file)
+	if err != nil {
+		return Image{}, err
+	}
+	if changes.Rating != nil {
+		s.Rating = *changes.Rating
+	}
+	tags := s.Tags
+	if changes.Tags != nil {
+		tags = *changes.Tags
+	}
+	tags = append(append([]string{}, tags...), changes.AddTags...)
+	removed := map[string]bool{}
+	for _, t := range changes.RemoveTags {
+		removed[strings.TrimSpace(t)] = true
+	}
+	kept := tags[:0]
+	for _, t := range tags {
+		if !removed[strings.TrimSpace(t)] {
+			kept = append(kept, t)
+		}
+	}
+	s.SetTags(kept)
+	if err = s.Save(file); err != nil {
+		return Image{}, err
+	}
+
+	image := c.images[Clean(rel)]
+	if image == nil { // Forgotten by a scan meanwhile
+		return Image{}, fmt.Errorf("%s: no such image", rel)
+	}
+	updated := *image
+	updated.Rating = s.Rating
+	updated.Tags = append([]string{}, s.Tags...)
+	c.images[updated.Path] = &updated
+	c.generation++
+	c.updated[updated.Path] = c.generation
+	return updated, nil
+}
@@ -0,0 +1,63 @@
+package catalog
+
+import (
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func newCatalog(t *testing.T, images int) *Catalog {
+	t.Helper()
+	root := t.TempDir()
+	for i := 0; i < images; i++ {
+		f, err := os.Create(filepath.Join(root, fmt.Sprintf("%03d.png", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = png.Encode(f, image.NewGray(image.Rect(0, 0, 8, 8)))
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := New(root, []string{".png"})
+	if err := c.Scan(); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// TestScanUpdate updates images while scanning, the scans must not bring
+// back the ratings and tags read before the updates.
+func TestScanUpdate(t *testing.T) {
+	c := newCatalog(t, 20)
+	for i := 1; i <= 50; i++ {
+		rating, tags := i%(MAX_RATING+1), []string{fmt.Sprint("tag", i)}
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := c.Scan(); err != nil {
+				t.Error(err)
+			}
+		}()
+		for j := 0; j < 20; j += 4 {
+			if _, err := c.Update(fmt.Sprintf("%03d.png", j), Changes{Rating: &rating, Tags: &tags}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		wg.Wait()
+		for j := 0; j < 20; j += 4 {
+			image, _ := c.Image(fmt.Sprintf("%03d.png", j))
+			if image.Rating != rating || len(image.Tags) != 1 || image.Tags[0] != tags[0] {
+				t.Fatalf("round %d: image %d rated %d, tagged %q, want %d and %q", i, j, image.Rating, image.Tags, rating, tags)
+			}
+		}
+	}
+}
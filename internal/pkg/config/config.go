@@ -0,0 +1,304 @@
+// Package config holds the settings shared by every command under cmd/.
+//
+// Settings are resolved in this order, each step overriding the previous one:
+//
+//  1. built-in defaults (Default),
+//  2. the configuration file: the -config flag, else $IMAGINATION_CONFIG, else
+//     the first of config.toml, config.yaml and config.yml found in
+//     ~/.config/imagination (or $XDG_CONFIG_HOME/imagination),
+//  3. IMAGINATION_* environment variables (see the env tags of Config),
+//  4. command-line flags, which commands declare with the loaded values as
+//     their defaults.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+/** Prefix of the environment variables overriding the configuration file. */
+const ENV_PREFIX = "IMAGINATION_"
+
+// Duration is a time.Duration written as "5s", "1m30s", ... in configuration files.
+type Duration time.Duration
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	*d = Duration(v)
+	return err
+}
+
+// Window describes the initial window of the viewers.
+type Window struct {
+	Width      int  `toml:"width" yaml:"width" env:"WINDOW_WIDTH"`
+	Height     int  `toml:"height" yaml:"height" env:"WINDOW_HEIGHT"`
+	Fullscreen bool `toml:"fullscreen" yaml:"fullscreen" env:"FULLSCREEN"`
+}
+
+// Slideshow holds the settings of the slideshows.
+type Slideshow struct {
+	Interval  Duration `toml:"interval" yaml:"interval" env:"SLIDESHOW_INTERVAL"`
+	Fit       string   `toml:"fit" yaml:"fit" env:"SLIDESHOW_FIT"`
+	Upscale   bool     `toml:"upscale" yaml:"upscale" env:"SLIDESHOW_UPSCALE"`
+	Letterbox string   `toml:"letterbox" yaml:"letterbox" env:"SLIDESHOW_LETTERBOX"`
+	Filter    string   `toml:"filter" yaml:"filter" env:"SLIDESHOW_FILTER"`
+}
+
+// Hud holds the settings of the information panel of the SDL viewer.
+type Hud struct {
+	Visible  bool    `toml:"visible" yaml:"visible" env:"HUD_VISIBLE"`
+	Position string  `toml:"position" yaml:"position" env:"HUD_POSITION"` // center, top-left, top-right, bottom-left or bottom-right
+	Opacity  float64 `toml:"opacity" yaml:"opacity" env:"HUD_OPACITY"`    // Panel background opacity, from 0 to 1
+}
+
+// Watermark holds the watermark and caption bars stamped on exported and
+// converted images, see the watermark package which it converts to.
+type Watermark struct {
+	Image         string  `toml:"image" yaml:"image" env:"WATERMARK_IMAGE"`                  // PNG watermark file
+	Text          string  `toml:"text" yaml:"text" env:"WATERMARK_TEXT"`                     // Watermark text, used when there is no image
+	Position      string  `toml:"position" yaml:"position" env:"WATERMARK_POSITION"`         // bottom-right, bottom-left, top-right, top-left or center
+	Margin        float64 `toml:"margin" yaml:"margin" env:"WATERMARK_MARGIN"`               // Fraction of the shorter image edge
+	Opacity       float64 `toml:"opacity" yaml:"opacity" env:"WATERMARK_OPACITY"`            // From 0 to 1
+	Size          float64 `toml:"size" yaml:"size" env:"WATERMARK_SIZE"`                     // Watermark width, fraction of the image width
+	Caption       string  `toml:"caption" yaml:"caption" env:"CAPTION"`                      // Template of the bar below the image, like "{date} — {camera}"
+	CaptionTop    string  `toml:"caption_top" yaml:"caption_top" env:"CAPTION_TOP"`          // Template of the bar above the image
+	CaptionHeight float64 `toml:"caption_height" yaml:"caption_height" env:"CAPTION_HEIGHT"` // Bar height, fraction of the image height
+}
+
+// Config is the whole configuration.
+type Config struct {
+	Assets     string    `toml:"assets" yaml:"assets" env:"ASSETS"`             // Directory shown when none is given
+	Extensions []string  `toml:"extensions" yaml:"extensions" env:"EXTENSIONS"` // Image file extensions looked for when scanning directories
+	Background string    `toml:"background" yaml:"background" env:"BACKGROUND"` // Color around images in the viewport
+	Keymap     string    `toml:"keymap" yaml:"keymap" env:"KEYMAP"`             // Key bindings file, see the keymap package
+	Remote     string    `toml:"remote" yaml:"remote" env:"REMOTE"`             // Remote control address of the viewers, see the remote package; empty for none
+	Window     Window    `toml:"window" yaml:"window"`
+	Slideshow  Slideshow `toml:"slideshow" yaml:"slideshow"`
+	Hud        Hud       `toml:"hud" yaml:"hud"`
+	Watermark  Watermark `toml:"watermark" yaml:"watermark"`
+
+	file string // Configuration file actually read, empty when none was found
+}
+
+// Default returns the built-in configuration.
+func Default() *Config {
+	keymap := ""
+	if dir := Dir(); dir != "" {
+		keymap = filepath.Join(dir, "keymap.conf")
+	}
+	return &Config{
+		Assets:     "../../assets",
+		Extensions: []string{".jpg", ".jpeg"},
+		Background: "#c0c0c0",
+		Keymap:     keymap,
+		Window: Window{
+			Width:  1280,
+			Height: 720,
+		},
+		Slideshow: Slideshow{
+			Interval:  0, // Wait for the space key, as the slideshow always did
+			Fit:       "fit",
+			Upscale:   true,
+			Letterbox: "black",
+			Filter:    "linear",
+		},
+		Hud: Hud{
+			Position: "top-left",
+			Opacity:  0.7,
+		},
+		Watermark: Watermark{
+			Position:      "bottom-right",
+			Margin:        0.03,
+			Opacity:       0.5,
+			Size:          0.25,
+			CaptionHeight: 0.05,
+		},
+	}
+}
+
+// Load returns the configuration resolved from the defaults, the configuration file and the environment.
+// args are the command-line arguments, only searched for the -config flag.
+func Load(args []string) (*Config, error) {
+	c := Default()
+
+	path := flagValue(args, "config")
+	if path == "" {
+		path = os.Getenv(ENV_PREFIX + "CONFIG")
+	}
+	if path == "" {
+		path = findFile()
+	}
+	if path != "" {
+		if err := c.readFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := applyEnv(reflect.ValueOf(c).Elem()); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// MustLoad is Load for commands, it exits when the configuration can not be read.
+func MustLoad() *Config {
+	c, err := Load(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "configuration:", err)
+		os.Exit(2)
+	}
+	return c
+}
+
+// File returns the configuration file that was read, or an empty string.
+func (c *Config) File() string {
+	return c.file
+}
+
+// Dir returns the directory holding the user configuration, ~/.config/imagination on Linux.
+func Dir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "imagination")
+}
+
+func findFile() string {
+	dir := Dir()
+	if dir == "" {
+		return ""
+	}
+	for _, name := range []string{"config.toml", "config.yaml", "config.yml"} {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+func (c *Config) readFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		err = toml.Unmarshal(data, c)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, c)
+	default:
+		err = errors.New("unknown configuration format, use .toml or .yaml")
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	c.file = path
+	return nil
+}
+
+// flagValue returns the value of -name or --name in args, given as "-name value" or "-name=value".
+func flagValue(args []string, name string) string {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		arg = strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-")
+		if arg == name && i+1 < len(args) {
+			return args[i+1]
+		}
+		if strings.HasPrefix(arg, name+"=") {
+			return strings.TrimPrefix(arg, name+"=")
+		}
+	}
+	return ""
+}
+
+// applyEnv overrides the fields having an env tag with the matching IMAGINATION_* variables.
+func applyEnv(v reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := v.Field(i)
+		if !field.CanSet() {
+			continue
+		}
+		if field.Kind() == reflect.Struct {
+			if err := applyEnv(field); err != nil {
+				return err
+			}
+			continue
+		}
+		name, ok := t.Field(i).Tag.Lookup("env")
+		if !ok {
+			continue
+		}
+		value, ok := os.LookupEnv(ENV_PREFIX + name)
+		if !ok {
+			continue
+		}
+		if err := setValue(field, value); err != nil {
+			return fmt.Errorf("%s%s: %w", ENV_PREFIX, name, err)
+		}
+	}
+	return nil
+}
+
+func setValue(field reflect.Value, value string) error {
+	if field.Type() == reflect.TypeOf(Duration(0)) {
+		return field.Addr().Interface().(*Duration).UnmarshalText([]byte(value))
+	}
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(n))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Slice:
+		// Lists are comma separated: IMAGINATION_EXTENSIONS=.jpg,.png
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported setting type %s", field.Type())
+	}
+	return nil
+}
+
+// AddFlag declares the -config flag on the default command line so flag.Parse accepts it, Load already used its value.
+func AddFlag() {
+	flag.String("config", "", "Configuration file (.toml or .yaml), default "+filepath.Join(Dir(), "config.toml"))
+}
@@ -0,0 +1,187 @@
+// Package crop computes crop rectangles constrained to aspect ratio presets and
+// to the image bounds, in image pixel coordinates.
+package crop
+
+import (
+	"fmt"
+	"image"
+	"strings"
+)
+
+// Preset is an aspect ratio a crop rectangle can be constrained to.
+type Preset int
+
+const (
+	PRESET_FREE Preset = iota // Any ratio
+	PRESET_1_1
+	PRESET_4_5
+	PRESET_3_2
+	PRESET_16_9
+	PRESETS_COUNT
+)
+
+var presets = [PRESETS_COUNT]struct {
+	name  string
+	ratio image.Point // Width and height of the ratio, zero when free
+}{
+	{"free", image.Point{}},
+	{"1:1", image.Pt(1, 1)},
+	{"4:5", image.Pt(4, 5)},
+	{"3:2", image.Pt(3, 2)},
+	{"16:9", image.Pt(16, 9)},
+}
+
+func (p Preset) String() string {
+	if p < 0 || p >= PRESETS_COUNT {
+		return fmt.Sprintf("Preset(%d)", int(p))
+	}
+	return presets[p].name
+}
+
+// Ratio returns the width and height of the preset ratio, zero for PRESET_FREE.
+// rotated swaps them, for images displayed with a quarter turn.
+func (p Preset) Ratio(rotated bool) image.Point {
+	r := presets[p].ratio
+	if rotated {
+		r.X, r.Y = r.Y, r.X
+	}
+	return r
+}
+
+// Next returns the following preset, back to PRESET_FREE after the last one.
+func (p Preset) Next() Preset {
+	return (p + 1) % PRESETS_COUNT
+}
+
+// ParsePreset finds a preset from its name: free, 1:1, 4:5, 3:2 or 16:9.
+func ParsePreset(s string) (Preset, error) {
+	for p := PRESET_FREE; p < PRESETS_COUNT; p++ {
+		if strings.EqualFold(s, presets[p].name) {
+			return p, nil
+		}
+	}
+	return PRESET_FREE, fmt.Errorf("unknown crop preset %q (want free, 1:1, 4:5, 3:2 or 16:9)", s)
+}
+
+// Rect is a crop rectangle as stored in sidecar files.
+type Rect struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+	W int `json:"w"`
+	H int `json:"h"`
+}
+
+// FromRectangle converts an image rectangle, nil when it is empty.
+func FromRectangle(r image.Rectangle) *Rect {
+	if r.Empty() {
+		return nil
+	}
+	return &Rect{X: r.Min.X, Y: r.Min.Y, W: r.Dx(), H: r.Dy()}
+}
+
+// Rectangle converts the crop rectangle to an image one.
+func (r Rect) Rectangle() image.Rectangle {
+	return image.Rect(r.X, r.Y, r.X+r.W, r.Y+r.H)
+}
+
+// constrain shrinks a size to the ratio, zero when free.
+func constrain(w, h int, ratio image.Point) (int, int) {
+	if ratio.X <= 0 || ratio.Y <= 0 {
+		return w, h
+	}
+	if w*ratio.Y > h*ratio.X {
+		w = h * ratio.X / ratio.Y
+	} else {
+		h = w * ratio.Y / ratio.X
+	}
+	return w, h
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// Drag returns the rectangle dragged from one corner to the opposite one, the
+// from corner staying in place, constrained to the ratio and kept inside bounds.
+func Drag(from, to image.Point, ratio image.Point, bounds image.Rectangle) image.Rectangle {
+	from.X = clamp(from.X, bounds.Min.X, bounds.Max.X)
+	from.Y = clamp(from.Y, bounds.Min.Y, bounds.Max.Y)
+
+	// Room left in the drag direction
+	roomX, roomY := bounds.Max.X-from.X, bounds.Max.Y-from.Y
+	if to.X < from.X {
+		roomX = from.X - bounds.Min.X
+	}
+	if to.Y < from.Y {
+		roomY = from.Y - bounds.Min.Y
+	}
+	w, h := constrain(min(abs(to.X-from.X), roomX), min(abs(to.Y-from.Y), roomY), ratio)
+
+	r := image.Rectangle{Min: from, Max: from.Add(image.Pt(w, h))}
+	if to.X < from.X {
+		r.Min.X, r.Max.X = from.X-w, from.X
+	}
+	if to.Y < from.Y {
+		r.Min.Y, r.Max.Y = from.Y-h, from.Y
+	}
+	return r
+}
+
+// Fit constrains an existing rectangle to a ratio around its center.
+func Fit(r image.Rectangle, ratio image.Point, bounds image.Rectangle) image.Rectangle {
+	r = r.Intersect(bounds)
+	if r.Empty() {
+		return r
+	}
+	w, h := constrain(r.Dx(), r.Dy(), ratio)
+	center := r.Min.Add(r.Max).Div(2)
+	corner := center.Sub(image.Pt(w/2, h/2))
+	return Move(image.Rectangle{Min: corner, Max: corner.Add(image.Pt(w, h))}, 0, 0, bounds)
+}
+
+// Move translates a rectangle, stopping at the bounds.
+func Move(r image.Rectangle, dx, dy int, bounds image.Rectangle) image.Rectangle {
+	r = r.Add(image.Pt(dx, dy))
+	if r.Max.X > bounds.Max.X {
+		r = r.Sub(image.Pt(r.Max.X-bounds.Max.X, 0))
+	}
+	if r.Max.Y > bounds.Max.Y {
+		r = r.Sub(image.Pt(0, r.Max.Y-bounds.Max.Y))
+	}
+	if r.Min.X < bounds.Min.X {
+		r = r.Add(image.Pt(bounds.Min.X-r.Min.X, 0))
+	}
+	if r.Min.Y < bounds.Min.Y {
+		r = r.Add(image.Pt(0, bounds.Min.Y-r.Min.Y))
+	}
+	return r.Intersect(bounds)
+}
+
+// Grow enlarges (or shrinks, when step is negative) a rectangle around its
+// center by step pixels in width, the height following the ratio.
+func Grow(r image.Rectangle, step int, ratio image.Point, bounds image.Rectangle) image.Rectangle {
+	w, h := r.Dx()+step, r.Dy()+step
+	if ratio.X > 0 && ratio.Y > 0 {
+		h = w * ratio.Y / ratio.X
+	}
+	w, h = constrain(min(w, bounds.Dx()), min(h, bounds.Dy()), ratio)
+	if w < 1 || h < 1 {
+		return r
+	}
+	center := r.Min.Add(r.Max).Div(2)
+	corner := center.Sub(image.Pt(w/2, h/2))
+	return Move(image.Rectangle{Min: corner, Max: corner.Add(image.Pt(w, h))}, 0, 0, bounds)
+}
+
+func clamp(v, low, high int) int {
+	if v < low {
+		return low
+	}
+	if v > high {
+		return high
+	}
+	return v
+}
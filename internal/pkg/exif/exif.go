@@ -0,0 +1,504 @@
+// Package exif reads the EXIF metadata of JPEG and TIFF files.
+//
+// Only the tags the viewers and tools display are decoded into fields, every
+// other tag of the main, EXIF and GPS directories is still listed in Fields.
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+)
+
+// ErrNoExif is returned for images without EXIF metadata.
+var ErrNoExif = errors.New("no EXIF metadata")
+
+// Tags decoded into Exif fields.
+const (
+	TAG_IMAGE_DESCRIPTION  = 0x010e
+	TAG_MAKE               = 0x010f
+	TAG_MODEL              = 0x0110
+	TAG_ORIENTATION        = 0x0112
+	TAG_SOFTWARE           = 0x0131
+	TAG_DATE_TIME          = 0x0132
+	TAG_ARTIST             = 0x013b
+	TAG_COPYRIGHT          = 0x8298
+	TAG_EXPOSURE_TIME      = 0x829a
+	TAG_F_NUMBER           = 0x829d
+	TAG_EXIF_IFD           = 0x8769
+	TAG_GPS_IFD            = 0x8825
+	TAG_ISO                = 0x8827
+	TAG_DATE_TIME_ORIGINAL = 0x9003
+	TAG_FOCAL_LENGTH       = 0x920a
+	TAG_PIXEL_X_DIMENSION  = 0xa002
+	TAG_PIXEL_Y_DIMENSION  = 0xa003
+	TAG_LENS_MODEL         = 0xa434
+	TAG_GPS_LATITUDE_REF   = 0x0001
+	TAG_GPS_LATITUDE       = 0x0002
+	TAG_GPS_LONGITUDE_REF  = 0x0003
+	TAG_GPS_LONGITUDE      = 0x0004
+)
+
+/** Date format of the EXIF date tags, for time.Parse. */
+const DATE_LAYOUT = "2006:01:02 15:04:05"
+
+// Names of the tags listed in Fields, per directory.
+var names = map[string]map[uint16]string{
+	"ifd0": {
+		TAG_IMAGE_DESCRIPTION: "ImageDescription",
+		TAG_MAKE:              "Make",
+		TAG_MODEL:             "Model",
+		TAG_ORIENTATION:       "Orientation",
+		0x011a:                "XResolution",
+		0x011b:                "YResolution",
+		0x0128:                "ResolutionUnit",
+		TAG_SOFTWARE:          "Software",
+		TAG_DATE_TIME:         "DateTime",
+		TAG_ARTIST:            "Artist",
+		TAG_COPYRIGHT:         "Copyright",
+	},
+	"exif": {
+		TAG_EXPOSURE_TIME:      "ExposureTime",
+		TAG_F_NUMBER:           "FNumber",
+		0x8822:                 "ExposureProgram",
+		TAG_ISO:                "ISOSpeedRatings",
+		TAG_DATE_TIME_ORIGINAL: "DateTimeOriginal",
+		0x9004:                 "DateTimeDigitized",
+		0x9201:                 "ShutterSpeedValue",
+		0x9202:                 "ApertureValue",
+		0x9204:                 "ExposureBiasValue",
+		0x9207:                 "MeteringMode",
+		0x9209:                 "Flash",
+		TAG_FOCAL_LENGTH:       "FocalLength",
+		0xa001:                 "ColorSpace",
+		TAG_PIXEL_X_DIMENSION:  "PixelXDimension",
+		TAG_PIXEL_Y_DIMENSION:  "PixelYDimension",
+		0xa402:                 "ExposureMode",
+		0xa403:                 "WhiteBalance",
+		0xa405:                 "FocalLengthIn35mmFilm",
+		0xa433:                 "LensMake",
+		TAG_LENS_MODEL:         "LensModel",
+	},
+	"gps": {
+		TAG_GPS_LATITUDE_REF:  "GPSLatitudeRef",
+		TAG_GPS_LATITUDE:      "GPSLatitude",
+		TAG_GPS_LONGITUDE_REF: "GPSLongitudeRef",
+		TAG_GPS_LONGITUDE:     "GPSLongitude",
+		0x0005:                "GPSAltitudeRef",
+		0x0006:                "GPSAltitude",
+	},
+}
+
+// Rational is an EXIF fraction.
+type Rational struct {
+	Num, Den int64
+}
+
+// Float returns the fraction value, 0 when the denominator is 0.
+func (r Rational) Float() float64 {
+	if r.Den == 0 {
+		return 0
+	}
+	return float64(r.Num) / float64(r.Den)
+}
+
+func (r Rational) String() string {
+	return fmt.Sprintf("%d/%d", r.Num, r.Den)
+}
+
+// Field is a tag as listed by tools, with its value formatted as text.
+type Field struct {
+	IFD   string // ifd0, exif or gps
+	Tag   uint16
+	Name  string // Empty for unknown tags
+	Value string
+}
+
+// Exif holds the decoded metadata.
+type Exif struct {
+	Make             string
+	Model            string
+	LensModel        string
+	Software         string
+	Artist           string
+	Copyright        string
+	Description      string
+	DateTime         string // DATE_LAYOUT formatted
+	DateTimeOriginal string // DATE_LAYOUT formatted
+	Orientation      int    // 1 to 8, 0 when missing
+	ExposureTime     Rational
+	FNumber          Rational
+	FocalLength      Rational
+	ISO              int
+	Width            int // PixelXDimension
+	Height           int // PixelYDimension
+	Latitude         float64
+	Longitude        float64
+	HasGPS           bool
+	Fields           []Field
+
+	// OrientationOffset is the file offset of the 16 bits orientation value, 0 when there is none.
+	OrientationOffset int64
+	// ByteOrder of the orientation value.
+	ByteOrder binary.ByteOrder
+}
+
+// Read decodes the EXIF metadata of a JPEG or TIFF file.
+func Read(path string) (*Exif, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Decode(f)
+}
+
+// Decode decodes the EXIF metadata of a JPEG or TIFF stream.
+func Decode(r io.Reader) (*Exif, error) {
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, ErrNoExif
+	}
+	switch {
+	case head[0] == 0xff && head[1] == 0xd8:
+		return decodeJPEG(io.MultiReader(bytes.NewReader(head[2:]), r))
+	case string(head) == "II*\x00" || string(head) == "MM\x00*":
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		return Parse(append(head, data...), 0)
+	}
+	return nil, ErrNoExif
+}
+
+// decodeJPEG walks the JPEG segments, after the SOI marker, up to the EXIF APP1 segment.
+func decodeJPEG(r io.Reader) (*Exif, error) {
+	offset := int64(2)
+	marker := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(r, marker); err != nil {
+			return nil, ErrNoExif
+		}
+		if marker[0] != 0xff {
+			return nil, errors.New("exif: invalid JPEG marker")
+		}
+		// Metadata segments come before the image data
+		if marker[1] == 0xda || marker[1] == 0xd9 {
+			return nil, ErrNoExif
+		}
+		size := int64(binary.BigEndian.Uint16(marker[2:])) - 2
+		if size < 0 {
+			return nil, errors.New("exif: invalid JPEG segment")
+		}
+		offset += 4
+		if marker[1] == 0xe1 && size >= 6 {
+			data := make([]byte, size)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return nil, err
+			}
+			if string(data[:6]) == "Exif\x00\x00" {
+				return Parse(data[6:], offset+6)
+			}
+		} else if _, err := io.CopyN(io.Discard, r, size); err != nil {
+			return nil, ErrNoExif
+		}
+		offset += size
+	}
+}
+
+type parser struct {
+	data   []byte
+	order  binary.ByteOrder
+	offset int64 // File offset of data
+	e      *Exif
+	south  bool // GPS references, applied once every GPS tag is read
+	west   bool
+}
+
+// Parse decodes a TIFF structure, the content of the EXIF segment of JPEG files.
+// offset is the file offset of tiff, used to locate values to patch.
+func Parse(tiff []byte, offset int64) (*Exif, error) {
+	p := &parser{data: tiff, offset: offset, e: &Exif{}}
+	if len(tiff) < 8 {
+		return nil, ErrNoExif
+	}
+	switch string(tiff[:2]) {
+	case "II":
+		p.order = binary.LittleEndian
+	case "MM":
+		p.order = binary.BigEndian
+	default:
+		return nil, errors.New("exif: invalid byte order")
+	}
+	p.e.ByteOrder = p.order
+	if err := p.ifd("ifd0", p.order.Uint32(tiff[4:])); err != nil {
+		return nil, err
+	}
+	if p.south {
+		p.e.Latitude = -p.e.Latitude
+	}
+	if p.west {
+		p.e.Longitude = -p.e.Longitude
+	}
+	return p.e, nil
+}
+
+func (p *parser) ifd(name string, at uint32) error {
+	if int64(at)+2 > int64(len(p.data)) {
+		return errors.New("exif: directory out of bounds")
+	}
+	count := int(p.order.Uint16(p.data[at:]))
+	for i := 0; i < count; i++ {
+		entry := int64(at) + 2 + int64(i)*12
+		if entry+12 > int64(len(p.data)) {
+			return errors.New("exif: entry out of bounds")
+		}
+		tag := p.order.Uint16(p.data[entry:])
+		kind := p.order.Uint16(p.data[entry+2:])
+		n := p.order.Uint32(p.data[entry+4:])
+		value, valueAt, ok := p.value(kind, n, entry+8)
+		if !ok {
+			continue
+		}
+
+		switch {
+		case name == "ifd0" && (tag == TAG_EXIF_IFD || tag == TAG_GPS_IFD):
+			sub := "exif"
+			if tag == TAG_GPS_IFD {
+				sub = "gps"
+			}
+			if err := p.ifd(sub, p.order.Uint32(p.data[entry+8:])); err != nil {
+				return err
+			}
+			continue
+		case name == "ifd0" && tag == TAG_ORIENTATION && kind == 3:
+			p.e.OrientationOffset = p.offset + valueAt
+		}
+		p.decode(name, tag, kind, value)
+		p.e.Fields = append(p.e.Fields, Field{IFD: name, Tag: tag, Name: names[name][tag], Value: format(kind, value)})
+	}
+	return nil
+}
+
+var sizes = map[uint16]int64{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8}
+
+// value returns the decoded values of an entry: a string, []int64, []Rational or []byte.
+func (p *parser) value(kind uint16, n uint32, at int64) (value interface{}, valueAt int64, ok bool) {
+	size, ok := sizes[kind]
+	if !ok || n > 1<<20 {
+		return nil, 0, false
+	}
+	length := size * int64(n)
+	valueAt = at
+	if length > 4 {
+		valueAt = int64(p.order.Uint32(p.data[at:]))
+	}
+	if valueAt+length > int64(len(p.data)) {
+		return nil, 0, false
+	}
+	raw := p.data[valueAt : valueAt+length]
+	switch kind {
+	case 2:
+		return strings.TrimRight(string(raw), "\x00 "), valueAt, true
+	case 1, 7:
+		return raw, valueAt, true
+	case 6:
+		ints := make([]int64, n)
+		for i := range ints {
+			ints[i] = int64(int8(raw[i]))
+		}
+		return ints, valueAt, true
+	case 3, 8:
+		ints := make([]int64, n)
+		for i := range ints {
+			v := p.order.Uint16(raw[2*i:])
+			if kind == 8 {
+				ints[i] = int64(int16(v))
+			} else {
+				ints[i] = int64(v)
+			}
+		}
+		return ints, valueAt, true
+	case 4, 9:
+		ints := make([]int64, n)
+		for i := range ints {
+			v := p.order.Uint32(raw[4*i:])
+			if kind == 9 {
+				ints[i] = int64(int32(v))
+			} else {
+				ints[i] = int64(v)
+			}
+		}
+		return ints, valueAt, true
+	case 5, 10:
+		rats := make([]Rational, n)
+		for i := range rats {
+			num, den := p.order.Uint32(raw[8*i:]), p.order.Uint32(raw[8*i+4:])
+			if kind == 10 {
+				rats[i] = Rational{int64(int32(num)), int64(int32(den))}
+			} else {
+				rats[i] = Rational{int64(num), int64(den)}
+			}
+		}
+		return rats, valueAt, true
+	}
+	return nil, 0, false
+}
+
+func format(kind uint16, value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []byte:
+		if kind == 7 && len(v) <= 4 {
+			return string(bytes.TrimRight(v, "\x00"))
+		}
+		return fmt.Sprintf("%d bytes", len(v))
+	case []int64:
+		parts := make([]string, len(v))
+		for i, n := range v {
+			parts[i] = fmt.Sprint(n)
+		}
+		return strings.Join(parts, " ")
+	case []Rational:
+		parts := make([]string, len(v))
+		for i, r := range v {
+			parts[i] = r.String()
+		}
+		return strings.Join(parts, " ")
+	}
+	return ""
+}
+
+func (p *parser) decode(ifd string, tag uint16, kind uint16, value interface{}) {
+	e := p.e
+	s, _ := value.(string)
+	ints, _ := value.([]int64)
+	rats, _ := value.([]Rational)
+	first := func() int {
+		if len(ints) == 0 {
+			return 0
+		}
+		return int(ints[0])
+	}
+	rational := func() Rational {
+		if len(rats) == 0 {
+			return Rational{}
+		}
+		return rats[0]
+	}
+
+	if ifd == "gps" {
+		switch tag {
+		case TAG_GPS_LATITUDE:
+			e.Latitude = degrees(rats)
+			e.HasGPS = len(rats) == 3
+		case TAG_GPS_LONGITUDE:
+			e.Longitude = degrees(rats)
+		case TAG_GPS_LATITUDE_REF:
+			p.south = s == "S"
+		case TAG_GPS_LONGITUDE_REF:
+			p.west = s == "W"
+		}
+		return
+	}
+
+	switch tag {
+	case TAG_MAKE:
+		e.Make = s
+	case TAG_MODEL:
+		e.Model = s
+	case TAG_LENS_MODEL:
+		e.LensModel = s
+	case TAG_SOFTWARE:
+		e.Software = s
+	case TAG_ARTIST:
+		e.Artist = s
+	case TAG_COPYRIGHT:
+		e.Copyright = s
+	case TAG_IMAGE_DESCRIPTION:
+		e.Description = s
+	case TAG_DATE_TIME:
+		e.DateTime = s
+	case TAG_DATE_TIME_ORIGINAL:
+		e.DateTimeOriginal = s
+	case TAG_ORIENTATION:
+		e.Orientation = first()
+	case TAG_EXPOSURE_TIME:
+		e.ExposureTime = rational()
+	case TAG_F_NUMBER:
+		e.FNumber = rational()
+	case TAG_FOCAL_LENGTH:
+		e.FocalLength = rational()
+	case TAG_ISO:
+		e.ISO = first()
+	case TAG_PIXEL_X_DIMENSION:
+		e.Width = first()
+	case TAG_PIXEL_Y_DIMENSION:
+		e.Height = first()
+	}
+}
+
+// degrees converts degrees, minutes and seconds to decimal degrees.
+func degrees(dms []Rational) float64 {
+	if len(dms) != 3 {
+		return 0
+	}
+	return dms[0].Float() + dms[1].Float()/60 + dms[2].Float()/3600
+}
+
+// Camera returns the camera name, without the maker repeated in the model like "Canon Canon EOS 5D".
+func (e *Exif) Camera() string {
+	if e.Make == "" || strings.HasPrefix(strings.ToLower(e.Model), strings.ToLower(strings.Fields(e.Make + " ")[0])) {
+		return e.Model
+	}
+	return strings.TrimSpace(e.Make + " " + e.Model)
+}
+
+// Date returns the shooting date, or the file modification date recorded by the camera.
+func (e *Exif) Date() string {
+	if e.DateTimeOriginal != "" {
+		return e.DateTimeOriginal
+	}
+	return e.DateTime
+}
+
+// Exposure returns the shooting parameters, like "1/200s f/8 ISO 100 50mm".
+func (e *Exif) Exposure() string {
+	var parts []string
+	if t := e.ExposureTime.Float(); t > 0 {
+		if t < 1 {
+			parts = append(parts, fmt.Sprintf("1/%.0fs", 1/t))
+		} else {
+			parts = append(parts, fmt.Sprintf("%gs", t))
+		}
+	}
+	if f := e.FNumber.Float(); f > 0 {
+		parts = append(parts, fmt.Sprintf("f/%g", math.Round(f*10)/10))
+	}
+	if e.ISO > 0 {
+		parts = append(parts, fmt.Sprintf("ISO %d", e.ISO))
+	}
+	if l := e.FocalLength.Float(); l > 0 {
+		parts = append(parts, fmt.Sprintf("%gmm", math.Round(l)))
+	}
+	return strings.Join(parts, " ")
+}
+
+// Summary returns the metadata that matters most on a single line, like
+// "Canon EOS 5D  1/200s f/8 ISO 100 50mm  2021:06:01 12:00:00".
+func (e *Exif) Summary() string {
+	var parts []string
+	for _, part := range []string{e.Camera(), e.Exposure(), e.Date()} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, "  ")
+}
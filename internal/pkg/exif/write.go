@@ -0,0 +1,146 @@
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// WriteOrientation sets the orientation tag of a JPEG or TIFF file, 1 to 8.
+// The tag is patched in place when it exists. A JPEG file without EXIF
+// metadata gets a minimal EXIF segment holding only the orientation.
+func WriteOrientation(path string, orientation int) error {
+	if orientation < 1 || orientation > 8 {
+		return errors.New("exif: orientation must be between 1 and 8")
+	}
+	e, err := Read(path)
+	switch {
+	case err == nil && e.OrientationOffset > 0:
+		f, err := os.OpenFile(path, os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+		value := make([]byte, 2)
+		e.ByteOrder.PutUint16(value, uint16(orientation))
+		if _, err = f.WriteAt(value, e.OrientationOffset); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	case err == nil:
+		if orientation == 1 {
+			return nil
+		}
+		return errors.New("exif: the metadata have no orientation tag to rewrite")
+	case err != ErrNoExif:
+		return err
+	case orientation == 1:
+		return nil // No tag means upright already
+	}
+	return insertOrientation(path, orientation)
+}
+
+// insertOrientation adds an EXIF segment to a JPEG file, after its JFIF segment if any.
+func insertOrientation(path string, orientation int) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	// A big endian TIFF structure with a single directory entry
+	var tiff bytes.Buffer
+	tiff.WriteString("MM\x00*")
+	binary.Write(&tiff, binary.BigEndian, uint32(8))
+	binary.Write(&tiff, binary.BigEndian, uint16(1))
+	binary.Write(&tiff, binary.BigEndian, []uint16{TAG_ORIENTATION, 3})
+	binary.Write(&tiff, binary.BigEndian, uint32(1))
+	binary.Write(&tiff, binary.BigEndian, []uint16{uint16(orientation), 0})
+	binary.Write(&tiff, binary.BigEndian, uint32(0))
+
+	var segment bytes.Buffer
+	segment.Write([]byte{0xff, 0xe1})
+	binary.Write(&segment, binary.BigEndian, uint16(2+6+tiff.Len()))
+	segment.WriteString("Exif\x00\x00")
+	segment.Write(tiff.Bytes())
+
+	out, err := Insert(data, segment.Bytes())
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(out); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if info, err := os.Stat(path); err == nil {
+		os.Chmod(tmp.Name(), info.Mode().Perm())
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Segment returns the EXIF APP1 segment of a JPEG file, marker included, ready
+// to be inserted in another JPEG file.
+func Segment(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, ErrNoExif
+	}
+	for at := 2; at+4 <= len(data) && data[at] == 0xff; {
+		marker := data[at+1]
+		if marker == 0xda || marker == 0xd9 {
+			break
+		}
+		end := at + 2 + int(binary.BigEndian.Uint16(data[at+2:]))
+		if end > len(data) {
+			break
+		}
+		if marker == 0xe1 && end-at >= 10 && string(data[at+4:at+10]) == "Exif\x00\x00" {
+			return append([]byte{}, data[at:end]...), nil
+		}
+		at = end
+	}
+	return nil, ErrNoExif
+}
+
+// SetOrientation sets the orientation tag, 1 to 8, of an EXIF segment
+// returned by Segment. It does nothing when the segment has no such tag.
+func SetOrientation(segment []byte, orientation int) error {
+	if len(segment) < 10 {
+		return ErrNoExif
+	}
+	e, err := Parse(segment[10:], 10)
+	if err != nil {
+		return err
+	}
+	if e.OrientationOffset > 0 {
+		e.ByteOrder.PutUint16(segment[e.OrientationOffset:], uint16(orientation))
+	}
+	return nil
+}
+
+// Insert returns a JPEG file with a segment added after its SOI marker and
+// its JFIF segment if any.
+func Insert(jpeg []byte, segment []byte) ([]byte, error) {
+	if len(jpeg) < 4 || jpeg[0] != 0xff || jpeg[1] != 0xd8 {
+		return nil, errors.New("exif: only JPEG files can get new metadata")
+	}
+	at := 2
+	if jpeg[2] == 0xff && jpeg[3] == 0xe0 && len(jpeg) >= 6 {
+		at += 2 + int(binary.BigEndian.Uint16(jpeg[4:]))
+	}
+	out := make([]byte, 0, len(jpeg)+len(segment))
+	out = append(append(append(out, jpeg[:at]...), segment...), jpeg[at:]...)
+	return out, nil
+}
@@ -0,0 +1,117 @@
+// Package fit computes where an image lands inside a display area. It is the
+// backend-neutral counterpart of the ratio keeping done by the SDL viewport:
+// images are centered, their aspect ratio is kept and the remaining area is
+// letterboxed.
+package fit
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strconv"
+	"strings"
+)
+
+// Mode selects how an image is scaled into a display area.
+type Mode int
+
+const (
+	// ModeFit shows the whole image, adding letterbox borders when the ratios differ.
+	ModeFit Mode = iota
+	// ModeFill covers the whole display area, cropping what does not fit.
+	ModeFill
+	// ModeStretch covers the whole display area without keeping the image ratio.
+	ModeStretch
+)
+
+var modeNames = map[Mode]string{
+	ModeFit:     "fit",
+	ModeFill:    "fill",
+	ModeStretch: "stretch",
+}
+
+func (m Mode) String() string {
+	if name, ok := modeNames[m]; ok {
+		return name
+	}
+	return "Mode(" + strconv.Itoa(int(m)) + ")"
+}
+
+// ParseMode converts a mode name ("fit", "fill" or "stretch") to a Mode.
+func ParseMode(s string) (Mode, error) {
+	for m, name := range modeNames {
+		if strings.EqualFold(s, name) {
+			return m, nil
+		}
+	}
+	return ModeFit, fmt.Errorf("unknown fit mode %q (want fit, fill or stretch)", s)
+}
+
+// Rect returns the rectangle, in display coordinates, the image must be drawn
+// into. The rectangle can be larger than the display in ModeFill.
+//
+// When upscale is false images smaller than the display keep their native size,
+// which is what the viewport does until it is asked to scale the image.
+func Rect(imageWidth, imageHeight, displayWidth, displayHeight int, mode Mode, upscale bool) image.Rectangle {
+	if imageWidth <= 0 || imageHeight <= 0 || displayWidth <= 0 || displayHeight <= 0 {
+		return image.Rectangle{}
+	}
+
+	w, h := displayWidth, displayHeight
+	if mode != ModeStretch {
+		// Compare both ratios with cross products to stay in integer arithmetic
+		imageIsWider := imageWidth*displayHeight > displayWidth*imageHeight
+		if imageIsWider == (mode == ModeFit) {
+			h = (imageHeight*displayWidth + imageWidth/2) / imageWidth
+		} else {
+			w = (imageWidth*displayHeight + imageHeight/2) / imageHeight
+		}
+	}
+
+	// Keep the native size when the image already fits and upscaling is not wanted
+	if !upscale && imageWidth <= displayWidth && imageHeight <= displayHeight {
+		w, h = imageWidth, imageHeight
+	}
+
+	x := (displayWidth - w) / 2
+	y := (displayHeight - h) / 2
+	return image.Rect(x, y, x+w, y+h)
+}
+
+// Scale returns the horizontal and vertical factors needed to draw the image in r.
+func Scale(imageWidth, imageHeight int, r image.Rectangle) (sx float64, sy float64) {
+	if imageWidth <= 0 || imageHeight <= 0 {
+		return 1, 1
+	}
+	return float64(r.Dx()) / float64(imageWidth), float64(r.Dy()) / float64(imageHeight)
+}
+
+var namedColors = map[string]color.RGBA{
+	"black": {0, 0, 0, 255},
+	"white": {255, 255, 255, 255},
+	"gray":  {128, 128, 128, 255},
+	"grey":  {128, 128, 128, 255},
+}
+
+// ParseColor parses a letterbox color written as "#rrggbb", "#rrggbbaa", "#rgb"
+// or one of the names black, white and gray. The alpha of "#rrggbbaa" is not
+// premultiplied, the returned color is.
+func ParseColor(s string) (color.RGBA, error) {
+	if c, ok := namedColors[strings.ToLower(s)]; ok {
+		return c, nil
+	}
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if len(hex) != 8 || err != nil {
+		return color.RGBA{}, fmt.Errorf("invalid color %q", s)
+	}
+	// The alpha is written straight, color.RGBA is premultiplied
+	c := color.NRGBA{R: uint8(v >> 24), G: uint8(v >> 16), B: uint8(v >> 8), A: uint8(v)}
+	return color.RGBAModel.Convert(c).(color.RGBA), nil
+}
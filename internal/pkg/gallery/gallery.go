@@ -0,0 +1,267 @@
+// Package gallery serves a directory of images as a browsable HTML gallery:
+// one page per folder with its subfolders and a grid of thumbnails, a
+// full-screen lightbox, and the original files.
+//
+// URLs mirror the directory tree under the gallery root:
+//
+//	/browse/<folder>/     folder page
+//	/thumb/<image>        cached JPEG thumbnail
+//	/original/<image>     original file, with Range and conditional requests
+//	/static/<file>        embedded CSS and JavaScript
+//
+// Hidden files and folders are neither listed nor served, and paths can not
+// leave the root.
+package gallery
+
+import (
+	"fmt"
+	"html/template"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nicky-ayoub/imagination/internal/pkg/imagefs"
+	"github.com/nicky-ayoub/imagination/internal/pkg/thumbcache"
+	"github.com/nicky-ayoub/imagination/web"
+)
+
+// Server is the HTTP handler of a gallery.
+type Server struct {
+	root      string
+	exts      []string
+	cache     *thumbcache.Cache
+	templates *template.Template
+	mux       *http.ServeMux
+	thumbs    chan struct{} // Limits the thumbnails created at the same time
+}
+
+// New returns the gallery of the images of root having one of the extensions,
+// their thumbnails being kept in cache.
+func New(root string, exts []string, cache *thumbcache.Cache) (*Server, error) {
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s: not a directory", root)
+	}
+	templates, err := template.New("").Funcs(template.FuncMap{
+		"link":   link,
+		"folder": folderLink,
+		"size":   imagefs.FormatSize,
+	}).ParseFS(web.FS, "template/*.html")
+	if err != nil {
+		return nil, err
+	}
+	static, err := fs.Sub(web.FS, "static")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		root:      root,
+		exts:      exts,
+		cache:     cache,
+		templates: templates,
+		mux:       http.NewServeMux(),
+		thumbs:    make(chan struct{}, runtime.NumCPU()),
+	}
+	s.mux.Handle("/browse/", http.StripPrefix("/browse", http.HandlerFunc(s.serveFolder)))
+	s.mux.Handle("/thumb/", http.StripPrefix("/thumb", http.HandlerFunc(s.serveThumbnail)))
+	s.mux.Handle("/original/", http.StripPrefix("/original", http.HandlerFunc(s.serveOriginal)))
+	s.mux.Handle("/static/", http.StripPrefix("/static", http.FileServer(http.FS(static))))
+	s.mux.Handle("/", http.RedirectHandler("/browse/", http.StatusFound))
+	return s, nil
+}
+
+// ServeHTTP answers the gallery requests.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// resolve returns the file of a slash separated path relative to the root.
+// The path is cleaned so it can not go above the root, and hidden names are refused.
+func (s *Server) resolve(rel string) (string, bool) {
+	rel = path.Clean("/" + rel)
+	for _, name := range strings.Split(rel, "/") {
+		if strings.HasPrefix(name, ".") {
+			return "", false
+		}
+	}
+	return filepath.Join(s.root, filepath.FromSlash(rel)), true
+}
+
+// link returns the URL of a slash separated path under a prefix, each name being escaped.
+func link(prefix string, rel string) string {
+	names := strings.Split(strings.Trim(rel, "/"), "/")
+	for i, name := range names {
+		names[i] = url.PathEscape(name)
+	}
+	return prefix + "/" + strings.Join(names, "/")
+}
+
+// folderLink returns the URL of the page of a folder.
+func folderLink(rel string) string {
+	if strings.Trim(rel, "/") == "" {
+		return "/browse/"
+	}
+	return link("/browse", rel) + "/"
+}
+
+// Crumb is a folder of the path leading to the displayed one.
+type Crumb struct {
+	Name string
+	Path string // Relative to the root, slash separated
+}
+
+// Folder is a subfolder of the displayed folder.
+type Folder struct {
+	Name string
+	Path string
+}
+
+// Image is an image of the displayed folder.
+type Image struct {
+	Name    string
+	Path    string
+	Size    int64
+	ModTime time.Time
+}
+
+// page is the data of the folder template.
+type page struct {
+	Title   string
+	Crumbs  []Crumb // From the root to the displayed folder
+	Folders []Folder
+	Images  []Image
+}
+
+// serveFolder writes the page of a folder, redirecting folder paths missing their final slash.
+func (s *Server) serveFolder(w http.ResponseWriter, r *http.Request) {
+	dir, ok := s.resolve(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if !strings.HasSuffix(r.URL.Path, "/") {
+		http.Redirect(w, r, folderLink(r.URL.Path), http.StatusMovedPermanently)
+		return
+	}
+
+	rel := strings.Trim(path.Clean("/"+r.URL.Path), "/")
+	p := page{Title: filepath.Base(s.root), Crumbs: []Crumb{{filepath.Base(s.root), ""}}}
+	if rel != "" {
+		names := strings.Split(rel, "/")
+		for i, name := range names {
+			p.Crumbs = append(p.Crumbs, Crumb{name, strings.Join(names[:i+1], "/")})
+		}
+		p.Title = names[len(names)-1]
+	}
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		switch {
+		case e.IsDir():
+			p.Folders = append(p.Folders, Folder{e.Name(), path.Join(rel, e.Name())})
+		case e.Type().IsRegular() && imagefs.MatchExt(e.Name(), s.exts):
+			info, err := e.Info()
+			if err != nil {
+				continue
+			}
+			p.Images = append(p.Images, Image{e.Name(), path.Join(rel, e.Name()), info.Size(), info.ModTime()})
+		}
+	}
+	sort.Slice(p.Folders, func(i, j int) bool { return p.Folders[i].Name < p.Folders[j].Name })
+	sort.Slice(p.Images, func(i, j int) bool { return p.Images[i].Name < p.Images[j].Name })
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := s.templates.ExecuteTemplate(w, "folder.html", p); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// serveThumbnail writes the thumbnail of an image, creating it when it is not cached yet.
+func (s *Server) serveThumbnail(w http.ResponseWriter, r *http.Request) {
+	file, ok := s.image(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	thumbnail, cached := s.cache.Get(file)
+	if !cached {
+		s.thumbs <- struct{}{}
+		var err error
+		thumbnail, _, err = s.cache.Make(file, false)
+		<-s.thumbs
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	// The thumbnail is named after the image path, size and date, which with the thumbnail size makes a fine ETag
+	w.Header().Set("ETag", fmt.Sprintf(`"%d-%s"`, s.cache.Size, strings.TrimSuffix(filepath.Base(thumbnail), filepath.Ext(thumbnail))))
+	ServeFile(w, r, thumbnail)
+}
+
+// serveOriginal streams an image file.
+func (s *Server) serveOriginal(w http.ResponseWriter, r *http.Request) {
+	file, ok := s.image(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	ServeFile(w, r, file)
+}
+
+// image returns the image file of a path, which must have one of the extensions of the gallery.
+func (s *Server) image(rel string) (string, bool) {
+	file, ok := s.resolve(rel)
+	if !ok || !imagefs.MatchExt(file, s.exts) {
+		return "", false
+	}
+	return file, true
+}
+
+// ServeFile writes a file with its content type, an ETag made of its size and
+// date unless one is set, and Range and conditional request support.
+func ServeFile(w http.ResponseWriter, r *http.Request, file string) {
+	f, err := os.Open(file)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		http.NotFound(w, r)
+		return
+	}
+	if t := mime.TypeByExtension(strings.ToLower(filepath.Ext(file))); t != "" {
+		w.Header().Set("Content-Type", t)
+	}
+	if w.Header().Get("ETag") == "" {
+		w.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, info.Size(), info.ModTime().UnixNano()))
+	}
+	// Revalidated on every use, the ETag making it cheap
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+}
@@ -0,0 +1,247 @@
+// Package glview draws an image with OpenGL 3.3 core: the image is a
+// mipmapped texture on a quad, placed by a projection matrix that fits it to
+// the framebuffer, then zooms, pans, flips and turns it as a viewer.View tells.
+//
+// It draws in the current context and does not depend on the windowing
+// library, so it runs in a GLFW window as well as in an offscreen context.
+// Mesa's software rasterizer (LIBGL_ALWAYS_SOFTWARE=1) is enough.
+package glview
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"log"
+	"strings"
+
+	"github.com/go-gl/gl/v3.3-core/gl"
+	xdraw "golang.org/x/image/draw"
+
+	"github.com/nicky-ayoub/imagination/internal/pkg/fit"
+	"github.com/nicky-ayoub/imagination/internal/pkg/viewer"
+)
+
+/** Vertex shader, placing the unit square with the projection matrix. */
+const VERTEX_SHADER = `#version 330 core
+uniform mat4 projection;
+in vec2 corner;
+out vec2 uv;
+void main() {
+	uv = corner;
+	gl_Position = projection * vec4(corner, 0.0, 1.0);
+}
+` + "\x00"
+
+/** Fragment shader, sampling the image texture. */
+const FRAGMENT_SHADER = `#version 330 core
+uniform sampler2D picture;
+in vec2 uv;
+out vec4 color;
+void main() {
+	color = texture(picture, uv);
+}
+` + "\x00"
+
+// Renderer draws one image at a time in the current OpenGL context.
+type Renderer struct {
+	program    uint32
+	projection int32 // Location of the projection uniform
+	vao        uint32
+	vbo        uint32
+	texture    uint32
+	size       image.Point // Image size, the texture can be smaller
+	maxSize    int         // Largest texture side
+	background [4]float32
+}
+
+// New initializes OpenGL in the current context and returns a renderer drawing in it.
+func New() (*Renderer, error) {
+	if err := gl.Init(); err != nil {
+		return nil, err
+	}
+	r := &Renderer{background: [4]float32{0, 0, 0, 1}}
+	var err error
+	if r.program, err = link(VERTEX_SHADER, FRAGMENT_SHADER); err != nil {
+		return nil, err
+	}
+	r.projection = gl.GetUniformLocation(r.program, gl.Str("projection\x00"))
+
+	// A unit square drawn as a triangle strip, its corners being the texture coordinates
+	corners := []float32{0, 0, 1, 0, 0, 1, 1, 1}
+	gl.GenVertexArrays(1, &r.vao)
+	gl.BindVertexArray(r.vao)
+	gl.GenBuffers(1, &r.vbo)
+	gl.BindBuffer(gl.ARRAY_BUFFER, r.vbo)
+	gl.BufferData(gl.ARRAY_BUFFER, len(corners)*4, gl.Ptr(corners), gl.STATIC_DRAW)
+	corner := uint32(gl.GetAttribLocation(r.program, gl.Str("corner\x00")))
+	gl.EnableVertexAttribArray(corner)
+	gl.VertexAttribPointer(corner, 2, gl.FLOAT, false, 0, nil)
+
+	var maxSize int32
+	gl.GetIntegerv(gl.MAX_TEXTURE_SIZE, &maxSize)
+	r.maxSize = int(maxSize)
+	return r, nil
+}
+
+// SetBackground sets the color around the image.
+func (r *Renderer) SetBackground(c color.Color) {
+	rgba := color.NRGBAModel.Convert(c).(color.NRGBA)
+	r.background = [4]float32{float32(rgba.R) / 255, float32(rgba.G) / 255, float32(rgba.B) / 255, float32(rgba.A) / 255}
+}
+
+// SetImage uploads the image to display. Images larger than the largest
+// texture are reduced, keeping their ratio, and still placed at their size.
+func (r *Renderer) SetImage(img image.Image) {
+	r.size = img.Bounds().Size()
+	rgba := image.NewRGBA(image.Rect(0, 0, r.size.X, r.size.Y))
+	if r.size.X > r.maxSize || r.size.Y > r.maxSize {
+		reduced := fit.Rect(r.size.X, r.size.Y, r.maxSize, r.maxSize, fit.ModeFit, false)
+		rgba = image.NewRGBA(image.Rect(0, 0, reduced.Dx(), reduced.Dy()))
+		log.Printf("Reducing the %dx%d image to %dx%d, the largest texture side being %d", r.size.X, r.size.Y, rgba.Rect.Dx(), rgba.Rect.Dy(), r.maxSize)
+		xdraw.ApproxBiLinear.Scale(rgba, rgba.Rect, img, img.Bounds(), draw.Src, nil)
+	} else {
+		draw.Draw(rgba, rgba.Rect, img, img.Bounds().Min, draw.Src)
+	}
+
+	if r.texture == 0 {
+		gl.GenTextures(1, &r.texture)
+	}
+	gl.BindTexture(gl.TEXTURE_2D, r.texture)
+	gl.PixelStorei(gl.UNPACK_ALIGNMENT, 1)
+	gl.TexImage2D(gl.TEXTURE_2D, 0, gl.RGBA8, int32(rgba.Rect.Dx()), int32(rgba.Rect.Dy()), 0, gl.RGBA, gl.UNSIGNED_BYTE, gl.Ptr(rgba.Pix))
+	// Mipmaps keep downscaled images smooth
+	gl.GenerateMipmap(gl.TEXTURE_2D)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.LINEAR_MIPMAP_LINEAR)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.LINEAR)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_S, gl.CLAMP_TO_EDGE)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_EDGE)
+}
+
+// Draw clears a framebuffer of a size and draws the image in it. The view
+// focus and the pan offset are in framebuffer pixels.
+func (r *Renderer) Draw(framebuffer image.Point, view viewer.View, pan image.Point) {
+	gl.Viewport(0, 0, int32(framebuffer.X), int32(framebuffer.Y))
+	gl.ClearColor(r.background[0], r.background[1], r.background[2], r.background[3])
+	gl.Clear(gl.COLOR_BUFFER_BIT)
+	if r.texture == 0 || framebuffer.X <= 0 || framebuffer.Y <= 0 {
+		return
+	}
+	projection := Projection(r.size, framebuffer, view, pan)
+
+	// Texels are premultiplied, transparent images are blended over the background
+	gl.Enable(gl.BLEND)
+	gl.BlendFunc(gl.ONE, gl.ONE_MINUS_SRC_ALPHA)
+	gl.UseProgram(r.program)
+	gl.UniformMatrix4fv(r.projection, 1, false, &projection[0])
+	gl.ActiveTexture(gl.TEXTURE0)
+	gl.BindTexture(gl.TEXTURE_2D, r.texture)
+	gl.BindVertexArray(r.vao)
+	gl.DrawArrays(gl.TRIANGLE_STRIP, 0, 4)
+}
+
+// Delete frees the OpenGL objects of the renderer.
+func (r *Renderer) Delete() {
+	if r.texture != 0 {
+		gl.DeleteTextures(1, &r.texture)
+	}
+	gl.DeleteBuffers(1, &r.vbo)
+	gl.DeleteVertexArrays(1, &r.vao)
+	gl.DeleteProgram(r.program)
+}
+
+// Placement returns the framebuffer rectangle an image of a size is drawn
+// into once turned: fitted to the framebuffer, enlarged to it when the view
+// scales, enlarged around the view focus, then moved by the pan offset.
+func Placement(size image.Point, framebuffer image.Point, view viewer.View, pan image.Point) (x0, y0, x1, y1 float64) {
+	if view.Transform().SwapsSize() {
+		size.X, size.Y = size.Y, size.X
+	}
+	dst := fit.Rect(size.X, size.Y, framebuffer.X, framebuffer.Y, fit.ModeFit, view.Scale)
+	x0, y0, x1, y1 = float64(dst.Min.X), float64(dst.Min.Y), float64(dst.Max.X), float64(dst.Max.Y)
+	if view.Zoom > 1 {
+		z, fx, fy := float64(view.Zoom), float64(view.Focus.X), float64(view.Focus.Y)
+		x0, y0, x1, y1 = fx+(x0-fx)*z, fy+(y0-fy)*z, fx+(x1-fx)*z, fy+(y1-fy)*z
+	}
+	px, py := float64(pan.X), float64(pan.Y)
+	return x0 + px, y0 + py, x1 + px, y1 + py
+}
+
+// Projection returns the column major matrix taking the corners of the unit
+// square, which are texture coordinates, to the clip space corners of the
+// placed image, flipped and turned.
+func Projection(size image.Point, framebuffer image.Point, view viewer.View, pan image.Point) [16]float32 {
+	x0, y0, x1, y1 := Placement(size, framebuffer, view, pan)
+
+	// Flip and turn around the square center: a mirror negates x, a clockwise quarter turn takes (x, y) to (-y, x)
+	t := view.Transform()
+	l := [2][2]float64{{1, 0}, {0, 1}}
+	if t.Mirror {
+		l[0][0] = -1
+	}
+	for i := 0; i < t.Turns; i++ {
+		l = [2][2]float64{{-l[1][0], -l[1][1]}, {l[0][0], l[0][1]}}
+	}
+	// Scale to the placed rectangle, then to clip space whose y axis goes up
+	sx, sy := 2*(x1-x0)/float64(framebuffer.X), -2*(y1-y0)/float64(framebuffer.Y)
+	cx, cy := (x0+x1)/float64(framebuffer.X)-1, 1-(y0+y1)/float64(framebuffer.Y)
+	a := [2][2]float64{{sx * l[0][0], sx * l[0][1]}, {sy * l[1][0], sy * l[1][1]}}
+	bx := cx - (a[0][0]+a[0][1])/2
+	by := cy - (a[1][0]+a[1][1])/2
+	return [16]float32{
+		float32(a[0][0]), float32(a[1][0]), 0, 0,
+		float32(a[0][1]), float32(a[1][1]), 0, 0,
+		0, 0, 1, 0,
+		float32(bx), float32(by), 0, 1,
+	}
+}
+
+// link compiles and links a shader program.
+func link(vertexSource string, fragmentSource string) (uint32, error) {
+	vertex, err := compile(vertexSource, gl.VERTEX_SHADER)
+	if err != nil {
+		return 0, err
+	}
+	defer gl.DeleteShader(vertex)
+	fragment, err := compile(fragmentSource, gl.FRAGMENT_SHADER)
+	if err != nil {
+		return 0, err
+	}
+	defer gl.DeleteShader(fragment)
+
+	program := gl.CreateProgram()
+	gl.AttachShader(program, vertex)
+	gl.AttachShader(program, fragment)
+	gl.LinkProgram(program)
+	var status int32
+	if gl.GetProgramiv(program, gl.LINK_STATUS, &status); status == gl.FALSE {
+		message := infoLog(program, gl.GetProgramiv, gl.GetProgramInfoLog)
+		gl.DeleteProgram(program)
+		return 0, fmt.Errorf("linking the shaders: %s", message)
+	}
+	return program, nil
+}
+
+func compile(source string, kind uint32) (uint32, error) {
+	shader := gl.CreateShader(kind)
+	sources, free := gl.Strs(source)
+	gl.ShaderSource(shader, 1, sources, nil)
+	free()
+	gl.CompileShader(shader)
+	var status int32
+	if gl.GetShaderiv(shader, gl.COMPILE_STATUS, &status); status == gl.FALSE {
+		message := infoLog(shader, gl.GetShaderiv, gl.GetShaderInfoLog)
+		gl.DeleteShader(shader)
+		return 0, fmt.Errorf("compiling a shader: %s", message)
+	}
+	return shader, nil
+}
+
+// infoLog returns the log of a shader or program.
+func infoLog(object uint32, get func(uint32, uint32, *int32), read func(uint32, int32, *int32, *uint8)) string {
+	var length int32
+	get(object, gl.INFO_LOG_LENGTH, &length)
+	message := strings.Repeat("\x00", int(length)+1)
+	read(object, length, nil, gl.Str(message))
+	return strings.TrimRight(message, "\x00\n")
+}
@@ -0,0 +1,127 @@
+// Package histogram counts the pixel values of an image per channel and draws
+// the result as a panel the viewers display over the image.
+package histogram
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+/** Number of bins of each channel, one per 8 bits value. */
+const BINS = 256
+
+// Histogram holds the pixel counts of each channel.
+type Histogram struct {
+	Red   [BINS]uint32
+	Green [BINS]uint32
+	Blue  [BINS]uint32
+	Luma  [BINS]uint32 // Rec. 709 luma
+	Total uint32       // Number of pixels counted
+}
+
+// Luma returns the Rec. 709 luma of a color, from 0 to 255.
+func Luma(r, g, b uint8) uint8 {
+	return uint8((2126*uint32(r) + 7152*uint32(g) + 722*uint32(b) + 5000) / 10000)
+}
+
+// Compute counts the pixels of img. Fully transparent pixels are skipped.
+func Compute(img image.Image) *Histogram {
+	h := &Histogram{}
+	bounds := img.Bounds()
+	count := func(r, g, b uint8) {
+		h.Red[r]++
+		h.Green[g]++
+		h.Blue[b]++
+		h.Luma[Luma(r, g, b)]++
+		h.Total++
+	}
+
+	// Read the pixel buffer directly for the usual formats, At is slow
+	switch src := img.(type) {
+	case *image.NRGBA:
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			row := src.Pix[src.PixOffset(bounds.Min.X, y):src.PixOffset(bounds.Max.X, y)]
+			for i := 0; i < len(row); i += 4 {
+				if row[i+3] != 0 {
+					count(row[i], row[i+1], row[i+2])
+				}
+			}
+		}
+	case *image.Gray:
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for _, v := range src.Pix[src.PixOffset(bounds.Min.X, y):src.PixOffset(bounds.Max.X, y)] {
+				count(v, v, v)
+			}
+		}
+	default:
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+				if c.A != 0 {
+					count(c.R, c.G, c.B)
+				}
+			}
+		}
+	}
+	return h
+}
+
+// Style describes how the histogram panel looks.
+type Style struct {
+	Width      int // Panel size in pixels, the curves are stretched horizontally to fit
+	Height     int
+	Background color.RGBA // Premultiplied, usually translucent
+}
+
+// DefaultStyle returns a 256x100 panel on a translucent black background.
+func DefaultStyle() Style {
+	return Style{Width: BINS, Height: 100, Background: color.RGBA{0, 0, 0, 176}}
+}
+
+// Image draws the red, green and blue counts additively, so overlapping channels
+// turn white, with the luma as a gray outline.
+func (h *Histogram) Image(style Style) *image.RGBA {
+	panel := image.NewRGBA(image.Rect(0, 0, style.Width, style.Height))
+	draw.Draw(panel, panel.Bounds(), image.NewUniform(style.Background), image.Point{}, draw.Src)
+
+	// Scale on the highest count, ignoring the extreme bins where clipped pixels pile up
+	peak := uint32(1)
+	for i := 1; i < BINS-1; i++ {
+		for _, n := range []uint32{h.Red[i], h.Green[i], h.Blue[i], h.Luma[i]} {
+			if n > peak {
+				peak = n
+			}
+		}
+	}
+	height := func(n uint32) int {
+		v := int(uint64(n) * uint64(style.Height) / uint64(peak))
+		if v > style.Height {
+			v = style.Height
+		}
+		return v
+	}
+
+	for x := 0; x < style.Width; x++ {
+		bin := x * BINS / style.Width
+		r, g, b := height(h.Red[bin]), height(h.Green[bin]), height(h.Blue[bin])
+		for y := 0; y < style.Height; y++ {
+			level := style.Height - y
+			c := panel.RGBAAt(x, y)
+			if level <= r {
+				c.R, c.A = 255, 255
+			}
+			if level <= g {
+				c.G, c.A = 255, 255
+			}
+			if level <= b {
+				c.B, c.A = 255, 255
+			}
+			panel.SetRGBA(x, y, c)
+		}
+		if l := height(h.Luma[bin]); l > 0 {
+			panel.SetRGBA(x, style.Height-l, color.RGBA{160, 160, 160, 255})
+		}
+	}
+	return panel
+}
@@ -0,0 +1,431 @@
+// Package iiif serves the images of a directory with the IIIF Image API 3.0
+// (https://iiif.io/api/image/3.0/), at compliance level 2 with mirroring
+// and upscaling, so IIIF viewers can browse and deep-zoom them:
+//
+//	<prefix>/<identifier>/info.json
+//	<prefix>/<identifier>/<region>/<size>/<rotation>/<quality>.<format>
+//
+// The identifier is the image path relative to the root, its slashes
+// escaped as %2F. Images are served upright, as their EXIF orientation asks.
+// info.json announces tiles of TILE_SIZE pixels at power of two scale
+// factors; decoded images are kept in memory between requests and the
+// returned images in a disk cache, so tiled viewers do not decode large
+// scans again for every tile.
+package iiif
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"image"
+	"image/color"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/sync/singleflight"
+
+	"github.com/nicky-ayoub/imagination/internal/pkg/imagefs"
+	"github.com/nicky-ayoub/imagination/internal/pkg/imageio"
+	"github.com/nicky-ayoub/imagination/internal/pkg/imageops"
+	"github.com/nicky-ayoub/imagination/internal/pkg/rendition"
+	"github.com/nicky-ayoub/imagination/internal/pkg/resize"
+)
+
+/** Width and height of the tiles announced by info.json. */
+const TILE_SIZE = 512
+
+/** Pixels of the decoded images kept in memory when no limit is given, 256 MP or 1 GB. */
+const DEFAULT_DECODED_PIXELS = 256 << 20
+
+// Values of info.json.
+const (
+	CONTEXT  = "http://iiif.io/api/image/3/context.json"
+	PROTOCOL = "http://iiif.io/api/image"
+	PROFILE  = "level2"
+)
+
+// Server is the HTTP handler of the IIIF service.
+type Server struct {
+	prefix  string // Path of the service, like "/iiif"
+	root    string
+	exts    []string
+	cache   *rendition.Cache // Returned images
+	limits  rendition.Limits
+	decoded *decodedCache
+	group   singleflight.Group // Makes identical images arriving together once
+	work    chan struct{}      // Limits the images made at the same time
+}
+
+// New returns the IIIF service of the images of root having one of the
+// extensions, answering the requests under prefix. The returned images are
+// kept in cache and their size is checked against limits.
+func New(prefix string, root string, exts []string, cache *rendition.Cache, limits rendition.Limits) *Server {
+	return &Server{
+		prefix:  strings.TrimSuffix(prefix, "/"),
+		root:    root,
+		exts:    exts,
+		cache:   cache,
+		limits:  limits,
+		decoded: newDecodedCache(DEFAULT_DECODED_PIXELS),
+		work:    make(chan struct{}, runtime.NumCPU()),
+	}
+}
+
+// ServeHTTP answers the IIIF requests.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*") // Viewers are usually on other sites
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	// The escaped path, the identifiers holding escaped slashes
+	segments := strings.Split(strings.TrimPrefix(strings.TrimPrefix(r.URL.EscapedPath(), s.prefix), "/"), "/")
+	for i, segment := range segments {
+		var err error
+		if segments[i], err = url.PathUnescape(segment); err != nil {
+			http.Error(w, "invalid path", http.StatusBadRequest)
+			return
+		}
+	}
+	file, info, ok := s.resolve(segments[0])
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch {
+	case len(segments) == 1:
+		http.Redirect(w, r, s.id(r, segments[0])+"/info.json", http.StatusSeeOther)
+	case len(segments) == 2 && segments[1] == "info.json":
+		s.serveInfo(w, r, segments[0], file)
+	case len(segments) == 5:
+		request, err := ParseRequest(segments[1], segments[2], segments[3], segments[4])
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		s.serveImage(w, r, file, info, request)
+	default:
+		http.Error(w, "want {identifier}/info.json or {identifier}/{region}/{size}/{rotation}/{quality}.{format}", http.StatusBadRequest)
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	if e, ok := err.(*Error); ok {
+		http.Error(w, e.Message, e.Status)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+// resolve returns the image file of an identifier. The path can not go above
+// the root, and hidden names are refused.
+func (s *Server) resolve(identifier string) (string, os.FileInfo, bool) {
+	rel := path.Clean("/" + identifier)
+	for _, name := range strings.Split(rel, "/") {
+		if strings.HasPrefix(name, ".") {
+			return "", nil, false
+		}
+	}
+	if rel == "/" || !imagefs.MatchExt(rel, s.exts) {
+		return "", nil, false
+	}
+	file := filepath.Join(s.root, filepath.FromSlash(rel))
+	info, err := os.Stat(file)
+	if err != nil || !info.Mode().IsRegular() {
+		return "", nil, false
+	}
+	return file, info, true
+}
+
+// id returns the URI of the image of an identifier, as seen by the client.
+func (s *Server) id(r *http.Request, identifier string) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return scheme + "://" + r.Host + s.prefix + "/" + url.PathEscape(identifier)
+}
+
+// Tiles is a tile set of info.json.
+type Tiles struct {
+	Width        int   `json:"width"`
+	ScaleFactors []int `json:"scaleFactors"`
+}
+
+// Size is a preferred size of info.json.
+type Size struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// Info is the info.json document of an image.
+type Info struct {
+	Context        string   `json:"@context"`
+	ID             string   `json:"id"`
+	Type           string   `json:"type"`
+	Protocol       string   `json:"protocol"`
+	Profile        string   `json:"profile"`
+	Width          int      `json:"width"`
+	Height         int      `json:"height"`
+	MaxWidth       int      `json:"maxWidth,omitempty"`
+	MaxHeight      int      `json:"maxHeight,omitempty"`
+	Sizes          []Size   `json:"sizes"`
+	Tiles          []Tiles  `json:"tiles"`
+	ExtraQualities []string `json:"extraQualities"`
+	ExtraFormats   []string `json:"extraFormats"`
+	ExtraFeatures  []string `json:"extraFeatures"`
+}
+
+func (s *Server) serveInfo(w http.ResponseWriter, r *http.Request, identifier string, file string) {
+	width, height, err := dimensions(file)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	info := Info{
+		Context:        CONTEXT,
+		ID:             s.id(r, identifier),
+		Type:           "ImageService3",
+		Protocol:       PROTOCOL,
+		Profile:        PROFILE,
+		Width:          width,
+		Height:         height,
+		MaxWidth:       s.limits.MaxWidth,
+		MaxHeight:      s.limits.MaxHeight,
+		Tiles:          []Tiles{{TILE_SIZE, scaleFactors(width, height)}},
+		ExtraQualities: []string{QUALITY_COLOR, QUALITY_GRAY, QUALITY_BITONAL},
+		ExtraFormats:   []string{"gif", "tif"},
+		ExtraFeatures:  []string{"mirroring", "sizeUpscaling"},
+	}
+	// The whole image at each scale factor that the limits allow, smallest first
+	for _, factor := range info.Tiles[0].ScaleFactors {
+		size := Size{(width + factor - 1) / factor, (height + factor - 1) / factor}
+		if (s.limits.MaxWidth <= 0 || size.Width <= s.limits.MaxWidth) && (s.limits.MaxHeight <= 0 || size.Height <= s.limits.MaxHeight) {
+			info.Sizes = append(info.Sizes, size)
+		}
+	}
+	sort.Slice(info.Sizes, func(i, j int) bool { return info.Sizes[i].Width < info.Sizes[j].Width })
+
+	if strings.Contains(r.Header.Get("Accept"), "application/ld+json") {
+		w.Header().Set("Content-Type", `application/ld+json;profile="`+CONTEXT+`"`)
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(info)
+}
+
+// scaleFactors returns the powers of two from 1 to the first one showing the whole image in a tile.
+func scaleFactors(width int, height int) []int {
+	factors := []int{1}
+	for f := 1; (width+f-1)/f > TILE_SIZE || (height+f-1)/f > TILE_SIZE; {
+		f *= 2
+		factors = append(factors, f)
+	}
+	return factors
+}
+
+// dimensions returns the size of an image file once upright.
+func dimensions(file string) (width int, height int, err error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	config, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return 0, 0, err
+	}
+	if imageops.Orientation(file).SwapsSize() {
+		return config.Height, config.Width, nil
+	}
+	return config.Width, config.Height, nil
+}
+
+func (s *Server) serveImage(w http.ResponseWriter, r *http.Request, file string, info os.FileInfo, request *Request) {
+	width, height, err := dimensions(file)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	// Checked before decoding anything
+	area, err := request.Crop(width, height)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	size, err := request.Scale(area.Size(), s.limits.MaxWidth, s.limits.MaxHeight)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	abs, _ := filepath.Abs(file)
+	key := strings.Join([]string{"iiif", abs, strconv.FormatInt(info.Size(), 10), strconv.FormatInt(info.ModTime().UnixNano(), 10), request.String()}, "\x00")
+	sum := sha256.Sum256([]byte(key))
+	etag := `"` + hex.EncodeToString(sum[:12]) + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Link", `<`+PROTOCOL+`/3/`+PROFILE+`.json>;rel="profile"`)
+	if strings.Contains(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	data, cached := s.cache.Get(key)
+	if !cached {
+		v, err, _ := s.group.Do(key, func() (interface{}, error) {
+			s.work <- struct{}{}
+			defer func() { <-s.work }()
+			data, err := s.render(file, info, area, size, request)
+			if err != nil {
+				return nil, err
+			}
+			s.cache.Put(key, data) // Served even when it can not be kept
+			return data, nil
+		})
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		data = v.([]byte)
+	}
+	w.Header().Set("Content-Type", "image/"+formats[request.Format])
+	http.ServeContent(w, r, "", info.ModTime(), bytes.NewReader(data))
+}
+
+// render makes the image of a request: the area cropped and scaled to size, rotated, and in the requested quality and format.
+func (s *Server) render(file string, info os.FileInfo, area image.Rectangle, size image.Point, request *Request) ([]byte, error) {
+	img, err := s.decoded.get(file, info, s.limits.MaxSourcePixels)
+	if err != nil {
+		return nil, err
+	}
+	area = area.Add(img.Bounds().Min)
+	img = imageops.Crop(img, area)
+	img = resize.Resize(img, size, resize.CATMULL_ROM)
+	if !request.Rotation.IsIdentity() {
+		img = imageops.Apply(img, request.Rotation)
+	}
+	switch request.Quality {
+	case QUALITY_GRAY:
+		img = gray(img, false)
+	case QUALITY_BITONAL:
+		img = gray(img, true)
+	}
+	var buffer bytes.Buffer
+	if err := imageio.Encode(&buffer, img, formats[request.Format], imageio.DEFAULT_QUALITY); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// gray returns the image in shades of gray, or only black and white when bitonal is set.
+func gray(img image.Image, bitonal bool) image.Image {
+	b := img.Bounds()
+	dst := image.NewGray(image.Rect(0, 0, b.Dx(), b.Dy()))
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			g := color.GrayModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.Gray)
+			if bitonal {
+				if g.Y >= 128 {
+					g.Y = 255
+				} else {
+					g.Y = 0
+				}
+			}
+			dst.SetGray(x, y, g)
+		}
+	}
+	return dst
+}
+
+// decodedCache keeps decoded upright images, forgetting the least recently
+// used ones when their pixels go over a capacity. It is safe for concurrent use.
+type decodedCache struct {
+	mutex    sync.Mutex
+	capacity int64
+	pixels   int64
+	lru      *list.List // Of *decoded, the most recently used first
+	entries  map[string]*list.Element
+	group    singleflight.Group // Decodes an image once when it is asked for several times together
+}
+
+type decoded struct {
+	key    string
+	img    image.Image
+	pixels int64
+}
+
+func newDecodedCache(capacity int64) *decodedCache {
+	return &decodedCache{capacity: capacity, lru: list.New(), entries: map[string]*list.Element{}}
+}
+
+// get returns the decoded upright image of a file, refusing images of more than maxPixels when positive.
+func (c *decodedCache) get(file string, info os.FileInfo, maxPixels int64) (image.Image, error) {
+	key := file + "\x00" + strconv.FormatInt(info.Size(), 10) + "\x00" + strconv.FormatInt(info.ModTime().UnixNano(), 10)
+	c.mutex.Lock()
+	if element := c.entries[key]; element != nil {
+		c.lru.MoveToFront(element)
+		c.mutex.Unlock()
+		return element.Value.(*decoded).img, nil
+	}
+	c.mutex.Unlock()
+
+	v, err, _ := c.group.Do(key, func() (interface{}, error) {
+		width, height, err := dimensions(file)
+		if err != nil {
+			return nil, err
+		}
+		if maxPixels > 0 && int64(width)*int64(height) > maxPixels {
+			return nil, &Error{http.StatusUnprocessableEntity, "the image is larger than the pixels allowed"}
+		}
+		img, _, err := imageio.Load(file)
+		if err != nil {
+			return nil, err
+		}
+		if t := imageops.Orientation(file); !t.IsIdentity() {
+			img = imageops.Apply(img, t)
+		}
+		c.put(&decoded{key, img, int64(width) * int64(height)})
+		return img, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(image.Image), nil
+}
+
+func (c *decodedCache) put(d *decoded) {
+	if d.pixels > c.capacity {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.entries[d.key] != nil {
+		return
+	}
+	c.entries[d.key] = c.lru.PushFront(d)
+	c.pixels += d.pixels
+	for c.pixels > c.capacity {
+		element := c.lru.Back()
+		old := element.Value.(*decoded)
+		c.lru.Remove(element)
+		delete(c.entries, old.key)
+		c.pixels -= old.pixels
+	}
+}
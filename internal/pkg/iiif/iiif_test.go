@@ -0,0 +1,324 @@
+package iiif
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/nicky-ayoub/imagination/internal/pkg/imageio"
+	"github.com/nicky-ayoub/imagination/internal/pkg/rendition"
+)
+
+// newServer returns the service of a root holding a.jpg (40x30), sub/b.png
+// (80x60), a hidden .private/c.jpg, a text file and a broken JPEG file.
+func newServer(t *testing.T, limits rendition.Limits) (*Server, *rendition.Cache) {
+	t.Helper()
+	parent := t.TempDir()
+	root := filepath.Join(parent, "root")
+	for _, f := range []struct {
+		name          string
+		width, height int
+	}{
+		{"a.jpg", 40, 30},
+		{"sub/b.png", 80, 60},
+		{".private/c.jpg", 10, 10},
+		{"../outside.jpg", 10, 10},
+	} {
+		path := filepath.Join(root, filepath.FromSlash(f.name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		img := image.NewNRGBA(image.Rect(0, 0, f.width, f.height))
+		for i := range img.Pix {
+			img.Pix[i] = 200
+		}
+		img.SetNRGBA(0, 0, color.NRGBA{255, 0, 0, 255})
+		if err := imageio.Save(path, img, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, data := range map[string]string{"notes.txt": "notes", "broken.jpg": "\xff\xd8 not a JPEG"} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cache, err := rendition.NewCache(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New("/iiif", root, []string{".jpg", ".png"}, cache, limits), cache
+}
+
+// get makes a request and checks its status.
+func get(t *testing.T, s *Server, method string, target string, header http.Header, status int) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(method, target, nil)
+	for name, values := range header {
+		r.Header[name] = values
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != status {
+		t.Fatalf("%s %s: status %d, want %d: %s", method, target, w.Code, status, w.Body)
+	}
+	return w
+}
+
+// decode checks the type and the size of an image, returning it.
+func decode(t *testing.T, w *httptest.ResponseRecorder, format string, size image.Point) image.Image {
+	t.Helper()
+	if got := w.Header().Get("Content-Type"); got != "image/"+format {
+		t.Errorf("content type %q, want image/%s", got, format)
+	}
+	img, got, err := image.Decode(bytes.NewReader(w.Body.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != format || img.Bounds().Size() != size {
+		t.Errorf("%s %v, want %s %v", got, img.Bounds().Size(), format, size)
+	}
+	return img
+}
+
+func TestInfo(t *testing.T) {
+	s, _ := newServer(t, rendition.Limits{MaxWidth: 50, MaxHeight: 50})
+	w := get(t, s, http.MethodGet, "/iiif/sub%2Fb.png", nil, http.StatusSeeOther)
+	if got := w.Header().Get("Location"); got != "http://example.com/iiif/sub%2Fb.png/info.json" {
+		t.Errorf("redirected to %q", got)
+	}
+
+	w = get(t, s, http.MethodGet, "/iiif/sub%2Fb.png/info.json", nil, http.StatusOK)
+	if got := w.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("content type %q, want application/json", got)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("Access-Control-Allow-Origin %q, want *", got)
+	}
+	var info Info
+	if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil {
+		t.Fatal(err)
+	}
+	if info.ID != "http://example.com/iiif/sub%2Fb.png" || info.Width != 80 || info.Height != 60 || info.MaxWidth != 50 {
+		t.Errorf("info %+v", info)
+	}
+	// 80x60 is larger than the limits, only the smaller sizes are given
+	if len(info.Sizes) != 0 {
+		t.Errorf("sizes %v, want none for a single scale factor over the limits", info.Sizes)
+	}
+
+	w = get(t, s, http.MethodGet, "/iiif/a.jpg/info.json", http.Header{"Accept": {"application/ld+json"}}, http.StatusOK)
+	if got := w.Header().Get("Content-Type"); got != `application/ld+json;profile="`+CONTEXT+`"` {
+		t.Errorf("content type %q", got)
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil {
+		t.Fatal(err)
+	}
+	if len(info.Sizes) != 1 || info.Sizes[0] != (Size{40, 30}) {
+		t.Errorf("sizes %v, want [{40 30}]", info.Sizes)
+	}
+}
+
+func TestScaleFactors(t *testing.T) {
+	for _, test := range []struct {
+		width, height int
+		want          []int
+	}{
+		{40, 30, []int{1}},
+		{512, 512, []int{1}},
+		{513, 100, []int{1, 2}},
+		{100, 4000, []int{1, 2, 4, 8}},
+	} {
+		if got := scaleFactors(test.width, test.height); !equal(got, test.want) {
+			t.Errorf("%dx%d: %v, want %v", test.width, test.height, got, test.want)
+		}
+	}
+}
+
+func equal(a []int, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestImage(t *testing.T) {
+	s, _ := newServer(t, rendition.DefaultLimits())
+	for _, test := range []struct {
+		target string
+		format string
+		size   image.Point
+	}{
+		{"/iiif/a.jpg/full/max/0/default.jpg", "jpeg", image.Pt(40, 30)},
+		{"/iiif/a.jpg/full/20,/0/default.png", "png", image.Pt(20, 15)},
+		{"/iiif/a.jpg/square/10,10/0/default.jpg", "jpeg", image.Pt(10, 10)},
+		{"/iiif/a.jpg/0,0,20,10/max/90/default.png", "png", image.Pt(10, 20)},
+		{"/iiif/a.jpg/full/^80,/!270/color.gif", "gif", image.Pt(60, 80)},
+		{"/iiif/sub%2Fb.png/pct:50,50,50,50/max/0/default.tif", "tiff", image.Pt(40, 30)},
+	} {
+		decode(t, get(t, s, http.MethodGet, test.target, nil, http.StatusOK), test.format, test.size)
+	}
+
+	img := decode(t, get(t, s, http.MethodGet, "/iiif/sub%2Fb.png/full/max/0/gray.png", nil, http.StatusOK), "png", image.Pt(80, 60))
+	if _, ok := img.(*image.Gray); !ok {
+		t.Errorf("gray quality decoded as %T, want *image.Gray", img)
+	}
+	img = decode(t, get(t, s, http.MethodGet, "/iiif/sub%2Fb.png/full/max/0/bitonal.png", nil, http.StatusOK), "png", image.Pt(80, 60))
+	for _, p := range []image.Point{{0, 0}, {40, 30}} {
+		if g := color.GrayModel.Convert(img.At(p.X, p.Y)).(color.Gray).Y; g != 0 && g != 255 {
+			t.Errorf("bitonal pixel %v is %d", p, g)
+		}
+	}
+}
+
+func TestImageCache(t *testing.T) {
+	s, cache := newServer(t, rendition.DefaultLimits())
+	const target = "/iiif/a.jpg/full/20,/0/default.jpg"
+	first := get(t, s, http.MethodGet, target, nil, http.StatusOK)
+	size := cache.Size()
+	if size != int64(first.Body.Len()) {
+		t.Errorf("cache size %d, want %d", size, first.Body.Len())
+	}
+	second := get(t, s, http.MethodGet, target, nil, http.StatusOK)
+	if !bytes.Equal(first.Body.Bytes(), second.Body.Bytes()) {
+		t.Error("the cached image differs")
+	}
+	if cache.Size() != size {
+		t.Errorf("cache size %d after a cached request, want %d", cache.Size(), size)
+	}
+
+	etag := first.Header().Get("ETag")
+	if etag == "" || etag != second.Header().Get("ETag") {
+		t.Fatalf("ETags %q and %q", etag, second.Header().Get("ETag"))
+	}
+	if got := first.Header().Get("Link"); got != `<`+PROTOCOL+`/3/`+PROFILE+`.json>;rel="profile"` {
+		t.Errorf("Link %q", got)
+	}
+	get(t, s, http.MethodGet, target, http.Header{"If-None-Match": {etag}}, http.StatusNotModified)
+	other := get(t, s, http.MethodGet, "/iiif/a.jpg/full/20,/0/gray.jpg", nil, http.StatusOK)
+	if other.Header().Get("ETag") == etag {
+		t.Error("another quality has the same ETag")
+	}
+	if w := get(t, s, http.MethodHead, target, nil, http.StatusOK); w.Body.Len() != 0 {
+		t.Errorf("HEAD returned %d bytes", w.Body.Len())
+	}
+
+	// Identical requests arriving together are made and cached once
+	size = cache.Size()
+	const concurrent = "/iiif/sub%2Fb.png/full/40,/0/default.png"
+	bodies := make([][]byte, 8)
+	var wait sync.WaitGroup
+	for i := range bodies {
+		wait.Add(1)
+		go func(i int) {
+			defer wait.Done()
+			r := httptest.NewRequest(http.MethodGet, concurrent, nil)
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, r)
+			if w.Code == http.StatusOK {
+				bodies[i] = w.Body.Bytes()
+			}
+		}(i)
+	}
+	wait.Wait()
+	for i, body := range bodies {
+		if body == nil || !bytes.Equal(body, bodies[0]) {
+			t.Fatalf("concurrent request %d failed or differs", i)
+		}
+	}
+	if got := cache.Size() - size; got != int64(len(bodies[0])) {
+		t.Errorf("cache grew by %d, want %d", got, len(bodies[0]))
+	}
+	// a.jpg and b.png were decoded once each for all their requests
+	if len(s.decoded.entries) != 2 {
+		t.Errorf("%d decoded images, want 2", len(s.decoded.entries))
+	}
+}
+
+func TestDecodedCache(t *testing.T) {
+	s, _ := newServer(t, rendition.DefaultLimits())
+	a := filepath.Join(s.root, "a.jpg")
+	b := filepath.Join(s.root, "sub", "b.png")
+	stat := func(file string) os.FileInfo {
+		info, err := os.Stat(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info
+	}
+
+	// Room for b.png (4800 pixels) and a.jpg (1200 pixels), but not for both
+	c := newDecodedCache(5000)
+	first, err := c.get(a, stat(a), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := c.get(a, stat(a), 0); again != first {
+		t.Error("a.jpg was decoded again")
+	}
+	if _, err := c.get(b, stat(b), 0); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.entries) != 1 || c.pixels != 4800 || c.lru.Front().Value.(*decoded).img.Bounds().Dx() != 80 {
+		t.Errorf("%d entries of %d pixels, want only b.png", len(c.entries), c.pixels)
+	}
+
+	// Images larger than the capacity are returned but not kept
+	c = newDecodedCache(1000)
+	if _, err := c.get(b, stat(b), 0); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.entries) != 0 || c.pixels != 0 {
+		t.Errorf("%d entries of %d pixels, want none", len(c.entries), c.pixels)
+	}
+
+	// Images larger than the pixels allowed are refused
+	_, err = c.get(b, stat(b), 4799)
+	if e, ok := err.(*Error); !ok || e.Status != http.StatusUnprocessableEntity {
+		t.Errorf("4800 pixels for 4799 allowed: %v, want status 422", err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	s, _ := newServer(t, rendition.Limits{MaxWidth: 100, MaxHeight: 100, MaxSourcePixels: 2000})
+	for _, test := range []struct {
+		method string
+		target string
+		status int
+	}{
+		{http.MethodPost, "/iiif/a.jpg/info.json", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/iiif/", http.StatusNotFound},
+		{http.MethodGet, "/iiif/missing.jpg/info.json", http.StatusNotFound},
+		{http.MethodGet, "/iiif/notes.txt/info.json", http.StatusNotFound},
+		{http.MethodGet, "/iiif/sub/info.json", http.StatusNotFound},
+		{http.MethodGet, "/iiif/.private%2Fc.jpg/info.json", http.StatusNotFound},
+		{http.MethodGet, "/iiif/..%2Foutside.jpg/info.json", http.StatusNotFound},
+		{http.MethodGet, "/iiif/%2e%2e%2Foutside.jpg/info.json", http.StatusNotFound},
+		{http.MethodGet, "/iiif/sub%2F..%2F..%2Foutside.jpg/full/max/0/default.jpg", http.StatusNotFound},
+		{http.MethodGet, "/iiif/a.jpg/full/max", http.StatusBadRequest},
+		{http.MethodGet, "/iiif/a.jpg/full/max/0/default.webp", http.StatusNotImplemented},
+		{http.MethodGet, "/iiif/a.jpg/full/max/45/default.jpg", http.StatusNotImplemented},
+		{http.MethodGet, "/iiif/a.jpg/100,100,10,10/max/0/default.jpg", http.StatusBadRequest},
+		{http.MethodGet, "/iiif/a.jpg/full/80,/0/default.jpg", http.StatusBadRequest},
+		{http.MethodGet, "/iiif/a.jpg/full/^200,/0/default.jpg", http.StatusBadRequest},
+		{http.MethodGet, "/iiif/broken.jpg/info.json", http.StatusUnprocessableEntity},
+		{http.MethodGet, "/iiif/broken.jpg/full/max/0/default.jpg", http.StatusUnprocessableEntity},
+		{http.MethodGet, "/iiif/sub%2Fb.png/full/max/0/default.png", http.StatusUnprocessableEntity},
+	} {
+		w := get(t, s, test.method, test.target, nil, test.status)
+		if test.status == http.StatusMethodNotAllowed && w.Header().Get("Allow") != "GET, HEAD" {
+			t.Errorf("Allow %q, want GET, HEAD", w.Header().Get("Allow"))
+		}
+	}
+}
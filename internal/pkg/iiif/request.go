@@ -0,0 +1,233 @@
+package iiif
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/nicky-ayoub/imagination/internal/pkg/imageops"
+)
+
+// Error is a request the server can not answer, with the HTTP status telling why.
+type Error struct {
+	Status  int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func badRequest(format string, args ...interface{}) *Error {
+	return &Error{http.StatusBadRequest, fmt.Sprintf(format, args...)}
+}
+
+func notImplemented(format string, args ...interface{}) *Error {
+	return &Error{http.StatusNotImplemented, fmt.Sprintf(format, args...)}
+}
+
+// Qualities of the returned images.
+const (
+	QUALITY_DEFAULT = "default"
+	QUALITY_COLOR   = "color"
+	QUALITY_GRAY    = "gray"
+	QUALITY_BITONAL = "bitonal"
+)
+
+// Formats that can be returned, by IIIF name.
+var formats = map[string]string{
+	"jpg": "jpeg",
+	"png": "png",
+	"gif": "gif",
+	"tif": "tiff",
+}
+
+// Request is an image request: region, size, rotation, quality and format.
+type Request struct {
+	Region   string
+	Size     string
+	Rotation imageops.Transform
+	Quality  string
+	Format   string // IIIF name, like "jpg"
+}
+
+// ParseRequest reads the path segments following the identifier of an image request.
+func ParseRequest(region string, size string, rotation string, file string) (*Request, error) {
+	r := &Request{Region: region, Size: size}
+	dot := strings.LastIndexByte(file, '.')
+	if dot < 0 {
+		return nil, badRequest("no format in %q", file)
+	}
+	r.Quality, r.Format = file[:dot], file[dot+1:]
+	switch r.Quality {
+	case QUALITY_DEFAULT, QUALITY_COLOR, QUALITY_GRAY, QUALITY_BITONAL:
+	default:
+		return nil, badRequest("unknown quality %q", r.Quality)
+	}
+	if formats[r.Format] == "" {
+		switch r.Format {
+		case "webp", "jp2", "pdf":
+			return nil, notImplemented("format %s is not supported", r.Format)
+		}
+		return nil, badRequest("unknown format %q", r.Format)
+	}
+
+	mirror := strings.HasPrefix(rotation, "!")
+	degrees, err := strconv.ParseFloat(strings.TrimPrefix(rotation, "!"), 64)
+	if err != nil || degrees < 0 || degrees > 360 {
+		return nil, badRequest("invalid rotation %q", rotation)
+	}
+	if math.Mod(degrees, 90) != 0 {
+		return nil, notImplemented("only rotations by multiples of 90 degrees are supported")
+	}
+	r.Rotation = imageops.Transform{Mirror: mirror, Turns: int(degrees/90) % 4}
+	return r, nil
+}
+
+// String returns the request as it is written in URLs.
+func (r *Request) String() string {
+	rotation := strconv.Itoa(r.Rotation.Turns * 90)
+	if r.Rotation.Mirror {
+		rotation = "!" + rotation
+	}
+	return strings.Join([]string{r.Region, r.Size, rotation, r.Quality + "." + r.Format}, "/")
+}
+
+// Crop returns the area of a width x height image that the region selects,
+// clipped to the image.
+func (r *Request) Crop(width int, height int) (image.Rectangle, error) {
+	bounds := image.Rect(0, 0, width, height)
+	switch {
+	case r.Region == "full":
+		return bounds, nil
+	case r.Region == "square":
+		if width > height {
+			x := (width - height) / 2
+			return image.Rect(x, 0, x+height, height), nil
+		}
+		y := (height - width) / 2
+		return image.Rect(0, y, width, y+width), nil
+	}
+
+	percent := strings.HasPrefix(r.Region, "pct:")
+	values, err := numbers(strings.TrimPrefix(r.Region, "pct:"), 4, percent)
+	if err != nil {
+		return image.Rectangle{}, badRequest("invalid region %q", r.Region)
+	}
+	if percent {
+		values[0], values[2] = values[0]*float64(width)/100, values[2]*float64(width)/100
+		values[1], values[3] = values[1]*float64(height)/100, values[3]*float64(height)/100
+	}
+	x, y := int(math.Round(values[0])), int(math.Round(values[1]))
+	area := image.Rect(x, y, x+int(math.Round(values[2])), y+int(math.Round(values[3])))
+	if area.Empty() {
+		return image.Rectangle{}, badRequest("empty region %q", r.Region)
+	}
+	if area = area.Intersect(bounds); area.Empty() {
+		return image.Rectangle{}, badRequest("region %q is outside the %dx%d image", r.Region, width, height)
+	}
+	return area, nil
+}
+
+// numbers parses n comma separated non-negative numbers, integers unless decimals is set.
+func numbers(s string, n int, decimals bool) ([]float64, error) {
+	fields := strings.Split(s, ",")
+	if len(fields) != n {
+		return nil, fmt.Errorf("want %d numbers", n)
+	}
+	values := make([]float64, n)
+	for i, f := range fields {
+		var err error
+		if decimals {
+			values[i], err = strconv.ParseFloat(f, 64)
+		} else {
+			var v int
+			v, err = strconv.Atoi(f)
+			values[i] = float64(v)
+		}
+		if err != nil || values[i] < 0 || math.IsInf(values[i], 0) || math.IsNaN(values[i]) {
+			return nil, fmt.Errorf("invalid number %q", f)
+		}
+	}
+	return values, nil
+}
+
+// Scale returns the size a region is scaled to, checked against the largest
+// width and height of the server, not positive for no limit.
+func (r *Request) Scale(region image.Point, maxWidth int, maxHeight int) (image.Point, error) {
+	upscale := strings.HasPrefix(r.Size, "^")
+	s := strings.TrimPrefix(r.Size, "^")
+	rw, rh := float64(region.X), float64(region.Y)
+	limit := func(scale float64) float64 {
+		if maxWidth > 0 {
+			scale = math.Min(scale, float64(maxWidth)/rw)
+		}
+		if maxHeight > 0 {
+			scale = math.Min(scale, float64(maxHeight)/rh)
+		}
+		return scale
+	}
+
+	var w, h float64
+	switch {
+	case s == "max":
+		scale := 1.0
+		if upscale && (maxWidth > 0 || maxHeight > 0) {
+			scale = math.Inf(1)
+		}
+		scale = limit(scale)
+		w, h = rw*scale, rh*scale
+	case strings.HasPrefix(s, "pct:"):
+		n, err := strconv.ParseFloat(strings.TrimPrefix(s, "pct:"), 64)
+		if err != nil || n <= 0 || math.IsInf(n, 0) {
+			return image.Point{}, badRequest("invalid size %q", r.Size)
+		}
+		w, h = rw*n/100, rh*n/100
+	case strings.HasPrefix(s, "!"):
+		values, err := numbers(strings.TrimPrefix(s, "!"), 2, false)
+		if err != nil || values[0] == 0 || values[1] == 0 {
+			return image.Point{}, badRequest("invalid size %q", r.Size)
+		}
+		scale := math.Min(values[0]/rw, values[1]/rh)
+		if !upscale {
+			scale = math.Min(scale, 1)
+		}
+		scale = limit(scale)
+		w, h = rw*scale, rh*scale
+	default:
+		fields := strings.Split(s, ",")
+		if len(fields) != 2 || fields[0] == "" && fields[1] == "" {
+			return image.Point{}, badRequest("invalid size %q", r.Size)
+		}
+		var err error
+		if fields[0] != "" {
+			if w, err = strconv.ParseFloat(fields[0], 64); err != nil || w != math.Trunc(w) || w <= 0 {
+				return image.Point{}, badRequest("invalid size %q", r.Size)
+			}
+		}
+		if fields[1] != "" {
+			if h, err = strconv.ParseFloat(fields[1], 64); err != nil || h != math.Trunc(h) || h <= 0 {
+				return image.Point{}, badRequest("invalid size %q", r.Size)
+			}
+		}
+		if w == 0 {
+			w = rw * h / rh
+		} else if h == 0 {
+			h = rh * w / rw
+		}
+	}
+
+	size := image.Pt(int(math.Round(w)), int(math.Round(h)))
+	switch {
+	case size.X < 1 || size.Y < 1:
+		return image.Point{}, badRequest("size %q gives an empty image", r.Size)
+	case !upscale && (size.X > region.X || size.Y > region.Y):
+		return image.Point{}, badRequest("size %q is larger than the region, ask for ^%s to upscale", r.Size, r.Size)
+	case maxWidth > 0 && size.X > maxWidth || maxHeight > 0 && size.Y > maxHeight:
+		return image.Point{}, badRequest("size %dx%d is larger than the %dx%d allowed", size.X, size.Y, maxWidth, maxHeight)
+	}
+	return size, nil
+}
@@ -0,0 +1,119 @@
+package iiif
+
+import (
+	"image"
+	"net/http"
+	"testing"
+
+	"github.com/nicky-ayoub/imagination/internal/pkg/imageops"
+)
+
+func TestParseRequest(t *testing.T) {
+	for _, test := range []struct {
+		segments [4]string
+		want     Request
+	}{
+		{[4]string{"full", "max", "0", "default.jpg"}, Request{"full", "max", imageops.Transform{}, QUALITY_DEFAULT, "jpg"}},
+		{[4]string{"square", "^!100,100", "!90", "gray.png"}, Request{"square", "^!100,100", imageops.Transform{Mirror: true, Turns: 1}, QUALITY_GRAY, "png"}},
+		{[4]string{"pct:10,10,50,50", "pct:25", "360", "bitonal.tif"}, Request{"pct:10,10,50,50", "pct:25", imageops.Transform{}, QUALITY_BITONAL, "tif"}},
+	} {
+		s := test.segments
+		r, err := ParseRequest(s[0], s[1], s[2], s[3])
+		if err != nil {
+			t.Errorf("%v: %v", s, err)
+			continue
+		}
+		if *r != test.want {
+			t.Errorf("%v: %+v, want %+v", s, *r, test.want)
+		}
+	}
+	for _, test := range []struct {
+		segments [4]string
+		status   int
+	}{
+		{[4]string{"full", "max", "0", "default"}, http.StatusBadRequest},
+		{[4]string{"full", "max", "0", "sepia.jpg"}, http.StatusBadRequest},
+		{[4]string{"full", "max", "0", "default.bmp"}, http.StatusBadRequest},
+		{[4]string{"full", "max", "0", "default.webp"}, http.StatusNotImplemented},
+		{[4]string{"full", "max", "-90", "default.jpg"}, http.StatusBadRequest},
+		{[4]string{"full", "max", "450", "default.jpg"}, http.StatusBadRequest},
+		{[4]string{"full", "max", "left", "default.jpg"}, http.StatusBadRequest},
+		{[4]string{"full", "max", "45", "default.jpg"}, http.StatusNotImplemented},
+	} {
+		s := test.segments
+		_, err := ParseRequest(s[0], s[1], s[2], s[3])
+		if e, ok := err.(*Error); !ok || e.Status != test.status {
+			t.Errorf("%v: %v, want status %d", s, err, test.status)
+		}
+	}
+}
+
+func TestCrop(t *testing.T) {
+	for _, test := range []struct {
+		region string
+		want   image.Rectangle
+	}{
+		{"full", image.Rect(0, 0, 40, 30)},
+		{"square", image.Rect(5, 0, 35, 30)},
+		{"10,5,20,10", image.Rect(10, 5, 30, 15)},
+		{"10,10,100,100", image.Rect(10, 10, 40, 30)},
+		{"pct:50,50,50,50", image.Rect(20, 15, 40, 30)},
+		{"pct:12.5,0,25,100", image.Rect(5, 0, 15, 30)},
+	} {
+		r := &Request{Region: test.region}
+		if got, err := r.Crop(40, 30); err != nil || got != test.want {
+			t.Errorf("%s: %v, %v, want %v", test.region, got, err, test.want)
+		}
+	}
+	for _, region := range []string{"40,0,10,10", "0,0,0,10", "1,2,3", "1,2,3,x", "-1,0,10,10", "1.5,0,10,10", "pct:0,0,NaN,10", "everything"} {
+		r := &Request{Region: region}
+		if got, err := r.Crop(40, 30); err == nil {
+			t.Errorf("%s: %v, want an error", region, got)
+		}
+	}
+}
+
+func TestScale(t *testing.T) {
+	for _, test := range []struct {
+		size    string
+		maxEdge int
+		want    image.Point
+	}{
+		{"max", 0, image.Pt(40, 30)},
+		{"max", 20, image.Pt(20, 15)},
+		{"^max", 80, image.Pt(80, 60)},
+		{"20,", 0, image.Pt(20, 15)},
+		{",15", 0, image.Pt(20, 15)},
+		{"10,10", 0, image.Pt(10, 10)},
+		{"!10,10", 0, image.Pt(10, 8)},
+		{"!100,100", 0, image.Pt(40, 30)},
+		{"^!100,100", 0, image.Pt(100, 75)},
+		{"pct:50", 0, image.Pt(20, 15)},
+		{"^80,", 0, image.Pt(80, 60)},
+	} {
+		r := &Request{Size: test.size}
+		if got, err := r.Scale(image.Pt(40, 30), test.maxEdge, test.maxEdge); err != nil || got != test.want {
+			t.Errorf("%s, at most %d: %v, %v, want %v", test.size, test.maxEdge, got, err, test.want)
+		}
+	}
+	for _, test := range []struct {
+		size    string
+		maxEdge int
+	}{
+		{"80,", 0},
+		{"pct:200", 0},
+		{"^80,", 50},
+		{",", 0},
+		{"0,10", 0},
+		{"10.5,", 0},
+		{"pct:0", 0},
+		{"pct:0.1", 0},
+		{"!0,10", 0},
+		{"big", 0},
+	} {
+		r := &Request{Size: test.size}
+		if got, err := r.Scale(image.Pt(40, 30), test.maxEdge, test.maxEdge); err == nil {
+			t.Errorf("%s, at most %d: %v, want an error", test.size, test.maxEdge, got)
+		}
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 )
 
 func CountGoFiles(folder string) (count int) {
@@ -37,6 +39,9 @@ func CountAllFilesByExt(folder string, ext string) (count int) {
 	fsys := os.DirFS(folder)
 	fmt.Println("Scanning  all " + ext + " in folder " + folder)
 	fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if hidden(p, d) {
+			return fs.SkipDir
+		}
 		if filepath.Ext(p) == ext {
 			count++
 		}
@@ -54,13 +59,23 @@ func AllGoFiles(folder string) (files []string) {
 }
 
 func AllFilesByExt(folder string, exts []string) (files []string) {
+	fmt.Println("Scanning  all in folder " + folder)
+	return FilesByExt(folder, exts)
+}
+
+// FilesByExt is AllFilesByExt without the progress message, for commands
+// whose standard output is parsed. Paths are relative to folder. Hidden
+// directories are skipped, as by Walk.
+func FilesByExt(folder string, exts []string) (files []string) {
 	valid := make(map[string]bool)
 	for _, s := range exts {
 		valid[s] = true
 	}
 	fsys := os.DirFS(folder)
-	fmt.Println("Scanning  all in folder " + folder)
 	fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if hidden(p, d) {
+			return fs.SkipDir
+		}
 		if valid[filepath.Ext(p)] {
 			files = append(files, p)
 		}
@@ -68,3 +83,102 @@ func AllFilesByExt(folder string, exts []string) (files []string) {
 	})
 	return files
 }
+
+// hidden tells whether a walked entry is a hidden directory other than the root.
+func hidden(p string, d fs.DirEntry) bool {
+	return d != nil && d.IsDir() && p != "." && strings.HasPrefix(d.Name(), ".")
+}
+
+// FormatSize returns a file size as a short human readable text, like "2.4 MB".
+func FormatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// File is an image file found by Find.
+type File struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modified"`
+}
+
+// MatchExt tells whether a file name has one of the extensions, ignoring case.
+// Every file matches when exts is empty.
+func MatchExt(name string, exts []string) bool {
+	if len(exts) == 0 {
+		return true
+	}
+	ext := filepath.Ext(name)
+	for _, e := range exts {
+		if strings.EqualFold(ext, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// Walk calls fn for every file of root having one of the extensions, in
+// lexical order. Hidden directories, like the .trash of the viewers, are
+// skipped. root can also be a single file, which is then reported whatever
+// its extension. Errors reading directories are passed to onError, which
+// stops the walk by returning an error; a nil onError ignores them.
+func Walk(root string, exts []string, fn func(File) error, onError func(path string, err error) error) error {
+	info, err := os.Stat(root)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fn(File{Path: root, Size: info.Size(), ModTime: info.ModTime()})
+	}
+	return filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if onError != nil {
+				return onError(p, err)
+			}
+			return nil
+		}
+		if d.IsDir() {
+			if p != root && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || !MatchExt(p, exts) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			if onError != nil {
+				return onError(p, err)
+			}
+			return nil
+		}
+		return fn(File{Path: p, Size: info.Size(), ModTime: info.ModTime()})
+	})
+}
+
+// Find returns the image files of several roots, see Walk. Unreadable roots
+// and directories are reported in errs, the other files are still returned.
+func Find(roots []string, exts []string) (files []File, errs []error) {
+	for _, root := range roots {
+		err := Walk(root, exts, func(f File) error {
+			files = append(files, f)
+			return nil
+		}, func(path string, err error) error {
+			errs = append(errs, err)
+			return nil
+		})
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return files, errs
+}
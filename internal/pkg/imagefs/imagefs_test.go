@@ -0,0 +1,40 @@
+package imagefs
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestHidden checks the files moved to the .trash of the viewers are not listed again.
+func TestHidden(t *testing.T) {
+	directory := t.TempDir()
+	for _, name := range []string{"a.jpg", "sub/b.jpg", ".trash/c.jpg", "sub/.cache/d.jpg", ".e.jpg", "f.png"} {
+		path := filepath.Join(directory, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{".e.jpg", "a.jpg", "sub/b.jpg"}
+	if files := FilesByExt(directory, []string{".jpg"}); !reflect.DeepEqual(files, want) {
+		t.Errorf("FilesByExt: %q, want %q", files, want)
+	}
+	if count := CountAllFilesByExt(directory, ".jpg"); count != len(want) {
+		t.Errorf("CountAllFilesByExt: %d, want %d", count, len(want))
+	}
+	var walked []string
+	if err := Walk(directory, []string{".jpg"}, func(f File) error {
+		rel, _ := filepath.Rel(directory, f.Path)
+		walked = append(walked, filepath.ToSlash(rel))
+		return nil
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(walked, want) {
+		t.Errorf("Walk: %q, want %q", walked, want)
+	}
+}
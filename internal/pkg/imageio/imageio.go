@@ -0,0 +1,139 @@
+// Package imageio reads and writes image files, choosing the format from the
+// file extension.
+package imageio
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+/** JPEG quality used when none is given. */
+const DEFAULT_QUALITY = 90
+
+// Formats that can be written, by name.
+var formats = map[string]string{
+	".jpg":  "jpeg",
+	".jpeg": "jpeg",
+	".png":  "png",
+	".gif":  "gif",
+	".bmp":  "bmp",
+	".tif":  "tiff",
+	".tiff": "tiff",
+}
+
+// Format returns the format written for a file name, or an empty string when it is not supported.
+func Format(path string) string {
+	return formats[strings.ToLower(filepath.Ext(path))]
+}
+
+// Extension returns the usual file extension of a format.
+func Extension(format string) string {
+	switch format {
+	case "jpeg":
+		return ".jpg"
+	case "tiff":
+		return ".tif"
+	}
+	return "." + format
+}
+
+// Load decodes an image file.
+func Load(path string) (img image.Image, format string, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+	if img, format, err = image.Decode(f); err != nil {
+		return nil, "", fmt.Errorf("%s: %w", path, err)
+	}
+	return img, format, nil
+}
+
+// Config reads the size and color model of an image file from its header, without decoding it.
+func Config(path string) (config image.Config, format string, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return image.Config{}, "", err
+	}
+	defer f.Close()
+	if config, format, err = image.DecodeConfig(f); err != nil {
+		return image.Config{}, "", fmt.Errorf("%s: %w", path, err)
+	}
+	return config, format, nil
+}
+
+// Save encodes img to path in the format of its extension. quality is the JPEG quality, 0 for DEFAULT_QUALITY.
+func Save(path string, img image.Image, quality int) (err error) {
+	format := Format(path)
+	if format == "" {
+		return fmt.Errorf("%s: unsupported image format", path)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(path)
+		}
+	}()
+	return Encode(f, img, format, quality)
+}
+
+// Encode writes img in a format: jpeg, png, gif, bmp or tiff.
+func Encode(w io.Writer, img image.Image, format string, quality int) error {
+	if quality <= 0 {
+		quality = DEFAULT_QUALITY
+	}
+	switch format {
+	case "jpeg":
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	case "png":
+		return png.Encode(w, img)
+	case "gif":
+		return gif.Encode(w, img, nil)
+	case "bmp":
+		return bmp.Encode(w, img)
+	case "tiff":
+		// The TIFF encoder reads the pixels of images not starting at 0,0 out of bounds
+		if b := img.Bounds(); b.Min != (image.Point{}) {
+			dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+			draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+			img = dst
+		}
+		return tiff.Encode(w, img, &tiff.Options{Compression: tiff.Deflate})
+	}
+	return fmt.Errorf("unsupported image format %q", format)
+}
+
+// FreePath returns path with a suffix added before its extension ("photo.jpg"
+// becomes "photo-adjusted.jpg"), numbered when that file already exists.
+func FreePath(path string, suffix string, ext string) string {
+	if ext == "" {
+		ext = filepath.Ext(path)
+	}
+	base := strings.TrimSuffix(path, filepath.Ext(path)) + "-" + suffix
+	candidate := base + ext
+	for i := 2; ; i++ {
+		if _, err := os.Stat(candidate); os.IsNotExist(err) {
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s-%d%s", base, i, ext)
+	}
+}
@@ -0,0 +1,282 @@
+// Package imageops crops, flips and rotates images, in memory or in their files.
+//
+// JPEG files are transformed losslessly, on the DCT coefficients, by the
+// jpegtran tool of libjpeg when it is installed. When it is not, or when the
+// image size prevents a perfect transformation, only the EXIF orientation tag
+// is rewritten so viewers honoring it display the transformed image. Other
+// formats are decoded, transformed and encoded again, which is lossless for
+// PNG, GIF, BMP and TIFF.
+package imageops
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/nicky-ayoub/imagination/internal/pkg/exif"
+	"github.com/nicky-ayoub/imagination/internal/pkg/imageio"
+)
+
+// Transform is one of the 8 flips and quarter turns of an image: an optional
+// horizontal mirror followed by a number of clockwise quarter turns.
+type Transform struct {
+	Mirror bool
+	Turns  int // Clockwise quarter turns, 0 to 3
+}
+
+// The transforms jpegtran and the EXIF orientation tag know.
+var (
+	IDENTITY        = Transform{}
+	FLIP_HORIZONTAL = Transform{Mirror: true}
+	FLIP_VERTICAL   = Transform{Mirror: true, Turns: 2}
+	ROTATE_90       = Transform{Turns: 1}
+	ROTATE_180      = Transform{Turns: 2}
+	ROTATE_270      = Transform{Turns: 3}
+	TRANSPOSE       = Transform{Mirror: true, Turns: 3} // Across the top left to bottom right diagonal
+	TRANSVERSE      = Transform{Mirror: true, Turns: 1} // Across the top right to bottom left diagonal
+)
+
+// EXIF orientations, the index being the orientation tag value.
+var orientations = [9]Transform{1: IDENTITY, 2: FLIP_HORIZONTAL, 3: ROTATE_180, 4: FLIP_VERTICAL, 5: TRANSPOSE, 6: ROTATE_90, 7: TRANSVERSE, 8: ROTATE_270}
+
+func (t Transform) normalize() Transform {
+	t.Turns = ((t.Turns % 4) + 4) % 4
+	return t
+}
+
+// Then returns the transform applying t, then next.
+func (t Transform) Then(next Transform) Transform {
+	t, next = t.normalize(), next.normalize()
+	// A mirror reverses the direction of the turns done before it
+	turns := t.Turns
+	if next.Mirror {
+		turns = -turns
+	}
+	return Transform{Mirror: t.Mirror != next.Mirror, Turns: turns + next.Turns}.normalize()
+}
+
+// IsIdentity tells whether the transform leaves images unchanged.
+func (t Transform) IsIdentity() bool {
+	return t.normalize() == IDENTITY
+}
+
+// SwapsSize tells whether the transform swaps the image width and height.
+func (t Transform) SwapsSize() bool {
+	return t.normalize().Turns%2 == 1
+}
+
+// FromOrientation returns the transform an EXIF orientation tag asks for, IDENTITY for unknown values.
+func FromOrientation(orientation int) Transform {
+	if orientation < 1 || orientation > 8 {
+		return IDENTITY
+	}
+	return orientations[orientation]
+}
+
+// Orientation returns the EXIF orientation tag value of the transform.
+func (t Transform) Orientation() int {
+	t = t.normalize()
+	for o := 1; o <= 8; o++ {
+		if orientations[o] == t {
+			return o
+		}
+	}
+	return 1
+}
+
+// Arguments returns the jpegtran arguments doing the transform.
+func (t Transform) Arguments() []string {
+	switch t.normalize() {
+	case FLIP_HORIZONTAL:
+		return []string{"-flip", "horizontal"}
+	case FLIP_VERTICAL:
+		return []string{"-flip", "vertical"}
+	case ROTATE_90:
+		return []string{"-rotate", "90"}
+	case ROTATE_180:
+		return []string{"-rotate", "180"}
+	case ROTATE_270:
+		return []string{"-rotate", "270"}
+	case TRANSPOSE:
+		return []string{"-transpose"}
+	case TRANSVERSE:
+		return []string{"-transverse"}
+	}
+	return nil
+}
+
+func (t Transform) String() string {
+	if t.IsIdentity() {
+		return "none"
+	}
+	return strings.TrimPrefix(strings.Join(t.Arguments(), " "), "-")
+}
+
+// Parse reads a transform written as in jpegtran arguments: "rotate 90",
+// "flip horizontal", "transpose", ... or "none".
+func Parse(s string) (Transform, error) {
+	fields := strings.Fields(strings.TrimLeft(strings.ToLower(s), "-"))
+	for o := 1; o <= 8; o++ {
+		if strings.Join(fields, " ") == orientations[o].String() {
+			return orientations[o], nil
+		}
+	}
+	return IDENTITY, fmt.Errorf("unknown transform %q", s)
+}
+
+// Apply returns the transformed image.
+func Apply(img image.Image, t Transform) *image.NRGBA {
+	t = t.normalize()
+	bounds := img.Bounds()
+	src, ok := img.(*image.NRGBA)
+	if !ok {
+		src = image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+		draw.Draw(src, src.Rect, img, bounds.Min, draw.Src)
+		bounds = src.Rect
+	}
+	w, h := bounds.Dx(), bounds.Dy()
+	dw, dh := w, h
+	if t.SwapsSize() {
+		dw, dh = h, w
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			a, b := x, y
+			if t.Mirror {
+				a = w - 1 - a
+			}
+			switch t.Turns {
+			case 1:
+				a, b = h-1-b, a
+			case 2:
+				a, b = w-1-a, h-1-b
+			case 3:
+				a, b = b, w-1-a
+			}
+			i := src.PixOffset(bounds.Min.X+x, bounds.Min.Y+y)
+			copy(dst.Pix[dst.PixOffset(a, b):dst.PixOffset(a, b)+4], src.Pix[i:i+4])
+		}
+	}
+	return dst
+}
+
+// Crop returns the part of img inside r, sharing the pixels when the image
+// type has a SubImage method. Decoders are free to return images without
+// one, which are copied instead, the copy bounds starting at 0,0.
+func Crop(img image.Image, r image.Rectangle) image.Image {
+	if sub, ok := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok {
+		return sub.SubImage(r)
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(dst, dst.Rect, img, r.Min, draw.Src)
+	return dst
+}
+
+// Method tells how a file was transformed.
+type Method string
+
+const (
+	METHOD_NONE      Method = "none"      // Nothing to do
+	METHOD_JPEGTRAN  Method = "jpegtran"  // Lossless JPEG transform, the orientation tag reset
+	METHOD_TAG       Method = "exif-tag"  // Only the EXIF orientation tag changed
+	METHOD_REENCODED Method = "reencoded" // Decoded, transformed and encoded again
+)
+
+// Options control how files are transformed.
+type Options struct {
+	TagOnly  bool   // Never change JPEG pixels, only the orientation tag
+	Jpegtran string // jpegtran executable, looked for in $PATH when empty
+}
+
+// Orientation returns the transform the EXIF orientation tag of a file asks for.
+func Orientation(path string) Transform {
+	e, err := exif.Read(path)
+	if err != nil {
+		return IDENTITY
+	}
+	return FromOrientation(e.Orientation)
+}
+
+// TransformFile applies t to the image as it is displayed, that is after its EXIF orientation.
+func TransformFile(path string, t Transform, options Options) (Method, error) {
+	return Persist(path, Orientation(path).Then(t), options)
+}
+
+// Persist rewrites a file so that viewers honoring the EXIF orientation display
+// its stored pixels transformed by total.
+func Persist(path string, total Transform, options Options) (Method, error) {
+	if imageio.Format(path) != "jpeg" {
+		if total.IsIdentity() {
+			return METHOD_NONE, nil
+		}
+		img, _, err := imageio.Load(path)
+		if err != nil {
+			return METHOD_NONE, err
+		}
+		return METHOD_REENCODED, imageio.Save(path, Apply(img, total), 0)
+	}
+
+	current := Orientation(path)
+	if !options.TagOnly && !total.IsIdentity() {
+		err := jpegtran(path, total, options.Jpegtran)
+		if err == nil {
+			// jpegtran copied the orientation tag, the pixels are now upright
+			if current.IsIdentity() {
+				return METHOD_JPEGTRAN, nil
+			}
+			return METHOD_JPEGTRAN, exif.WriteOrientation(path, 1)
+		}
+		if !errors.Is(err, ErrNotPerfect) && !errors.Is(err, exec.ErrNotFound) {
+			return METHOD_NONE, err
+		}
+	}
+	if total.normalize() == current {
+		return METHOD_NONE, nil
+	}
+	return METHOD_TAG, exif.WriteOrientation(path, total.Orientation())
+}
+
+// ErrNotPerfect is returned when jpegtran can not transform an image losslessly, its size not being a multiple of the JPEG blocks.
+var ErrNotPerfect = errors.New("the image can not be transformed losslessly")
+
+// jpegtran transforms a JPEG file losslessly, replacing it only on success.
+func jpegtran(path string, t Transform, executable string) error {
+	if executable == "" {
+		executable = "jpegtran"
+	}
+	executable, err := exec.LookPath(executable)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	args := append([]string{"-copy", "all", "-perfect"}, t.Arguments()...)
+	args = append(args, "-outfile", tmp.Name(), path)
+	if output, err := exec.Command(executable, args...).CombinedOutput(); err != nil {
+		if strings.Contains(string(output), "perfect") {
+			return ErrNotPerfect
+		}
+		return fmt.Errorf("jpegtran: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	// Keep the file permissions
+	if info, err := os.Stat(path); err == nil {
+		os.Chmod(tmp.Name(), info.Mode().Perm())
+	}
+	return os.Rename(tmp.Name(), path)
+}
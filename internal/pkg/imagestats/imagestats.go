@@ -0,0 +1,175 @@
+// Package imagestats measures image pixels: channel statistics, dominant
+// colors, sharpness and exposure clipping, to flag blurry or badly exposed
+// shots without looking at them.
+package imagestats
+
+import (
+	"image"
+	"image/draw"
+	"math"
+
+	"github.com/nicky-ayoub/imagination/internal/pkg/histogram"
+	"github.com/nicky-ayoub/imagination/internal/pkg/resize"
+)
+
+/** Longest edge of the image the sharpness is measured on, so that values compare across image sizes. */
+const SHARPNESS_EDGE = 1024
+
+/** Channel values from which a pixel counts as clipped: black at or below the first, blown at or above the second. */
+const (
+	CLIP_SHADOWS    = 1
+	CLIP_HIGHLIGHTS = 254
+)
+
+// Default thresholds of Flags.
+const (
+	DEFAULT_BLUR_THRESHOLD = 100 // Laplacian variance
+	DEFAULT_CLIP_THRESHOLD = 5   // Percentage of clipped pixels
+)
+
+// Channel holds the statistics of a channel, values ranging from 0 to 255.
+type Channel struct {
+	Mean   float64 `json:"mean"`
+	StdDev float64 `json:"stddev"`
+	Min    int     `json:"min"`
+	Max    int     `json:"max"`
+}
+
+// Stats holds the measures of an image.
+type Stats struct {
+	Red        Channel  `json:"red"`
+	Green      Channel  `json:"green"`
+	Blue       Channel  `json:"blue"`
+	Luma       Channel  `json:"luma"`
+	Palette    []Swatch `json:"palette"`    // Dominant colors, most frequent first
+	Sharpness  float64  `json:"sharpness"`  // Variance of the Laplacian of the luma, low for blurry images
+	Shadows    float64  `json:"shadows"`    // Percentage of black pixels, every channel at most CLIP_SHADOWS
+	Highlights float64  `json:"highlights"` // Percentage of blown pixels, a channel at least CLIP_HIGHLIGHTS
+}
+
+// Thresholds tell when Flags reports a problem.
+type Thresholds struct {
+	Blur float64 // Sharpness below which an image is blurry
+	Clip float64 // Clipped pixel percentage above which an image is under or overexposed
+}
+
+// DefaultThresholds returns the thresholds used when none is configured.
+func DefaultThresholds() Thresholds {
+	return Thresholds{Blur: DEFAULT_BLUR_THRESHOLD, Clip: DEFAULT_CLIP_THRESHOLD}
+}
+
+// Compute measures img, looking for a palette of the given number of dominant colors.
+func Compute(img image.Image, colors int) *Stats {
+	b := img.Bounds()
+	src, ok := img.(*image.NRGBA)
+	if !ok {
+		src = image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	}
+
+	h := histogram.Compute(src)
+	s := &Stats{
+		Red:   channel(&h.Red, h.Total),
+		Green: channel(&h.Green, h.Total),
+		Blue:  channel(&h.Blue, h.Total),
+		Luma:  channel(&h.Luma, h.Total),
+	}
+
+	// Clipping
+	var shadows, highlights, total int
+	sb := src.Bounds()
+	for y := sb.Min.Y; y < sb.Max.Y; y++ {
+		row := src.Pix[src.PixOffset(sb.Min.X, y):src.PixOffset(sb.Max.X, y)]
+		for i := 0; i < len(row); i += 4 {
+			if row[i+3] == 0 {
+				continue
+			}
+			total++
+			high := max(int(row[i]), max(int(row[i+1]), int(row[i+2])))
+			if high <= CLIP_SHADOWS {
+				shadows++
+			}
+			if high >= CLIP_HIGHLIGHTS {
+				highlights++
+			}
+		}
+	}
+	if total > 0 {
+		s.Shadows = 100 * float64(shadows) / float64(total)
+		s.Highlights = 100 * float64(highlights) / float64(total)
+	}
+
+	s.Sharpness = Sharpness(src)
+	s.Palette = Palette(src, colors)
+	return s
+}
+
+// channel computes the statistics of a histogram.
+func channel(bins *[histogram.BINS]uint32, total uint32) Channel {
+	c := Channel{Min: -1}
+	if total == 0 {
+		return Channel{}
+	}
+	var sum, squares float64
+	for v, n := range bins {
+		if n == 0 {
+			continue
+		}
+		if c.Min < 0 {
+			c.Min = v
+		}
+		c.Max = v
+		sum += float64(v) * float64(n)
+		squares += float64(v) * float64(v) * float64(n)
+	}
+	c.Mean = sum / float64(total)
+	c.StdDev = math.Sqrt(math.Max(0, squares/float64(total)-c.Mean*c.Mean))
+	return c
+}
+
+// Sharpness returns the variance of the Laplacian of the image luma, measured
+// on the image scaled down to SHARPNESS_EDGE pixels. In-focus photographs
+// usually score in the hundreds or more, blurry ones well below 100.
+func Sharpness(img image.Image) float64 {
+	spec := resize.Spec{Mode: resize.MODE_MAX_EDGE, Width: SHARPNESS_EDGE}
+	scaled := resize.Resize(img, spec.Size(img.Bounds().Size(), false), resize.BILINEAR)
+	b := scaled.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w < 3 || h < 3 {
+		return 0
+	}
+	luma := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			r, g, bl, _ := scaled.At(b.Min.X+x, b.Min.Y+y).RGBA()
+			luma[y*w+x] = float64(histogram.Luma(uint8(r>>8), uint8(g>>8), uint8(bl>>8)))
+		}
+	}
+	var sum, squares float64
+	for y := 1; y < h-1; y++ {
+		for x := 1; x < w-1; x++ {
+			i := y*w + x
+			l := luma[i-w] + luma[i+w] + luma[i-1] + luma[i+1] - 4*luma[i]
+			sum += l
+			squares += l * l
+		}
+	}
+	n := float64((w - 2) * (h - 2))
+	mean := sum / n
+	return squares/n - mean*mean
+}
+
+// Flags names the problems found: "blurry", "underexposed" and "overexposed".
+func (s *Stats) Flags(t Thresholds) []string {
+	flags := []string{}
+	if s.Sharpness < t.Blur {
+		flags = append(flags, "blurry")
+	}
+	if s.Shadows > t.Clip {
+		flags = append(flags, "underexposed")
+	}
+	if s.Highlights > t.Clip {
+		flags = append(flags, "overexposed")
+	}
+	return flags
+}
@@ -0,0 +1,140 @@
+package imagestats
+
+import (
+	"fmt"
+	"image"
+	"math/rand"
+	"sort"
+)
+
+/** Number of pixels sampled to find the palette, k-means cost grows with it. */
+const PALETTE_SAMPLES = 20000
+
+/** Iterations after which k-means stops even when clusters still move. */
+const PALETTE_ITERATIONS = 20
+
+// Swatch is a dominant color and the share of the image it covers.
+type Swatch struct {
+	Color string  `json:"color"` // #rrggbb
+	Share float64 `json:"share"` // Percentage of the pixels
+}
+
+type point [3]float64
+
+func (p point) distance(q point) float64 {
+	dr, dg, db := p[0]-q[0], p[1]-q[1], p[2]-q[2]
+	return dr*dr + dg*dg + db*db
+}
+
+// Palette finds the dominant colors of an image by k-means clustering of a
+// sample of its pixels. Results are repeatable, the random choices being seeded.
+func Palette(img *image.NRGBA, colors int) []Swatch {
+	if colors <= 0 {
+		return nil
+	}
+	samples := sample(img)
+	if len(samples) == 0 {
+		return nil
+	}
+	if colors > len(samples) {
+		colors = len(samples)
+	}
+
+	// k-means++ seeding: each center is picked with a probability growing with its distance to the previous ones
+	random := rand.New(rand.NewSource(1))
+	centers := []point{samples[random.Intn(len(samples))]}
+	distances := make([]float64, len(samples))
+	for len(centers) < colors {
+		total := 0.0
+		for i, p := range samples {
+			distances[i] = p.distance(centers[nearest(centers, p)])
+			total += distances[i]
+		}
+		if total == 0 {
+			break // Fewer distinct colors than asked
+		}
+		pick := random.Float64() * total
+		i := 0
+		for ; i < len(samples)-1 && pick >= distances[i]; i++ {
+			pick -= distances[i]
+		}
+		centers = append(centers, samples[i])
+	}
+
+	// Lloyd iterations
+	assigned := make([]int, len(samples))
+	counts := make([]int, len(centers))
+	for iteration := 0; iteration < PALETTE_ITERATIONS; iteration++ {
+		moved := iteration == 0
+		for i, p := range samples {
+			if c := nearest(centers, p); c != assigned[i] {
+				assigned[i] = c
+				moved = true
+			}
+		}
+		sums := make([]point, len(centers))
+		for c := range counts {
+			counts[c] = 0
+		}
+		for i, p := range samples {
+			c := assigned[i]
+			counts[c]++
+			for j := range p {
+				sums[c][j] += p[j]
+			}
+		}
+		for c := range centers {
+			if counts[c] > 0 {
+				for j := range sums[c] {
+					centers[c][j] = sums[c][j] / float64(counts[c])
+				}
+			}
+		}
+		if !moved {
+			break
+		}
+	}
+
+	palette := make([]Swatch, 0, len(centers))
+	for c, center := range centers {
+		if counts[c] == 0 {
+			continue
+		}
+		palette = append(palette, Swatch{
+			Color: fmt.Sprintf("#%02x%02x%02x", uint8(center[0]+0.5), uint8(center[1]+0.5), uint8(center[2]+0.5)),
+			Share: 100 * float64(counts[c]) / float64(len(samples)),
+		})
+	}
+	sort.SliceStable(palette, func(i, j int) bool { return palette[i].Share > palette[j].Share })
+	return palette
+}
+
+// sample returns about PALETTE_SAMPLES opaque pixels, evenly spread over the image.
+func sample(img *image.NRGBA) []point {
+	b := img.Bounds()
+	stride := 1
+	for b.Dx()/stride*(b.Dy()/stride) > PALETTE_SAMPLES {
+		stride++
+	}
+	samples := make([]point, 0, PALETTE_SAMPLES)
+	for y := b.Min.Y; y < b.Max.Y; y += stride {
+		for x := b.Min.X; x < b.Max.X; x += stride {
+			i := img.PixOffset(x, y)
+			if img.Pix[i+3] != 0 {
+				samples = append(samples, point{float64(img.Pix[i]), float64(img.Pix[i+1]), float64(img.Pix[i+2])})
+			}
+		}
+	}
+	return samples
+}
+
+// nearest returns the index of the center closest to p.
+func nearest(centers []point, p point) int {
+	best, bestDistance := 0, p.distance(centers[0])
+	for c := 1; c < len(centers); c++ {
+		if d := p.distance(centers[c]); d < bestDistance {
+			best, bestDistance = c, d
+		}
+	}
+	return best
+}
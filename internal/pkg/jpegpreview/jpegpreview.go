@@ -0,0 +1,627 @@
+// Package jpegpreview decodes a JPEG image at an eighth of its size from the
+// DC coefficient of each 8x8 block, which is the block average. It neither
+// runs the inverse DCT nor holds the full resolution image, so the preview of
+// a huge scan comes long before the image itself is decoded.
+//
+// Baseline, extended sequential and progressive Huffman coded images with one
+// (gray) or three (YCbCr, or RGB when the file tells) 8-bit components are
+// supported. Only the first DC scan of progressive images is read, which is
+// usually a small part of the file.
+package jpegpreview
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+)
+
+// Markers
+const (
+	SOF0  = 0xc0 // Baseline
+	SOF1  = 0xc1 // Extended sequential
+	SOF2  = 0xc2 // Progressive
+	DHT   = 0xc4
+	RST0  = 0xd0
+	RST7  = 0xd7
+	SOI   = 0xd8
+	EOI   = 0xd9
+	SOS   = 0xda
+	DQT   = 0xdb
+	DNL   = 0xdc
+	DRI   = 0xdd
+	APP14 = 0xee // Adobe, telling the color transform
+)
+
+/** Size of the read buffer. */
+const BUFFER_SIZE = 64 << 10
+
+// ErrUnsupported is returned for the JPEG variants the package does not decode.
+var ErrUnsupported = errors.New("unsupported JPEG variant")
+
+// component is an image component and the DC values of its blocks.
+type component struct {
+	id    uint8
+	h, v  int   // Sampling factors
+	tq    uint8 // Quantization table
+	width int   // Blocks per line, padded to whole MCUs
+	dc    []int // Quantized DC values
+	done  bool  // The DC values were read
+}
+
+// huffman is a decoding table.
+type huffman struct {
+	lookup  [256]uint16 // Value<<8 | code length, by the next 8 bits, 0 for longer codes
+	maxcode [17]int32   // Largest code of each length, -1 when none
+	mincode [17]int32
+	valptr  [17]int32 // Index in values of the first code of each length
+	values  []uint8
+}
+
+type decoder struct {
+	r           *bufio.Reader
+	bits        uint64 // The next bits of the entropy coded data, most significant first
+	count       int    // Number of bits in bits
+	marker      uint8  // Marker met in the entropy coded data, 0 for none
+	width       int
+	height      int
+	progressive bool
+	components  []*component
+	hmax, vmax  int
+	mcusX       int
+	mcusY       int
+	quant       [4]uint16 // DC quantizers
+	dcTables    [4]*huffman
+	acTables    [4]*huffman
+	restart     int  // MCUs between restart markers, 0 for none
+	adobe       bool // An Adobe marker was read
+	transform   uint8
+}
+
+// Decode reads a JPEG image and returns it at an eighth of its size, each
+// pixel being the average of a block, the sides being rounded up.
+func Decode(r io.Reader) (*image.NRGBA, error) {
+	d := &decoder{r: bufio.NewReaderSize(r, BUFFER_SIZE)}
+	var soi [2]byte
+	if _, err := io.ReadFull(d.r, soi[:]); err != nil {
+		return nil, err
+	}
+	if soi[0] != 0xff || soi[1] != SOI {
+		return nil, errors.New("not a JPEG image")
+	}
+	for {
+		marker, err := d.nextMarker()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case marker == SOF0 || marker == SOF1 || marker == SOF2:
+			d.progressive = marker == SOF2
+			err = d.readFrame()
+		case marker >= 0xc3 && marker <= 0xcf && marker != DHT && marker != 0xc8 && marker != 0xcc:
+			return nil, fmt.Errorf("%w: frame type %#x", ErrUnsupported, marker)
+		case marker == DHT:
+			err = d.readHuffman()
+		case marker == DQT:
+			err = d.readQuantization()
+		case marker == DRI:
+			err = d.readRestart()
+		case marker == APP14:
+			err = d.readAdobe()
+		case marker == DNL:
+			return nil, fmt.Errorf("%w: DNL marker", ErrUnsupported)
+		case marker == SOS:
+			if err = d.readScan(); err == nil && d.complete() {
+				return d.image(), nil
+			}
+		case marker == EOI:
+			return nil, io.ErrUnexpectedEOF
+		case marker >= RST0 && marker <= RST7:
+		default:
+			err = d.skipSegment()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// nextMarker returns the next marker, skipping what comes before.
+func (d *decoder) nextMarker() (uint8, error) {
+	if d.marker != 0 {
+		marker := d.marker
+		d.marker = 0
+		return marker, nil
+	}
+	for {
+		b, err := d.r.ReadByte()
+		if err != nil {
+			return 0, unexpected(err)
+		}
+		if b != 0xff {
+			continue
+		}
+		// Any number of fill bytes can precede a marker
+		for b == 0xff {
+			if b, err = d.r.ReadByte(); err != nil {
+				return 0, unexpected(err)
+			}
+		}
+		if b != 0 {
+			return b, nil
+		}
+	}
+}
+
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// readSegment returns the content of a marker segment.
+func (d *decoder) readSegment() ([]byte, error) {
+	var length [2]byte
+	if _, err := io.ReadFull(d.r, length[:]); err != nil {
+		return nil, unexpected(err)
+	}
+	n := int(length[0])<<8 | int(length[1]) - 2
+	if n < 0 {
+		return nil, errors.New("invalid JPEG segment length")
+	}
+	segment := make([]byte, n)
+	if _, err := io.ReadFull(d.r, segment); err != nil {
+		return nil, unexpected(err)
+	}
+	return segment, nil
+}
+
+func (d *decoder) skipSegment() error {
+	_, err := d.readSegment()
+	return err
+}
+
+func (d *decoder) readFrame() error {
+	s, err := d.readSegment()
+	if err != nil {
+		return err
+	}
+	if d.components != nil {
+		return errors.New("several JPEG frames")
+	}
+	if len(s) < 6 {
+		return errors.New("invalid JPEG frame")
+	}
+	if s[0] != 8 {
+		return fmt.Errorf("%w: %d-bit precision", ErrUnsupported, s[0])
+	}
+	d.height, d.width = int(s[1])<<8|int(s[2]), int(s[3])<<8|int(s[4])
+	n := int(s[5])
+	if d.width == 0 || d.height == 0 {
+		return fmt.Errorf("%w: no image size", ErrUnsupported)
+	}
+	if n != 1 && n != 3 {
+		return fmt.Errorf("%w: %d components", ErrUnsupported, n)
+	}
+	if len(s) != 6+3*n {
+		return errors.New("invalid JPEG frame")
+	}
+	d.hmax, d.vmax = 1, 1
+	for i := 0; i < n; i++ {
+		c := &component{id: s[6+3*i], h: int(s[7+3*i] >> 4), v: int(s[7+3*i] & 15), tq: s[8+3*i]}
+		if c.h < 1 || c.h > 4 || c.v < 1 || c.v > 4 {
+			return errors.New("invalid JPEG sampling factors")
+		}
+		if c.tq > 3 {
+			return errors.New("invalid JPEG quantization table selector")
+		}
+		if n == 1 {
+			// The blocks of a single component are never interleaved
+			c.h, c.v = 1, 1
+		}
+		d.hmax, d.vmax = max(d.hmax, c.h), max(d.vmax, c.v)
+		d.components = append(d.components, c)
+	}
+	d.mcusX = (d.width + 8*d.hmax - 1) / (8 * d.hmax)
+	d.mcusY = (d.height + 8*d.vmax - 1) / (8 * d.vmax)
+	for _, c := range d.components {
+		c.width = d.mcusX * c.h
+		c.dc = make([]int, c.width*d.mcusY*c.v)
+	}
+	return nil
+}
+
+func (d *decoder) readQuantization() error {
+	s, err := d.readSegment()
+	if err != nil {
+		return err
+	}
+	for len(s) > 0 {
+		precision, id := s[0]>>4, s[0]&15
+		if precision > 1 || id > 3 {
+			return errors.New("invalid JPEG quantization table")
+		}
+		size := 1 + 64
+		if precision != 0 {
+			size = 1 + 128
+		}
+		if len(s) < size {
+			return errors.New("invalid JPEG quantization table")
+		}
+		// Only the first value, the DC one, is needed
+		d.quant[id] = uint16(s[1])
+		if precision != 0 {
+			d.quant[id] = uint16(s[1])<<8 | uint16(s[2])
+		}
+		s = s[size:]
+	}
+	return nil
+}
+
+func (d *decoder) readHuffman() error {
+	s, err := d.readSegment()
+	if err != nil {
+		return err
+	}
+	for len(s) > 0 {
+		if len(s) < 17 {
+			return errors.New("invalid JPEG Huffman table")
+		}
+		class, id := s[0]>>4, s[0]&15
+		if class > 1 || id > 3 {
+			return errors.New("invalid JPEG Huffman table")
+		}
+		counts := s[1:17]
+		total := 0
+		for _, n := range counts {
+			total += int(n)
+		}
+		if total == 0 || total > 256 || len(s) < 17+total {
+			return errors.New("invalid JPEG Huffman table")
+		}
+		h, err := newHuffman(counts, s[17:17+total])
+		if err != nil {
+			return err
+		}
+		if class == 0 {
+			d.dcTables[id] = h
+		} else {
+			d.acTables[id] = h
+		}
+		s = s[17+total:]
+	}
+	return nil
+}
+
+// newHuffman returns the table of the canonical codes of the numbers of codes
+// of each length, an error when there are more codes than the lengths allow.
+func newHuffman(counts []byte, values []byte) (*huffman, error) {
+	h := &huffman{values: append([]byte{}, values...)}
+	code, k := int32(0), int32(0)
+	for length := 1; length <= 16; length++ {
+		h.valptr[length], h.mincode[length] = k, code
+		if code+int32(counts[length-1]) > 1<<uint(length) {
+			return nil, errors.New("invalid JPEG Huffman table: too many codes")
+		}
+		for i := 0; i < int(counts[length-1]); i++ {
+			if length <= 8 {
+				shift := uint(8 - length)
+				for j := int32(0); j < 1<<shift; j++ {
+					h.lookup[code<<shift|j] = uint16(values[k])<<8 | uint16(length)
+				}
+			}
+			code++
+			k++
+		}
+		h.maxcode[length] = code - 1
+		if counts[length-1] == 0 {
+			h.maxcode[length] = -1
+		}
+		code <<= 1
+	}
+	return h, nil
+}
+
+func (d *decoder) readRestart() error {
+	s, err := d.readSegment()
+	if err != nil {
+		return err
+	}
+	if len(s) != 2 {
+		return errors.New("invalid JPEG restart interval")
+	}
+	d.restart = int(s[0])<<8 | int(s[1])
+	return nil
+}
+
+func (d *decoder) readAdobe() error {
+	s, err := d.readSegment()
+	if err != nil {
+		return err
+	}
+	if len(s) >= 12 && string(s[:5]) == "Adobe" {
+		d.adobe, d.transform = true, s[11]
+	}
+	return nil
+}
+
+// readScan reads the DC values of the components of a scan, or skips it when
+// it only holds refinements or AC coefficients.
+func (d *decoder) readScan() error {
+	s, err := d.readSegment()
+	if err != nil {
+		return err
+	}
+	if d.components == nil {
+		return errors.New("JPEG scan before the frame")
+	}
+	if len(s) < 1 || len(s) != 4+2*int(s[0]) {
+		return errors.New("invalid JPEG scan")
+	}
+	n := int(s[0])
+	if n < 1 || n > 4 {
+		return fmt.Errorf("invalid JPEG scan: %d components", n)
+	}
+	scan := make([]*component, n)
+	dc := make([]*huffman, n)
+	ac := make([]*huffman, n)
+	for i := 0; i < n; i++ {
+		for _, c := range d.components {
+			if c.id == s[1+2*i] {
+				scan[i] = c
+			}
+		}
+		if scan[i] == nil {
+			return errors.New("unknown JPEG scan component")
+		}
+		td, ta := s[2+2*i]>>4, s[2+2*i]&15
+		if td > 3 || ta > 3 {
+			return errors.New("invalid JPEG Huffman table selector")
+		}
+		dc[i], ac[i] = d.dcTables[td], d.acTables[ta]
+	}
+	start, ah, al := s[1+2*n], s[3+2*n]>>4, uint(s[3+2*n]&15)
+	if d.progressive && (start != 0 || ah != 0) || scan[0].done {
+		return d.skipScan()
+	}
+	for i := range scan {
+		if dc[i] == nil || !d.progressive && ac[i] == nil {
+			return errors.New("missing JPEG Huffman table")
+		}
+	}
+
+	d.bits, d.count, d.marker = 0, 0, 0
+	predictors := make([]int, n)
+	// A single component is not interleaved, its blocks cover its own size
+	mcusX, mcusY := d.mcusX, d.mcusY
+	if n == 1 {
+		c := scan[0]
+		mcusX = ((d.width*c.h+d.hmax-1)/d.hmax + 7) / 8
+		mcusY = ((d.height*c.v+d.vmax-1)/d.vmax + 7) / 8
+	}
+	mcu := 0
+	for my := 0; my < mcusY; my++ {
+		for mx := 0; mx < mcusX; mx++ {
+			if d.restart > 0 && mcu > 0 && mcu%d.restart == 0 {
+				if err = d.readRestartMarker(); err != nil {
+					return err
+				}
+				for i := range predictors {
+					predictors[i] = 0
+				}
+			}
+			mcu++
+			for i, c := range scan {
+				if n == 1 {
+					if err = d.readBlock(c, my*c.width+mx, &predictors[i], dc[i], ac[i], al); err != nil {
+						return err
+					}
+					continue
+				}
+				for v := 0; v < c.v; v++ {
+					for h := 0; h < c.h; h++ {
+						if err = d.readBlock(c, (my*c.v+v)*c.width+mx*c.h+h, &predictors[i], dc[i], ac[i], al); err != nil {
+							return err
+						}
+					}
+				}
+			}
+		}
+	}
+	for _, c := range scan {
+		c.done = true
+	}
+	return nil
+}
+
+// readBlock reads the DC value of a block, and skips its AC coefficients in sequential images.
+func (d *decoder) readBlock(c *component, index int, predictor *int, dc *huffman, ac *huffman, al uint) error {
+	size, err := d.decode(dc)
+	if err != nil {
+		return err
+	}
+	diff, err := d.receive(size)
+	if err != nil {
+		return err
+	}
+	*predictor += diff
+	c.dc[index] = *predictor << al
+	if d.progressive {
+		return nil
+	}
+	for k := 1; k < 64; k++ {
+		rs, err := d.decode(ac)
+		if err != nil {
+			return err
+		}
+		run, size := int(rs>>4), rs&15
+		if size == 0 {
+			if run != 15 {
+				break // End of block
+			}
+			k += 15
+			continue
+		}
+		// The coefficient bits are in the buffer, see decode()
+		k += run
+		d.bits <<= size
+		d.count -= int(size)
+	}
+	return nil
+}
+
+// skipScan skips entropy coded data up to the next marker that is not a restart marker.
+func (d *decoder) skipScan() error {
+	for {
+		marker, err := d.nextMarker()
+		if err != nil {
+			return err
+		}
+		if marker < RST0 || marker > RST7 {
+			d.marker = marker
+			return nil
+		}
+	}
+}
+
+// readRestartMarker drops the bits left before a restart marker and reads it.
+func (d *decoder) readRestartMarker() error {
+	d.bits, d.count = 0, 0
+	marker, err := d.nextMarker()
+	if err != nil {
+		return err
+	}
+	if marker < RST0 || marker > RST7 {
+		return errors.New("missing JPEG restart marker")
+	}
+	return nil
+}
+
+// fill adds bytes of entropy coded data to the bits, zeros once a marker is met.
+func (d *decoder) fill() error {
+	for d.count <= 56 {
+		var b byte
+		if d.marker == 0 {
+			var err error
+			if b, err = d.r.ReadByte(); err != nil {
+				return unexpected(err)
+			}
+			if b == 0xff {
+				next, err := d.r.ReadByte()
+				for err == nil && next == 0xff {
+					next, err = d.r.ReadByte()
+				}
+				if err != nil {
+					return unexpected(err)
+				}
+				if next != 0 {
+					// A marker, the data of the scan is over
+					d.marker, b = next, 0
+				}
+			}
+		}
+		d.bits |= uint64(b) << uint(56-d.count)
+		d.count += 8
+	}
+	return nil
+}
+
+// decode reads a Huffman coded value, leaving at least 16 bits to read
+// without filling, enough for the value that follows it.
+func (d *decoder) decode(h *huffman) (uint8, error) {
+	if d.count < 32 {
+		if err := d.fill(); err != nil {
+			return 0, err
+		}
+	}
+	if e := h.lookup[d.bits>>56]; e != 0 {
+		length := uint(e & 0xff)
+		d.bits <<= length
+		d.count -= int(length)
+		return uint8(e >> 8), nil
+	}
+	for length := uint(9); length <= 16; length++ {
+		code := int32(d.bits >> (64 - length))
+		if code <= h.maxcode[length] {
+			d.bits <<= length
+			d.count -= int(length)
+			return h.values[h.valptr[length]+code-h.mincode[length]], nil
+		}
+	}
+	return 0, errors.New("invalid JPEG Huffman code")
+}
+
+// receive reads a value of a number of bits, extending its sign.
+func (d *decoder) receive(size uint8) (int, error) {
+	if size == 0 {
+		return 0, nil
+	}
+	if size > 16 {
+		return 0, errors.New("invalid JPEG coefficient size")
+	}
+	if d.count < int(size) {
+		if err := d.fill(); err != nil {
+			return 0, err
+		}
+	}
+	v := int(d.bits >> (64 - uint(size)))
+	d.bits <<= size
+	d.count -= int(size)
+	if v < 1<<(size-1) {
+		v += -1<<size + 1
+	}
+	return v, nil
+}
+
+// complete tells whether the DC values of every component are read.
+func (d *decoder) complete() bool {
+	for _, c := range d.components {
+		if !c.done {
+			return false
+		}
+	}
+	return d.components != nil
+}
+
+// image returns the block averages.
+func (d *decoder) image() *image.NRGBA {
+	width, height := (d.width+7)/8, (d.height+7)/8
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	// The components are stored as RGB when the file tells so
+	rgb := d.adobe && d.transform == 0 || len(d.components) == 3 && d.components[0].id == 'R' && d.components[1].id == 'G' && d.components[2].id == 'B'
+	var values [3]uint8
+	for y := 0; y < height; y++ {
+		row := img.Pix[y*img.Stride:]
+		for x := 0; x < width; x++ {
+			for i, c := range d.components {
+				block := c.dc[(y*c.v/d.vmax)*c.width+x*c.h/d.hmax]
+				values[i] = average(block * int(d.quant[c.tq]))
+			}
+			r, g, b := values[0], values[0], values[0]
+			if len(d.components) == 3 {
+				r, g, b = values[0], values[1], values[2]
+				if !rgb {
+					r, g, b = color.YCbCrToRGB(values[0], values[1], values[2])
+				}
+			}
+			row[4*x], row[4*x+1], row[4*x+2], row[4*x+3] = r, g, b, 255
+		}
+	}
+	return img
+}
+
+// average returns the average of a block from its dequantized DC coefficient,
+// which is 8 times the average of the level shifted samples.
+func average(dc int) uint8 {
+	v := 128 + (dc+4)>>3
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return uint8(v)
+}
@@ -0,0 +1,233 @@
+package jpegpreview
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// blockAverages returns the average color of each 8x8 block of an image.
+func blockAverages(img image.Image) *image.NRGBA {
+	b := img.Bounds()
+	averages := image.NewNRGBA(image.Rect(0, 0, (b.Dx()+7)/8, (b.Dy()+7)/8))
+	for by := 0; by < averages.Rect.Dy(); by++ {
+		for bx := 0; bx < averages.Rect.Dx(); bx++ {
+			var sum [3]int
+			n := 0
+			for y := b.Min.Y + 8*by; y < b.Min.Y+8*by+8 && y < b.Max.Y; y++ {
+				for x := b.Min.X + 8*bx; x < b.Min.X+8*bx+8 && x < b.Max.X; x++ {
+					c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+					sum[0], sum[1], sum[2] = sum[0]+int(c.R), sum[1]+int(c.G), sum[2]+int(c.B)
+					n++
+				}
+			}
+			averages.SetNRGBA(bx, by, color.NRGBA{uint8((sum[0] + n/2) / n), uint8((sum[1] + n/2) / n), uint8((sum[2] + n/2) / n), 255})
+		}
+	}
+	return averages
+}
+
+// difference returns the mean and largest difference of the color components of two images of the same size.
+func difference(a *image.NRGBA, b *image.NRGBA) (float64, int) {
+	sum, largest := 0, 0
+	for i := range a.Pix {
+		d := int(a.Pix[i]) - int(b.Pix[i])
+		if d < 0 {
+			d = -d
+		}
+		sum += d
+		if d > largest {
+			largest = d
+		}
+	}
+	return float64(sum) / float64(len(a.Pix)), largest
+}
+
+// check compares the preview of a JPEG image with the block averages of its full decoding.
+func check(t *testing.T, data []byte, meanLimit float64, largestLimit int) {
+	t.Helper()
+	preview, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	full, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := blockAverages(full)
+	if preview.Rect != want.Rect {
+		t.Fatalf("preview bounds %v, want %v", preview.Rect, want.Rect)
+	}
+	if mean, largest := difference(preview, want); mean > meanLimit || largest > largestLimit {
+		t.Errorf("preview differs from the block averages by %.2f on average, %d at most", mean, largest)
+	}
+}
+
+func gradient(width int, height int) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(255 * x / width), uint8(255 * y / height), uint8(255 * (x + y) / (width + height)), 255})
+		}
+	}
+	return img
+}
+
+func TestDecode(t *testing.T) {
+	// Whole 16x16 blocks, as the encoder pads the others by repeating the last
+	// pixels, and smooth colors, as the chroma blocks cover 16 pixels
+	for _, size := range []image.Point{{256, 256}, {512, 384}, {400, 272}} {
+		for _, img := range []image.Image{gradient(size.X, size.Y), toGray(gradient(size.X, size.Y))} {
+			var data bytes.Buffer
+			if err := jpeg.Encode(&data, img, &jpeg.Options{Quality: 95}); err != nil {
+				t.Fatal(err)
+			}
+			check(t, data.Bytes(), 2, 24)
+		}
+	}
+}
+
+func TestBounds(t *testing.T) {
+	for _, size := range []image.Point{{1, 1}, {9, 17}, {203, 77}} {
+		var data bytes.Buffer
+		if err := jpeg.Encode(&data, gradient(size.X, size.Y), nil); err != nil {
+			t.Fatal(err)
+		}
+		preview, err := Decode(&data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := image.Rect(0, 0, (size.X+7)/8, (size.Y+7)/8); preview.Rect != want {
+			t.Errorf("preview of a %v image: bounds %v, want %v", size, preview.Rect, want)
+		}
+	}
+}
+
+func toGray(img image.Image) image.Image {
+	b := img.Bounds()
+	gray := image.NewGray(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			gray.Set(x, y, img.At(x, y))
+		}
+	}
+	return gray
+}
+
+// TestVariants decodes the sample images of the Go distribution, which cover
+// the progressive mode, restart markers, the sampling factors and RGB files.
+func TestVariants(t *testing.T) {
+	directory := filepath.Join(runtime.GOROOT(), "src", "image", "testdata")
+	files := []struct {
+		name    string
+		mean    float64
+		largest int
+	}{
+		{"video-001.jpeg", 2, 24},
+		{"video-001.progressive.jpeg", 2, 24},
+		{"video-001.progressive.truncated.jpeg", 2, 24},
+		{"video-001.q50.444.progressive.jpeg", 3, 24},
+		{"video-001.restart2.jpeg", 8, 64},
+		{"video-001.separate.dc.progression.progressive.jpeg", 8, 64},
+		// Chroma blocks cover 16 pixels of these, while the full decoding interpolates them
+		{"video-001.q50.420.jpeg", 8, 64},
+		{"video-001.q50.420.progressive.jpeg", 8, 64},
+		{"video-001.q50.422.progressive.jpeg", 8, 64},
+		{"video-001.q50.440.progressive.jpeg", 8, 64},
+		{"video-001.rgb.jpeg", 20, 128},
+		{"video-005.gray.jpeg", 2, 8},
+		{"video-005.gray.q50.2x2.progressive.jpeg", 2, 8},
+	}
+	for _, f := range files {
+		t.Run(f.name, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join(directory, f.name))
+			if os.IsNotExist(err) {
+				t.Skip("no Go sample images")
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			check(t, data, f.mean, f.largest)
+		})
+	}
+}
+
+func TestErrors(t *testing.T) {
+	var data bytes.Buffer
+	if err := jpeg.Encode(&data, gradient(64, 64), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Decode(bytes.NewReader(data.Bytes()[:data.Len()/2])); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("truncated image: got %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+	if _, err := Decode(bytes.NewReader([]byte("GIF89a"))); err == nil {
+		t.Error("no error decoding a GIF header")
+	}
+	cmyk, err := os.ReadFile(filepath.Join(runtime.GOROOT(), "src", "image", "testdata", "video-001.cmyk.jpeg"))
+	if err == nil {
+		if _, err = Decode(bytes.NewReader(cmyk)); !errors.Is(err, ErrUnsupported) {
+			t.Errorf("CMYK image: got %v, want %v", err, ErrUnsupported)
+		}
+	}
+}
+
+// segment returns a JPEG segment of a marker and its payload.
+func segment(marker byte, payload ...byte) []byte {
+	length := len(payload) + 2
+	return append([]byte{0xff, marker, byte(length >> 8), byte(length)}, payload...)
+}
+
+// TestCorrupt decodes broken files, which must fail without panicking, as
+// the decoding runs in goroutines without recover.
+func TestCorrupt(t *testing.T) {
+	var valid bytes.Buffer
+	if err := jpeg.Encode(&valid, gradient(48, 40), nil); err != nil {
+		t.Fatal(err)
+	}
+	soi := []byte{0xff, 0xd8}
+	frame := segment(0xc0, 8, 0, 8, 0, 8, 1, 1, 0x11, 0)
+	table := append([]byte{0x00, 1}, make([]byte, 15)...)
+	crafted := []struct {
+		name string
+		data [][]byte
+	}{
+		{"no scan components", [][]byte{soi, frame, segment(0xda, 0, 0, 63, 0)}},
+		{"DC table selector", [][]byte{soi, frame, segment(0xda, 1, 1, 0xf0, 0, 63, 0)}},
+		{"AC table selector", [][]byte{soi, frame, segment(0xda, 1, 1, 0x0f, 0, 63, 0)}},
+		{"over-subscribed Huffman table", [][]byte{soi, segment(0xc4, append([]byte{0x00, 3}, append(make([]byte, 15), 0, 1, 2)...)...)}},
+		{"Huffman table id", [][]byte{soi, segment(0xc4, append(append([]byte{0x04}, table[1:]...), 0)...)}},
+		{"Huffman table class", [][]byte{soi, segment(0xc4, append(append([]byte{0x20}, table[1:]...), 0)...)}},
+		{"quantization table id", [][]byte{soi, segment(0xdb, append([]byte{0x04}, make([]byte, 64)...)...)}},
+		{"frame quantization table", [][]byte{soi, segment(0xc0, 8, 0, 8, 0, 8, 1, 1, 0x11, 4)}},
+	}
+	for _, c := range crafted {
+		t.Run(c.name, func(t *testing.T) {
+			if _, err := Decode(bytes.NewReader(bytes.Join(c.data, nil))); err == nil {
+				t.Error("no error")
+			}
+		})
+	}
+
+	// Truncated at every length, then with single bytes changed
+	data := valid.Bytes()
+	for n := 0; n < len(data); n++ {
+		if _, err := Decode(bytes.NewReader(data[:n])); err == nil {
+			t.Errorf("no error decoding the first %d bytes", n)
+		}
+	}
+	mutated := make([]byte, len(data))
+	for i := range data {
+		for _, b := range []byte{0x00, 0x01, 0x0f, 0x10, 0x7f, 0xf0, 0xff, data[i] ^ 0x80} {
+			copy(mutated, data)
+			mutated[i] = b
+			Decode(bytes.NewReader(mutated))
+		}
+	}
+}
@@ -0,0 +1,93 @@
+// Package ebitenkeys translates ebiten input to keymap strokes.
+package ebitenkeys
+
+import (
+	"strings"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+
+	"github.com/nicky-ayoub/imagination/internal/pkg/keymap"
+)
+
+// Ebiten key names (see ebiten.Key.String) that differ from the keymap ones.
+var names = map[string]string{
+	"arrowleft":      "left",
+	"arrowright":     "right",
+	"arrowup":        "up",
+	"arrowdown":      "down",
+	"numpadadd":      "kpplus",
+	"numpadsubtract": "kpminus",
+	"numpadmultiply": "kpmultiply",
+	"numpaddivide":   "kpdivide",
+	"numpaddecimal":  "kpdecimal",
+	"numpadenter":    "kpenter",
+	"numpadequal":    "kpequal",
+}
+
+func modifiers() (mods keymap.Modifier) {
+	if ebiten.IsKeyPressed(ebiten.KeyShift) {
+		mods |= keymap.MOD_SHIFT
+	}
+	if ebiten.IsKeyPressed(ebiten.KeyControl) {
+		mods |= keymap.MOD_CTRL
+	}
+	if ebiten.IsKeyPressed(ebiten.KeyAlt) {
+		mods |= keymap.MOD_ALT
+	}
+	if ebiten.IsKeyPressed(ebiten.KeyMeta) {
+		mods |= keymap.MOD_SUPER
+	}
+	return mods
+}
+
+func name(k ebiten.Key) string {
+	n := strings.ToLower(k.String())
+	if mapped, ok := names[n]; ok {
+		return mapped
+	}
+	switch {
+	case strings.HasPrefix(n, "digit"):
+		return strings.TrimPrefix(n, "digit")
+	case strings.HasPrefix(n, "numpad"):
+		return "kp" + strings.TrimPrefix(n, "numpad")
+	}
+	return n
+}
+
+// Strokes returns the strokes that started during the current tick, to call from Update.
+func Strokes() (strokes []keymap.Stroke) {
+	mods := modifiers()
+	for _, k := range inpututil.PressedKeys() {
+		if !inpututil.IsKeyJustPressed(k) {
+			continue
+		}
+		switch k {
+		case ebiten.KeyShift, ebiten.KeyShiftLeft, ebiten.KeyShiftRight,
+			ebiten.KeyControl, ebiten.KeyControlLeft, ebiten.KeyControlRight,
+			ebiten.KeyAlt, ebiten.KeyAltLeft, ebiten.KeyAltRight,
+			ebiten.KeyMeta, ebiten.KeyMetaLeft, ebiten.KeyMetaRight:
+			continue
+		}
+		if n := name(k); n != "" {
+			strokes = append(strokes, keymap.Stroke{Mods: mods, Key: n})
+		}
+	}
+
+	for button, n := range map[ebiten.MouseButton]string{
+		ebiten.MouseButtonLeft:   "mouseleft",
+		ebiten.MouseButtonMiddle: "mousemiddle",
+		ebiten.MouseButtonRight:  "mouseright",
+	} {
+		if inpututil.IsMouseButtonJustPressed(button) {
+			strokes = append(strokes, keymap.Stroke{Mods: mods, Key: n})
+		}
+	}
+
+	if _, dy := ebiten.Wheel(); dy > 0 {
+		strokes = append(strokes, keymap.Stroke{Mods: mods, Key: "wheelup"})
+	} else if dy < 0 {
+		strokes = append(strokes, keymap.Stroke{Mods: mods, Key: "wheeldown"})
+	}
+	return strokes
+}
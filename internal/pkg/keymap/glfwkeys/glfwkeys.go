@@ -0,0 +1,152 @@
+// Package glfwkeys translates GLFW input callbacks to keymap strokes.
+package glfwkeys
+
+import (
+	"github.com/go-gl/glfw/v3.3/glfw"
+
+	"github.com/nicky-ayoub/imagination/internal/pkg/keymap"
+)
+
+// Printable key names (see glfw.GetKeyName) that differ from the keymap ones.
+var names = map[string]string{
+	"=":  "equal",
+	"-":  "minus",
+	",":  "comma",
+	".":  "period",
+	"/":  "slash",
+	";":  "semicolon",
+	"'":  "quote",
+	"`":  "backquote",
+	"\\": "backslash",
+	"[":  "bracketleft",
+	"]":  "bracketright",
+}
+
+// Names of the keys glfw.GetKeyName does not name.
+var functionKeys = map[glfw.Key]string{
+	glfw.KeySpace:      "space",
+	glfw.KeyEscape:     "escape",
+	glfw.KeyEnter:      "enter",
+	glfw.KeyTab:        "tab",
+	glfw.KeyBackspace:  "backspace",
+	glfw.KeyInsert:     "insert",
+	glfw.KeyDelete:     "delete",
+	glfw.KeyRight:      "right",
+	glfw.KeyLeft:       "left",
+	glfw.KeyDown:       "down",
+	glfw.KeyUp:         "up",
+	glfw.KeyPageUp:     "pageup",
+	glfw.KeyPageDown:   "pagedown",
+	glfw.KeyHome:       "home",
+	glfw.KeyEnd:        "end",
+	glfw.KeyF1:         "f1",
+	glfw.KeyF2:         "f2",
+	glfw.KeyF3:         "f3",
+	glfw.KeyF4:         "f4",
+	glfw.KeyF5:         "f5",
+	glfw.KeyF6:         "f6",
+	glfw.KeyF7:         "f7",
+	glfw.KeyF8:         "f8",
+	glfw.KeyF9:         "f9",
+	glfw.KeyF10:        "f10",
+	glfw.KeyF11:        "f11",
+	glfw.KeyF12:        "f12",
+	glfw.KeyKPAdd:      "kpplus",
+	glfw.KeyKPSubtract: "kpminus",
+	glfw.KeyKPMultiply: "kpmultiply",
+	glfw.KeyKPDivide:   "kpdivide",
+	glfw.KeyKPDecimal:  "kpdecimal",
+	glfw.KeyKPEnter:    "kpenter",
+	glfw.KeyKPEqual:    "kpequal",
+	glfw.KeyKP0:        "kp0",
+	glfw.KeyKP1:        "kp1",
+	glfw.KeyKP2:        "kp2",
+	glfw.KeyKP3:        "kp3",
+	glfw.KeyKP4:        "kp4",
+	glfw.KeyKP5:        "kp5",
+	glfw.KeyKP6:        "kp6",
+	glfw.KeyKP7:        "kp7",
+	glfw.KeyKP8:        "kp8",
+	glfw.KeyKP9:        "kp9",
+}
+
+func modifiers(mods glfw.ModifierKey) (m keymap.Modifier) {
+	if mods&glfw.ModShift != 0 {
+		m |= keymap.MOD_SHIFT
+	}
+	if mods&glfw.ModControl != 0 {
+		m |= keymap.MOD_CTRL
+	}
+	if mods&glfw.ModAlt != 0 {
+		m |= keymap.MOD_ALT
+	}
+	if mods&glfw.ModSuper != 0 {
+		m |= keymap.MOD_SUPER
+	}
+	return m
+}
+
+// pressedModifiers returns the modifiers held down in a window, for the events GLFW gives none with.
+func pressedModifiers(window *glfw.Window) (mods glfw.ModifierKey) {
+	for _, m := range []struct {
+		mod  glfw.ModifierKey
+		keys [2]glfw.Key
+	}{
+		{glfw.ModShift, [2]glfw.Key{glfw.KeyLeftShift, glfw.KeyRightShift}},
+		{glfw.ModControl, [2]glfw.Key{glfw.KeyLeftControl, glfw.KeyRightControl}},
+		{glfw.ModAlt, [2]glfw.Key{glfw.KeyLeftAlt, glfw.KeyRightAlt}},
+		{glfw.ModSuper, [2]glfw.Key{glfw.KeyLeftSuper, glfw.KeyRightSuper}},
+	} {
+		if window.GetKey(m.keys[0]) == glfw.Press || window.GetKey(m.keys[1]) == glfw.Press {
+			mods |= m.mod
+		}
+	}
+	return mods
+}
+
+// Key returns the stroke of a key callback. ok is false for releases and for modifier keys pressed alone.
+func Key(key glfw.Key, scancode int, action glfw.Action, mods glfw.ModifierKey) (stroke keymap.Stroke, ok bool) {
+	if action != glfw.Press {
+		return stroke, false
+	}
+	name, found := functionKeys[key]
+	if !found {
+		// Printable keys are named after the keyboard layout
+		name = glfw.GetKeyName(key, scancode)
+		if mapped, found := names[name]; found {
+			name = mapped
+		}
+	}
+	if name == "" {
+		return stroke, false
+	}
+	return keymap.Stroke{Mods: modifiers(mods), Key: name}, true
+}
+
+// Button returns the stroke of a mouse button callback. ok is false for releases.
+func Button(button glfw.MouseButton, action glfw.Action, mods glfw.ModifierKey) (stroke keymap.Stroke, ok bool) {
+	if action != glfw.Press {
+		return stroke, false
+	}
+	switch button {
+	case glfw.MouseButtonLeft:
+		return keymap.Stroke{Mods: modifiers(mods), Key: "mouseleft"}, true
+	case glfw.MouseButtonMiddle:
+		return keymap.Stroke{Mods: modifiers(mods), Key: "mousemiddle"}, true
+	case glfw.MouseButtonRight:
+		return keymap.Stroke{Mods: modifiers(mods), Key: "mouseright"}, true
+	}
+	return stroke, false
+}
+
+// Wheel returns the stroke of a scroll callback of a window.
+func Wheel(window *glfw.Window, yoff float64) (stroke keymap.Stroke, ok bool) {
+	mods := modifiers(pressedModifiers(window))
+	switch {
+	case yoff > 0:
+		return keymap.Stroke{Mods: mods, Key: "wheelup"}, true
+	case yoff < 0:
+		return keymap.Stroke{Mods: mods, Key: "wheeldown"}, true
+	}
+	return stroke, false
+}
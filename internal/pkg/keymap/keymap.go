@@ -0,0 +1,447 @@
+// Package keymap binds keys, key chords and mouse buttons to named actions.
+//
+// Action names are shared by every front end, so a user keymap file works the
+// same in the SDL viewer, the plain SDL slideshow and the ebiten slideshow.
+// Front ends translate their native events to Strokes (see the sdlkeys,
+// ebitenkeys and glfwkeys packages), feed them to a Keymap and run the
+// resulting action from a Registry.
+//
+// A keymap file holds one binding per line, "#" starting a comment:
+//
+//	ctrl+o      next          # a key with modifiers
+//	g g         first         # a chord: g pressed twice
+//	wheelup     zoom-in       # mouse wheel and buttons are keys too
+//	unbind f                  # remove the default bindings of a key
+package keymap
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Actions known by the front ends.
+const (
+	ACTION_NEXT       = "next"
+	ACTION_PREV       = "prev"
+	ACTION_FIRST      = "first"
+	ACTION_LAST       = "last"
+	ACTION_ZOOM_IN    = "zoom-in"
+	ACTION_ZOOM_OUT   = "zoom-out"
+	ACTION_FIT        = "fit"
+	ACTION_FLIP       = "flip"
+	ACTION_ROTATE     = "rotate"
+	ACTION_DELETE     = "delete"
+	ACTION_COLLECT    = "collect"
+	ACTION_PAUSE      = "pause"
+	ACTION_FULLSCREEN = "fullscreen"
+	ACTION_HELP       = "help"
+	ACTION_HUD        = "hud"
+	ACTION_HISTOGRAM  = "histogram"
+	ACTION_INSPECT    = "inspect"
+
+	ACTION_BRIGHTNESS_UP   = "brightness-up"
+	ACTION_BRIGHTNESS_DOWN = "brightness-down"
+	ACTION_CONTRAST_UP     = "contrast-up"
+	ACTION_CONTRAST_DOWN   = "contrast-down"
+	ACTION_GAMMA_UP        = "gamma-up"
+	ACTION_GAMMA_DOWN      = "gamma-down"
+	ACTION_SATURATION_UP   = "saturation-up"
+	ACTION_SATURATION_DOWN = "saturation-down"
+	ACTION_EXPOSURE_UP     = "exposure-up"
+	ACTION_EXPOSURE_DOWN   = "exposure-down"
+	ACTION_GRAYSCALE       = "grayscale"
+	ACTION_INVERT          = "invert"
+	ACTION_RESET           = "reset"
+	ACTION_SAVE            = "save"
+	ACTION_EXPORT          = "export"
+
+	ACTION_SAVE_ORIENTATION = "save-orientation"
+
+	ACTION_CROP        = "crop"
+	ACTION_CROP_PRESET = "crop-preset"
+	ACTION_CROP_CLEAR  = "crop-clear"
+	ACTION_CROP_LEFT   = "crop-left"
+	ACTION_CROP_RIGHT  = "crop-right"
+	ACTION_CROP_UP     = "crop-up"
+	ACTION_CROP_DOWN   = "crop-down"
+	ACTION_CROP_GROW   = "crop-grow"
+	ACTION_CROP_SHRINK = "crop-shrink"
+	ACTION_QUIT        = "quit"
+	ACTION_RATE_0      = "rate-0"
+	ACTION_RATE_1      = "rate-1"
+	ACTION_RATE_2      = "rate-2"
+	ACTION_RATE_3      = "rate-3"
+	ACTION_RATE_4      = "rate-4"
+	ACTION_RATE_5      = "rate-5"
+)
+
+/** How long the keymap waits for the next key of a chord. */
+const CHORD_TIMEOUT = time.Second
+
+// Modifier keys, combined as a bit mask.
+type Modifier uint8
+
+const (
+	MOD_SHIFT Modifier = 1 << iota
+	MOD_CTRL
+	MOD_ALT
+	MOD_SUPER
+)
+
+var modifierNames = []struct {
+	mod  Modifier
+	name string
+}{
+	{MOD_CTRL, "ctrl"},
+	{MOD_ALT, "alt"},
+	{MOD_SHIFT, "shift"},
+	{MOD_SUPER, "super"},
+}
+
+// Stroke is a single key or mouse button press with its modifiers.
+// Key is a lower case name: "a", "5", "space", "left", "f1", "kpplus", "wheelup", "mouseleft", ...
+type Stroke struct {
+	Mods Modifier
+	Key  string
+}
+
+func (s Stroke) String() string {
+	var parts []string
+	for _, m := range modifierNames {
+		if s.Mods&m.mod != 0 {
+			parts = append(parts, m.name)
+		}
+	}
+	return strings.Join(append(parts, s.Key), "+")
+}
+
+// IsMouse tells whether the stroke is a mouse button or wheel one.
+func (s Stroke) IsMouse() bool {
+	return strings.HasPrefix(s.Key, "mouse") || strings.HasPrefix(s.Key, "wheel")
+}
+
+// ParseStroke parses a stroke written as "ctrl+shift+f".
+func ParseStroke(s string) (stroke Stroke, err error) {
+	parts := strings.Split(strings.ToLower(strings.TrimSpace(s)), "+")
+	for i, part := range parts {
+		if i == len(parts)-1 {
+			if part == "" {
+				return stroke, fmt.Errorf("missing key in %q", s)
+			}
+			stroke.Key = keyAliases[part]
+			if stroke.Key == "" {
+				stroke.Key = part
+			}
+			break
+		}
+		found := false
+		for _, m := range modifierNames {
+			if part == m.name || (m.mod == MOD_CTRL && part == "control") || (m.mod == MOD_SUPER && (part == "meta" || part == "cmd")) {
+				stroke.Mods |= m.mod
+				found = true
+			}
+		}
+		if !found {
+			return stroke, fmt.Errorf("unknown modifier %q in %q", part, s)
+		}
+	}
+	return stroke, nil
+}
+
+// Other spellings accepted in keymap files.
+var keyAliases = map[string]string{
+	"esc":        "escape",
+	"return":     "enter",
+	"del":        "delete",
+	"pgup":       "pageup",
+	"pgdown":     "pagedown",
+	"arrowleft":  "left",
+	"arrowright": "right",
+	"arrowup":    "up",
+	"arrowdown":  "down",
+	"=":          "equal",
+	"-":          "minus",
+	",":          "comma",
+	".":          "period",
+	"/":          "slash",
+}
+
+// ParseChord parses a space separated list of strokes, like "g g" or "ctrl+x ctrl+s".
+func ParseChord(s string) (chord []Stroke, err error) {
+	for _, field := range strings.Fields(s) {
+		stroke, err := ParseStroke(field)
+		if err != nil {
+			return nil, err
+		}
+		chord = append(chord, stroke)
+	}
+	if len(chord) == 0 {
+		return nil, fmt.Errorf("empty key binding")
+	}
+	return chord, nil
+}
+
+func chordString(chord []Stroke) string {
+	var parts []string
+	for _, s := range chord {
+		parts = append(parts, s.String())
+	}
+	return strings.Join(parts, " ")
+}
+
+// Binding associates a chord to an action.
+type Binding struct {
+	Keys   []Stroke
+	Action string
+}
+
+func (b Binding) String() string {
+	return chordString(b.Keys)
+}
+
+// Keymap turns strokes into actions.
+type Keymap struct {
+	bindings []Binding
+	pending  []Stroke // Strokes of the chord being typed
+	last     time.Time
+}
+
+// Default returns the built-in bindings.
+func Default() *Keymap {
+	k := &Keymap{}
+	for _, b := range [][2]string{
+		{"right", ACTION_NEXT},
+		{"space", ACTION_NEXT},
+		{"left", ACTION_PREV},
+		{"backspace", ACTION_PREV},
+		{"home", ACTION_FIRST},
+		{"end", ACTION_LAST},
+		{"up", ACTION_ZOOM_IN},
+		{"wheelup", ACTION_ZOOM_IN},
+		{"down", ACTION_ZOOM_OUT},
+		{"wheeldown", ACTION_ZOOM_OUT},
+		{"s", ACTION_FIT},
+		{"f", ACTION_FLIP},
+		{"r", ACTION_ROTATE},
+		{"ctrl+t", ACTION_SAVE_ORIENTATION},
+		{"delete", ACTION_DELETE},
+		{"a", ACTION_COLLECT},
+		{"p", ACTION_PAUSE},
+		{"f11", ACTION_FULLSCREEN},
+		{"h", ACTION_HELP},
+		{"f1", ACTION_HELP},
+		{"i", ACTION_HUD},
+		{"shift+h", ACTION_HISTOGRAM},
+		{"shift+i", ACTION_INSPECT},
+		{"b", ACTION_BRIGHTNESS_UP},
+		{"shift+b", ACTION_BRIGHTNESS_DOWN},
+		{"k", ACTION_CONTRAST_UP},
+		{"shift+k", ACTION_CONTRAST_DOWN},
+		{"m", ACTION_GAMMA_UP},
+		{"shift+m", ACTION_GAMMA_DOWN},
+		{"u", ACTION_SATURATION_UP},
+		{"shift+u", ACTION_SATURATION_DOWN},
+		{"e", ACTION_EXPOSURE_UP},
+		{"shift+e", ACTION_EXPOSURE_DOWN},
+		{"shift+g", ACTION_GRAYSCALE},
+		{"n", ACTION_INVERT},
+		{"shift+r", ACTION_RESET},
+		{"ctrl+s", ACTION_SAVE},
+		{"ctrl+e", ACTION_EXPORT},
+		{"c", ACTION_CROP},
+		{"shift+c", ACTION_CROP_PRESET},
+		{"alt+c", ACTION_CROP_CLEAR},
+		{"alt+left", ACTION_CROP_LEFT},
+		{"alt+right", ACTION_CROP_RIGHT},
+		{"alt+up", ACTION_CROP_UP},
+		{"alt+down", ACTION_CROP_DOWN},
+		{"alt+equal", ACTION_CROP_GROW},
+		{"alt+minus", ACTION_CROP_SHRINK},
+		{"q", ACTION_QUIT},
+		{"escape", ACTION_QUIT},
+		{"0", ACTION_RATE_0},
+		{"1", ACTION_RATE_1},
+		{"2", ACTION_RATE_2},
+		{"3", ACTION_RATE_3},
+		{"4", ACTION_RATE_4},
+		{"5", ACTION_RATE_5},
+	} {
+		if err := k.Bind(b[0], b[1]); err != nil {
+			panic(err)
+		}
+	}
+	return k
+}
+
+// Load returns the default bindings updated by the keymap file at path.
+// A missing file is not an error, the default bindings are returned.
+func Load(path string) (*Keymap, error) {
+	k := Default()
+	if path == "" {
+		return k, nil
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return k, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if i := strings.Index(text, "#"); i >= 0 {
+			text = text[:i]
+		}
+		fields := strings.Fields(text)
+		switch {
+		case len(fields) == 0:
+			continue
+		case fields[0] == "unbind":
+			err = k.Unbind(strings.Join(fields[1:], " "))
+		case len(fields) < 2:
+			err = fmt.Errorf("want \"<keys> <action>\"")
+		default:
+			err = k.Bind(strings.Join(fields[:len(fields)-1], " "), fields[len(fields)-1])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+	}
+	return k, scanner.Err()
+}
+
+// Bind associates keys (e.g. "ctrl+o" or "g g") to an action, replacing any previous binding of these keys.
+// The action must be one of the ACTION_ names.
+func (k *Keymap) Bind(keys string, action string) error {
+	chord, err := ParseChord(keys)
+	if err != nil {
+		return err
+	}
+	if _, ok := descriptions[action]; !ok {
+		return fmt.Errorf("unknown action %q", action)
+	}
+	k.remove(chordString(chord))
+	k.bindings = append(k.bindings, Binding{Keys: chord, Action: action})
+	return nil
+}
+
+// Unbind removes the binding of keys.
+func (k *Keymap) Unbind(keys string) error {
+	chord, err := ParseChord(keys)
+	if err != nil {
+		return err
+	}
+	k.remove(chordString(chord))
+	return nil
+}
+
+func (k *Keymap) remove(keys string) {
+	kept := k.bindings[:0]
+	for _, b := range k.bindings {
+		if b.String() != keys {
+			kept = append(kept, b)
+		}
+	}
+	k.bindings = kept
+}
+
+// Feed adds a stroke to the chord being typed and returns the action it completes, if any.
+func (k *Keymap) Feed(s Stroke) (action string, ok bool) {
+	now := time.Now()
+	if now.Sub(k.last) > CHORD_TIMEOUT {
+		k.pending = nil
+	}
+	k.last = now
+
+	k.pending = append(k.pending, s)
+	action, ok, prefix := k.match(k.pending)
+	if !ok && !prefix && len(k.pending) > 1 {
+		// The chord is broken, start again from this stroke alone
+		k.pending = []Stroke{s}
+		action, ok, prefix = k.match(k.pending)
+	}
+	if ok || !prefix {
+		k.pending = nil
+	}
+	return action, ok
+}
+
+// match looks for a binding equal to keys, and tells whether keys starts a longer binding.
+func (k *Keymap) match(keys []Stroke) (action string, ok bool, prefix bool) {
+	for _, b := range k.bindings {
+		if len(b.Keys) < len(keys) {
+			continue
+		}
+		same := true
+		for i := range keys {
+			if b.Keys[i] != keys[i] {
+				same = false
+				break
+			}
+		}
+		if !same {
+			continue
+		}
+		if len(b.Keys) == len(keys) {
+			action, ok = b.Action, true
+		} else {
+			prefix = true
+		}
+	}
+	// An exact match on a key that also starts a chord waits for the chord
+	if ok && prefix {
+		return "", false, true
+	}
+	return action, ok, prefix
+}
+
+// Pending returns the strokes typed so far of an unfinished chord.
+func (k *Keymap) Pending() []Stroke {
+	return k.pending
+}
+
+// Keys returns the bindings of an action, e.g. ["space", "right"].
+func (k *Keymap) Keys(action string) (keys []string) {
+	for _, b := range k.bindings {
+		if b.Action == action {
+			keys = append(keys, b.String())
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Help returns one line per action registered in r: its keys and description.
+func (k *Keymap) Help(r *Registry) (lines []string) {
+	for _, action := range r.Names() {
+		keys := k.Keys(action)
+		if len(keys) == 0 {
+			keys = []string{"-"}
+		}
+		lines = append(lines, fmt.Sprintf("%-20s %-10s %s", strings.Join(keys, ", "), action, r.Description(action)))
+	}
+	return lines
+}
+
+// Expire ends a chord left unfinished for longer than CHORD_TIMEOUT. When the
+// typed strokes are bound on their own, like "g" when "g g" is bound too, that
+// action is returned. Front ends call it regularly, once per frame is fine.
+func (k *Keymap) Expire() (action string, ok bool) {
+	if len(k.pending) == 0 || time.Since(k.last) <= CHORD_TIMEOUT {
+		return "", false
+	}
+	for _, b := range k.bindings {
+		if chordString(b.Keys) == chordString(k.pending) {
+			action, ok = b.Action, true
+		}
+	}
+	k.pending = nil
+	return action, ok
+}
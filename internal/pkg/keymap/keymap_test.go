@@ -0,0 +1,40 @@
+package keymap
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBind(t *testing.T) {
+	k := Default()
+	if err := k.Bind("g g", ACTION_FIRST); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Bind("ctrl+o", "nxt"); err == nil || !strings.Contains(err.Error(), "nxt") {
+		t.Errorf("binding an unknown action: %v", err)
+	}
+	if keys := k.Keys("nxt"); keys != nil {
+		t.Errorf("unknown action bound to %q", keys)
+	}
+	if _, ok := k.Feed(Stroke{Key: "g"}); ok {
+		t.Error("first key of a chord ran an action")
+	}
+	if action, ok := k.Feed(Stroke{Key: "g"}); !ok || action != ACTION_FIRST {
+		t.Errorf("chord ran %q, want %q", action, ACTION_FIRST)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keymap.conf")
+	if err := os.WriteFile(path, []byte("ctrl+o next\nx zoom-inn # typo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil || !strings.Contains(err.Error(), path+":2:") {
+		t.Errorf("loading a typo: %v, want an error on line 2", err)
+	}
+	if _, err := Load(filepath.Join("..", "..", "..", "configs", "keymap.example.conf")); err != nil {
+		t.Errorf("example keymap: %v", err)
+	}
+}
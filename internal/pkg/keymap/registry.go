@@ -0,0 +1,117 @@
+package keymap
+
+import (
+	"image"
+)
+
+// Trigger describes what started an action.
+type Trigger struct {
+	Stroke Stroke
+	Mouse  bool        // True when the stroke came from the mouse
+	At     image.Point // Pointer position in window coordinates
+}
+
+// Handler runs an action.
+type Handler func(t Trigger)
+
+type action struct {
+	description string
+	handler     Handler
+}
+
+// Registry holds the actions a front end supports.
+type Registry struct {
+	actions map[string]action
+	names   []string // Registration order, used by the help
+}
+
+// Default action descriptions, used when Register is given an empty description.
+var descriptions = map[string]string{
+	ACTION_NEXT:       "Show the next image",
+	ACTION_PREV:       "Show the previous image",
+	ACTION_FIRST:      "Show the first image",
+	ACTION_LAST:       "Show the last image",
+	ACTION_ZOOM_IN:    "Zoom in",
+	ACTION_ZOOM_OUT:   "Zoom out",
+	ACTION_FIT:        "Scale the image to the window",
+	ACTION_FLIP:       "Cycle through the flipping modes",
+	ACTION_ROTATE:     "Rotate the image by 90 degrees",
+	ACTION_DELETE:     "Move the image to the trash",
+	ACTION_COLLECT:    "Append the image to the collect playlist",
+	ACTION_PAUSE:      "Pause or resume the slideshow",
+	ACTION_FULLSCREEN: "Toggle fullscreen",
+	ACTION_HELP:       "Show or hide this help",
+	ACTION_HUD:        "Show or hide the image information",
+	ACTION_HISTOGRAM:  "Show or hide the RGB and luma histogram",
+	ACTION_INSPECT:    "Show or hide the values of the pixel under the mouse",
+
+	ACTION_BRIGHTNESS_UP:   "Increase the brightness",
+	ACTION_BRIGHTNESS_DOWN: "Decrease the brightness",
+	ACTION_CONTRAST_UP:     "Increase the contrast",
+	ACTION_CONTRAST_DOWN:   "Decrease the contrast",
+	ACTION_GAMMA_UP:        "Increase the gamma (lighter midtones)",
+	ACTION_GAMMA_DOWN:      "Decrease the gamma (darker midtones)",
+	ACTION_SATURATION_UP:   "Increase the saturation",
+	ACTION_SATURATION_DOWN: "Decrease the saturation",
+	ACTION_EXPOSURE_UP:     "Increase the exposure by a third of a stop",
+	ACTION_EXPOSURE_DOWN:   "Decrease the exposure by a third of a stop",
+	ACTION_GRAYSCALE:       "Toggle grayscale",
+	ACTION_INVERT:          "Toggle color inversion",
+	ACTION_RESET:           "Remove the color adjustments",
+	ACTION_SAVE:            "Save the adjustments and the crop in the image sidecar",
+	ACTION_EXPORT:          "Export the cropped and adjusted image to a new file",
+
+	ACTION_SAVE_ORIENTATION: "Write the displayed flipping and rotation to the image file",
+
+	ACTION_CROP:        "Start or stop cropping, drag the mouse to draw the crop rectangle",
+	ACTION_CROP_PRESET: "Cycle through the crop ratios: free, 1:1, 4:5, 3:2 and 16:9",
+	ACTION_CROP_CLEAR:  "Remove the crop rectangle",
+	ACTION_CROP_LEFT:   "Move the crop rectangle left",
+	ACTION_CROP_RIGHT:  "Move the crop rectangle right",
+	ACTION_CROP_UP:     "Move the crop rectangle up",
+	ACTION_CROP_DOWN:   "Move the crop rectangle down",
+	ACTION_CROP_GROW:   "Enlarge the crop rectangle",
+	ACTION_CROP_SHRINK: "Shrink the crop rectangle",
+	ACTION_QUIT:        "Quit",
+	ACTION_RATE_0:      "Remove the rating",
+	ACTION_RATE_1:      "Rate 1 star",
+	ACTION_RATE_2:      "Rate 2 stars",
+	ACTION_RATE_3:      "Rate 3 stars",
+	ACTION_RATE_4:      "Rate 4 stars",
+	ACTION_RATE_5:      "Rate 5 stars",
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{actions: make(map[string]action)}
+}
+
+// Register adds an action, or replaces its handler when it already exists.
+func (r *Registry) Register(name string, description string, handler Handler) {
+	if description == "" {
+		description = descriptions[name]
+	}
+	if _, ok := r.actions[name]; !ok {
+		r.names = append(r.names, name)
+	}
+	r.actions[name] = action{description: description, handler: handler}
+}
+
+// Run calls the handler of an action and tells whether the action exists.
+func (r *Registry) Run(name string, t Trigger) bool {
+	a, ok := r.actions[name]
+	if ok {
+		a.handler(t)
+	}
+	return ok
+}
+
+// Names returns the registered actions in registration order.
+func (r *Registry) Names() []string {
+	return r.names
+}
+
+// Description returns the description of an action.
+func (r *Registry) Description(name string) string {
+	return r.actions[name].description
+}
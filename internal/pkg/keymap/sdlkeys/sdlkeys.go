@@ -0,0 +1,90 @@
+// Package sdlkeys translates SDL events to keymap strokes.
+package sdlkeys
+
+import (
+	"strings"
+
+	"github.com/nicky-ayoub/imagination/internal/pkg/keymap"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// SDL key names (see SDL_GetKeyName) that differ from the keymap ones.
+var names = map[string]string{
+	"return":       "enter",
+	"=":            "equal",
+	"-":            "minus",
+	",":            "comma",
+	".":            "period",
+	"/":            "slash",
+	";":            "semicolon",
+	"'":            "quote",
+	"`":            "backquote",
+	"\\":           "backslash",
+	"[":            "bracketleft",
+	"]":            "bracketright",
+	"keypad +":     "kpplus",
+	"keypad -":     "kpminus",
+	"keypad *":     "kpmultiply",
+	"keypad /":     "kpdivide",
+	"keypad .":     "kpdecimal",
+	"keypad enter": "kpenter",
+}
+
+func modifiers(mod uint16) (mods keymap.Modifier) {
+	if mod&sdl.KMOD_SHIFT != 0 {
+		mods |= keymap.MOD_SHIFT
+	}
+	if mod&sdl.KMOD_CTRL != 0 {
+		mods |= keymap.MOD_CTRL
+	}
+	if mod&sdl.KMOD_ALT != 0 {
+		mods |= keymap.MOD_ALT
+	}
+	if mod&sdl.KMOD_GUI != 0 {
+		mods |= keymap.MOD_SUPER
+	}
+	return mods
+}
+
+// Key returns the stroke of a key press. ok is false for modifier keys pressed alone.
+func Key(t *sdl.KeyboardEvent) (stroke keymap.Stroke, ok bool) {
+	name := strings.ToLower(sdl.GetKeyName(t.Keysym.Sym))
+	if mapped, found := names[name]; found {
+		name = mapped
+	} else if strings.HasPrefix(name, "keypad ") {
+		name = "kp" + strings.TrimPrefix(name, "keypad ")
+	}
+	switch {
+	case name == "":
+		return stroke, false
+	case strings.HasSuffix(name, "shift"), strings.HasSuffix(name, "ctrl"), strings.HasSuffix(name, "alt"), strings.HasSuffix(name, "gui"):
+		return stroke, false
+	}
+	return keymap.Stroke{Mods: modifiers(t.Keysym.Mod), Key: strings.ReplaceAll(name, " ", "")}, true
+}
+
+// Button returns the stroke of a mouse button press.
+func Button(t *sdl.MouseButtonEvent) (stroke keymap.Stroke, ok bool) {
+	mods := modifiers(uint16(sdl.GetModState()))
+	switch t.Button {
+	case sdl.BUTTON_LEFT:
+		return keymap.Stroke{Mods: mods, Key: "mouseleft"}, true
+	case sdl.BUTTON_MIDDLE:
+		return keymap.Stroke{Mods: mods, Key: "mousemiddle"}, true
+	case sdl.BUTTON_RIGHT:
+		return keymap.Stroke{Mods: mods, Key: "mouseright"}, true
+	}
+	return stroke, false
+}
+
+// Wheel returns the stroke of a mouse wheel rotation.
+func Wheel(t *sdl.MouseWheelEvent) (stroke keymap.Stroke, ok bool) {
+	mods := modifiers(uint16(sdl.GetModState()))
+	switch {
+	case t.Y > 0:
+		return keymap.Stroke{Mods: mods, Key: "wheelup"}, true
+	case t.Y < 0:
+		return keymap.Stroke{Mods: mods, Key: "wheeldown"}, true
+	}
+	return stroke, false
+}
@@ -0,0 +1,254 @@
+// Package librarystats summarizes a tree of images: formats, extensions,
+// cameras, capture dates, sizes and resolutions, largest files and busiest
+// folders. Only the image headers and EXIF metadata are read, so large
+// libraries are summarized quickly.
+package librarystats
+
+import (
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+
+	"github.com/nicky-ayoub/imagination/internal/pkg/exif"
+)
+
+/** Name counted for the images whose value is not known, like the camera of images without EXIF metadata. */
+const UNKNOWN = "unknown"
+
+/** Number of largest files and busiest folders listed when none is given. */
+const DEFAULT_TOP = 10
+
+// Resolution buckets, in megapixels: an image falls in the first bucket whose limit it is below.
+var resolutions = []struct {
+	label string
+	limit float64
+}{
+	{"< 1 MP", 1},
+	{"1-4 MP", 4},
+	{"4-12 MP", 12},
+	{"12-24 MP", 24},
+	{"24-50 MP", 50},
+	{">= 50 MP", 0}, // No limit
+}
+
+// Entry is what is known of an image file.
+type Entry struct {
+	Path   string
+	Size   int64
+	Format string // Decoded format, UNKNOWN when the file can not be decoded
+	Width  int
+	Height int
+	Camera string    // Empty when unknown
+	Date   time.Time // Capture date, zero when unknown
+}
+
+// Read reads the header and the EXIF metadata of an image file.
+func Read(path string, size int64) Entry {
+	e := Entry{Path: path, Size: size, Format: UNKNOWN}
+	f, err := os.Open(path)
+	if err != nil {
+		return e
+	}
+	defer f.Close()
+	if config, format, err := image.DecodeConfig(f); err == nil {
+		e.Format, e.Width, e.Height = format, config.Width, config.Height
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		return e
+	}
+	if x, err := exif.Decode(f); err == nil {
+		e.Camera = x.Camera()
+		e.Date, _ = time.Parse(exif.DATE_LAYOUT, x.Date())
+	}
+	return e
+}
+
+// Count is the number of images sharing a value.
+type Count struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// File is a file listed in a report.
+type File struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// Report summarizes a library.
+type Report struct {
+	Root        string    `json:"root"`
+	Generated   time.Time `json:"generated"`
+	Files       int       `json:"files"`
+	Size        int64     `json:"size"`         // Total size in bytes
+	AverageSize int64     `json:"average_size"` // In bytes
+	Unreadable  int       `json:"unreadable"`   // Files that could not be decoded
+	Formats     []Count   `json:"formats"`      // Most frequent first
+	Extensions  []Count   `json:"extensions"`   // Case kept, so ".JPG" and ".jpg" are told apart
+	Cameras     []Count   `json:"cameras"`
+	Years       []Count   `json:"years"`  // Chronological, UNKNOWN last
+	Months      []Count   `json:"months"` // "2006-01", chronological, UNKNOWN last
+	Resolutions []Count   `json:"resolutions"`
+	Largest     []File    `json:"largest"`
+	Folders     []Count   `json:"folders"` // Folders with the most images
+}
+
+// Collector gathers entries into a report. It is safe for concurrent use.
+type Collector struct {
+	mutex       sync.Mutex
+	root        string
+	top         int
+	report      Report
+	formats     map[string]int
+	extensions  map[string]int
+	cameras     map[string]int
+	years       map[string]int
+	months      map[string]int
+	resolutions []int
+	folders     map[string]int
+	files       []File
+}
+
+// NewCollector returns a collector for the images of root, listing top largest files and folders.
+func NewCollector(root string, top int) *Collector {
+	if top <= 0 {
+		top = DEFAULT_TOP
+	}
+	return &Collector{
+		root:        root,
+		top:         top,
+		formats:     map[string]int{},
+		extensions:  map[string]int{},
+		cameras:     map[string]int{},
+		years:       map[string]int{},
+		months:      map[string]int{},
+		resolutions: make([]int, len(resolutions)),
+		folders:     map[string]int{},
+	}
+}
+
+// Add counts an entry.
+func (c *Collector) Add(e Entry) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.report.Files++
+	c.report.Size += e.Size
+	c.formats[e.Format]++
+	c.extensions[filepath.Ext(e.Path)]++
+	c.folders[filepath.Dir(e.Path)]++
+	c.files = append(c.files, File{e.Path, e.Size})
+
+	if e.Format == UNKNOWN {
+		c.report.Unreadable++
+	} else {
+		mp := float64(e.Width) * float64(e.Height) / 1e6
+		i := 0
+		for i < len(resolutions)-1 && mp >= resolutions[i].limit {
+			i++
+		}
+		c.resolutions[i]++
+	}
+
+	camera := strings.TrimSpace(e.Camera)
+	if camera == "" {
+		camera = UNKNOWN
+	}
+	c.cameras[camera]++
+	if e.Date.IsZero() {
+		c.years[UNKNOWN]++
+		c.months[UNKNOWN]++
+	} else {
+		c.years[e.Date.Format("2006")]++
+		c.months[e.Date.Format("2006-01")]++
+	}
+}
+
+// Report returns the summary of the entries added so far.
+func (c *Collector) Report() *Report {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	r := c.report
+	r.Root = c.root
+	r.Generated = time.Now()
+	if r.Files > 0 {
+		r.AverageSize = r.Size / int64(r.Files)
+	}
+	r.Formats = byCount(c.formats, 0)
+	r.Extensions = byCount(c.extensions, 0)
+	r.Cameras = byCount(c.cameras, 0)
+	r.Years = byName(c.years)
+	r.Months = byName(c.months)
+	r.Folders = byCount(c.folders, c.top)
+	r.Resolutions = make([]Count, len(resolutions))
+	for i, b := range resolutions {
+		r.Resolutions[i] = Count{b.label, c.resolutions[i]}
+	}
+
+	files := append([]File{}, c.files...)
+	sort.SliceStable(files, func(i, j int) bool {
+		if files[i].Size != files[j].Size {
+			return files[i].Size > files[j].Size
+		}
+		return files[i].Path < files[j].Path
+	})
+	if len(files) > c.top {
+		files = files[:c.top]
+	}
+	r.Largest = files
+	return &r
+}
+
+// byCount sorts counts from the most frequent, keeping the top ones when top is positive.
+func byCount(m map[string]int, top int) []Count {
+	counts := make([]Count, 0, len(m))
+	for name, n := range m {
+		counts = append(counts, Count{name, n})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Name < counts[j].Name
+	})
+	if top > 0 && len(counts) > top {
+		counts = counts[:top]
+	}
+	return counts
+}
+
+// byName sorts counts by name, UNKNOWN last.
+func byName(m map[string]int) []Count {
+	counts := make([]Count, 0, len(m))
+	for name, n := range m {
+		counts = append(counts, Count{name, n})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if (counts[i].Name == UNKNOWN) != (counts[j].Name == UNKNOWN) {
+			return counts[j].Name == UNKNOWN
+		}
+		return counts[i].Name < counts[j].Name
+	})
+	return counts
+}
+
+// Max returns the largest count, to scale charts.
+func Max(counts []Count) int {
+	m := 0
+	for _, c := range counts {
+		if c.Count > m {
+			m = c.Count
+		}
+	}
+	return m
+}
@@ -0,0 +1,173 @@
+package librarystats
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"io"
+	"strings"
+
+	"github.com/nicky-ayoub/imagination/internal/pkg/imagefs"
+)
+
+/** Width of the longest bar of the text charts, in characters. */
+const TEXT_BAR_WIDTH = 30
+
+// Section is a titled list of counts, as charted by the text and HTML reports.
+type Section struct {
+	Title    string
+	Counts   []Count
+	Timeline bool // Drawn as columns, in order, rather than as rows
+}
+
+// Sections returns the charted parts of the report.
+func (r *Report) Sections() []Section {
+	return []Section{
+		{"Formats", r.Formats, false},
+		{"Extensions", r.Extensions, false},
+		{"Cameras", r.Cameras, false},
+		{"Resolutions", r.Resolutions, false},
+		{"Years", r.Years, false},
+		{"Months", r.Months, true},
+		{"Folders with the most images", r.Folders, false},
+	}
+}
+
+// Summary returns the first line of the reports.
+func (r *Report) Summary() string {
+	s := fmt.Sprintf("%s: %d images, %s, %s on average", r.Root, r.Files, imagefs.FormatSize(r.Size), imagefs.FormatSize(r.AverageSize))
+	if r.Unreadable > 0 {
+		s += fmt.Sprintf(", %d unreadable", r.Unreadable)
+	}
+	return s
+}
+
+// WriteText writes the report as text tables with bar charts.
+func (r *Report) WriteText(w io.Writer) error {
+	fmt.Fprintln(w, r.Summary())
+	for _, s := range r.Sections() {
+		if len(s.Counts) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\n%s\n", s.Title)
+		width := 0
+		for _, c := range s.Counts {
+			if len(c.Name) > width {
+				width = len(c.Name)
+			}
+		}
+		max := Max(s.Counts)
+		for _, c := range s.Counts {
+			bar := 0
+			if max > 0 {
+				bar = (c.Count*TEXT_BAR_WIDTH + max - 1) / max
+			}
+			fmt.Fprintf(w, "  %-*s %7d %6.1f%%  %s\n", width, c.Name, c.Count, r.percent(c.Count), strings.Repeat("#", bar))
+		}
+	}
+	if len(r.Largest) > 0 {
+		fmt.Fprintf(w, "\nLargest files\n")
+		for _, f := range r.Largest {
+			fmt.Fprintf(w, "  %10s  %s\n", imagefs.FormatSize(f.Size), f.Path)
+		}
+	}
+	_, err := fmt.Fprintf(w, "\nGenerated %s\n", r.Generated.Format("2006-01-02 15:04"))
+	return err
+}
+
+func (r *Report) percent(n int) float64 {
+	if r.Files == 0 {
+		return 0
+	}
+	return 100 * float64(n) / float64(r.Files)
+}
+
+// WriteHTML writes the report as a self-contained HTML page, the charts being inline SVG.
+func (r *Report) WriteHTML(w io.Writer) error {
+	return page.Execute(w, r)
+}
+
+var page = template.Must(template.New("report").Funcs(template.FuncMap{
+	"size":  imagefs.FormatSize,
+	"chart": chart,
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Imagination library report: {{.Root}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em auto; max-width: 60em; color: #222; background: #fafafa; }
+h1 { font-size: 1.4em; }
+h2 { font-size: 1.1em; margin-top: 2em; border-bottom: 1px solid #ccc; }
+table { border-collapse: collapse; }
+td { padding: 0.2em 0.8em 0.2em 0; }
+td.number { text-align: right; font-variant-numeric: tabular-nums; }
+svg text { font-size: 12px; fill: #222; }
+svg rect { fill: #4a7ebb; }
+.overview td:first-child { color: #666; }
+footer { margin-top: 3em; color: #888; font-size: 0.9em; }
+</style>
+</head>
+<body>
+<h1>Library report: {{.Root}}</h1>
+<table class="overview">
+<tr><td>Images</td><td class="number">{{.Files}}</td></tr>
+<tr><td>Total size</td><td class="number">{{size .Size}}</td></tr>
+<tr><td>Average size</td><td class="number">{{size .AverageSize}}</td></tr>
+{{if .Unreadable}}<tr><td>Unreadable</td><td class="number">{{.Unreadable}}</td></tr>{{end}}
+</table>
+{{range .Sections}}{{if .Counts}}
+<h2>{{.Title}}</h2>
+{{chart .}}
+{{end}}{{end}}
+{{if .Largest}}
+<h2>Largest files</h2>
+<table>
+{{range .Largest}}<tr><td class="number">{{size .Size}}</td><td>{{.Path}}</td></tr>
+{{end}}</table>
+{{end}}
+<footer>Generated {{.Generated.Format "2006-01-02 15:04"}} by imagination stats.</footer>
+</body>
+</html>
+`))
+
+// chart draws a section as an SVG bar chart: one row per count, or one column per count for timelines.
+func chart(s Section) template.HTML {
+	const (
+		row      = 20  // Row height, or column width
+		label    = 220 // Label column width
+		barWidth = 420 // Longest bar
+		height   = 160 // Highest column
+	)
+	max := Max(s.Counts)
+	if max == 0 {
+		max = 1
+	}
+	var b strings.Builder
+	if s.Timeline {
+		width := len(s.Counts) * row
+		fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" role="img">`, width, height+70)
+		for i, c := range s.Counts {
+			h := c.Count * height / max
+			x := i * row
+			fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%d" height="%d"><title>%s: %d</title></rect>`,
+				x+2, height-h+10, row-4, h, html.EscapeString(c.Name), c.Count)
+			fmt.Fprintf(&b, `<text transform="translate(%d,%d) rotate(60)">%s</text>`, x+row/2-4, height+16, html.EscapeString(c.Name))
+		}
+	} else {
+		fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" role="img">`, label+barWidth+80, len(s.Counts)*row)
+		for i, c := range s.Counts {
+			y := i * row
+			w := c.Count * barWidth / max
+			name := c.Name
+			if runes := []rune(name); len(runes) > 34 {
+				name = "…" + string(runes[len(runes)-33:])
+			}
+			fmt.Fprintf(&b, `<text x="%d" y="%d" text-anchor="end">%s<title>%s</title></text>`, label-8, y+14, html.EscapeString(name), html.EscapeString(c.Name))
+			fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%d" height="%d"></rect>`, label, y+3, w, row-6)
+			fmt.Fprintf(&b, `<text x="%d" y="%d">%d</text>`, label+w+6, y+14, c.Count)
+		}
+	}
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
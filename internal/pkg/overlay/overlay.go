@@ -0,0 +1,61 @@
+// Package overlay renders the text panels displayed over images (help, HUD, ...)
+// into plain RGBA images, so every front end can upload them as it likes.
+package overlay
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// Style describes how a panel looks.
+type Style struct {
+	Foreground color.RGBA
+	Background color.RGBA // Usually translucent
+	Padding    int        // Space between the text and the panel border, in pixels
+}
+
+// DefaultStyle returns white text on a translucent black panel.
+func DefaultStyle() Style {
+	return Style{
+		Foreground: color.RGBA{255, 255, 255, 255},
+		Background: color.RGBA{0, 0, 0, 176},
+		Padding:    8,
+	}
+}
+
+/** The embedded bitmap font all panels use. */
+var face = basicfont.Face7x13
+
+// LineHeight returns the height of a text line in pixels.
+func LineHeight() int {
+	return face.Height + 2
+}
+
+// TextWidth returns the width of a text line in pixels.
+func TextWidth(s string) int {
+	return font.MeasureString(face, s).Ceil()
+}
+
+// Text renders lines of text on a panel just large enough to hold them.
+func Text(lines []string, style Style) *image.RGBA {
+	width := 0
+	for _, line := range lines {
+		if w := TextWidth(line); w > width {
+			width = w
+		}
+	}
+	panel := image.NewRGBA(image.Rect(0, 0, width+2*style.Padding, len(lines)*LineHeight()+2*style.Padding))
+	draw.Draw(panel, panel.Bounds(), image.NewUniform(style.Background), image.Point{}, draw.Src)
+
+	d := font.Drawer{Dst: panel, Src: image.NewUniform(style.Foreground), Face: face}
+	for i, line := range lines {
+		d.Dot = fixed.P(style.Padding, style.Padding+i*LineHeight()+face.Ascent)
+		d.DrawString(line)
+	}
+	return panel
+}
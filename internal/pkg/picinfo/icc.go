@@ -0,0 +1,82 @@
+package picinfo
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+	"strings"
+	"unicode/utf16"
+)
+
+// ProfileName returns the description of an ICC profile, like
+// "sRGB IEC61966-2.1", or an empty string when it has none.
+func ProfileName(profile []byte) string {
+	if len(profile) < 132 {
+		return ""
+	}
+	count := int(binary.BigEndian.Uint32(profile[128:]))
+	for i := 0; i < count; i++ {
+		entry := 132 + 12*i
+		if entry+12 > len(profile) {
+			break
+		}
+		if string(profile[entry:entry+4]) != "desc" {
+			continue
+		}
+		at := int(binary.BigEndian.Uint32(profile[entry+4:]))
+		size := int(binary.BigEndian.Uint32(profile[entry+8:]))
+		if at < 0 || size < 12 || at+size > len(profile) {
+			return ""
+		}
+		return description(profile[at : at+size])
+	}
+	return ""
+}
+
+// description decodes a textDescriptionType (ICC v2) or a
+// multiLocalizedUnicodeType (ICC v4) tag, the first record for the latter.
+func description(tag []byte) string {
+	switch string(tag[:4]) {
+	case "desc":
+		n := int(binary.BigEndian.Uint32(tag[8:]))
+		if 12+n > len(tag) {
+			return ""
+		}
+		return strings.TrimRight(string(tag[12:12+n]), "\x00 ")
+	case "mluc":
+		if len(tag) < 28 || binary.BigEndian.Uint32(tag[8:]) == 0 {
+			return ""
+		}
+		n := int(binary.BigEndian.Uint32(tag[20:]))
+		at := int(binary.BigEndian.Uint32(tag[24:]))
+		if at+n > len(tag) {
+			return ""
+		}
+		units := make([]uint16, n/2)
+		for i := range units {
+			units[i] = binary.BigEndian.Uint16(tag[at+2*i:])
+		}
+		return strings.TrimRight(string(utf16.Decode(units)), "\x00 ")
+	}
+	return ""
+}
+
+// pngProfile decompresses the profile of an iCCP chunk: a name, a null byte,
+// the compression method and the zlib stream.
+func pngProfile(chunk []byte) []byte {
+	i := bytes.IndexByte(chunk, 0)
+	if i < 0 || i+2 > len(chunk) {
+		return nil
+	}
+	r, err := zlib.NewReader(bytes.NewReader(chunk[i+2:]))
+	if err != nil {
+		return nil
+	}
+	defer r.Close()
+	profile, err := io.ReadAll(r)
+	if err != nil {
+		return nil
+	}
+	return profile
+}
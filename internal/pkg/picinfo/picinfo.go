@@ -0,0 +1,238 @@
+// Package picinfo inspects image files: format, dimensions, color model, bit
+// depth, embedded ICC profile, EXIF summary and content hash. Only the image
+// header is decoded, the pixels are not.
+package picinfo
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"os"
+
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+
+	"github.com/nicky-ayoub/imagination/internal/pkg/exif"
+	"github.com/nicky-ayoub/imagination/internal/pkg/imagefs"
+)
+
+// Info describes an image file.
+type Info struct {
+	Path        string `json:"path"`
+	Format      string `json:"format"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	ColorModel  string `json:"color_model"`
+	BitDepth    int    `json:"bit_depth"` // Bits per channel, or per pixel index for paletted images
+	Size        int64  `json:"size"`      // File size in bytes
+	ICCProfile  string `json:"icc_profile,omitempty"`
+	Exif        string `json:"exif,omitempty"` // EXIF summary, see exif.Exif.Summary
+	Orientation int    `json:"orientation,omitempty"`
+	SHA256      string `json:"sha256"`
+}
+
+// Inspect reads an image file and describes it.
+func Inspect(path string) (*Info, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	sum := sha256.Sum256(data)
+	info := &Info{
+		Path:       path,
+		Format:     format,
+		Width:      config.Width,
+		Height:     config.Height,
+		ColorModel: modelName(config.ColorModel),
+		BitDepth:   modelDepth(config.ColorModel),
+		Size:       int64(len(data)),
+		SHA256:     hex.EncodeToString(sum[:]),
+	}
+
+	// The format headers tell more than the Go color models
+	var profile []byte
+	switch format {
+	case "jpeg":
+		profile = jpegHeader(data, info)
+	case "png":
+		profile = pngHeader(data, info)
+	case "webp":
+		profile = webpProfile(data)
+	}
+	if profile != nil {
+		info.ICCProfile = ProfileName(profile)
+	}
+
+	if e, err := exif.Decode(bytes.NewReader(data)); err == nil {
+		info.Exif = e.Summary()
+		info.Orientation = e.Orientation
+	}
+	return info, nil
+}
+
+// WriteText writes the path followed by the description, one indented field per line.
+func (i *Info) WriteText(w io.Writer) {
+	fmt.Fprintln(w, i.Path)
+	fmt.Fprintf(w, "  Format:       %s\n", i.Format)
+	fmt.Fprintf(w, "  Dimensions:   %dx%d (%.1f MP)\n", i.Width, i.Height, i.Megapixels())
+	fmt.Fprintf(w, "  Color model:  %s\n", i.ColorModel)
+	fmt.Fprintf(w, "  Bit depth:    %d\n", i.BitDepth)
+	fmt.Fprintf(w, "  File size:    %s (%d bytes)\n", imagefs.FormatSize(i.Size), i.Size)
+	if i.ICCProfile != "" {
+		fmt.Fprintf(w, "  ICC profile:  %s\n", i.ICCProfile)
+	}
+	if i.Exif != "" {
+		fmt.Fprintf(w, "  EXIF:         %s\n", i.Exif)
+	}
+	if i.Orientation > 1 {
+		fmt.Fprintf(w, "  Orientation:  %d\n", i.Orientation)
+	}
+	fmt.Fprintf(w, "  SHA-256:      %s\n", i.SHA256)
+}
+
+// Megapixels returns the number of pixels in millions.
+func (i *Info) Megapixels() float64 {
+	return float64(i.Width) * float64(i.Height) / 1e6
+}
+
+func modelName(m color.Model) string {
+	switch m {
+	case color.RGBAModel, color.RGBA64Model:
+		return "RGBA"
+	case color.NRGBAModel, color.NRGBA64Model:
+		return "NRGBA"
+	case color.GrayModel, color.Gray16Model:
+		return "Gray"
+	case color.AlphaModel, color.Alpha16Model:
+		return "Alpha"
+	case color.YCbCrModel:
+		return "YCbCr"
+	case color.NYCbCrAModel:
+		return "YCbCrA"
+	case color.CMYKModel:
+		return "CMYK"
+	}
+	if p, ok := m.(color.Palette); ok {
+		return fmt.Sprintf("Paletted (%d colors)", len(p))
+	}
+	return "Unknown"
+}
+
+func modelDepth(m color.Model) int {
+	switch m {
+	case color.RGBA64Model, color.NRGBA64Model, color.Gray16Model, color.Alpha16Model:
+		return 16
+	}
+	if p, ok := m.(color.Palette); ok {
+		depth := 1
+		for 1<<depth < len(p) {
+			depth++
+		}
+		return depth
+	}
+	return 8
+}
+
+// jpegHeader reads the precision and chroma subsampling of the frame header,
+// and returns the ICC profile spread over APP2 segments.
+func jpegHeader(data []byte, info *Info) (profile []byte) {
+	var chunks [256][]byte
+	count := 0
+	for at := 2; at+4 <= len(data) && data[at] == 0xff; {
+		marker := data[at+1]
+		if marker == 0xda || marker == 0xd9 {
+			break
+		}
+		// The length counts its own two bytes
+		end := at + 2 + int(binary.BigEndian.Uint16(data[at+2:]))
+		if end < at+4 || end > len(data) {
+			break
+		}
+		segment := data[at+4 : end]
+		switch {
+		case marker == 0xe2 && len(segment) > 14 && string(segment[:12]) == "ICC_PROFILE\x00":
+			chunks[segment[12]] = segment[14:]
+			count = int(segment[13])
+		case marker >= 0xc0 && marker <= 0xcf && marker != 0xc4 && marker != 0xc8 && marker != 0xcc && len(segment) >= 6:
+			info.BitDepth = int(segment[0])
+			components := int(segment[5])
+			if components == 3 && len(segment) >= 6+3*3 {
+				info.ColorModel = "YCbCr " + subsampling(segment[7], segment[10])
+			}
+			if marker == 0xc2 || marker == 0xc6 || marker == 0xca || marker == 0xce {
+				info.ColorModel += ", progressive"
+			}
+		}
+		at = end
+	}
+	for i := 1; i <= count; i++ {
+		profile = append(profile, chunks[i]...)
+	}
+	return profile
+}
+
+// subsampling names the chroma subsampling from the sampling factors of the luma and first chroma components.
+func subsampling(luma byte, chroma byte) string {
+	h := int(luma>>4) / max(1, int(chroma>>4))
+	v := int(luma&15) / max(1, int(chroma&15))
+	switch {
+	case h == 1 && v == 1:
+		return "4:4:4"
+	case h == 2 && v == 1:
+		return "4:2:2"
+	case h == 2 && v == 2:
+		return "4:2:0"
+	case h == 1 && v == 2:
+		return "4:4:0"
+	case h == 4 && v == 1:
+		return "4:1:1"
+	}
+	return fmt.Sprintf("%dx%d", h, v)
+}
+
+// pngHeader reads the bit depth of the IHDR chunk and returns the iCCP chunk profile.
+func pngHeader(data []byte, info *Info) []byte {
+	if len(data) >= 26 {
+		info.BitDepth = int(data[24])
+	}
+	for at := 8; at+8 <= len(data); {
+		size := int(binary.BigEndian.Uint32(data[at:]))
+		kind := string(data[at+4 : at+8])
+		if at+8+size > len(data) || kind == "IDAT" {
+			break
+		}
+		if kind == "iCCP" {
+			return pngProfile(data[at+8 : at+8+size])
+		}
+		at += 12 + size
+	}
+	return nil
+}
+
+// webpProfile returns the ICCP chunk of an extended WebP file.
+func webpProfile(data []byte) []byte {
+	for at := 12; at+8 <= len(data); {
+		size := int(binary.LittleEndian.Uint32(data[at+4:]))
+		if at+8+size > len(data) {
+			break
+		}
+		if string(data[at:at+4]) == "ICCP" {
+			return data[at+8 : at+8+size]
+		}
+		at += 8 + size + size&1
+	}
+	return nil
+}
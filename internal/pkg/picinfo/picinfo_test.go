@@ -0,0 +1,65 @@
+package picinfo
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func encode(t *testing.T) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, 32, 16))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i)
+	}
+	img.SetNRGBA(0, 0, color.NRGBA{255, 0, 0, 255})
+	var data bytes.Buffer
+	if err := jpeg.Encode(&data, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	return data.Bytes()
+}
+
+func TestInspect(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "image.jpg")
+	if err := os.WriteFile(path, encode(t), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := Inspect(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Format != "jpeg" || info.Width != 32 || info.Height != 16 || info.BitDepth != 8 {
+		t.Errorf("format %s, size %dx%d, depth %d, want jpeg 32x16 8", info.Format, info.Width, info.Height, info.BitDepth)
+	}
+	if info.ColorModel != "YCbCr 4:2:0" {
+		t.Errorf("color model %q, want YCbCr 4:2:0", info.ColorModel)
+	}
+}
+
+// TestMalformedSegments reads headers whose segment lengths are too short or
+// too long, which must stop the reading at the segment without panicking.
+func TestMalformedSegments(t *testing.T) {
+	data := encode(t)
+	sos := bytes.Index(data, []byte{0xff, 0xda})
+	for _, segment := range [][]byte{
+		{0xff, 0xe2, 0x00, 0x00},
+		{0xff, 0xe2, 0x00, 0x01},
+		{0xff, 0xe2, 0x00, 0x02},
+		{0xff, 0xe2, 0xff, 0xff},
+		{0xff, 0xe2},
+	} {
+		malformed := append(append(append([]byte{}, data[:sos]...), segment...), data[sos:]...)
+		var info Info
+		if profile := jpegHeader(malformed, &info); profile != nil {
+			t.Errorf("segment % x: profile %q", segment, profile)
+		}
+		if info.BitDepth != 8 {
+			t.Errorf("segment % x: depth %d, want 8 from the frame before it", segment, info.BitDepth)
+		}
+	}
+}
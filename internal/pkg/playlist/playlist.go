@@ -0,0 +1,242 @@
+// Package playlist reads and writes the image lists shared by the viewers.
+//
+// Two formats are supported and chosen from the file extension:
+//
+//   - plain lists (.m3u, .m3u8, .txt): one path per line, blank lines and
+//     comments ignored; the M3U "#EXTINF:<seconds>,<caption>" line is used to
+//     carry the duration and caption of the next entry.
+//   - rich lists (.json, .yaml, .yml): an object holding the items with their
+//     duration, caption, crop rectangle and transition.
+//
+// Relative paths are relative to the directory holding the playlist file.
+package playlist
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Transitions understood by the slideshows. An empty transition means TRANSITION_CUT.
+const (
+	TRANSITION_CUT  = "cut"
+	TRANSITION_FADE = "fade"
+)
+
+// Duration is a time.Duration written as "5s", "1m30s", ... in rich playlists.
+type Duration time.Duration
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	*d = Duration(v)
+	return err
+}
+
+// Crop is a source image region, in pixels.
+type Crop struct {
+	X int `json:"x" yaml:"x"`
+	Y int `json:"y" yaml:"y"`
+	W int `json:"w" yaml:"w"`
+	H int `json:"h" yaml:"h"`
+}
+
+// Item is a single playlist entry.
+type Item struct {
+	Path       string   `json:"path" yaml:"path"`
+	Duration   Duration `json:"duration,omitempty" yaml:"duration,omitempty"`
+	Caption    string   `json:"caption,omitempty" yaml:"caption,omitempty"`
+	Crop       *Crop    `json:"crop,omitempty" yaml:"crop,omitempty"`
+	Transition string   `json:"transition,omitempty" yaml:"transition,omitempty"`
+}
+
+// Playlist is an ordered list of images.
+type Playlist struct {
+	Name  string `json:"name,omitempty" yaml:"name,omitempty"`
+	Items []Item `json:"items" yaml:"items"`
+
+	dir string // Directory relative item paths are resolved against
+}
+
+// FromPaths builds a playlist from paths relative to dir, e.g. the output of imagefs.AllJpgFiles(dir).
+func FromPaths(dir string, paths []string) *Playlist {
+	p := &Playlist{dir: dir}
+	for _, path := range paths {
+		p.Items = append(p.Items, Item{Path: path})
+	}
+	return p
+}
+
+// Load reads a playlist file, guessing its format from the file extension.
+func Load(path string) (p *Playlist, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	p = &Playlist{}
+	switch format(path) {
+	case "json":
+		err = json.NewDecoder(f).Decode(p)
+	case "yaml":
+		err = yaml.NewDecoder(f).Decode(p)
+	default:
+		err = p.readPlain(f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	p.dir = filepath.Dir(path)
+	return p, nil
+}
+
+// Save writes the playlist to path, in the format matching the file extension.
+// Item paths are stored relative to the playlist directory when possible.
+func (p *Playlist) Save(path string) (err error) {
+	out := Playlist{Name: p.Name}
+	dir := filepath.Dir(path)
+	for _, item := range p.Items {
+		item.Path = relative(dir, p.Resolve(item))
+		out.Items = append(out.Items, item)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	switch format(path) {
+	case "json":
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(&out)
+	case "yaml":
+		enc := yaml.NewEncoder(f)
+		enc.SetIndent(2)
+		if err = enc.Encode(&out); err == nil {
+			err = enc.Close()
+		}
+	default:
+		err = out.writePlain(f)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Append adds an image to the playlist file at path, creating the file when it does not exist yet.
+// A relative image path is relative to the current working directory, not to the playlist.
+func Append(path string, image string) error {
+	image, err := filepath.Abs(image)
+	if err != nil {
+		return err
+	}
+	p, err := Load(path)
+	if os.IsNotExist(err) {
+		p, err = &Playlist{dir: filepath.Dir(path)}, nil
+	}
+	if err != nil {
+		return err
+	}
+	p.Items = append(p.Items, Item{Path: image})
+	return p.Save(path)
+}
+
+// Resolve returns the path of an item usable from the current working directory.
+func (p *Playlist) Resolve(item Item) string {
+	if filepath.IsAbs(item.Path) || p.dir == "" {
+		return item.Path
+	}
+	return filepath.Join(p.dir, item.Path)
+}
+
+// Paths returns the resolved path of every item.
+func (p *Playlist) Paths() (paths []string) {
+	for _, item := range p.Items {
+		paths = append(paths, p.Resolve(item))
+	}
+	return paths
+}
+
+func format(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return "json"
+	case ".yaml", ".yml":
+		return "yaml"
+	}
+	return "plain"
+}
+
+func relative(dir string, path string) string {
+	absDir, err1 := filepath.Abs(dir)
+	absPath, err2 := filepath.Abs(path)
+	if err1 != nil || err2 != nil {
+		return path
+	}
+	if rel, err := filepath.Rel(absDir, absPath); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return rel
+	}
+	return absPath
+}
+
+func (p *Playlist) readPlain(r io.Reader) error {
+	var pending Item
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#EXTINF:"):
+			// #EXTINF:<seconds>,<caption>
+			info := strings.SplitN(strings.TrimPrefix(line, "#EXTINF:"), ",", 2)
+			if seconds, err := strconv.ParseFloat(strings.TrimSpace(info[0]), 64); err == nil && seconds > 0 {
+				pending.Duration = Duration(seconds * float64(time.Second))
+			}
+			if len(info) == 2 {
+				pending.Caption = strings.TrimSpace(info[1])
+			}
+		case strings.HasPrefix(line, "#PLAYLIST:"):
+			p.Name = strings.TrimSpace(strings.TrimPrefix(line, "#PLAYLIST:"))
+		case strings.HasPrefix(line, "#"):
+			// Other comments and directives are ignored
+		default:
+			pending.Path = line
+			p.Items = append(p.Items, pending)
+			pending = Item{}
+		}
+	}
+	return scanner.Err()
+}
+
+func (p *Playlist) writePlain(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "#EXTM3U")
+	if p.Name != "" {
+		fmt.Fprintln(bw, "#PLAYLIST:"+p.Name)
+	}
+	for _, item := range p.Items {
+		// Crop and transition need a rich playlist, only duration and caption fit in M3U
+		if item.Duration > 0 || item.Caption != "" {
+			seconds := -1.0
+			if item.Duration > 0 {
+				seconds = time.Duration(item.Duration).Seconds()
+			}
+			fmt.Fprintf(bw, "#EXTINF:%s,%s\n", strconv.FormatFloat(seconds, 'f', -1, 64), item.Caption)
+		}
+		fmt.Fprintln(bw, item.Path)
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,105 @@
+package playlist
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSaveLoad(t *testing.T) {
+	directory := t.TempDir()
+	outside := filepath.Join(t.TempDir(), "outside.jpg")
+	list := &Playlist{Name: "Holidays", dir: directory, Items: []Item{
+		{Path: "a.jpg"},
+		{Path: filepath.Join("sub", "b.jpg"), Duration: Duration(2500 * time.Millisecond), Caption: "Beach"},
+		{Path: filepath.Join(directory, "c.png")},
+		{Path: outside},
+	}}
+	rich := []Item{
+		{Path: "d.jpg", Crop: &Crop{X: 1, Y: 2, W: 30, H: 40}, Transition: TRANSITION_FADE},
+	}
+	want := []string{
+		filepath.Join(directory, "a.jpg"),
+		filepath.Join(directory, "sub", "b.jpg"),
+		filepath.Join(directory, "c.png"),
+		outside,
+	}
+	for _, name := range []string{"list.m3u", "list.json", "list.yaml"} {
+		t.Run(name, func(t *testing.T) {
+			saved := &Playlist{Name: list.Name, dir: list.dir, Items: list.Items}
+			if name != "list.m3u" {
+				saved.Items = append(append([]Item{}, list.Items...), rich...)
+			}
+			path := filepath.Join(directory, name)
+			if err := saved.Save(path); err != nil {
+				t.Fatal(err)
+			}
+			loaded, err := Load(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if loaded.Name != saved.Name {
+				t.Errorf("name %q, want %q", loaded.Name, saved.Name)
+			}
+			paths := loaded.Paths()
+			if !reflect.DeepEqual(paths[:len(want)], want) {
+				t.Errorf("paths %q, want %q", paths, want)
+			}
+			if loaded.Items[2].Path != "c.png" || loaded.Items[3].Path != outside {
+				t.Errorf("stored paths %q and %q, want relative inside the directory only", loaded.Items[2].Path, loaded.Items[3].Path)
+			}
+			if b := loaded.Items[1]; b.Duration != saved.Items[1].Duration || b.Caption != saved.Items[1].Caption {
+				t.Errorf("item %+v, want duration and caption of %+v", b, saved.Items[1])
+			}
+			if name != "list.m3u" && !reflect.DeepEqual(loaded.Items[4], rich[0]) {
+				t.Errorf("item %+v, want %+v", loaded.Items[4], rich[0])
+			}
+		})
+	}
+}
+
+// TestAppend appends paths relative to the working directory to a playlist in another directory.
+func TestAppend(t *testing.T) {
+	working, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(working)
+	directory := t.TempDir()
+	images := filepath.Join(directory, "images")
+	if err = os.Mkdir(images, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(images); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(directory, "lists", "collected.m3u")
+	if err = os.Mkdir(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, image := range []string{"a.jpg", filepath.Join("sub", "b.jpg")} {
+		if err = Append(path, image); err != nil {
+			t.Fatal(err)
+		}
+	}
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The temporary directory may be behind a symbolic link
+	images, err = os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{filepath.Join(images, "a.jpg"), filepath.Join(images, "sub", "b.jpg")}
+	for i, p := range loaded.Paths() {
+		if got, _ := filepath.Abs(p); got != want[i] {
+			t.Errorf("item %d: %s, want %s", i, got, want[i])
+		}
+	}
+	if len(loaded.Items) != len(want) {
+		t.Errorf("%d items, want %d", len(loaded.Items), len(want))
+	}
+}
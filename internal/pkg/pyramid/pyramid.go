@@ -0,0 +1,481 @@
+// Package pyramid splits an image too large for a single texture into tiles,
+// at several resolutions, so a viewer only uploads the tiles it displays, at
+// the resolution it displays them.
+//
+// Level 0 is the image itself and each level halves the previous one, the
+// last level, the preview, fitting in a preview size. The levels are averaged
+// in the background, from the finest to the coarsest.
+//
+// New makes the pyramid of decoded pixels, sampling the preview at once for a
+// first display. Open decodes an image file in the background instead: the
+// preview of a JPEG file is averaged from the block averages stored in the
+// file, long before the image is decoded. Level 0 is then kept as decoded, a
+// single buffer often smaller than its RGBA pixels, and converted a tile at a
+// time when displayed.
+package pyramid
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"os"
+	"runtime"
+	"sync"
+
+	"github.com/nicky-ayoub/imagination/internal/pkg/imageio"
+	"github.com/nicky-ayoub/imagination/internal/pkg/jpegpreview"
+)
+
+/** Level of the JPEG block averages, an 8x8 block being a pixel. */
+const JPEG_PREVIEW_LEVEL = 3
+
+// Tile is a part of a level.
+type Tile struct {
+	Level  int
+	Index  image.Point     // Column and row of the tile in its level
+	Bounds image.Rectangle // Tile pixels in its level
+	Source image.Rectangle // Image pixels the tile covers, in level 0 pixels
+}
+
+// Pyramid holds the levels of an image.
+type Pyramid struct {
+	tileSize int
+	size     image.Point // Level 0 size
+	mutex    sync.Mutex
+	levels   []image.Image // nil until built, level 0 as decoded, the others *image.NRGBA
+	ready    []bool        // Whether the level is averaged from the image, a first preview is not
+	err      error         // Why the image could not be decoded
+	done     chan struct{} // Closed when every level is built, the decoding failed or the pyramid closed
+	closed   bool
+}
+
+var errClosed = errors.New("pyramid closed")
+
+// newPyramid returns a pyramid without levels yet.
+func newPyramid(size image.Point, tileSize int, previewSize int) *Pyramid {
+	p := &Pyramid{tileSize: tileSize, size: size, done: make(chan struct{})}
+	count := 1
+	for ; size.X > previewSize || size.Y > previewSize; size = half(size) {
+		count++
+	}
+	p.levels = make([]image.Image, count)
+	p.ready = make([]bool, count)
+	return p
+}
+
+// New returns the pyramid of an image with square tiles of a side, the
+// coarsest level fitting in a square of previewSize. The image must not be
+// modified while the pyramid is in use.
+func New(img *image.NRGBA, tileSize int, previewSize int) *Pyramid {
+	p := newPyramid(img.Rect.Size(), tileSize, previewSize)
+	p.levels[0], p.ready[0] = img, true
+	if last := len(p.levels) - 1; last > 0 {
+		p.levels[last] = sample(img, last)
+	}
+	go func() {
+		defer close(p.done)
+		p.build()
+	}()
+	return p
+}
+
+// Open returns the pyramid of an image file, as New does, reading only its
+// header before returning. The levels come as the file is decoded, see Err()
+// when it can not be.
+func Open(path string, tileSize int, previewSize int) (*Pyramid, error) {
+	config, format, err := imageio.Config(path)
+	if err != nil {
+		return nil, err
+	}
+	p := newPyramid(image.Pt(config.Width, config.Height), tileSize, previewSize)
+	var work sync.WaitGroup
+	work.Add(1)
+	go func() {
+		defer work.Done()
+		p.decode(path)
+	}()
+	if format == "jpeg" && len(p.levels) > JPEG_PREVIEW_LEVEL {
+		work.Add(1)
+		go func() {
+			defer work.Done()
+			p.decodePreview(path)
+		}()
+	}
+	go func() {
+		work.Wait()
+		close(p.done)
+	}()
+	return p, nil
+}
+
+// reader reads a file until the pyramid is closed, which stops its decoding.
+type reader struct {
+	file *os.File
+	p    *Pyramid
+}
+
+func (r reader) Read(b []byte) (int, error) {
+	if r.p.isClosed() {
+		return 0, errClosed
+	}
+	return r.file.Read(b)
+}
+
+// decode decodes level 0 and averages the other levels from it.
+func (p *Pyramid) decode(path string) {
+	img, err := p.load(path)
+	if err == nil && img.Bounds() != (image.Rectangle{Max: p.size}) {
+		err = fmt.Errorf("%s: image bounds %v instead of the size %v of its header", path, img.Bounds(), p.size)
+	}
+	if err != nil {
+		p.mutex.Lock()
+		if !p.closed {
+			p.err = err
+		}
+		p.mutex.Unlock()
+		return
+	}
+	if len(p.levels) == 1 {
+		// The image is the preview, drawn as a whole
+		img = toNRGBA(img)
+	}
+	if p.set(0, img) {
+		p.build()
+	}
+}
+
+func (p *Pyramid) load(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(bufio.NewReader(reader{f, p}))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return img, nil
+}
+
+// decodePreview averages a first preview from the block averages of a JPEG
+// file, unless the full decoding got there first.
+func (p *Pyramid) decodePreview(path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	averages, err := jpegpreview.Decode(reader{f, p})
+	// The full decoding tells why a file can not be read, and decodes the variants jpegpreview does not
+	if err != nil || averages.Rect.Size() != p.levelSize(JPEG_PREVIEW_LEVEL) {
+		return
+	}
+	preview := averages
+	last := len(p.levels) - 1
+	for level := JPEG_PREVIEW_LEVEL; level < last; level++ {
+		if preview = p.reduce(preview); preview == nil {
+			return
+		}
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if !p.closed && !p.ready[last] {
+		p.levels[last] = preview
+	}
+}
+
+// half returns the size of the level following a level of a size.
+func half(size image.Point) image.Point {
+	return image.Pt((size.X+1)/2, (size.Y+1)/2)
+}
+
+// levelSize returns the size of a level.
+func (p *Pyramid) levelSize(level int) image.Point {
+	size := p.size
+	for i := 0; i < level; i++ {
+		size = half(size)
+	}
+	return size
+}
+
+// set stores a level, unless the pyramid is closed. It tells whether it did.
+func (p *Pyramid) set(level int, img image.Image) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.closed {
+		return false
+	}
+	p.levels[level], p.ready[level] = img, true
+	return true
+}
+
+// build averages the levels, each one from the previous, the preview being averaged again last.
+func (p *Pyramid) build() {
+	for level := 1; level < len(p.levels); level++ {
+		p.mutex.Lock()
+		previous, closed := p.levels[level-1], p.closed
+		p.mutex.Unlock()
+		if closed {
+			return
+		}
+		averaged := p.reduce(previous)
+		if averaged == nil || !p.set(level, averaged) {
+			return
+		}
+	}
+}
+
+func (p *Pyramid) isClosed() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.closed
+}
+
+// reduce averages each 2x2 block of pixels, on all processors. It returns nil when the pyramid is closed meanwhile.
+func (p *Pyramid) reduce(src image.Image) *image.NRGBA {
+	b := src.Bounds()
+	size := half(b.Size())
+	dst := image.NewNRGBA(image.Rect(0, 0, size.X, size.Y))
+	rows := make(chan int, size.Y)
+	for y := 0; y < size.Y; y++ {
+		rows <- y
+	}
+	close(rows)
+
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.NumCPU(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Other images are converted two rows at a time
+			var strip *image.NRGBA
+			for y := range rows {
+				if y%64 == 0 && p.isClosed() {
+					return
+				}
+				y0 := b.Min.Y + 2*y
+				y1 := min(y0+1, b.Max.Y-1)
+				pixels, ok := src.(*image.NRGBA)
+				if !ok {
+					if strip == nil {
+						strip = image.NewNRGBA(image.Rect(b.Min.X, 0, b.Max.X, 2))
+					}
+					strip.Rect = image.Rect(b.Min.X, y0, b.Max.X, y0+2)
+					convert(strip, src)
+					pixels = strip
+				}
+				reduceRow(dst, pixels, y, y0, y1)
+			}
+		}()
+	}
+	wg.Wait()
+	if p.isClosed() {
+		return nil
+	}
+	return dst
+}
+
+// reduceRow computes a row of dst from two rows of src, the last column of an
+// odd sized src being repeated.
+func reduceRow(dst *image.NRGBA, src *image.NRGBA, y int, y0 int, y1 int) {
+	b := src.Rect
+	row := dst.Pix[y*dst.Stride:]
+	for x := 0; x < dst.Rect.Dx(); x++ {
+		x0 := b.Min.X + 2*x
+		x1 := x0 + 1
+		if x1 >= b.Max.X {
+			x1 = x0
+		}
+		i00, i10, i01, i11 := src.PixOffset(x0, y0), src.PixOffset(x1, y0), src.PixOffset(x0, y1), src.PixOffset(x1, y1)
+		for c := 0; c < 4; c++ {
+			sum := int(src.Pix[i00+c]) + int(src.Pix[i10+c]) + int(src.Pix[i01+c]) + int(src.Pix[i11+c])
+			row[4*x+c] = uint8((sum + 2) / 4)
+		}
+	}
+}
+
+// convert copies the pixels of src in the bounds of dst, fast for the image types the decoders return.
+func convert(dst *image.NRGBA, src image.Image) {
+	r := dst.Rect.Intersect(src.Bounds())
+	switch s := src.(type) {
+	case *image.YCbCr, *image.Gray, *image.CMYK:
+		// Opaque, they are converted fast to RGBA, the same bytes as NRGBA for opaque pixels
+		draw.Draw(&image.RGBA{Pix: dst.Pix, Stride: dst.Stride, Rect: dst.Rect}, r, src, r.Min, draw.Src)
+	case *image.NRGBA:
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			copy(dst.Pix[dst.PixOffset(r.Min.X, y):dst.PixOffset(r.Max.X, y)], s.Pix[s.PixOffset(r.Min.X, y):])
+		}
+	case *image.RGBA:
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			i, j := s.PixOffset(r.Min.X, y), dst.PixOffset(r.Min.X, y)
+			for x := r.Min.X; x < r.Max.X; x, i, j = x+1, i+4, j+4 {
+				a := uint(s.Pix[i+3])
+				switch a {
+				case 255:
+					copy(dst.Pix[j:j+4], s.Pix[i:i+4])
+				case 0:
+					copy(dst.Pix[j:j+4], []uint8{0, 0, 0, 0})
+				default:
+					for c := 0; c < 3; c++ {
+						dst.Pix[j+c] = uint8((uint(s.Pix[i+c])*255 + a/2) / a)
+					}
+					dst.Pix[j+3] = uint8(a)
+				}
+			}
+		}
+	default:
+		draw.Draw(dst, r, src, r.Min, draw.Src)
+	}
+}
+
+// toNRGBA returns the pixels of an image as NRGBA.
+func toNRGBA(img image.Image) *image.NRGBA {
+	if pixels, ok := img.(*image.NRGBA); ok {
+		return pixels
+	}
+	pixels := image.NewNRGBA(img.Bounds())
+	convert(pixels, img)
+	return pixels
+}
+
+// sample returns a level of an image picking one pixel out of each block, fast enough for a first display.
+func sample(img *image.NRGBA, level int) *image.NRGBA {
+	size := img.Rect.Size()
+	for i := 0; i < level; i++ {
+		size = half(size)
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, size.X, size.Y))
+	step := 1 << level
+	for y := 0; y < size.Y; y++ {
+		for x := 0; x < size.X; x++ {
+			// Take the pixel nearest to the block center
+			sx, sy := min(x*step+step/2, img.Rect.Dx()-1), min(y*step+step/2, img.Rect.Dy()-1)
+			i := img.PixOffset(img.Rect.Min.X+sx, img.Rect.Min.Y+sy)
+			copy(dst.Pix[dst.PixOffset(x, y):dst.PixOffset(x, y)+4], img.Pix[i:i+4])
+		}
+	}
+	return dst
+}
+
+// Close stops decoding the image and building the levels.
+func (p *Pyramid) Close() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.closed = true
+}
+
+// Wait waits until every level is built, the decoding failed or the pyramid is closed.
+func (p *Pyramid) Wait() {
+	<-p.done
+}
+
+// Err returns why the image could not be decoded, nil while it is or once it is.
+func (p *Pyramid) Err() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.err
+}
+
+// Size returns the image size.
+func (p *Pyramid) Size() image.Point {
+	return p.size
+}
+
+// Levels returns the number of levels, the last one being the preview.
+func (p *Pyramid) Levels() int {
+	return len(p.levels)
+}
+
+// Preview returns the coarsest level, nil until there is one, and whether it
+// is averaged from the image rather than a first preview.
+func (p *Pyramid) Preview() (*image.NRGBA, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	last := len(p.levels) - 1
+	preview, _ := p.levels[last].(*image.NRGBA)
+	return preview, p.ready[last]
+}
+
+// Ready tells whether a level is built.
+func (p *Pyramid) Ready(level int) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return level >= 0 && level < len(p.levels) && p.ready[level]
+}
+
+// Pixels returns the pixels of a tile, false when its level is not built yet.
+// They must not be modified.
+func (p *Pyramid) Pixels(t Tile) (*image.NRGBA, bool) {
+	p.mutex.Lock()
+	img, ready := p.levels[t.Level], p.ready[t.Level]
+	p.mutex.Unlock()
+	if !ready {
+		return nil, false
+	}
+	if pixels, ok := img.(*image.NRGBA); ok {
+		return pixels.SubImage(t.Bounds).(*image.NRGBA), true
+	}
+	pixels := image.NewNRGBA(t.Bounds)
+	convert(pixels, img)
+	return pixels, true
+}
+
+// At returns the color of an image pixel from the finest level built, false when none is.
+func (p *Pyramid) At(x int, y int) (color.NRGBA, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for level, img := range p.levels {
+		if p.ready[level] {
+			return color.NRGBAModel.Convert(img.At(x>>uint(level), y>>uint(level))).(color.NRGBA), true
+		}
+	}
+	return color.NRGBA{}, false
+}
+
+// Image returns the image as decoded, false until it is.
+func (p *Pyramid) Image() (image.Image, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.levels[0], p.ready[0]
+}
+
+// LevelFor returns the level to display the image at a scale, in displayed
+// pixels per image pixel: the coarsest one keeping at least one level pixel
+// per displayed pixel.
+func (p *Pyramid) LevelFor(scale float64) int {
+	last := len(p.levels) - 1
+	if scale <= 0 {
+		return last
+	}
+	level := int(math.Floor(math.Log2(1 / scale)))
+	if level < 0 {
+		return 0
+	}
+	if level > last {
+		return last
+	}
+	return level
+}
+
+// Tiles returns the tiles of a level covering an area of the image, given in level 0 pixels.
+func (p *Pyramid) Tiles(level int, area image.Rectangle) (tiles []Tile) {
+	area = area.Intersect(image.Rectangle{Max: p.size})
+	if area.Empty() || level < 0 || level >= len(p.levels) {
+		return nil
+	}
+	size := p.levelSize(level)
+	scale := 1 << level
+	span := p.tileSize * scale // Tile side in level 0 pixels
+	for row := area.Min.Y / span; row*span < area.Max.Y; row++ {
+		for column := area.Min.X / span; column*span < area.Max.X; column++ {
+			bounds := image.Rect(column*p.tileSize, row*p.tileSize, (column+1)*p.tileSize, (row+1)*p.tileSize).Intersect(image.Rectangle{Max: size})
+			source := image.Rectangle{Min: bounds.Min.Mul(scale), Max: bounds.Max.Mul(scale)}.Intersect(image.Rectangle{Max: p.size})
+			tiles = append(tiles, Tile{Level: level, Index: image.Pt(column, row), Bounds: bounds, Source: source})
+		}
+	}
+	return tiles
+}
@@ -0,0 +1,193 @@
+package pyramid
+
+import (
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nicky-ayoub/imagination/internal/pkg/imageio"
+)
+
+func gradient(width int, height int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(255 * x / width), uint8(255 * y / height), uint8(255 * (x + y) / (width + height)), 255})
+		}
+	}
+	return img
+}
+
+// near tells whether two colors differ by at most a tolerance on each component.
+func near(a color.NRGBA, b color.NRGBA, tolerance int) bool {
+	for _, d := range []int{int(a.R) - int(b.R), int(a.G) - int(b.G), int(a.B) - int(b.B), int(a.A) - int(b.A)} {
+		if d < -tolerance || d > tolerance {
+			return false
+		}
+	}
+	return true
+}
+
+// checkLevels checks every level is built, each one averaging the previous.
+func checkLevels(t *testing.T, p *Pyramid, levels int, tolerance int) {
+	t.Helper()
+	p.Wait()
+	if err := p.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if p.Levels() != levels {
+		t.Fatalf("%d levels, want %d", p.Levels(), levels)
+	}
+	previous := image.Image(nil)
+	for level := 0; level < levels; level++ {
+		if !p.Ready(level) {
+			t.Fatalf("level %d not built", level)
+		}
+		tiles := p.Tiles(level, image.Rectangle{Max: p.Size()})
+		size := p.levelSize(level)
+		pixels := image.NewNRGBA(image.Rectangle{Max: size})
+		for _, tile := range tiles {
+			tilePixels, ok := p.Pixels(tile)
+			if !ok {
+				t.Fatalf("tile %v of level %d not built", tile.Index, level)
+			}
+			if tilePixels.Rect != tile.Bounds {
+				t.Fatalf("tile %v of level %d: bounds %v, want %v", tile.Index, level, tilePixels.Rect, tile.Bounds)
+			}
+			convert(pixels.SubImage(tile.Bounds).(*image.NRGBA), tilePixels)
+		}
+		if previous != nil {
+			want := toNRGBA(previous)
+			for y := 0; y < size.Y; y++ {
+				for x := 0; x < size.X; x++ {
+					w := want.NRGBAAt(min(2*x+1, want.Rect.Dx()-1), min(2*y+1, want.Rect.Dy()-1))
+					if got := pixels.NRGBAAt(x, y); !near(got, w, tolerance) {
+						t.Fatalf("level %d pixel %d,%d: %v, not near %v", level, x, y, got, w)
+					}
+				}
+			}
+		}
+		previous = pixels
+	}
+	preview, final := p.Preview()
+	if !final || preview.Rect.Size() != p.levelSize(levels-1) {
+		t.Errorf("preview %v, complete %v, want %v", preview.Rect, final, p.levelSize(levels-1))
+	}
+}
+
+func TestNew(t *testing.T) {
+	img := gradient(1000, 600)
+	p := New(img, 128, 100)
+	defer p.Close()
+	// 1000 500 250 125 63
+	checkLevels(t, p, 5, 4)
+	if decoded, ok := p.Image(); !ok || decoded != image.Image(img) {
+		t.Error("level 0 is not the image")
+	}
+}
+
+func TestOpen(t *testing.T) {
+	directory := t.TempDir()
+	img := gradient(1000, 600)
+	for _, name := range []string{"gradient.jpg", "gradient.png"} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(directory, name)
+			if err := imageio.Save(path, img, 95); err != nil {
+				t.Fatal(err)
+			}
+			p, err := Open(path, 128, 100)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer p.Close()
+			if p.Size() != img.Rect.Size() {
+				t.Fatalf("size %v, want %v", p.Size(), img.Rect.Size())
+			}
+			checkLevels(t, p, 5, 4)
+			if c, ok := p.At(500, 300); !ok || !near(c, img.NRGBAAt(500, 300), 4) {
+				t.Errorf("pixel 500,300: %v, not near %v", c, img.NRGBAAt(500, 300))
+			}
+		})
+	}
+}
+
+// TestJPEGPreview checks the first preview of a JPEG file, averaged from its block averages.
+func TestJPEGPreview(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gradient.jpg")
+	img := gradient(1000, 600)
+	if err := imageio.Save(path, img, 95); err != nil {
+		t.Fatal(err)
+	}
+	// Without the full decoding
+	p := newPyramid(img.Rect.Size(), 128, 100)
+	p.decodePreview(path)
+	preview, final := p.Preview()
+	if preview == nil || final {
+		t.Fatalf("preview %v, averaged from the image %v, want a first preview", preview != nil, final)
+	}
+	if size := p.levelSize(p.Levels() - 1); preview.Rect.Size() != size {
+		t.Fatalf("preview size %v, want %v", preview.Rect.Size(), size)
+	}
+	reference := New(img, 128, 100)
+	reference.Wait()
+	want, _ := reference.Preview()
+	for y := 0; y < preview.Rect.Dy(); y++ {
+		for x := 0; x < preview.Rect.Dx(); x++ {
+			if !near(preview.NRGBAAt(x, y), want.NRGBAAt(x, y), 8) {
+				t.Fatalf("preview pixel %d,%d: %v, not near %v", x, y, preview.NRGBAAt(x, y), want.NRGBAAt(x, y))
+			}
+		}
+	}
+	if p.Ready(p.Levels()-1) || p.Ready(JPEG_PREVIEW_LEVEL) {
+		t.Error("levels built from the block averages")
+	}
+}
+
+func TestOpenErrors(t *testing.T) {
+	directory := t.TempDir()
+	if _, err := Open(filepath.Join(directory, "missing.png"), 128, 100); err == nil {
+		t.Error("no error opening a missing file")
+	}
+
+	// The header is fine, the decoding fails later
+	path := filepath.Join(directory, "truncated.png")
+	if err := imageio.Save(path, gradient(300, 200), 0); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(path, data[:len(data)/2], 0644); err != nil {
+		t.Fatal(err)
+	}
+	p, err := Open(path, 128, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Wait()
+	if p.Err() == nil {
+		t.Error("no error decoding a truncated file")
+	}
+	if p.Ready(0) {
+		t.Error("level 0 of a truncated file built")
+	}
+}
+
+func TestClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gradient.jpg")
+	if err := imageio.Save(path, gradient(2000, 1500), 0); err != nil {
+		t.Fatal(err)
+	}
+	p, err := Open(path, 128, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Close()
+	p.Wait()
+	if err = p.Err(); err != nil {
+		t.Errorf("closing reported %v", err)
+	}
+}
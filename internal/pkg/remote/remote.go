@@ -0,0 +1,398 @@
+// Package remote lets the viewers be driven without a keyboard, by HTTP
+// requests on a local TCP address or Unix socket:
+//
+//	POST /command   runs a command given as JSON, like {"command": "next"},
+//	                and answers the state once it has run
+//	GET  /state     the current state
+//	GET  /events    the state each time it changes, as server-sent events
+//
+// The commands are:
+//
+//	next, prev, first, last
+//	goto     {"index": 3} (from 0) or {"path": "holidays/beach.jpg"}
+//	pause    {"paused": true}, toggles when paused is left out
+//	zoom     {"zoom": "in"}, "out" or "fit"
+//	load     {"path": "lobby.m3u"}, shows a playlist from its first image
+//	action   {"action": "fullscreen"}, any action of the keymap package
+//
+// Events are server-sent rather than sent over a WebSocket: browsers read
+// them with EventSource, curl -N prints them, and they need nothing more
+// than net/http.
+//
+// The server never touches the viewer: commands wait on a channel that the
+// viewer drains from its event loop, so they run on the thread owning the
+// window, and the viewer publishes its state after each frame.
+package remote
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nicky-ayoub/imagination/internal/pkg/keymap"
+)
+
+// Commands
+const (
+	COMMAND_NEXT   = "next"
+	COMMAND_PREV   = "prev"
+	COMMAND_FIRST  = "first"
+	COMMAND_LAST   = "last"
+	COMMAND_GOTO   = "goto"
+	COMMAND_PAUSE  = "pause"
+	COMMAND_ZOOM   = "zoom"
+	COMMAND_LOAD   = "load"
+	COMMAND_ACTION = "action"
+)
+
+// Zoom changes
+const (
+	ZOOM_IN  = "in"
+	ZOOM_OUT = "out"
+	ZOOM_FIT = "fit"
+)
+
+/** How long a request waits for the viewer to run its command. */
+const COMMAND_TIMEOUT = 5 * time.Second
+
+/** Commands waiting for the viewer beyond this number are refused. */
+const QUEUE_SIZE = 16
+
+/** Largest command body, in bytes. */
+const MAX_BODY = 64 << 10
+
+/** Interval of the comments keeping idle event streams open through proxies. */
+const KEEP_ALIVE = 30 * time.Second
+
+// ErrUnsupported is returned by viewers for the commands they have no use for.
+var ErrUnsupported = errors.New("not supported by this viewer")
+
+// Command is a request to the viewer.
+type Command struct {
+	Name   string `json:"command"`
+	Index  *int   `json:"index,omitempty"`  // goto: image index, from 0
+	Path   string `json:"path,omitempty"`   // goto: image file; load: playlist file
+	Paused *bool  `json:"paused,omitempty"` // pause: state wanted, toggled when nil
+	Zoom   string `json:"zoom,omitempty"`   // zoom: in, out or fit
+	Action string `json:"action,omitempty"` // action: keymap action name
+
+	server *Server
+	err    error
+	done   chan error
+}
+
+// Validate checks that the command is known and has the parameters it needs.
+func (c *Command) Validate() error {
+	switch c.Name {
+	case COMMAND_NEXT, COMMAND_PREV, COMMAND_FIRST, COMMAND_LAST, COMMAND_PAUSE:
+	case COMMAND_GOTO:
+		if (c.Index == nil) == (c.Path == "") {
+			return errors.New("goto: give an index or a path")
+		}
+		if c.Index != nil && *c.Index < 0 {
+			return fmt.Errorf("goto: negative index %d", *c.Index)
+		}
+	case COMMAND_ZOOM:
+		switch c.Zoom {
+		case ZOOM_IN, ZOOM_OUT, ZOOM_FIT:
+		default:
+			return fmt.Errorf("zoom: unknown change %q (want in, out or fit)", c.Zoom)
+		}
+	case COMMAND_LOAD:
+		if c.Path == "" {
+			return errors.New("load: the playlist path is missing")
+		}
+	case COMMAND_ACTION:
+		if c.Action == "" {
+			return errors.New("action: the action name is missing")
+		}
+	case "":
+		return errors.New("the command is missing")
+	default:
+		return fmt.Errorf("unknown command %q", c.Name)
+	}
+	return nil
+}
+
+// Done reports that the viewer ran the command, or why it could not. The
+// request is answered with the state the viewer publishes next.
+func (c *Command) Done(err error) {
+	if c.server == nil {
+		return
+	}
+	c.err = err
+	c.server.mutex.Lock()
+	c.server.ran = append(c.server.ran, c)
+	c.server.mutex.Unlock()
+}
+
+// KeymapAction returns the keymap action doing what the command asks, empty
+// for the commands viewers run themselves: goto, pause, load and action.
+func (c *Command) KeymapAction() string {
+	switch c.Name {
+	case COMMAND_NEXT:
+		return keymap.ACTION_NEXT
+	case COMMAND_PREV:
+		return keymap.ACTION_PREV
+	case COMMAND_FIRST:
+		return keymap.ACTION_FIRST
+	case COMMAND_LAST:
+		return keymap.ACTION_LAST
+	case COMMAND_ZOOM:
+		switch c.Zoom {
+		case ZOOM_IN:
+			return keymap.ACTION_ZOOM_IN
+		case ZOOM_OUT:
+			return keymap.ACTION_ZOOM_OUT
+		}
+		return keymap.ACTION_FIT
+	}
+	return ""
+}
+
+// Target returns the index of the image a goto command asks for among paths.
+// A path matches an image file, compared once both are cleaned and absolute.
+func (c *Command) Target(paths []string) (int, error) {
+	if c.Index != nil {
+		if *c.Index >= len(paths) {
+			return 0, fmt.Errorf("goto: index %d out of range 0-%d", *c.Index, len(paths)-1)
+		}
+		return *c.Index, nil
+	}
+	want := absolute(c.Path)
+	for i, p := range paths {
+		if absolute(p) == want {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("goto: %s is not shown", c.Path)
+}
+
+func absolute(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
+
+// State is what the viewer shows.
+type State struct {
+	Image    string  `json:"image"`              // File of the displayed image
+	Title    string  `json:"title,omitempty"`    // Playlist caption, or the file
+	Index    int     `json:"index"`              // From 0
+	Count    int     `json:"count"`              // Number of images
+	Playlist string  `json:"playlist,omitempty"` // Playlist file, empty for a directory
+	Paused   bool    `json:"paused"`
+	Zoom     float64 `json:"zoom"` // 1 when the image fits the window
+}
+
+// Server is a remote control endpoint.
+type Server struct {
+	listener net.Listener
+	server   *http.Server
+	socket   string // Unix socket file, empty for TCP
+	commands chan *Command
+
+	mutex    sync.Mutex
+	state    State
+	watchers map[chan State]struct{}
+	ran      []*Command // Commands run since the last Publish
+}
+
+// Listen starts a server on a TCP address, like localhost:7070, or on a Unix
+// socket when the address is a path, like /run/imagination.sock or ./viewer.sock.
+// A socket file left by a previous run is replaced.
+func Listen(address string) (*Server, error) {
+	s := &Server{
+		commands: make(chan *Command, QUEUE_SIZE),
+		watchers: map[chan State]struct{}{},
+	}
+	network := "tcp"
+	if strings.ContainsRune(address, os.PathSeparator) || strings.ContainsRune(address, '/') {
+		network, s.socket = "unix", address
+		if info, err := os.Lstat(address); err == nil && info.Mode()&os.ModeSocket != 0 {
+			os.Remove(address)
+		}
+	}
+	var err error
+	if s.listener, err = net.Listen(network, address); err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/command", s.command)
+	mux.HandleFunc("/state", s.current)
+	mux.HandleFunc("/events", s.events)
+	s.server = &http.Server{Handler: mux}
+	go s.server.Serve(s.listener)
+	return s, nil
+}
+
+// Addr returns the address the server listens on.
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Commands returns the commands waiting to be run. The viewer must call Done
+// on each, then Publish its state.
+func (s *Server) Commands() <-chan *Command {
+	return s.commands
+}
+
+// Publish sets the state, sending it to the event streams when it changed,
+// and answers the commands run since the previous call.
+func (s *Server) Publish(state State) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if state != s.state {
+		s.state = state
+		for watcher := range s.watchers {
+			// Only the latest state matters to a slow reader
+			select {
+			case <-watcher:
+			default:
+			}
+			watcher <- state
+		}
+	}
+	for _, c := range s.ran {
+		c.done <- c.err
+	}
+	s.ran = nil
+}
+
+// State returns the last published state.
+func (s *Server) State() State {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.state
+}
+
+// Close stops the server, ending the event streams.
+func (s *Server) Close() error {
+	err := s.server.Close()
+	if s.socket != "" {
+		os.Remove(s.socket)
+	}
+	return err
+}
+
+// command queues a command for the viewer and answers the state once it has run.
+func (s *Server) command(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeError(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+		return
+	}
+	c := &Command{}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, MAX_BODY)).Decode(c); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid command: "+err.Error())
+		return
+	}
+	if err := c.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	c.server, c.done = s, make(chan error, 1)
+	select {
+	case s.commands <- c:
+	default:
+		writeError(w, http.StatusServiceUnavailable, "too many commands waiting for the viewer")
+		return
+	}
+
+	timer := time.NewTimer(COMMAND_TIMEOUT)
+	defer timer.Stop()
+	select {
+	case err := <-c.done:
+		switch {
+		case errors.Is(err, ErrUnsupported):
+			writeError(w, http.StatusNotImplemented, err.Error())
+		case err != nil:
+			writeError(w, http.StatusUnprocessableEntity, err.Error())
+		default:
+			writeJSON(w, http.StatusOK, s.State())
+		}
+	case <-timer.C:
+		// The viewer still runs the command when it gets to it
+		writeError(w, http.StatusGatewayTimeout, "the viewer did not run the command in time")
+	case <-r.Context().Done():
+	}
+}
+
+// current answers the state.
+func (s *Server) current(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeError(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+		return
+	}
+	writeJSON(w, http.StatusOK, s.State())
+}
+
+// events streams the state, starting with the current one, until the client goes away.
+func (s *Server) events(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeError(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+	watcher := make(chan State, 1)
+	s.mutex.Lock()
+	s.watchers[watcher] = struct{}{}
+	watcher <- s.state
+	s.mutex.Unlock()
+	defer func() {
+		s.mutex.Lock()
+		delete(s.watchers, watcher)
+		s.mutex.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	ticker := time.NewTicker(KEEP_ALIVE)
+	defer ticker.Stop()
+	for {
+		select {
+		case state := <-watcher:
+			data, err := json.Marshal(state)
+			if err != nil {
+				return
+			}
+			if _, err = fmt.Fprintf(w, "event: state\ndata: %s\n\n", data); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,194 @@
+package rendition
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+/** Size of the rendition cache when none is given, in bytes. */
+const DEFAULT_CACHE_SIZE = 512 << 20
+
+// DefaultDir returns the rendition directory in the cache directory of the
+// user, or in the temporary directory when the user has none.
+func DefaultDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "imagination", "renditions")
+}
+
+// Cache keeps renditions in a directory, removing the least recently used
+// ones when their total size goes over a capacity. It is safe for concurrent use.
+type Cache struct {
+	dir      string
+	capacity int64
+	mutex    sync.Mutex
+	size     int64                    // Total size of the files
+	lru      *list.List               // Of *cached, the most recently used first
+	entries  map[string]*list.Element // By file name
+}
+
+// cached is a file of the cache.
+type cached struct {
+	name string
+	size int64
+}
+
+// NewCache opens a cache directory, in DefaultDir when dir is empty, holding
+// at most capacity bytes, DEFAULT_CACHE_SIZE when not positive. The files
+// already there are kept, their modification date telling when they were last used.
+func NewCache(dir string, capacity int64) (*Cache, error) {
+	if dir == "" {
+		dir = DefaultDir()
+	}
+	if capacity <= 0 {
+		capacity = DEFAULT_CACHE_SIZE
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	c := &Cache{dir: dir, capacity: capacity, lru: list.New(), entries: map[string]*list.Element{}}
+
+	type file struct {
+		cached
+		used time.Time
+	}
+	var files []file
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || filepath.Ext(p) != ".bin" {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			files = append(files, file{cached{d.Name(), info.Size()}, info.ModTime()})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].used.After(files[j].used) })
+	for _, f := range files {
+		e := f.cached
+		c.entries[e.name] = c.lru.PushBack(&e)
+		c.size += e.size
+	}
+	c.mutex.Lock()
+	c.evict()
+	c.mutex.Unlock()
+	return c, nil
+}
+
+// name returns the file name of a key.
+func name(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:]) + ".bin"
+}
+
+// path returns the file of a file name, in a subdirectory named after its first characters.
+func (c *Cache) path(name string) string {
+	return filepath.Join(c.dir, name[:2], name)
+}
+
+// Get returns the data kept for a key, marking it as the most recently used.
+func (c *Cache) Get(key string) ([]byte, bool) {
+	n := name(key)
+	c.mutex.Lock()
+	element := c.entries[n]
+	if element != nil {
+		c.lru.MoveToFront(element)
+	}
+	c.mutex.Unlock()
+	if element == nil {
+		return nil, false
+	}
+	data, err := os.ReadFile(c.path(n))
+	if err != nil {
+		c.remove(n)
+		return nil, false
+	}
+	now := time.Now()
+	os.Chtimes(c.path(n), now, now) // Remembered as used when the cache is opened again
+	return data, true
+}
+
+// Put keeps data for a key, removing the least recently used data when the
+// cache gets too large. Data larger than the capacity is not kept.
+func (c *Cache) Put(key string, data []byte) error {
+	if int64(len(data)) > c.capacity {
+		return nil
+	}
+	n := name(key)
+	file := c.path(n)
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	// Write then rename, so concurrent readers never see a partial file
+	tmp, err := os.CreateTemp(filepath.Dir(file), ".rendition.*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), file); err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if element := c.entries[n]; element != nil {
+		e := element.Value.(*cached)
+		c.size += int64(len(data)) - e.size
+		e.size = int64(len(data))
+		c.lru.MoveToFront(element)
+	} else {
+		c.entries[n] = c.lru.PushFront(&cached{n, int64(len(data))})
+		c.size += int64(len(data))
+	}
+	c.evict()
+	return nil
+}
+
+// Size returns the total size of the cached data.
+func (c *Cache) Size() int64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.size
+}
+
+// evict removes the least recently used files until the cache fits its capacity. c.mutex must be held.
+func (c *Cache) evict() {
+	for c.size > c.capacity {
+		element := c.lru.Back()
+		e := element.Value.(*cached)
+		c.lru.Remove(element)
+		delete(c.entries, e.name)
+		c.size -= e.size
+		os.Remove(c.path(e.name))
+	}
+}
+
+// remove forgets a file that could not be read.
+func (c *Cache) remove(name string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if element := c.entries[name]; element != nil {
+		c.lru.Remove(element)
+		delete(c.entries, name)
+		c.size -= element.Value.(*cached).size
+	}
+	os.Remove(c.path(name))
+}
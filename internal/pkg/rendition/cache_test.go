@@ -0,0 +1,61 @@
+package rendition
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	dir := t.TempDir()
+	c, err := NewCache(dir, 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := func(b byte) []byte { return bytes.Repeat([]byte{b}, 100) }
+	for _, key := range []string{"a", "b", "c"} {
+		if err = c.Put(key, data(key[0])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, ok := c.Get("a"); !ok || !bytes.Equal(got, data('a')) {
+		t.Fatalf("a: %v", ok)
+	}
+	// b is now the least recently used
+	if err = c.Put("d", data('d')); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Get("b"); ok {
+		t.Error("b kept, the least recently used")
+	}
+	for _, key := range []string{"a", "c", "d"} {
+		if _, ok := c.Get(key); !ok {
+			t.Errorf("%s evicted", key)
+		}
+	}
+	if c.Size() != 300 {
+		t.Errorf("size %d, want 300", c.Size())
+	}
+	if err = c.Put("large", make([]byte, 301)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Get("large"); ok || c.Size() != 300 {
+		t.Errorf("data larger than the capacity kept, size %d", c.Size())
+	}
+
+	// Opened again, the use order comes from the modification dates
+	old := time.Now().Add(-time.Hour)
+	if err = os.Chtimes(c.path(name("c")), old, old); err != nil {
+		t.Fatal(err)
+	}
+	if c, err = NewCache(dir, 200); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Get("c"); ok {
+		t.Error("c kept when opening again, the least recently used")
+	}
+	if c.Size() != 200 {
+		t.Errorf("size %d, want 200", c.Size())
+	}
+}
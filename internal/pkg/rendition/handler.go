@@ -0,0 +1,115 @@
+package rendition
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"golang.org/x/sync/singleflight"
+
+	"github.com/nicky-ayoub/imagination/internal/pkg/imagefs"
+)
+
+// Handler answers rendition requests, the URL path being the image path
+// relative to the root: mounted under /img/ with http.StripPrefix, it serves
+// /img/holidays/beach.jpg?w=800&h=600&fit=cover&fmt=png.
+type Handler struct {
+	root   string
+	exts   []string
+	cache  *Cache
+	limits Limits
+	group  singleflight.Group // Renders identical requests arriving together once
+	work   chan struct{}      // Limits the renditions made at the same time
+}
+
+// NewHandler returns the handler of the renditions of the images of root
+// having one of the extensions, kept in cache.
+func NewHandler(root string, exts []string, cache *Cache, limits Limits) *Handler {
+	return &Handler{
+		root:   root,
+		exts:   exts,
+		cache:  cache,
+		limits: limits,
+		work:   make(chan struct{}, runtime.NumCPU()),
+	}
+}
+
+// ServeHTTP writes a rendition. The X-Cache response header tells whether it was cached.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	file, ok := h.resolve(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	info, err := os.Stat(file)
+	if err != nil || !info.Mode().IsRegular() {
+		http.NotFound(w, r)
+		return
+	}
+	p, err := ParseParams(r.URL.Query(), h.limits)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	p = p.For(file)
+
+	key := Key(file, info, p)
+	sum := sha256.Sum256([]byte(key))
+	etag := `"` + hex.EncodeToString(sum[:12]) + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if strings.Contains(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", p.ContentType())
+
+	data, cached := h.cache.Get(key)
+	if cached {
+		w.Header().Set("X-Cache", "HIT")
+	} else {
+		w.Header().Set("X-Cache", "MISS")
+		v, err, _ := h.group.Do(key, func() (interface{}, error) {
+			h.work <- struct{}{}
+			defer func() { <-h.work }()
+			data, err := Render(file, p, h.limits)
+			if err != nil {
+				return nil, err
+			}
+			h.cache.Put(key, data) // Served even when it can not be kept
+			return data, nil
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		data = v.([]byte)
+	}
+	http.ServeContent(w, r, "", info.ModTime(), bytes.NewReader(data))
+}
+
+// resolve returns the image file of a slash separated path relative to the
+// root. The path can not go above the root, and hidden names are refused.
+func (h *Handler) resolve(rel string) (string, bool) {
+	rel = path.Clean("/" + rel)
+	for _, name := range strings.Split(rel, "/") {
+		if strings.HasPrefix(name, ".") {
+			return "", false
+		}
+	}
+	if !imagefs.MatchExt(rel, h.exts) {
+		return "", false
+	}
+	return filepath.Join(h.root, filepath.FromSlash(rel)), true
+}
@@ -0,0 +1,182 @@
+package rendition
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/nicky-ayoub/imagination/internal/pkg/imageio"
+)
+
+// newHandler returns a handler of a root holding a.jpg (40x30), sub/b.png
+// (80x60), a hidden .private/c.jpg, a text file and a broken JPEG file.
+func newHandler(t *testing.T, limits Limits) (*Handler, *Cache) {
+	t.Helper()
+	parent := t.TempDir()
+	root := filepath.Join(parent, "root")
+	for _, f := range []struct {
+		name          string
+		width, height int
+	}{
+		{"a.jpg", 40, 30},
+		{"sub/b.png", 80, 60},
+		{".private/c.jpg", 10, 10},
+		{"../outside.jpg", 10, 10},
+	} {
+		path := filepath.Join(root, filepath.FromSlash(f.name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		img := image.NewNRGBA(image.Rect(0, 0, f.width, f.height))
+		for i := range img.Pix {
+			img.Pix[i] = 200
+		}
+		img.SetNRGBA(0, 0, color.NRGBA{255, 0, 0, 255})
+		if err := imageio.Save(path, img, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, data := range map[string]string{"notes.txt": "notes", "broken.jpg": "\xff\xd8 not a JPEG"} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cache, err := NewCache(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewHandler(root, []string{".jpg", ".png"}, cache, limits), cache
+}
+
+// get makes a request and checks its status.
+func get(t *testing.T, h *Handler, method string, target string, header http.Header, status int) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(method, target, nil)
+	for name, values := range header {
+		r.Header[name] = values
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != status {
+		t.Fatalf("%s %s: status %d, want %d: %s", method, target, w.Code, status, w.Body)
+	}
+	return w
+}
+
+// decode checks the type and the size of a rendition.
+func decode(t *testing.T, w *httptest.ResponseRecorder, format string, size image.Point) {
+	t.Helper()
+	if got := w.Header().Get("Content-Type"); got != "image/"+format {
+		t.Errorf("content type %q, want image/%s", got, format)
+	}
+	config, got, err := image.DecodeConfig(bytes.NewReader(w.Body.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != format || config.Width != size.X || config.Height != size.Y {
+		t.Errorf("%s %dx%d, want %s %v", got, config.Width, config.Height, format, size)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	h, _ := newHandler(t, DefaultLimits())
+	for _, test := range []struct {
+		target string
+		format string
+		size   image.Point
+	}{
+		{"/a.jpg?w=20", "jpeg", image.Pt(20, 15)},
+		{"/a.jpg?h=15&fmt=png", "png", image.Pt(20, 15)},
+		{"/a.jpg?w=100", "jpeg", image.Pt(40, 30)},
+		{"/sub/b.png?w=10&h=10&fit=cover", "png", image.Pt(10, 10)},
+		{"/sub/b.png?w=10&h=30&fit=fill&fmt=jpeg", "jpeg", image.Pt(10, 30)},
+		{"/sub/../a.jpg?w=20", "jpeg", image.Pt(20, 15)},
+	} {
+		decode(t, get(t, h, http.MethodGet, test.target, nil, http.StatusOK), test.format, test.size)
+	}
+}
+
+func TestHandlerCache(t *testing.T) {
+	h, cache := newHandler(t, DefaultLimits())
+	first := get(t, h, http.MethodGet, "/a.jpg?w=20", nil, http.StatusOK)
+	if got := first.Header().Get("X-Cache"); got != "MISS" {
+		t.Errorf("first request X-Cache %q, want MISS", got)
+	}
+	again := get(t, h, http.MethodGet, "/a.jpg?w=20", nil, http.StatusOK)
+	if got := again.Header().Get("X-Cache"); got != "HIT" || !bytes.Equal(again.Body.Bytes(), first.Body.Bytes()) {
+		t.Errorf("second request X-Cache %q, same rendition %v", got, bytes.Equal(again.Body.Bytes(), first.Body.Bytes()))
+	}
+	etag := first.Header().Get("ETag")
+	get(t, h, http.MethodGet, "/a.jpg?w=20", http.Header{"If-None-Match": {etag}}, http.StatusNotModified)
+	if other := get(t, h, http.MethodGet, "/a.jpg?w=21", nil, http.StatusOK); other.Header().Get("ETag") == etag {
+		t.Error("same ETag for another size")
+	}
+	head := get(t, h, http.MethodHead, "/a.jpg?w=20", nil, http.StatusOK)
+	if head.Body.Len() != 0 {
+		t.Error("body written for HEAD")
+	}
+
+	// Identical requests arriving together are rendered and kept once
+	size := cache.Size()
+	var wg sync.WaitGroup
+	bodies := make([][]byte, 8)
+	for i := range bodies {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			r := httptest.NewRequest(http.MethodGet, "/sub/b.png?w=30", nil)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code == http.StatusOK {
+				bodies[i] = w.Body.Bytes()
+			}
+		}(i)
+	}
+	wg.Wait()
+	for i, body := range bodies {
+		if body == nil || !bytes.Equal(body, bodies[0]) {
+			t.Fatalf("request %d: %d bytes, differing from the first", i, len(body))
+		}
+	}
+	if got := cache.Size() - size; got != int64(len(bodies[0])) {
+		t.Errorf("cache grew by %d bytes, want %d", got, len(bodies[0]))
+	}
+}
+
+func TestHandlerErrors(t *testing.T) {
+	h, _ := newHandler(t, Limits{MaxWidth: 100, MaxHeight: 100, MaxSourcePixels: 40 * 30})
+	for _, test := range []struct {
+		method string
+		target string
+		status int
+	}{
+		// Paths outside of the root, hidden or not images
+		{http.MethodGet, "/../outside.jpg?w=10", http.StatusNotFound},
+		{http.MethodGet, "/%2e%2e/outside.jpg?w=10", http.StatusNotFound},
+		{http.MethodGet, "/.private/c.jpg?w=10", http.StatusNotFound},
+		{http.MethodGet, "/notes.txt?w=10", http.StatusNotFound},
+		{http.MethodGet, "/missing.jpg?w=10", http.StatusNotFound},
+		{http.MethodGet, "/sub?w=10", http.StatusNotFound},
+		// Parameters
+		{http.MethodGet, "/a.jpg", http.StatusBadRequest},
+		{http.MethodGet, "/a.jpg?w=101", http.StatusBadRequest},
+		{http.MethodGet, "/a.jpg?w=10&fit=zoom", http.StatusBadRequest},
+		{http.MethodGet, "/a.jpg?w=10&fmt=webp", http.StatusBadRequest},
+		// Originals that can not be rendered
+		{http.MethodGet, "/broken.jpg?w=10", http.StatusUnprocessableEntity},
+		{http.MethodGet, "/sub/b.png?w=10", http.StatusUnprocessableEntity},
+		{http.MethodPost, "/a.jpg?w=10", http.StatusMethodNotAllowed},
+	} {
+		w := get(t, h, test.method, test.target, nil, test.status)
+		if test.status == http.StatusMethodNotAllowed && w.Header().Get("Allow") != "GET, HEAD" {
+			t.Errorf("%s %s: Allow %q", test.method, test.target, w.Header().Get("Allow"))
+		}
+	}
+	get(t, h, http.MethodGet, "/a.jpg?w=10", nil, http.StatusOK)
+}
@@ -0,0 +1,214 @@
+// Package rendition makes resized, cropped and re-encoded copies of images
+// on request, as asked by URL query parameters:
+//
+//	w, h   target width and height, in pixels; one of them can be left out
+//	fit    contain (default), cover or fill
+//	fmt    jpeg or png, the format of the original when it can be kept
+//	q      JPEG quality, 1 to 100
+//
+// Renditions are kept in a size capped disk cache, evicting the least
+// recently used ones, and identical requests arriving together are only
+// rendered once. Limits on the requested and decoded sizes keep a client
+// from making the server allocate huge images.
+package rendition
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/nicky-ayoub/imagination/internal/pkg/imageio"
+	"github.com/nicky-ayoub/imagination/internal/pkg/imageops"
+	"github.com/nicky-ayoub/imagination/internal/pkg/resize"
+)
+
+// Fit modes, telling how an image is fitted into the requested width and height.
+const (
+	FIT_CONTAIN = "contain" // Inside the box, keeping the ratio, never enlarged
+	FIT_COVER   = "cover"   // Filling the box, keeping the ratio, the overflow cropped evenly
+	FIT_FILL    = "fill"    // Stretched to the box
+)
+
+/** Largest width and height of the renditions when no limit is given. */
+const DEFAULT_MAX_EDGE = 4096
+
+/** Largest number of pixels of the decoded originals when no limit is given, 200 MP. */
+const DEFAULT_MAX_SOURCE_PIXELS = 200000000
+
+// Limits are the largest sizes a request can make the server handle.
+type Limits struct {
+	MaxWidth        int
+	MaxHeight       int
+	MaxSourcePixels int64 // Originals larger than this are refused rather than decoded
+}
+
+// DefaultLimits returns DEFAULT_MAX_EDGE and DEFAULT_MAX_SOURCE_PIXELS limits.
+func DefaultLimits() Limits {
+	return Limits{DEFAULT_MAX_EDGE, DEFAULT_MAX_EDGE, DEFAULT_MAX_SOURCE_PIXELS}
+}
+
+// Params describe a rendition.
+type Params struct {
+	Width   int // 0 to scale with the height
+	Height  int // 0 to scale with the width
+	Fit     string
+	Format  string // jpeg or png, empty for the format of the original when possible
+	Quality int
+}
+
+// ParseParams reads the parameters of a query, checking them against limits.
+func ParseParams(values url.Values, limits Limits) (Params, error) {
+	p := Params{Fit: FIT_CONTAIN, Quality: imageio.DEFAULT_QUALITY}
+	for _, v := range []struct {
+		name     string
+		value    *int
+		min, max int
+	}{
+		{"w", &p.Width, 1, limits.MaxWidth},
+		{"h", &p.Height, 1, limits.MaxHeight},
+		{"q", &p.Quality, 1, 100},
+	} {
+		s := values.Get(v.name)
+		if s == "" {
+			continue
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return Params{}, fmt.Errorf("%s: not a number: %q", v.name, s)
+		}
+		if n < v.min || n > v.max {
+			return Params{}, fmt.Errorf("%s: %d out of range %d-%d", v.name, n, v.min, v.max)
+		}
+		*v.value = n
+	}
+	if p.Width == 0 && p.Height == 0 {
+		return Params{}, fmt.Errorf("w or h is required")
+	}
+	switch fit := values.Get("fit"); fit {
+	case "":
+	case FIT_CONTAIN, FIT_COVER, FIT_FILL:
+		p.Fit = fit
+	default:
+		return Params{}, fmt.Errorf("fit: unknown mode %q (want contain, cover or fill)", fit)
+	}
+	switch format := values.Get("fmt"); format {
+	case "", "jpeg", "png":
+		p.Format = format
+	case "jpg":
+		p.Format = "jpeg"
+	default:
+		return Params{}, fmt.Errorf("fmt: unknown format %q (want jpeg or png)", format)
+	}
+	return p, nil
+}
+
+// For returns the parameters with the format set for an original file: PNG
+// for the formats that can have transparency, JPEG for the others.
+func (p Params) For(file string) Params {
+	if p.Format == "" {
+		switch imageio.Format(file) {
+		case "png", "gif", "tiff":
+			p.Format = "png"
+		default:
+			p.Format = "jpeg"
+		}
+	}
+	return p
+}
+
+// String returns the parameters as a canonical query, used in cache keys.
+func (p Params) String() string {
+	s := fmt.Sprintf("w=%d&h=%d&fit=%s&fmt=%s", p.Width, p.Height, p.Fit, p.Format)
+	if p.Format == "jpeg" {
+		s += "&q=" + strconv.Itoa(p.Quality)
+	}
+	return s
+}
+
+// ContentType returns the media type of the renditions.
+func (p Params) ContentType() string {
+	return "image/" + p.Format
+}
+
+// Render decodes an image file, upright, and returns its encoded rendition.
+// p must have a format, see For.
+func Render(file string, p Params, limits Limits) ([]byte, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	config, _, err := image.DecodeConfig(f)
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath.Base(file), err)
+	}
+	if limits.MaxSourcePixels > 0 && int64(config.Width)*int64(config.Height) > limits.MaxSourcePixels {
+		return nil, fmt.Errorf("%s: %dx%d is larger than the %d pixels allowed", filepath.Base(file), config.Width, config.Height, limits.MaxSourcePixels)
+	}
+
+	img, _, err := imageio.Load(file)
+	if err != nil {
+		return nil, err
+	}
+	if t := imageops.Orientation(file); !t.IsIdentity() {
+		img = imageops.Apply(img, t)
+	}
+	img = Fit(img, p.Width, p.Height, p.Fit)
+	var buffer bytes.Buffer
+	if err = imageio.Encode(&buffer, img, p.Format, p.Quality); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// Fit resizes img into a width x height box as the fit mode asks. A zero
+// width or height is computed from the other, keeping the ratio.
+func Fit(img image.Image, width int, height int, fit string) image.Image {
+	size := img.Bounds().Size()
+	if size.X <= 0 || size.Y <= 0 {
+		return img
+	}
+	if width == 0 || height == 0 {
+		if width == 0 {
+			width = max(1, (height*size.X+size.Y/2)/size.Y)
+		} else {
+			height = max(1, (width*size.Y+size.X/2)/size.X)
+		}
+		if fit != FIT_FILL {
+			fit = FIT_CONTAIN
+		}
+	}
+
+	switch fit {
+	case FIT_COVER:
+		// Crop the centered area having the ratio of the box, then scale it to the box
+		area := size
+		if int64(size.X)*int64(height) > int64(size.Y)*int64(width) {
+			area.X = max(1, int(int64(size.Y)*int64(width)/int64(height)))
+		} else {
+			area.Y = max(1, int(int64(size.X)*int64(height)/int64(width)))
+		}
+		min := img.Bounds().Min.Add(size.Sub(area).Div(2))
+		img = imageops.Crop(img, image.Rectangle{min, min.Add(area)})
+		return resize.Resize(img, image.Pt(width, height), resize.CATMULL_ROM)
+	case FIT_FILL:
+		return resize.Resize(img, image.Pt(width, height), resize.CATMULL_ROM)
+	}
+	spec := resize.Spec{Mode: resize.MODE_BOX, Width: width, Height: height}
+	return resize.Resize(img, spec.Size(size, false), resize.CATMULL_ROM)
+}
+
+// Key returns the cache key of the rendition of a file: its absolute path,
+// size and modification date, so an edited original gets new renditions.
+func Key(file string, info os.FileInfo, p Params) string {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		abs = file
+	}
+	return strings.Join([]string{abs, strconv.FormatInt(info.Size(), 10), strconv.FormatInt(info.ModTime().UnixNano(), 10), p.String()}, "\x00")
+}
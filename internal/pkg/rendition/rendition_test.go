@@ -0,0 +1,50 @@
+package rendition
+
+import (
+	"image"
+	"net/url"
+	"testing"
+)
+
+func TestParseParams(t *testing.T) {
+	limits := Limits{MaxWidth: 1000, MaxHeight: 800}
+	for _, test := range []struct {
+		query string
+		want  Params
+	}{
+		{"w=200", Params{Width: 200, Fit: FIT_CONTAIN, Quality: 90}},
+		{"h=100&fit=cover&fmt=jpg&q=70", Params{Height: 100, Fit: FIT_COVER, Format: "jpeg", Quality: 70}},
+		{"w=1000&h=800&fit=fill&fmt=png", Params{Width: 1000, Height: 800, Fit: FIT_FILL, Format: "png", Quality: 90}},
+	} {
+		values, _ := url.ParseQuery(test.query)
+		if p, err := ParseParams(values, limits); err != nil || p != test.want {
+			t.Errorf("%s: %+v, %v, want %+v", test.query, p, err, test.want)
+		}
+	}
+	for _, query := range []string{"", "fit=cover", "w=0", "w=-5", "w=abc", "w=1001", "h=801", "w=10&q=0", "w=10&q=101", "w=10&fit=zoom", "w=10&fmt=gif"} {
+		values, _ := url.ParseQuery(query)
+		if p, err := ParseParams(values, limits); err == nil {
+			t.Errorf("%q: %+v, want an error", query, p)
+		}
+	}
+}
+
+func TestFit(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(10, 10, 410, 210))
+	for _, test := range []struct {
+		width, height int
+		fit           string
+		want          image.Point
+	}{
+		{100, 0, FIT_CONTAIN, image.Pt(100, 50)},
+		{0, 20, FIT_COVER, image.Pt(40, 20)},
+		{100, 100, FIT_CONTAIN, image.Pt(100, 50)},
+		{100, 100, FIT_COVER, image.Pt(100, 100)},
+		{100, 100, FIT_FILL, image.Pt(100, 100)},
+		{800, 800, FIT_CONTAIN, image.Pt(400, 200)},
+	} {
+		if got := Fit(img, test.width, test.height, test.fit).Bounds().Size(); got != test.want {
+			t.Errorf("%dx%d %s: %v, want %v", test.width, test.height, test.fit, got, test.want)
+		}
+	}
+}
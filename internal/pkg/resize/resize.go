@@ -0,0 +1,167 @@
+// Package resize computes target image sizes from a size specification and
+// resamples images to them.
+package resize
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// Mode tells how a Spec computes the target size.
+type Mode int
+
+const (
+	MODE_NONE     Mode = iota // Keep the image size
+	MODE_MAX_EDGE             // Scale the longest edge to Width
+	MODE_BOX                  // Fit inside Width x Height, keeping the ratio
+	MODE_EXACT                // Scale to exactly Width x Height
+	MODE_PERCENT              // Scale both edges by Percent
+)
+
+// Spec is a size specification, as written on command lines:
+//
+//	1600       the longest edge becomes 1600 pixels
+//	1600x1200  fits inside 1600x1200, keeping the ratio
+//	1600x1200! exactly 1600x1200, distorting the image when ratios differ
+//	50%        half the width and height
+type Spec struct {
+	Mode    Mode
+	Width   int
+	Height  int
+	Percent float64
+}
+
+// ParseSpec reads a size specification, an empty string meaning MODE_NONE.
+func ParseSpec(s string) (Spec, error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case s == "":
+		return Spec{}, nil
+	case strings.HasSuffix(s, "%"):
+		p, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+		if err != nil || p <= 0 {
+			return Spec{}, fmt.Errorf("invalid size percentage %q", s)
+		}
+		return Spec{Mode: MODE_PERCENT, Percent: p}, nil
+	case strings.ContainsAny(s, "xX"):
+		mode := MODE_BOX
+		if strings.HasSuffix(s, "!") {
+			mode = MODE_EXACT
+			s = strings.TrimSuffix(s, "!")
+		}
+		i := strings.IndexAny(s, "xX")
+		w, werr := strconv.Atoi(s[:i])
+		h, herr := strconv.Atoi(s[i+1:])
+		if werr != nil || herr != nil || w <= 0 || h <= 0 {
+			return Spec{}, fmt.Errorf("invalid size box %q (want WIDTHxHEIGHT)", s)
+		}
+		return Spec{Mode: mode, Width: w, Height: h}, nil
+	}
+	edge, err := strconv.Atoi(s)
+	if err != nil || edge <= 0 {
+		return Spec{}, fmt.Errorf("invalid size %q (want EDGE, WIDTHxHEIGHT, WIDTHxHEIGHT! or PERCENT%%)", s)
+	}
+	return Spec{Mode: MODE_MAX_EDGE, Width: edge}, nil
+}
+
+func (s Spec) String() string {
+	switch s.Mode {
+	case MODE_MAX_EDGE:
+		return strconv.Itoa(s.Width)
+	case MODE_BOX:
+		return fmt.Sprintf("%dx%d", s.Width, s.Height)
+	case MODE_EXACT:
+		return fmt.Sprintf("%dx%d!", s.Width, s.Height)
+	case MODE_PERCENT:
+		return strconv.FormatFloat(s.Percent, 'g', -1, 64) + "%"
+	}
+	return ""
+}
+
+// Size returns the target size of an image of the given size. Unless enlarge
+// is set, images smaller than the target keep their size, MODE_EXACT aside.
+func (s Spec) Size(size image.Point, enlarge bool) image.Point {
+	if size.X <= 0 || size.Y <= 0 {
+		return size
+	}
+	var scale float64
+	switch s.Mode {
+	case MODE_MAX_EDGE:
+		scale = float64(s.Width) / float64(max(size.X, size.Y))
+	case MODE_BOX:
+		scale = math.Min(float64(s.Width)/float64(size.X), float64(s.Height)/float64(size.Y))
+	case MODE_EXACT:
+		return image.Pt(s.Width, s.Height)
+	case MODE_PERCENT:
+		scale = s.Percent / 100
+	default:
+		return size
+	}
+	if scale >= 1 && !enlarge {
+		return size
+	}
+	return image.Pt(
+		max(1, int(math.Round(float64(size.X)*scale))),
+		max(1, int(math.Round(float64(size.Y)*scale))))
+}
+
+// Filter is a resampling filter.
+type Filter struct {
+	Name   string
+	scaler draw.Scaler
+}
+
+// Lanczos3 is the Lanczos windowed sinc with 3 lobes, sharper than Catmull-Rom.
+var Lanczos3 = &draw.Kernel{Support: 3, At: func(t float64) float64 {
+	if t == 0 {
+		return 1
+	}
+	if t >= 3 {
+		return 0
+	}
+	x := math.Pi * t
+	return 3 * math.Sin(x) * math.Sin(x/3) / (x * x)
+}}
+
+// Filters, from the sharpest and slowest to the fastest.
+var (
+	LANCZOS     = Filter{"lanczos", Lanczos3}
+	CATMULL_ROM = Filter{"catmullrom", draw.CatmullRom}
+	BILINEAR    = Filter{"bilinear", draw.BiLinear}
+	NEAREST     = Filter{"nearest", draw.NearestNeighbor}
+)
+
+var filters = []Filter{LANCZOS, CATMULL_ROM, BILINEAR, NEAREST}
+
+func (f Filter) String() string {
+	return f.Name
+}
+
+// ParseFilter finds a filter from its name: lanczos, catmullrom, bilinear or nearest.
+func ParseFilter(s string) (Filter, error) {
+	for _, f := range filters {
+		if strings.EqualFold(s, f.Name) {
+			return f, nil
+		}
+	}
+	return Filter{}, fmt.Errorf("unknown filter %q (want lanczos, catmullrom, bilinear or nearest)", s)
+}
+
+// Resize returns img resampled to size, or img itself when it already has that size.
+func Resize(img image.Image, size image.Point, filter Filter) image.Image {
+	b := img.Bounds()
+	if b.Size() == size {
+		return img
+	}
+	if filter.scaler == nil {
+		filter = CATMULL_ROM
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, size.X, size.Y))
+	filter.scaler.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
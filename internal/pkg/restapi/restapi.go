@@ -0,0 +1,346 @@
+// Package restapi serves a catalog as a JSON HTTP API, described by
+// api/openapi.yaml:
+//
+//	GET   /api/folders/<folder>       folder and its subfolders
+//	GET   /api/images?folder=...      images filtered, sorted and paged
+//	GET   /api/images/<image>         image metadata
+//	PATCH /api/images/<image>         rating and tags update
+//	GET   /api/thumbnails/<image>     cached JPEG thumbnail
+//	GET   /api/renditions/<image>     resized image, ?size=1600&format=png
+//	POST  /api/scan                   catalog refresh
+//
+// Paths are relative to the catalog root, slash separated. Errors are
+// answered with a JSON object holding an error message.
+package restapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nicky-ayoub/imagination/internal/pkg/catalog"
+	"github.com/nicky-ayoub/imagination/internal/pkg/gallery"
+	"github.com/nicky-ayoub/imagination/internal/pkg/imageio"
+	"github.com/nicky-ayoub/imagination/internal/pkg/picinfo"
+	"github.com/nicky-ayoub/imagination/internal/pkg/thumbcache"
+)
+
+/** Number of images of a page when no limit is given. */
+const DEFAULT_LIMIT = 100
+
+/** Largest number of images of a page. */
+const MAX_LIMIT = 1000
+
+/** Longest edge of the renditions when no size is given. */
+const DEFAULT_RENDITION_SIZE = 1600
+
+/** Largest longest edge of the renditions, decoding and encoding being expensive. */
+const MAX_RENDITION_SIZE = 4096
+
+/** Largest PATCH body, in bytes. */
+const MAX_BODY = 64 << 10
+
+// Server is the HTTP handler of the API.
+type Server struct {
+	catalog *catalog.Catalog
+	cache   *thumbcache.Cache
+	mux     *http.ServeMux
+	work    chan struct{} // Limits the thumbnails and renditions made at the same time
+}
+
+// New returns the API of a catalog, the thumbnails being kept in cache.
+func New(c *catalog.Catalog, cache *thumbcache.Cache) *Server {
+	s := &Server{
+		catalog: c,
+		cache:   cache,
+		mux:     http.NewServeMux(),
+		work:    make(chan struct{}, runtime.NumCPU()),
+	}
+	s.handle("/api/folders/", s.folders, http.MethodGet)
+	s.handle("/api/images", s.images, http.MethodGet)
+	s.handle("/api/images/", s.image, http.MethodGet, http.MethodPatch)
+	s.handle("/api/thumbnails/", s.thumbnail, http.MethodGet)
+	s.handle("/api/renditions/", s.rendition, http.MethodGet)
+	s.handle("/api/scan", s.scan, http.MethodPost)
+	s.mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, "no such endpoint")
+	})
+	return s
+}
+
+// ServeHTTP answers the API requests.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// handle routes the requests of a pattern to fn, the path following the
+// pattern, when it ends with a slash, being passed as rel. HEAD is allowed with GET.
+func (s *Server) handle(pattern string, fn func(w http.ResponseWriter, r *http.Request, rel string), methods ...string) {
+	s.mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		allowed := false
+		for _, m := range methods {
+			if r.Method == m || r.Method == http.MethodHead && m == http.MethodGet {
+				allowed = true
+			}
+		}
+		if !allowed {
+			w.Header().Set("Allow", strings.Join(methods, ", "))
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		fn(w, r, catalog.Clean(strings.TrimPrefix(r.URL.Path, pattern)))
+	})
+}
+
+// writeJSON writes v with a status.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(v)
+}
+
+// Error is the body of the error responses.
+type Error struct {
+	Error string `json:"error"`
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, Error{message})
+}
+
+// FolderList is the response of the folders endpoint.
+type FolderList struct {
+	Folder  catalog.Folder   `json:"folder"`
+	Folders []catalog.Folder `json:"folders"`
+}
+
+func (s *Server) folders(w http.ResponseWriter, r *http.Request, rel string) {
+	folder, subfolders, ok := s.catalog.Folder(rel)
+	if !ok && rel != "" { // The root is listed even when empty
+		writeError(w, http.StatusNotFound, "no such folder")
+		return
+	}
+	if subfolders == nil {
+		subfolders = []catalog.Folder{}
+	}
+	writeJSON(w, http.StatusOK, FolderList{folder, subfolders})
+}
+
+// ImageList is the response of the images endpoint.
+type ImageList struct {
+	Total  int             `json:"total"` // Images matching the query
+	Offset int             `json:"offset"`
+	Limit  int             `json:"limit"`
+	Images []catalog.Image `json:"images"`
+}
+
+func (s *Server) images(w http.ResponseWriter, r *http.Request, _ string) {
+	values := r.URL.Query()
+	q := catalog.Query{
+		Folder: values.Get("folder"),
+		Text:   values.Get("q"),
+		Tags:   values["tag"],
+		Format: values.Get("format"),
+		Sort:   values.Get("sort"),
+		Limit:  DEFAULT_LIMIT,
+	}
+	var err error
+	if q.Recursive, err = boolParameter(values.Get("recursive")); err != nil {
+		writeError(w, http.StatusBadRequest, "recursive: "+err.Error())
+		return
+	}
+	switch values.Get("order") {
+	case "", "asc":
+	case "desc":
+		q.Descending = true
+	default:
+		writeError(w, http.StatusBadRequest, "order: want asc or desc")
+		return
+	}
+	for _, p := range []struct {
+		name     string
+		value    *int
+		min, max int
+	}{
+		{"rating", &q.MinRating, 0, catalog.MAX_RATING},
+		{"offset", &q.Offset, 0, int(^uint(0) >> 1)},
+		{"limit", &q.Limit, 1, MAX_LIMIT},
+	} {
+		if err := intParameter(values.Get(p.name), p.value, p.min, p.max); err != nil {
+			writeError(w, http.StatusBadRequest, p.name+": "+err.Error())
+			return
+		}
+	}
+
+	images, total, err := s.catalog.Query(q)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, ImageList{total, q.Offset, q.Limit, images})
+}
+
+// intParameter parses a query parameter into v, left as it is when the parameter is missing.
+func intParameter(value string, v *int, min int, max int) error {
+	if value == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("not a number: %q", value)
+	}
+	if n < min || n > max {
+		return fmt.Errorf("%d out of range %d-%d", n, min, max)
+	}
+	*v = n
+	return nil
+}
+
+// boolParameter parses a query parameter, false when missing.
+func boolParameter(value string) (bool, error) {
+	if value == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(value)
+}
+
+// ImageDetails is the response of the image endpoint: the catalog entry, and
+// what picinfo tells of the file when it can be decoded.
+type ImageDetails struct {
+	catalog.Image
+	Info *picinfo.Info `json:"info,omitempty"`
+}
+
+func (s *Server) image(w http.ResponseWriter, r *http.Request, rel string) {
+	if r.Method == http.MethodPatch {
+		s.update(w, r, rel)
+		return
+	}
+	image, ok := s.catalog.Image(rel)
+	if !ok {
+		writeError(w, http.StatusNotFound, "no such image")
+		return
+	}
+	details := ImageDetails{Image: image}
+	file, _ := s.catalog.File(rel)
+	if info, err := picinfo.Inspect(file); err == nil {
+		info.Path = image.Path // Not disclosing where the library is
+		details.Info = info
+	}
+	writeJSON(w, http.StatusOK, details)
+}
+
+// update applies the catalog.Changes of the request body.
+func (s *Server) update(w http.ResponseWriter, r *http.Request, rel string) {
+	if _, ok := s.catalog.Image(rel); !ok {
+		writeError(w, http.StatusNotFound, "no such image")
+		return
+	}
+	var changes catalog.Changes
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, MAX_BODY))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&changes); err != nil {
+		writeError(w, http.StatusBadRequest, "body: "+err.Error())
+		return
+	}
+	image, err := s.catalog.Update(rel, changes)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, image)
+}
+
+func (s *Server) thumbnail(w http.ResponseWriter, r *http.Request, rel string) {
+	file, ok := s.catalog.File(rel)
+	if !ok {
+		writeError(w, http.StatusNotFound, "no such image")
+		return
+	}
+	s.work <- struct{}{}
+	thumbnail, _, err := s.cache.Make(file, false)
+	<-s.work
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("ETag", fmt.Sprintf(`"%d-%s"`, s.cache.Size, strings.TrimSuffix(filepath.Base(thumbnail), filepath.Ext(thumbnail))))
+	gallery.ServeFile(w, r, thumbnail)
+}
+
+func (s *Server) rendition(w http.ResponseWriter, r *http.Request, rel string) {
+	file, ok := s.catalog.File(rel)
+	if !ok {
+		writeError(w, http.StatusNotFound, "no such image")
+		return
+	}
+	values := r.URL.Query()
+	size, quality := DEFAULT_RENDITION_SIZE, imageio.DEFAULT_QUALITY
+	if err := intParameter(values.Get("size"), &size, 1, MAX_RENDITION_SIZE); err != nil {
+		writeError(w, http.StatusBadRequest, "size: "+err.Error())
+		return
+	}
+	if err := intParameter(values.Get("quality"), &quality, 1, 100); err != nil {
+		writeError(w, http.StatusBadRequest, "quality: "+err.Error())
+		return
+	}
+	format := values.Get("format")
+	switch format {
+	case "":
+		format = "jpeg"
+	case "jpeg", "png":
+	default:
+		writeError(w, http.StatusBadRequest, "format: want jpeg or png")
+		return
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "no such image")
+		return
+	}
+
+	// The rendition only depends on the file and the parameters, checked before doing the work
+	etag := fmt.Sprintf(`"%x-%x-%d-%s-%d"`, info.Size(), info.ModTime().UnixNano(), size, format, quality)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if match := r.Header.Get("If-None-Match"); match != "" && strings.Contains(match, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	s.work <- struct{}{}
+	img, err := thumbcache.Thumbnail(file, size)
+	var buffer bytes.Buffer
+	if err == nil {
+		err = imageio.Encode(&buffer, img, format, quality)
+	}
+	<-s.work
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "image/"+format)
+	http.ServeContent(w, r, "", info.ModTime(), bytes.NewReader(buffer.Bytes()))
+}
+
+// ScanResult is the response of the scan endpoint.
+type ScanResult struct {
+	Images  int       `json:"images"`
+	Scanned time.Time `json:"scanned"`
+}
+
+func (s *Server) scan(w http.ResponseWriter, r *http.Request, _ string) {
+	if err := s.catalog.Scan(); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, ScanResult{s.catalog.Len(), s.catalog.Scanned()})
+}
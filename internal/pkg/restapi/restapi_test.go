@@ -0,0 +1,319 @@
+package restapi
+
+import (
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nicky-ayoub/imagination/internal/pkg/catalog"
+	"github.com/nicky-ayoub/imagination/internal/pkg/sidecar"
+	"github.com/nicky-ayoub/imagination/internal/pkg/thumbcache"
+)
+
+// newServer returns the API of a catalog of a temporary directory:
+//
+//	a.jpg      40x30
+//	b.png      80x60, rated 4, tagged "cat"
+//	c.jpg      20x10, tagged "cat" and "dog"
+//	trip/d.jpg 30x30
+//	trip/e.png 10x10
+func newServer(t *testing.T) (*Server, string) {
+	t.Helper()
+	root := t.TempDir()
+	for _, f := range []struct {
+		name          string
+		width, height int
+	}{
+		{"a.jpg", 40, 30},
+		{"b.png", 80, 60},
+		{"c.jpg", 20, 10},
+		{"trip/d.jpg", 30, 30},
+		{"trip/e.png", 10, 10},
+	} {
+		writeImage(t, filepath.Join(root, filepath.FromSlash(f.name)), f.width, f.height)
+	}
+	for name, s := range map[string]sidecar.Sidecar{
+		"b.png": {Rating: 4, Tags: []string{"cat"}},
+		"c.jpg": {Tags: []string{"cat", "dog"}},
+	} {
+		if err := s.Save(filepath.Join(root, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := catalog.New(root, []string{".jpg", ".png"})
+	if err := c.Scan(); err != nil {
+		t.Fatal(err)
+	}
+	return New(c, thumbcache.New(t.TempDir(), 64)), root
+}
+
+func writeImage(t *testing.T, file string, width int, height int) {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = 200
+	}
+	img.SetNRGBA(0, 0, color.NRGBA{255, 0, 0, 255})
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if strings.HasSuffix(file, ".png") {
+		err = png.Encode(f, img)
+	} else {
+		err = jpeg.Encode(f, img, nil)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// do sends a request to the server and decodes the JSON response into v, checking its status.
+func do(t *testing.T, s *Server, method string, target string, body string, status int, v interface{}) {
+	t.Helper()
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != status {
+		t.Fatalf("%s %s: status %d, want %d: %s", method, target, w.Code, status, w.Body)
+	}
+	if got := w.Header().Get("Content-Type"); got != "application/json" {
+		t.Fatalf("%s %s: content type %q, want application/json", method, target, got)
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("%s %s: %v", method, target, err)
+	}
+}
+
+func paths(images []catalog.Image) []string {
+	p := []string{}
+	for _, image := range images {
+		p = append(p, image.Path)
+	}
+	return p
+}
+
+func TestFolders(t *testing.T) {
+	s, _ := newServer(t)
+
+	var root FolderList
+	do(t, s, http.MethodGet, "/api/folders/", "", http.StatusOK, &root)
+	if root.Folder.Images != 3 || root.Folder.Total != 5 {
+		t.Errorf("root holds %d images, %d in all, want 3 and 5", root.Folder.Images, root.Folder.Total)
+	}
+	if len(root.Folders) != 1 || root.Folders[0].Path != "trip" || root.Folders[0].Images != 2 {
+		t.Errorf("root subfolders %+v, want trip with 2 images", root.Folders)
+	}
+
+	var trip FolderList
+	do(t, s, http.MethodGet, "/api/folders/trip", "", http.StatusOK, &trip)
+	if trip.Folder.Path != "trip" || trip.Folder.Total != 2 || len(trip.Folders) != 0 {
+		t.Errorf("trip %+v, want 2 images and no subfolder", trip)
+	}
+
+	var e Error
+	do(t, s, http.MethodGet, "/api/folders/nowhere", "", http.StatusNotFound, &e)
+	if e.Error == "" {
+		t.Error("no error message for an unknown folder")
+	}
+}
+
+func TestImages(t *testing.T) {
+	s, _ := newServer(t)
+	for _, test := range []struct {
+		query string
+		total int
+		want  []string
+	}{
+		{"", 3, []string{"a.jpg", "b.png", "c.jpg"}},
+		{"?recursive=true", 5, []string{"a.jpg", "b.png", "c.jpg", "trip/d.jpg", "trip/e.png"}},
+		{"?folder=trip", 2, []string{"trip/d.jpg", "trip/e.png"}},
+		{"?tag=cat", 2, []string{"b.png", "c.jpg"}},
+		{"?tag=cat&tag=dog", 1, []string{"c.jpg"}},
+		{"?q=DOG", 1, []string{"c.jpg"}},
+		{"?rating=4", 1, []string{"b.png"}},
+		{"?format=png&recursive=1", 2, []string{"b.png", "trip/e.png"}},
+		{"?sort=rating&order=desc", 3, []string{"b.png", "c.jpg", "a.jpg"}}, // Ties are reversed too
+		{"?recursive=true&offset=1&limit=2", 5, []string{"b.png", "c.jpg"}},
+		{"?recursive=true&offset=9", 5, []string{}},
+	} {
+		var list ImageList
+		do(t, s, http.MethodGet, "/api/images"+test.query, "", http.StatusOK, &list)
+		if list.Total != test.total {
+			t.Errorf("%s: total %d, want %d", test.query, list.Total, test.total)
+		}
+		if !reflect.DeepEqual(paths(list.Images), test.want) {
+			t.Errorf("%s: images %v, want %v", test.query, paths(list.Images), test.want)
+		}
+	}
+
+	// Sorted by size, descending
+	var list ImageList
+	do(t, s, http.MethodGet, "/api/images?sort=size&order=desc&recursive=true", "", http.StatusOK, &list)
+	for i := 1; i < len(list.Images); i++ {
+		if list.Images[i-1].Size < list.Images[i].Size {
+			t.Errorf("%s (%d bytes) before %s (%d bytes)", list.Images[i-1].Path, list.Images[i-1].Size, list.Images[i].Path, list.Images[i].Size)
+		}
+	}
+	if list.Limit != DEFAULT_LIMIT || list.Offset != 0 {
+		t.Errorf("offset %d and limit %d, want 0 and %d", list.Offset, list.Limit, DEFAULT_LIMIT)
+	}
+}
+
+func TestImagesBadParameters(t *testing.T) {
+	s, _ := newServer(t)
+	for _, query := range []string{
+		"?limit=0",
+		"?limit=1001",
+		"?offset=-1",
+		"?rating=6",
+		"?rating=many",
+		"?order=up",
+		"?recursive=maybe",
+		"?sort=color",
+	} {
+		var e Error
+		do(t, s, http.MethodGet, "/api/images"+query, "", http.StatusBadRequest, &e)
+		if e.Error == "" {
+			t.Errorf("%s: no error message", query)
+		}
+	}
+}
+
+func TestImage(t *testing.T) {
+	s, _ := newServer(t)
+
+	var details ImageDetails
+	do(t, s, http.MethodGet, "/api/images/b.png", "", http.StatusOK, &details)
+	if details.Path != "b.png" || details.Width != 80 || details.Height != 60 || details.Rating != 4 {
+		t.Errorf("b.png %+v, want 80x60 rated 4", details.Image)
+	}
+	if details.Info == nil || details.Info.Path != "b.png" {
+		t.Errorf("b.png info %+v, want the relative path", details.Info)
+	}
+
+	for _, target := range []string{"/api/images/missing.jpg", "/api/images/trip/a.jpg", "/api/thumbnails/missing.jpg", "/api/renditions/missing.jpg"} {
+		var e Error
+		do(t, s, http.MethodGet, target, "", http.StatusNotFound, &e)
+	}
+	var e Error
+	do(t, s, http.MethodPatch, "/api/images/missing.jpg", `{"rating": 1}`, http.StatusNotFound, &e)
+	do(t, s, http.MethodDelete, "/api/images/a.jpg", "", http.StatusMethodNotAllowed, &e)
+	do(t, s, http.MethodGet, "/api/nothing", "", http.StatusNotFound, &e)
+}
+
+func TestUpdate(t *testing.T) {
+	s, root := newServer(t)
+
+	var image catalog.Image
+	do(t, s, http.MethodPatch, "/api/images/c.jpg", `{"rating": 3, "add_tags": ["sea"], "remove_tags": ["dog"]}`, http.StatusOK, &image)
+	if image.Rating != 3 || !reflect.DeepEqual(image.Tags, []string{"cat", "sea"}) {
+		t.Errorf("updated c.jpg rated %d tagged %v, want 3 and [cat sea]", image.Rating, image.Tags)
+	}
+	saved, err := sidecar.Load(filepath.Join(root, "c.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Rating != 3 || !reflect.DeepEqual(saved.Tags, []string{"cat", "sea"}) {
+		t.Errorf("sidecar rated %d tagged %v, want 3 and [cat sea]", saved.Rating, saved.Tags)
+	}
+
+	// The catalog answers with the update
+	var list ImageList
+	do(t, s, http.MethodGet, "/api/images?tag=sea", "", http.StatusOK, &list)
+	if !reflect.DeepEqual(paths(list.Images), []string{"c.jpg"}) {
+		t.Errorf("images tagged sea %v, want [c.jpg]", paths(list.Images))
+	}
+
+	// Clearing everything removes the sidecar
+	do(t, s, http.MethodPatch, "/api/images/c.jpg", `{"rating": 0, "tags": []}`, http.StatusOK, &image)
+	if _, err = os.Stat(sidecar.Path(filepath.Join(root, "c.jpg"))); !os.IsNotExist(err) {
+		t.Errorf("sidecar left after clearing the rating and the tags: %v", err)
+	}
+}
+
+func TestUpdateErrors(t *testing.T) {
+	s, root := newServer(t)
+	for _, test := range []struct {
+		body   string
+		status int
+	}{
+		{`{"rating": 6}`, http.StatusUnprocessableEntity},
+		{`{"rating": -1}`, http.StatusUnprocessableEntity},
+		{`{"rating": "five"}`, http.StatusBadRequest},
+		{`{"stars": 5}`, http.StatusBadRequest},
+		{`not json`, http.StatusBadRequest},
+	} {
+		var e Error
+		do(t, s, http.MethodPatch, "/api/images/b.png", test.body, test.status, &e)
+		if e.Error == "" {
+			t.Errorf("%s: no error message", test.body)
+		}
+	}
+	// The sidecar is left as it was
+	saved, err := sidecar.Load(filepath.Join(root, "b.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Rating != 4 || !reflect.DeepEqual(saved.Tags, []string{"cat"}) {
+		t.Errorf("sidecar rated %d tagged %v after failed updates, want 4 and [cat]", saved.Rating, saved.Tags)
+	}
+}
+
+func TestServer(t *testing.T) {
+	s, root := newServer(t)
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	response, err := http.Get(server.URL + "/api/thumbnails/trip/d.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK || response.Header.Get("ETag") == "" {
+		t.Errorf("thumbnail status %d, ETag %q, want 200 and a tag", response.StatusCode, response.Header.Get("ETag"))
+	}
+
+	response, err = http.Get(server.URL + "/api/renditions/b.png?size=20&format=png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(response.Body)
+	response.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := img.Bounds().Size(); size != image.Pt(20, 15) {
+		t.Errorf("rendition size %v, want (20,15)", size)
+	}
+
+	// A new file is found by a scan
+	writeImage(t, filepath.Join(root, "trip", "f.jpg"), 5, 5)
+	response, err = http.Post(server.URL+"/api/scan", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result ScanResult
+	err = json.NewDecoder(response.Body).Decode(&result)
+	response.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusOK || result.Images != 6 {
+		t.Errorf("scan status %d with %d images, want 200 and 6", response.StatusCode, result.Images)
+	}
+}
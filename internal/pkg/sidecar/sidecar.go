@@ -0,0 +1,79 @@
+// Package sidecar stores per-image data (rating, tags, ...) in a JSON file next to
+// the image, so it follows the image when the directory is moved or copied.
+package sidecar
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/nicky-ayoub/imagination/internal/pkg/adjust"
+	"github.com/nicky-ayoub/imagination/internal/pkg/crop"
+)
+
+/** Appended to the image file name to get its sidecar file name. */
+const EXTENSION = ".imagination.json"
+
+// Sidecar is the data kept about one image.
+type Sidecar struct {
+	Rating int            `json:"rating,omitempty"` // 0 (unrated) to 5
+	Tags   []string       `json:"tags,omitempty"`   // Sorted, without duplicates
+	Adjust *adjust.Recipe `json:"adjust,omitempty"` // Color adjustments applied when displaying and exporting
+	Crop   *crop.Rect     `json:"crop,omitempty"`   // Area kept when exporting, in image pixels
+}
+
+// Path returns the sidecar file of an image.
+func Path(image string) string {
+	return image + EXTENSION
+}
+
+// Load reads the sidecar of an image. An image without sidecar gets an empty one.
+func Load(image string) (s *Sidecar, err error) {
+	s = &Sidecar{}
+	data, err := os.ReadFile(Path(image))
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// SetTags replaces the tags, trimmed, sorted and without empty ones or duplicates.
+func (s *Sidecar) SetTags(tags []string) {
+	seen := map[string]bool{}
+	s.Tags = nil
+	for _, t := range tags {
+		if t = strings.TrimSpace(t); t != "" && !seen[t] {
+			seen[t] = true
+			s.Tags = append(s.Tags, t)
+		}
+	}
+	sort.Strings(s.Tags)
+}
+
+// IsEmpty tells whether there is nothing to keep about the image.
+func (s *Sidecar) IsEmpty() bool {
+	return s.Rating == 0 && len(s.Tags) == 0 && s.Adjust == nil && s.Crop == nil
+}
+
+// Save writes the sidecar of an image, or removes the file when there is nothing left to keep.
+func (s *Sidecar) Save(image string) error {
+	if s.IsEmpty() {
+		err := os.Remove(Path(image))
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(Path(image), append(data, '\n'), 0644)
+}
@@ -0,0 +1,348 @@
+// Package slidesync keeps slideshows running on several screens in step. One
+// slideshow leads: it listens on a TCP address and sends the position of the
+// show to the followers each time it changes. Followers connect to it, and
+// connect again when the leader goes away.
+//
+// The messages are JSON objects, one per line:
+//
+//	{"type": "state", "state": {...}}    leader to follower, on connection and on each change
+//	{"type": "ping", "sent": t0}        follower to leader
+//	{"type": "pong", "sent": t0, "clock": t1}
+//
+// Times are Unix nanoseconds of the leader clock. Followers estimate the
+// offset of their own clock from the pings, keeping the sample with the
+// shortest round trip, so images change at the same moment on machines whose
+// clocks differ. Between two states, followers advance the show by themselves
+// from the time the image was shown and its duration: they stay in step when a
+// message is late, and a follower joining late starts on the current image.
+//
+// Several slideshows on one machine can be synchronized through localhost.
+package slidesync
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+/** Interval between two clock measures of a follower. */
+const PING_INTERVAL = 2 * time.Second
+
+/** Number of the latest clock measures the offset is chosen from. */
+const CLOCK_SAMPLES = 8
+
+/** Longest wait before a follower connects again, the wait doubling from a second after each failure. */
+const MAX_RECONNECT_DELAY = 10 * time.Second
+
+/** Messages waiting to be sent to a follower beyond this number disconnect it. */
+const QUEUE_SIZE = 16
+
+/** How long sending a message to a follower can take. */
+const WRITE_TIMEOUT = 2 * time.Second
+
+// Message types
+const (
+	MESSAGE_STATE = "state"
+	MESSAGE_PING  = "ping"
+	MESSAGE_PONG  = "pong"
+)
+
+// State is the position of the show on the leader.
+type State struct {
+	Index    int           `json:"index"`    // From 0
+	Count    int           `json:"count"`    // Number of images of the leader
+	ShownAt  int64         `json:"shown_at"` // When the image was shown, leader clock
+	Duration time.Duration `json:"duration"` // How long it stays, 0 until the next state
+	Paused   bool          `json:"paused"`
+}
+
+// At returns the image shown at a time of the leader clock and since when, the
+// show going on with images of the same duration until the next state.
+func (s State) At(now int64) (index int, shownAt int64) {
+	if s.Paused || s.Duration <= 0 || s.Count <= 0 || now < s.ShownAt {
+		return s.Index, s.ShownAt
+	}
+	steps := (now - s.ShownAt) / int64(s.Duration)
+	return int((int64(s.Index) + steps) % int64(s.Count)), s.ShownAt + steps*int64(s.Duration)
+}
+
+type message struct {
+	Type  string `json:"type"`
+	State *State `json:"state,omitempty"`
+	Sent  int64  `json:"sent,omitempty"`  // ping: follower clock
+	Clock int64  `json:"clock,omitempty"` // pong: leader clock
+}
+
+// Leader sends the state of the show to its followers.
+type Leader struct {
+	listener net.Listener
+	mutex    sync.Mutex
+	state    State
+	started  bool                      // A state was published
+	peers    map[net.Conn]chan message // Messages waiting to be sent, by follower
+}
+
+// Lead starts a leader listening on a TCP address, like :7080.
+func Lead(address string) (*Leader, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	l := &Leader{listener: listener, peers: map[net.Conn]chan message{}}
+	go l.accept()
+	return l, nil
+}
+
+// Addr returns the address the leader listens on.
+func (l *Leader) Addr() string {
+	return l.listener.Addr().String()
+}
+
+// Publish sends the state to the followers when it changed.
+func (l *Leader) Publish(state State) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.started && state == l.state {
+		return
+	}
+	l.state, l.started = state, true
+	for conn, out := range l.peers {
+		l.send(conn, out, message{Type: MESSAGE_STATE, State: &state})
+	}
+}
+
+// Close stops listening and disconnects the followers.
+func (l *Leader) Close() error {
+	err := l.listener.Close()
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for conn := range l.peers {
+		l.drop(conn)
+	}
+	return err
+}
+
+func (l *Leader) accept() {
+	for {
+		conn, err := l.listener.Accept()
+		if err != nil {
+			return
+		}
+		out := make(chan message, QUEUE_SIZE)
+		l.mutex.Lock()
+		l.peers[conn] = out
+		if l.started {
+			// A follower joining late starts from the current state
+			state := l.state
+			l.send(conn, out, message{Type: MESSAGE_STATE, State: &state})
+		}
+		l.mutex.Unlock()
+		log.Println("Follower", conn.RemoteAddr(), "joined")
+		go l.write(conn, out)
+		go l.read(conn, out)
+	}
+}
+
+// send queues a message for a follower, dropping the follower when it does not keep up. l.mutex must be held.
+func (l *Leader) send(conn net.Conn, out chan message, m message) {
+	select {
+	case out <- m:
+	default:
+		l.drop(conn)
+	}
+}
+
+// drop disconnects a follower. l.mutex must be held.
+func (l *Leader) drop(conn net.Conn) {
+	if out, ok := l.peers[conn]; ok {
+		delete(l.peers, conn)
+		close(out)
+		conn.Close()
+		log.Println("Follower", conn.RemoteAddr(), "left")
+	}
+}
+
+// write sends the queued messages to a follower.
+func (l *Leader) write(conn net.Conn, out chan message) {
+	encoder := json.NewEncoder(conn)
+	for m := range out {
+		conn.SetWriteDeadline(time.Now().Add(WRITE_TIMEOUT))
+		if err := encoder.Encode(m); err != nil {
+			break
+		}
+	}
+	l.mutex.Lock()
+	l.drop(conn)
+	l.mutex.Unlock()
+}
+
+// read answers the pings of a follower.
+func (l *Leader) read(conn net.Conn, out chan message) {
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		var m message
+		if json.Unmarshal(scanner.Bytes(), &m) != nil || m.Type != MESSAGE_PING {
+			continue
+		}
+		l.mutex.Lock()
+		if _, ok := l.peers[conn]; ok {
+			l.send(conn, out, message{Type: MESSAGE_PONG, Sent: m.Sent, Clock: time.Now().UnixNano()})
+		}
+		l.mutex.Unlock()
+	}
+	l.mutex.Lock()
+	l.drop(conn)
+	l.mutex.Unlock()
+}
+
+// sample is a clock measure.
+type sample struct {
+	offset    int64 // Leader clock minus local clock
+	roundTrip int64
+}
+
+// Follower receives the state of the show from a leader.
+type Follower struct {
+	address string
+	mutex   sync.Mutex
+	state   State
+	started bool     // A state was received
+	samples []sample // The latest clock measures
+	offset  int64    // Leader clock minus local clock
+	conn    net.Conn
+	closed  bool
+}
+
+// Follow connects to the leader at a TCP address, like screen1.local:7080,
+// in the background, and connects again each time the connection is lost.
+func Follow(address string) *Follower {
+	f := &Follower{address: address}
+	go f.run()
+	return f
+}
+
+// State returns the latest state of the leader, false before the first one.
+func (f *Follower) State() (State, bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.state, f.started
+}
+
+// Now returns the time of the leader clock.
+func (f *Follower) Now() int64 {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return time.Now().UnixNano() + f.offset
+}
+
+// Local returns the local time of a time of the leader clock.
+func (f *Follower) Local(t int64) time.Time {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return time.Unix(0, t-f.offset)
+}
+
+// Close disconnects from the leader.
+func (f *Follower) Close() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.closed = true
+	if f.conn != nil {
+		f.conn.Close()
+	}
+}
+
+func (f *Follower) isClosed() bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.closed
+}
+
+// run keeps a connection to the leader open.
+func (f *Follower) run() {
+	delay := time.Second
+	for !f.isClosed() {
+		conn, err := net.Dial("tcp", f.address)
+		if err != nil {
+			time.Sleep(delay)
+			if delay *= 2; delay > MAX_RECONNECT_DELAY {
+				delay = MAX_RECONNECT_DELAY
+			}
+			continue
+		}
+		delay = time.Second
+		f.mutex.Lock()
+		if f.closed {
+			f.mutex.Unlock()
+			conn.Close()
+			return
+		}
+		f.conn = conn
+		f.mutex.Unlock()
+		log.Println("Following", f.address)
+		f.session(conn)
+		conn.Close()
+		if !f.isClosed() {
+			log.Println("Lost the leader", f.address)
+		}
+	}
+}
+
+// session reads the messages of the leader, measuring the clock offset, until the connection fails.
+func (f *Follower) session(conn net.Conn) {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		encoder := json.NewEncoder(conn)
+		ticker := time.NewTicker(PING_INTERVAL)
+		defer ticker.Stop()
+		for {
+			conn.SetWriteDeadline(time.Now().Add(WRITE_TIMEOUT))
+			if encoder.Encode(message{Type: MESSAGE_PING, Sent: time.Now().UnixNano()}) != nil {
+				conn.Close()
+				return
+			}
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		var m message
+		if json.Unmarshal(scanner.Bytes(), &m) != nil {
+			continue
+		}
+		received := time.Now().UnixNano()
+		f.mutex.Lock()
+		switch {
+		case m.Type == MESSAGE_STATE && m.State != nil:
+			f.state, f.started = *m.State, true
+		case m.Type == MESSAGE_PONG && m.Sent > 0:
+			f.measure(sample{offset: m.Clock - (m.Sent+received)/2, roundTrip: received - m.Sent})
+		}
+		f.mutex.Unlock()
+	}
+}
+
+// measure adds a clock measure and sets the offset from the one with the
+// shortest round trip, the least disturbed by the network. f.mutex must be held.
+func (f *Follower) measure(s sample) {
+	f.samples = append(f.samples, s)
+	if len(f.samples) > CLOCK_SAMPLES {
+		f.samples = f.samples[1:]
+	}
+	best := f.samples[0]
+	for _, s := range f.samples[1:] {
+		if s.roundTrip < best.roundTrip {
+			best = s
+		}
+	}
+	f.offset = best.offset
+}
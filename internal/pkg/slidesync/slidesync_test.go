@@ -0,0 +1,236 @@
+package slidesync
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+/** Environment variable telling TestProcess which part it plays. */
+const ROLE_VARIABLE = "SLIDESYNC_TEST_ROLE"
+
+/** Environment variable giving the leader address to a follower process. */
+const ADDRESS_VARIABLE = "SLIDESYNC_TEST_ADDRESS"
+
+/** Number of images of the shows of the tests. */
+const COUNT = 5
+
+/** Longest wait for a process to tell something. */
+const TIMEOUT = 10 * time.Second
+
+// TestProcess is a leader or a follower when run by TestProcesses, and does nothing otherwise.
+func TestProcess(t *testing.T) {
+	switch os.Getenv(ROLE_VARIABLE) {
+	case "leader":
+		runLeader()
+	case "follower":
+		runFollower(os.Getenv(ADDRESS_VARIABLE))
+	default:
+		t.Skip("run as a subprocess by TestProcesses")
+	}
+	os.Exit(0)
+}
+
+// runLeader prints the address it listens on, then publishes a state for
+// each "show <index> <duration>" line of its input, until the input ends.
+func runLeader() {
+	l, err := Lead("127.0.0.1:0")
+	if err != nil {
+		fmt.Println("error", err)
+		return
+	}
+	defer l.Close()
+	fmt.Println("address", l.Addr())
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		var index int
+		var text string
+		if _, err := fmt.Sscanf(scanner.Text(), "show %d %s", &index, &text); err != nil {
+			fmt.Println("error", err)
+			continue
+		}
+		duration, err := time.ParseDuration(text)
+		if err != nil {
+			fmt.Println("error", err)
+			continue
+		}
+		l.Publish(State{Index: index, Count: COUNT, ShownAt: time.Now().UnixNano(), Duration: duration})
+	}
+}
+
+// runFollower prints "index <index>" each time the image the show is at
+// changes, as a slideshow following the leader would show it, until its input ends.
+func runFollower(address string) {
+	f := Follow(address)
+	defer f.Close()
+	go func() {
+		io.Copy(io.Discard, os.Stdin)
+		f.Close()
+		os.Exit(0)
+	}()
+	shown := -1
+	for range time.Tick(10 * time.Millisecond) {
+		state, ok := f.State()
+		if !ok {
+			continue
+		}
+		if index, _ := state.At(f.Now()); index != shown {
+			shown = index
+			fmt.Println("index", index)
+		}
+	}
+}
+
+// process is a leader or a follower run in another process.
+type process struct {
+	name  string
+	cmd   *exec.Cmd
+	input io.WriteCloser
+	lines chan string // The output lines
+}
+
+func start(t *testing.T, name string, environment ...string) *process {
+	t.Helper()
+	p := &process{name: name, cmd: exec.Command(os.Args[0], "-test.run=^TestProcess$"), lines: make(chan string, 100)}
+	p.cmd.Env = append(os.Environ(), environment...)
+	var err error
+	if p.input, err = p.cmd.StdinPipe(); err != nil {
+		t.Fatal(err)
+	}
+	output, err := p.cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = p.cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		scanner := bufio.NewScanner(output)
+		for scanner.Scan() {
+			p.lines <- scanner.Text()
+		}
+		close(p.lines)
+	}()
+	t.Cleanup(func() {
+		p.input.Close()
+		done := make(chan error, 1)
+		go func() { done <- p.cmd.Wait() }()
+		select {
+		case <-done:
+		case <-time.After(TIMEOUT):
+			p.cmd.Process.Kill()
+			<-done
+		}
+	})
+	return p
+}
+
+// next returns the next output line of the process.
+func (p *process) next(t *testing.T) string {
+	t.Helper()
+	select {
+	case line, ok := <-p.lines:
+		if !ok {
+			t.Fatalf("%s exited", p.name)
+		}
+		return line
+	case <-time.After(TIMEOUT):
+		t.Fatalf("%s told nothing for %v", p.name, TIMEOUT)
+	}
+	return ""
+}
+
+// waitFor reads the output of the process until a line, failing on an error line.
+func (p *process) waitFor(t *testing.T, want string) {
+	t.Helper()
+	deadline := time.After(TIMEOUT)
+	for {
+		select {
+		case line, ok := <-p.lines:
+			if !ok {
+				t.Fatalf("%s exited waiting for %q", p.name, want)
+			}
+			if strings.HasPrefix(line, "error") {
+				t.Fatalf("%s: %s", p.name, line)
+			}
+			if line == want {
+				return
+			}
+		case <-deadline:
+			t.Fatalf("%s did not tell %q in %v", p.name, want, TIMEOUT)
+		}
+	}
+}
+
+func (p *process) send(t *testing.T, line string) {
+	t.Helper()
+	if _, err := fmt.Fprintln(p.input, line); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestProcesses runs a leader and followers in separate processes, synchronized through localhost.
+func TestProcesses(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts processes")
+	}
+	leader := start(t, "leader", ROLE_VARIABLE+"=leader")
+	line := leader.next(t)
+	if !strings.HasPrefix(line, "address ") {
+		t.Fatalf("leader: %s", line)
+	}
+	address := ADDRESS_VARIABLE + "=" + strings.TrimPrefix(line, "address ")
+	followers := []*process{
+		start(t, "follower 1", ROLE_VARIABLE+"=follower", address),
+		start(t, "follower 2", ROLE_VARIABLE+"=follower", address),
+	}
+
+	// The followers go through the images the leader shows
+	for index := 0; index < 3; index++ {
+		leader.send(t, fmt.Sprintf("show %d 0s", index))
+		for _, f := range followers {
+			f.waitFor(t, fmt.Sprintf("index %d", index))
+		}
+	}
+
+	// A follower joining late starts on the current image
+	late := start(t, "late follower", ROLE_VARIABLE+"=follower", address)
+	if line := late.next(t); line != "index 2" {
+		t.Fatalf("late follower started with %q, want index 2", line)
+	}
+	followers = append(followers, late)
+
+	// The followers advance by themselves when the images have a duration
+	sent := time.Now()
+	leader.send(t, "show 3 300ms")
+	for _, f := range followers {
+		f.waitFor(t, "index 3")
+	}
+	for _, f := range followers {
+		for _, want := range []string{"index 4", "index 0"} {
+			if line := f.next(t); line != want {
+				t.Fatalf("%s told %q, want %q", f.name, line, want)
+			}
+		}
+	}
+	if elapsed := time.Since(sent); elapsed < 600*time.Millisecond {
+		t.Errorf("two images of 300ms shown in %v", elapsed)
+	}
+
+	// The leader understood every command
+	for {
+		select {
+		case line := <-leader.lines:
+			if strings.HasPrefix(line, "error") {
+				t.Fatalf("leader: %s", line)
+			}
+		default:
+			return
+		}
+	}
+}
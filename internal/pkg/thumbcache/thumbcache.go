@@ -0,0 +1,177 @@
+// Package thumbcache keeps JPEG thumbnails of image files in a cache
+// directory, by default ~/.cache/imagination/thumbs.
+//
+// Thumbnails are named after a hash of the absolute image path, its size and
+// its modification time, so an edited image gets a new thumbnail and stale
+// ones are simply never read again. Each thumbnail size has its own
+// directory.
+package thumbcache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/nicky-ayoub/imagination/internal/pkg/imageio"
+	"github.com/nicky-ayoub/imagination/internal/pkg/imageops"
+	"github.com/nicky-ayoub/imagination/internal/pkg/resize"
+)
+
+/** Longest edge of the thumbnails when none is given. */
+const DEFAULT_SIZE = 256
+
+/** JPEG quality of the thumbnails. */
+const QUALITY = 85
+
+// Cache is a thumbnail directory for one thumbnail size.
+type Cache struct {
+	Dir  string // Root of the cache, the thumbnails being in a subdirectory per size
+	Size int    // Longest edge of the thumbnails
+}
+
+// DefaultDir returns the cache directory of the user, or a directory in the
+// temporary directory when the user has none.
+func DefaultDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "imagination", "thumbs")
+}
+
+// New returns a cache, in DefaultDir when dir is empty, of DEFAULT_SIZE thumbnails when size is not positive.
+func New(dir string, size int) *Cache {
+	if dir == "" {
+		dir = DefaultDir()
+	}
+	if size <= 0 {
+		size = DEFAULT_SIZE
+	}
+	return &Cache{Dir: dir, Size: size}
+}
+
+// Path returns where the thumbnail of an image file is stored, whether it exists or not.
+func (c *Cache) Path(file string) (string, error) {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(abs)
+	if err != nil {
+		return "", err
+	}
+	key := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%d\x00%d", abs, info.Size(), info.ModTime().UnixNano())))
+	name := hex.EncodeToString(key[:])
+	return filepath.Join(c.Dir, strconv.Itoa(c.Size), name[:2], name+".jpg"), nil
+}
+
+// Get returns the thumbnail path of an image file when it is cached.
+func (c *Cache) Get(file string) (string, bool) {
+	path, err := c.Path(file)
+	if err != nil {
+		return "", false
+	}
+	if _, err := os.Stat(path); err != nil {
+		return "", false
+	}
+	return path, true
+}
+
+// Make returns the thumbnail path of an image file, creating the thumbnail
+// when it is not cached or when force is set. created tells whether it was.
+func (c *Cache) Make(file string, force bool) (path string, created bool, err error) {
+	if path, err = c.Path(file); err != nil {
+		return "", false, err
+	}
+	if !force {
+		if _, err := os.Stat(path); err == nil {
+			return path, false, nil
+		}
+	}
+
+	img, err := Thumbnail(file, c.Size)
+	if err != nil {
+		return "", false, err
+	}
+	var buffer bytes.Buffer
+	if err = imageio.Encode(&buffer, img, "jpeg", QUALITY); err != nil {
+		return "", false, err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", false, err
+	}
+	// Write then rename, so concurrent readers never see a partial thumbnail
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".thumb.*")
+	if err != nil {
+		return "", false, err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(buffer.Bytes()); err != nil {
+		tmp.Close()
+		return "", false, err
+	}
+	if err = tmp.Close(); err != nil {
+		return "", false, err
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return "", false, err
+	}
+	return path, true, nil
+}
+
+// Thumbnail decodes an image file and returns it upright, its longest edge scaled down to size.
+func Thumbnail(file string, size int) (image.Image, error) {
+	img, _, err := imageio.Load(file)
+	if err != nil {
+		return nil, err
+	}
+	if t := imageops.Orientation(file); !t.IsIdentity() {
+		img = imageops.Apply(img, t)
+	}
+	spec := resize.Spec{Mode: resize.MODE_MAX_EDGE, Width: size}
+	return resize.Resize(img, spec.Size(img.Bounds().Size(), false), resize.CATMULL_ROM), nil
+}
+
+// Names of the directories and files of the cache, by depth: sizes,
+// hash prefixes and thumbnails, see Path.
+var layout = []*regexp.Regexp{
+	regexp.MustCompile(`^[0-9]+$`),
+	regexp.MustCompile(`^[0-9a-f]{2}$`),
+	regexp.MustCompile(`^([0-9a-f]{64}\.jpg|\.thumb\..*)$`),
+}
+
+// Clean removes the cached thumbnails of every size. As the cache directory
+// can be given by the user, nothing is removed unless it only holds
+// thumbnails, laid out as Path names them.
+func (c *Cache) Clean() error {
+	err := filepath.WalkDir(c.Dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == c.Dir {
+			return err
+		}
+		rel, err := filepath.Rel(c.Dir, path)
+		if err != nil {
+			return err
+		}
+		depth := strings.Count(filepath.ToSlash(rel), "/")
+		if depth >= len(layout) || !layout[depth].MatchString(d.Name()) || d.IsDir() != (depth < len(layout)-1) {
+			return fmt.Errorf("%s is not a thumbnail cache, it holds %s", c.Dir, rel)
+		}
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(c.Dir)
+}
@@ -0,0 +1,78 @@
+package thumbcache
+
+import (
+	"image"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nicky-ayoub/imagination/internal/pkg/imageio"
+)
+
+func TestMake(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "image.png")
+	if err := imageio.Save(file, image.NewGray(image.Rect(0, 0, 400, 100)), 0); err != nil {
+		t.Fatal(err)
+	}
+	c := New(filepath.Join(t.TempDir(), "thumbs"), 64)
+	path, created, err := c.Make(file, false)
+	if err != nil || !created {
+		t.Fatalf("created %v, %v", created, err)
+	}
+	config, _, err := imageio.Config(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Width != 64 || config.Height != 16 {
+		t.Errorf("thumbnail of %dx%d, want 64x16", config.Width, config.Height)
+	}
+	if cached, ok := c.Get(file); !ok || cached != path {
+		t.Errorf("cached thumbnail %q, want %q", cached, path)
+	}
+	if _, created, err = c.Make(file, false); err != nil || created {
+		t.Errorf("created again %v, %v", created, err)
+	}
+	if _, _, err = New(c.Dir, 128).Make(file, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = c.Clean(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(c.Dir); !os.IsNotExist(err) {
+		t.Errorf("cache directory left: %v", err)
+	}
+	if err = c.Clean(); err != nil {
+		t.Errorf("cleaning a missing cache: %v", err)
+	}
+}
+
+// TestCleanOther checks Clean keeps directories that are not a thumbnail cache.
+func TestCleanOther(t *testing.T) {
+	for _, files := range [][]string{
+		{"photo.jpg"},
+		{"2021/01/IMG_0001.jpg"},
+		{"256/ab/notes.txt"},
+		{"256/ab/0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef.jpg", "256/ab/cd/photo.jpg"},
+		{"256/0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef.jpg"},
+	} {
+		dir := t.TempDir()
+		for _, name := range files {
+			path := filepath.Join(dir, filepath.FromSlash(name))
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := New(dir, 0).Clean(); err == nil {
+			t.Errorf("%q: removed", files)
+		}
+		for _, name := range files {
+			if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name))); err != nil {
+				t.Errorf("%q: %v", files, err)
+			}
+		}
+	}
+}
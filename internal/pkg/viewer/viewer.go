@@ -0,0 +1,323 @@
+// Package viewer is the part of the image viewers that does not depend on a
+// windowing library: the list of images and the navigation in it, how the
+// current image is viewed (zoom, fitting, flipping and rotation), and the
+// keymap actions changing them.
+//
+// A front end implements Renderer to display what the Viewer shows, runs the
+// actions of Actions() for the strokes of its keymap, and registers its own
+// actions (fullscreen, help...) in the same registry.
+package viewer
+
+import (
+	"errors"
+	"image"
+	"log"
+	"math/rand"
+
+	"github.com/nicky-ayoub/imagination/internal/pkg/imageops"
+	"github.com/nicky-ayoub/imagination/internal/pkg/keymap"
+	"github.com/nicky-ayoub/imagination/internal/pkg/playlist"
+)
+
+/** Largest zoom factor, each zoom step doubling it. */
+const MAX_ZOOM = 16
+
+// Flipping modes, in the order the flip action cycles through them.
+const (
+	FLIP_NONE = iota
+	FLIP_HORIZONTAL
+	FLIP_VERTICAL
+	FLIP_BOTH
+	FLIP_MODES // How many flipping modes are available
+)
+
+// ErrEmpty is returned when there is no image left to show.
+var ErrEmpty = errors.New("no image to display")
+
+// View is how the current image is displayed.
+type View struct {
+	Zoom  int         // 1 shows the whole image, each zoom step doubles it
+	Focus image.Point // Window point the last zoom step was aimed at
+	Scale bool        // Images smaller than the window are enlarged to it
+	Flip  int         // FLIP_NONE, FLIP_HORIZONTAL, FLIP_VERTICAL or FLIP_BOTH
+	Turns int         // Clockwise quarter turns, done after flipping
+}
+
+// Transform returns the flipping and rotation of the view.
+func (v View) Transform() imageops.Transform {
+	var t imageops.Transform
+	switch v.Flip {
+	case FLIP_HORIZONTAL:
+		t = imageops.FLIP_HORIZONTAL
+	case FLIP_VERTICAL:
+		t = imageops.FLIP_VERTICAL
+	case FLIP_BOTH:
+		t = imageops.ROTATE_180
+	}
+	return t.Then(imageops.Transform{Turns: v.Turns})
+}
+
+// Renderer displays the images of a viewer.
+type Renderer interface {
+	// Load displays the image at an index of the list, an error telling why it can not.
+	Load(index int, path string, item playlist.Item) error
+	// SetView changes how the loaded image is displayed. It follows each Load.
+	SetView(v View)
+	// Center returns the window center, which keyboard zooms are aimed at.
+	Center() image.Point
+}
+
+// Viewer is the state shared by the front ends.
+type Viewer struct {
+	OnError func(err error) // Called when an action fails, log.Fatal when nil
+
+	list     *playlist.Playlist
+	paths    []string
+	index    int
+	view     View
+	renderer Renderer
+	actions  *keymap.Registry
+	quit     bool
+}
+
+// New returns a viewer of the images of a list, nothing being shown before Show is called.
+func New(list *playlist.Playlist, renderer Renderer) *Viewer {
+	v := &Viewer{list: list, paths: list.Paths(), renderer: renderer, view: View{Zoom: 1}}
+	v.registerActions()
+	return v
+}
+
+// Shuffle puts the images in a random order, the same for a same seed, so
+// previous and next walk the same order. The list given to New is left in
+// its order, List returns the shuffled one.
+func (v *Viewer) Shuffle(seed int64) {
+	shuffled := *v.list
+	shuffled.Items = append([]playlist.Item{}, v.list.Items...)
+	r := rand.New(rand.NewSource(seed))
+	r.Shuffle(len(shuffled.Items), func(i, j int) { shuffled.Items[i], shuffled.Items[j] = shuffled.Items[j], shuffled.Items[i] })
+	v.list, v.paths = &shuffled, shuffled.Paths()
+}
+
+// registerActions declares what the viewer does for the navigation and view actions.
+func (v *Viewer) registerActions() {
+	run := func(action func() error) keymap.Handler {
+		return func(keymap.Trigger) {
+			if err := action(); err != nil {
+				v.fail(err)
+			}
+		}
+	}
+	focus := func(t keymap.Trigger) image.Point {
+		if t.Mouse {
+			return t.At
+		}
+		return v.renderer.Center()
+	}
+	v.actions = keymap.NewRegistry()
+	v.actions.Register(keymap.ACTION_NEXT, "", run(v.Next))
+	v.actions.Register(keymap.ACTION_PREV, "", run(v.Prev))
+	v.actions.Register(keymap.ACTION_FIRST, "", run(v.First))
+	v.actions.Register(keymap.ACTION_LAST, "", run(v.Last))
+	v.actions.Register(keymap.ACTION_ZOOM_IN, "", func(t keymap.Trigger) { v.ZoomIn(focus(t)) })
+	v.actions.Register(keymap.ACTION_ZOOM_OUT, "", func(t keymap.Trigger) { v.ZoomOut(focus(t)) })
+	v.actions.Register(keymap.ACTION_FIT, "", func(keymap.Trigger) { v.Fit() })
+	v.actions.Register(keymap.ACTION_FLIP, "", func(keymap.Trigger) { v.Flip() })
+	v.actions.Register(keymap.ACTION_ROTATE, "", func(keymap.Trigger) { v.Rotate() })
+	v.actions.Register(keymap.ACTION_QUIT, "", func(keymap.Trigger) { v.quit = true })
+}
+
+func (v *Viewer) fail(err error) {
+	if v.OnError != nil {
+		v.OnError(err)
+		return
+	}
+	log.Fatal(err)
+}
+
+// Actions returns the registry of the viewer actions, where front ends add theirs.
+func (v *Viewer) Actions() *keymap.Registry {
+	return v.actions
+}
+
+// Quitting tells whether the quit action ran.
+func (v *Viewer) Quitting() bool {
+	return v.quit
+}
+
+// Len returns the number of images.
+func (v *Viewer) Len() int {
+	return len(v.paths)
+}
+
+// Index returns the index of the current image, from 0.
+func (v *Viewer) Index() int {
+	return v.index
+}
+
+// Path returns the file of the current image.
+func (v *Viewer) Path() string {
+	return v.paths[v.index]
+}
+
+// Paths returns the files of the images, in the order they are shown.
+func (v *Viewer) Paths() []string {
+	return v.paths
+}
+
+// Item returns the playlist entry of the current image.
+func (v *Viewer) Item() playlist.Item {
+	return v.list.Items[v.index]
+}
+
+// List returns the images.
+func (v *Viewer) List() *playlist.Playlist {
+	return v.list
+}
+
+// Title returns the playlist caption of the current image, or its file.
+func (v *Viewer) Title() string {
+	if caption := v.Item().Caption; caption != "" {
+		return caption
+	}
+	return v.Path()
+}
+
+// Show displays an image, the index wrapping around, upright as its EXIF
+// orientation tells. The current image is kept when it can not be loaded.
+func (v *Viewer) Show(index int) error {
+	if len(v.paths) == 0 {
+		return ErrEmpty
+	}
+	index = (index%len(v.paths) + len(v.paths)) % len(v.paths)
+	if err := v.renderer.Load(index, v.paths[index], v.list.Items[index]); err != nil {
+		return err
+	}
+	v.index = index
+
+	orientation := imageops.Orientation(v.paths[index])
+	v.view = View{Zoom: 1, Turns: orientation.Turns}
+	if orientation.Mirror {
+		v.view.Flip = FLIP_HORIZONTAL
+	}
+	v.renderer.SetView(v.view)
+	return nil
+}
+
+// Next shows the next image.
+func (v *Viewer) Next() error {
+	return v.Show(v.index + 1)
+}
+
+// Prev shows the previous image.
+func (v *Viewer) Prev() error {
+	return v.Show(v.index - 1)
+}
+
+// First shows the first image.
+func (v *Viewer) First() error {
+	return v.Show(0)
+}
+
+// Last shows the last image.
+func (v *Viewer) Last() error {
+	return v.Show(len(v.paths) - 1)
+}
+
+// SetList replaces the images, showing the first one. The current list is
+// kept when the new one is empty or its first image can not be loaded.
+func (v *Viewer) SetList(list *playlist.Playlist) error {
+	paths := list.Paths()
+	if len(paths) == 0 {
+		return ErrEmpty
+	}
+	previousList, previousPaths, previousIndex := v.list, v.paths, v.index
+	v.list, v.paths = list, paths
+	if err := v.Show(0); err != nil {
+		v.list, v.paths, v.index = previousList, previousPaths, previousIndex
+		return err
+	}
+	return nil
+}
+
+// Remove takes the current image out of the list, showing the following one.
+// ErrEmpty is returned when it was the last image. The list is kept, still
+// showing the current image, when the following one can not be loaded.
+func (v *Viewer) Remove() error {
+	if len(v.paths) == 1 {
+		v.list.Items, v.paths = v.list.Items[:0], v.paths[:0]
+		return ErrEmpty
+	}
+	previousItems, previousPaths, previousIndex := v.list.Items, v.paths, v.index
+	v.list.Items = append(append([]playlist.Item{}, v.list.Items[:v.index]...), v.list.Items[v.index+1:]...)
+	v.paths = append(append([]string{}, v.paths[:v.index]...), v.paths[v.index+1:]...)
+	// Removing the last image wraps around to the first one
+	if err := v.Show(v.index % len(v.paths)); err != nil {
+		v.list.Items, v.paths, v.index = previousItems, previousPaths, previousIndex
+		return err
+	}
+	return nil
+}
+
+// View returns how the current image is displayed.
+func (v *Viewer) View() View {
+	return v.view
+}
+
+// setView displays the image with another view.
+func (v *Viewer) setView(view View) {
+	if view != v.view {
+		v.view = view
+		v.renderer.SetView(view)
+	}
+}
+
+// ZoomIn doubles the zoom, aiming at a window point.
+func (v *Viewer) ZoomIn(focus image.Point) {
+	view := v.view
+	if view.Zoom < MAX_ZOOM {
+		view.Zoom *= 2
+		view.Focus = focus
+	}
+	v.setView(view)
+}
+
+// ZoomOut halves the zoom, aiming at a window point.
+func (v *Viewer) ZoomOut(focus image.Point) {
+	view := v.view
+	if view.Zoom > 1 {
+		view.Zoom /= 2
+		view.Focus = focus
+	}
+	v.setView(view)
+}
+
+// ResetZoom shows the whole image again, not enlarged, as the front ends do
+// when the window is resized.
+func (v *Viewer) ResetZoom() {
+	view := v.view
+	view.Zoom, view.Focus, view.Scale = 1, image.Point{}, false
+	v.setView(view)
+}
+
+// Fit shows the whole image, enlarged to the window when it is smaller.
+func (v *Viewer) Fit() {
+	view := v.view
+	view.Zoom, view.Focus, view.Scale = 1, image.Point{}, true
+	v.setView(view)
+}
+
+// Flip selects the next flipping mode, showing the whole image.
+func (v *Viewer) Flip() {
+	view := v.view
+	view.Flip = (view.Flip + 1) % FLIP_MODES
+	view.Zoom, view.Focus, view.Scale = 1, image.Point{}, false
+	v.setView(view)
+}
+
+// Rotate turns the image by 90 degrees clockwise, showing the whole image.
+func (v *Viewer) Rotate() {
+	view := v.view
+	view.Turns = (view.Turns + 1) % 4
+	view.Zoom, view.Focus, view.Scale = 1, image.Point{}, false
+	v.setView(view)
+}
@@ -0,0 +1,78 @@
+package viewer
+
+import (
+	"errors"
+	"image"
+	"reflect"
+	"testing"
+
+	"github.com/nicky-ayoub/imagination/internal/pkg/playlist"
+)
+
+// fakeRenderer records the loaded images, failing for the broken ones.
+type fakeRenderer struct {
+	broken map[string]bool
+	path   string
+}
+
+func (r *fakeRenderer) Load(index int, path string, item playlist.Item) error {
+	if r.broken[path] {
+		return errors.New("broken " + path)
+	}
+	r.path = path
+	return nil
+}
+
+func (r *fakeRenderer) SetView(v View) {}
+
+func (r *fakeRenderer) Center() image.Point {
+	return image.Point{}
+}
+
+func TestShuffle(t *testing.T) {
+	paths := []string{"a.jpg", "b.jpg", "c.jpg", "d.jpg", "e.jpg", "f.jpg"}
+	list := playlist.FromPaths("", paths)
+	v := New(list, &fakeRenderer{})
+	v.Shuffle(1)
+	if !reflect.DeepEqual(list.Paths(), paths) {
+		t.Errorf("list given to New shuffled: %q", list.Paths())
+	}
+	if reflect.DeepEqual(v.Paths(), paths) || !reflect.DeepEqual(v.List().Paths(), v.Paths()) {
+		t.Errorf("viewer paths %q, list %q, want the same shuffled order", v.Paths(), v.List().Paths())
+	}
+	other := New(playlist.FromPaths("", paths), &fakeRenderer{})
+	other.Shuffle(1)
+	if !reflect.DeepEqual(other.Paths(), v.Paths()) {
+		t.Errorf("same seed: %q, want %q", other.Paths(), v.Paths())
+	}
+}
+
+func TestRemove(t *testing.T) {
+	r := &fakeRenderer{broken: map[string]bool{"c.jpg": true}}
+	v := New(playlist.FromPaths("", []string{"a.jpg", "b.jpg", "c.jpg"}), r)
+	if err := v.Show(1); err != nil {
+		t.Fatal(err)
+	}
+
+	// c.jpg can not be shown, b.jpg stays
+	if err := v.Remove(); err == nil {
+		t.Fatal("no error showing a broken image")
+	}
+	if v.Path() != "b.jpg" || r.path != "b.jpg" || v.Len() != 3 {
+		t.Errorf("current image %s, displayed %s, %d images, want b.jpg of 3", v.Path(), r.path, v.Len())
+	}
+
+	delete(r.broken, "c.jpg")
+	if err := v.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if v.Path() != "c.jpg" || r.path != "c.jpg" || !reflect.DeepEqual(v.Paths(), []string{"a.jpg", "c.jpg"}) {
+		t.Errorf("current image %s, displayed %s, images %q, want c.jpg of a.jpg and c.jpg", v.Path(), r.path, v.Paths())
+	}
+	if err := v.Remove(); err != nil || v.Path() != "a.jpg" {
+		t.Errorf("removing the last image: %v, showing %s, want a.jpg", err, v.Path())
+	}
+	if err := v.Remove(); err != ErrEmpty || v.Len() != 0 {
+		t.Errorf("removing the only image: %v, %d images, want %v", err, v.Len(), ErrEmpty)
+	}
+}
@@ -1,8 +1,20 @@
 package viewport
 
 import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
 	"log"
+	"math"
+	"sort"
+	"strings"
+	"unsafe"
 
+	"github.com/nicky-ayoub/imagination/internal/pkg/adjust"
+	"github.com/nicky-ayoub/imagination/internal/pkg/histogram"
+	"github.com/nicky-ayoub/imagination/internal/pkg/overlay"
+	"github.com/nicky-ayoub/imagination/internal/pkg/pyramid"
 	"github.com/veandco/go-sdl2/sdl"
 )
 
@@ -20,6 +32,39 @@ const (
 	FLIPPING_MODE_IDS_COUNT                                                 //!< How many flipping modes are available.
 )
 
+/** All available rotations, clockwise. */
+type TViewportRotationID int
+
+const (
+	ROTATION_ID_0      TViewportRotationID = iota //!< The image is not rotated.
+	ROTATION_ID_90                                //!< Quarter turn.
+	ROTATION_ID_180                               //!< Half turn.
+	ROTATION_ID_270                               //!< Three quarter turn.
+	ROTATION_IDS_COUNT                            //!< How many rotations are available.
+)
+
+/** Panels that can be displayed over the image, drawn in this order. */
+type TViewportOverlayID int
+
+const (
+	OVERLAY_ID_HUD       TViewportOverlayID = iota //!< Image information and viewing state.
+	OVERLAY_ID_HISTOGRAM                           //!< RGB and luma histogram.
+	OVERLAY_ID_INSPECTOR                           //!< Values of the pixel under the mouse.
+	OVERLAY_ID_HELP                                //!< Key bindings list.
+	OVERLAY_IDS_COUNT                              //!< How many overlays are available.
+)
+
+/** Where an overlay is placed in the window. */
+type TViewportAnchorID int
+
+const (
+	ANCHOR_ID_CENTER       TViewportAnchorID = iota //!< Centered in the window.
+	ANCHOR_ID_TOP_LEFT                              //!< Top left corner.
+	ANCHOR_ID_TOP_RIGHT                             //!< Top right corner.
+	ANCHOR_ID_BOTTOM_LEFT                           //!< Bottom left corner.
+	ANCHOR_ID_BOTTOM_RIGHT                          //!< Bottom right corner.
+)
+
 //-------------------------------------------------------------------------------------------------
 // Constants
 //-------------------------------------------------------------------------------------------------
@@ -32,9 +77,25 @@ const (
 	VIEWPORT_MINIMUM_WINDOW_WIDTH int32 = 100
 	/** Window minimum height in pixels. */
 	VIEWPORT_MINIMUM_WINDOW_HEIGHT int32 = 100
+	/** Distance between the overlays anchored to a corner and the window borders, in pixels. */
+	VIEWPORT_OVERLAY_MARGIN int32 = 10
+	/** Opacity of the shade darkening the image outside of the crop rectangle. */
+	VIEWPORT_CROP_SHADE uint8 = 144
+	/** Side of the tiles large images are drawn with, in pixels. */
+	VIEWPORT_TILE_SIZE = 512
+	/** Largest side of the preview drawn under the tiles of large images, in pixels. */
+	VIEWPORT_PREVIEW_SIZE = 2048
+	/** How many tile textures are kept, the least recently drawn ones being destroyed beyond. */
+	VIEWPORT_MAXIMUM_TILE_TEXTURES = 192
+	/** How many tiles are uploaded per frame, so zooming and panning stay smooth, the others coming with the next frames. */
+	VIEWPORT_TILE_UPLOADS_PER_FRAME = 8
 )
 
 type viewport struct {
+	window_width          int32
+	window_height         int32
+	window_flags          uint32
+	background            sdl.Color
 	width                 int32
 	height                int32
 	original_width        int32
@@ -43,7 +104,37 @@ type viewport struct {
 	adjusted_width        int32
 	adjusted_height       int32
 	flip_mode             sdl.RendererFlip
+	rotation              TViewportRotationID
+	image_rect            sdl.Rect // Where the (rotated) image lies in the adapted texture
 	srcRect               sdl.Rect
+	source                *image.NRGBA // Copy of the loaded image pixels, for analysis
+	displayed             *image.NRGBA // source with the color adjustments applied
+	recipe                adjust.Recipe
+}
+
+type hud struct {
+	visible bool
+	lines   []string // Image information given by the application
+	state   string   // Text of the displayed panel, to know when it must be rendered again
+	anchor  TViewportAnchorID
+	opacity float64
+}
+
+type layer struct {
+	texture *sdl.Texture
+	width   int32
+	height  int32
+	anchor  TViewportAnchorID
+}
+
+type tileKey struct {
+	level int
+	index image.Point
+}
+
+type tileTexture struct {
+	texture *sdl.Texture
+	frame   uint64 // Last frame the tile was drawn in
 }
 
 var vp viewport
@@ -51,19 +142,79 @@ var vp viewport
 var window *sdl.Window
 var renderer *sdl.Renderer
 
+/** The surface drawn by the software renderer when the viewport has no window, nil otherwise. */
+var target *sdl.Surface
+
 /** The texture holding the loaded image. */
 var texture *sdl.Texture = nil
 
 /** The texture holding the image adapted to the current viewport dimensions. */
 var adapted_texture *sdl.Texture = nil
 
+/** The panels displayed over the image. */
+var overlays [OVERLAY_IDS_COUNT]layer
+
+/** Images with a side larger than this are drawn in tiles, 0 follows the renderer limit. */
+var maximum_texture_size int32
+
+/** The resolution pyramid of an image too large for a texture, nil when the image has a texture. */
+var tiles *pyramid.Pyramid
+
+/** The coarsest level of the pyramid, drawn under the tiles, and whether it is averaged from the image rather than a first preview. */
+var preview_texture *sdl.Texture
+var preview_final bool
+
+/** The tile textures uploaded so far. */
+var tile_textures = map[tileKey]*tileTexture{}
+
+/** Frames drawn since the image was loaded, to find the least recently drawn tiles. */
+var tile_frame uint64
+
+/** Whether visible tiles were not drawn in the last frame, waiting to be built or uploaded. */
+var tiles_missing bool
+
+/** Whether the decoding error of the large image was reported. */
+var tiles_failed bool
+
+/** The heads-up display settings and content. */
+var hd = hud{anchor: ANCHOR_ID_TOP_LEFT, opacity: 0.7}
+
+/** The crop rectangle displayed over the image, in loaded image pixels. */
+var crop_area image.Rectangle
+
+/** Whether the histogram and the pixel inspector are displayed. */
+var histogram_visible, inspector_visible bool
+
+/** Anchor names, as written in the configuration. */
+var anchor_names = map[string]TViewportAnchorID{
+	"center":       ANCHOR_ID_CENTER,
+	"top-left":     ANCHOR_ID_TOP_LEFT,
+	"top-right":    ANCHOR_ID_TOP_RIGHT,
+	"bottom-left":  ANCHOR_ID_BOTTOM_LEFT,
+	"bottom-right": ANCHOR_ID_BOTTOM_RIGHT,
+}
+
 func init() {
 	vp.flip_mode = sdl.FLIP_NONE
+	vp.window_width = 640
+	vp.window_height = 480
+	vp.window_flags = sdl.WINDOW_RESIZABLE | sdl.WINDOW_MAXIMIZED
+	vp.background = sdl.Color{R: 192, G: 192, B: 192, A: 0}
 }
 
 //-------------------------------------------------------------------------------------------------
 // Private functions
 //-------------------------------------------------------------------------------------------------
+/** Get the image dimensions once rotated.
+ * @return The displayed image width and height in pixels.
+ */
+func displayedSize() (width int32, height int32) {
+	if vp.rotation == ROTATION_ID_90 || vp.rotation == ROTATION_ID_270 {
+		return vp.original_height, vp.original_width
+	}
+	return vp.original_width, vp.original_height
+}
+
 /** Add eventual additional borders to the original image to make sure its ratio is kept regardless of the viewport dimensions.
  * @param Image_Width The image to display width in pixels.
  * @param Image_Height The image to display height in pixels.
@@ -75,6 +226,11 @@ func adaptImage(width int32, height int32) (err error) {
 	var Vertical_Scaling_Percentage int32
 	var dstRect sdl.Rect
 
+	// The viewport size is not known yet, SetDimensions() will adapt the image
+	if vp.width <= 0 || vp.height <= 0 {
+		return nil
+	}
+
 	//fmt.Println("AdaptImage(", Image_Width, ", ", Image_Height, ")")
 	//fmt.Printf("Viewport(%d, %d)\n", vp.width, vp.height)
 
@@ -98,6 +254,13 @@ func adaptImage(width int32, height int32) (err error) {
 		}
 	}
 
+	// Large images are drawn tile by tile straight to the window, only the image place in the adjusted size is needed
+	if tiles != nil {
+		vp.image_rect = sdl.Rect{X: vp.adjusted_width/2 - width/2, Y: vp.adjusted_height/2 - height/2, W: width, H: height}
+		SetZoomedArea(0, 0, 1)
+		return nil
+	}
+
 	// Remove the previously existing texture
 	if adapted_texture != nil {
 		adapted_texture.Destroy()
@@ -116,8 +279,8 @@ func adaptImage(width int32, height int32) (err error) {
 		log.Fatal(err)
 		return err
 	}
-	// Set a black background color
-	if err = renderer.SetDrawColor(192, 192, 192, 0); err != nil {
+	// Set the configured background color
+	if err = renderer.SetDrawColor(vp.background.R, vp.background.G, vp.background.B, vp.background.A); err != nil {
 		log.Fatal(err)
 		return err
 	}
@@ -127,13 +290,24 @@ func adaptImage(width int32, height int32) (err error) {
 		return err
 	}
 
-	// Copy the original image on the adjusted image
+	// Copy the original image on the adjusted image (the destination rectangle is given before rotation, the rotation is done around its center)
+	if vp.rotation == ROTATION_ID_90 || vp.rotation == ROTATION_ID_270 {
+		width, height = height, width
+	}
 	dstRect.X = renderer.GetViewport().W/2 - width/2
 	dstRect.Y = renderer.GetViewport().H/2 - height/2
 	dstRect.W = width - 1
 	dstRect.H = height - 1
 
-	if err = renderer.CopyEx(texture, nil, &dstRect, 0, nil, vp.flip_mode); err != nil {
+	// Remember the image footprint once rotated to map window coordinates to the image
+	vp.image_rect.W, vp.image_rect.H = width, height
+	if vp.rotation == ROTATION_ID_90 || vp.rotation == ROTATION_ID_270 {
+		vp.image_rect.W, vp.image_rect.H = height, width
+	}
+	vp.image_rect.X = renderer.GetViewport().W/2 - vp.image_rect.W/2
+	vp.image_rect.Y = renderer.GetViewport().H/2 - vp.image_rect.H/2
+
+	if err = renderer.CopyEx(texture, nil, &dstRect, 90*float64(vp.rotation), nil, vp.flip_mode); err != nil {
 		log.Fatal(err)
 		return err
 	}
@@ -150,25 +324,22 @@ func adaptImage(width int32, height int32) (err error) {
 	return
 }
 
-//-------------------------------------------------------------------------------------------------
-// Public functions
-//-------------------------------------------------------------------------------------------------
-
-func ScreenCenter() (X int32, Y int32) {
-	X = renderer.GetViewport().W / 2
-	Y = renderer.GetViewport().H / 2
-	return
-}
-
-func Initialize(title string, image *sdl.Surface) (err error) {
+/** Create the window and its renderer, the first time an image is displayed.
+ * @param title The window title.
+ * @return nil if the function succeeded,
+ * @return the SDL error if the window or the renderer could not be created.
+ */
+func createRenderer(title string) (err error) {
 	// Try to create the viewport window
-	if window == nil {
-		if window, err = sdl.CreateWindow("-", 0, 0, 640, 480, sdl.WINDOW_RESIZABLE|sdl.WINDOW_MAXIMIZED); err != nil {
+	if window == nil && target == nil {
+		if window, err = sdl.CreateWindow("-", 0, 0, vp.window_width, vp.window_height, vp.window_flags); err != nil {
 			log.Fatal(err)
 			return err
 		}
 	}
-	window.SetTitle(title)
+	if window != nil {
+		window.SetTitle(title)
+	}
 
 	// Try to create an hardware-accelerated renderer to plug to the window
 	if renderer == nil {
@@ -177,40 +348,987 @@ func Initialize(title string, image *sdl.Surface) (err error) {
 			return err
 		}
 	}
+
+	// Do not allow the window to be too small because it can prevent the texture rendering from working
+	if window != nil {
+		window.SetMinimumSize(VIEWPORT_MINIMUM_WINDOW_WIDTH, VIEWPORT_MINIMUM_WINDOW_HEIGHT)
+	}
+	return nil
+}
+
+/** Get the largest texture the renderer can create.
+ * @return The largest texture width and height in pixels, 0 when there is no limit.
+ */
+func maximumTextureSize() (width int32, height int32) {
+	if maximum_texture_size != 0 {
+		return maximum_texture_size, maximum_texture_size
+	}
+	info, err := renderer.GetInfo()
+	if err != nil {
+		return 0, 0
+	}
+	// 0 means there is no limit
+	return info.MaxTextureWidth, info.MaxTextureHeight
+}
+
+/** Get the tile and preview sizes of the resolution pyramids, so both fit in a texture.
+ * @return The tile side and the largest preview side in pixels.
+ */
+func pyramidSizes() (tileSize int, previewSize int) {
+	tileSize, previewSize = VIEWPORT_TILE_SIZE, VIEWPORT_PREVIEW_SIZE
+	Maximum_Width, Maximum_Height := maximumTextureSize()
+	for _, limit := range []int32{Maximum_Width, Maximum_Height} {
+		if limit > 0 && int(limit) < tileSize {
+			tileSize = int(limit)
+		}
+		if limit > 0 && int(limit) < previewSize {
+			previewSize = int(limit)
+		}
+	}
+	return tileSize, previewSize
+}
+
+/** Destroy the texture of the previous image, or stop building its resolution pyramid and destroy its tiles. */
+func releaseImage() {
 	if texture != nil {
 		texture.Destroy()
+		texture = nil
 	}
-	// Convert the image surface to a texture
-	if texture, err = renderer.CreateTextureFromSurface(image); err != nil {
-		log.Fatal(err)
+	// The image is not drawn through an adapted texture anymore
+	if adapted_texture != nil {
+		adapted_texture.Destroy()
+		adapted_texture = nil
+	}
+	if tiles != nil {
+		tiles.Close()
+		tiles = nil
+	}
+	releaseTileTextures()
+	tiles_failed = false
+}
+
+/** Destroy the tile textures, to upload them again with other colors for instance. */
+func releaseTileTextures() {
+	if preview_texture != nil {
+		preview_texture.Destroy()
+		preview_texture = nil
+	}
+	preview_final = false
+	for key, tile := range tile_textures {
+		tile.texture.Destroy()
+		delete(tile_textures, key)
+	}
+	tile_frame = 0
+	tiles_missing = false
+}
+
+/** Draw a large image: its preview, then the visible tiles of the pyramid level matching the zoom. Tiles not built or uploaded yet leave the preview visible, tiles_missing telling a next frame will show more.
+ */
+func drawTiles() {
+	renderer.SetDrawColor(vp.background.R, vp.background.G, vp.background.B, vp.background.A)
+	renderer.Clear()
+	tile_frame++
+	tiles_missing = false
+
+	// Nothing more will come from an image that can not be decoded
+	decoding := true
+	if err := tiles.Err(); err != nil {
+		if !tiles_failed {
+			log.Println(err)
+			tiles_failed = true
+		}
+		decoding = false
+	}
+
+	// Upload the preview once there is one, and again once it is averaged from the image
+	preview, final := tiles.Preview()
+	if preview != nil && (preview_texture == nil || (final && !preview_final)) {
+		if preview_texture != nil {
+			preview_texture.Destroy()
+			preview_texture = nil
+		}
+		var err error
+		if preview_texture, err = uploadTile(preview); err != nil {
+			log.Println(err)
+			return
+		}
+		preview_final = final
+	}
+	if preview_texture != nil {
+		drawTile(preview_texture, ImageBounds())
+	}
+	if !final && decoding {
+		tiles_missing = true
+	}
+
+	// Use the coarsest level with a pixel per displayed pixel, or a coarser one while it is built, the preview being enough beyond
+	level := tiles.LevelFor(float64(ZoomPercentage()) / 100)
+	for ; level < tiles.Levels()-1 && !tiles.Ready(level); level++ {
+		if decoding {
+			tiles_missing = true
+		}
+	}
+	if level == tiles.Levels()-1 {
+		return
+	}
+
+	uploads := 0
+	for _, tile := range tiles.Tiles(level, visibleArea()) {
+		key := tileKey{level: tile.Level, index: tile.Index}
+		uploaded, found := tile_textures[key]
+		if !found {
+			if uploads == VIEWPORT_TILE_UPLOADS_PER_FRAME {
+				tiles_missing = true
+				continue
+			}
+			uploads++
+			pixels, _ := tiles.Pixels(tile)
+			texture, err := uploadTile(pixels)
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+			uploaded = &tileTexture{texture: texture}
+			tile_textures[key] = uploaded
+		}
+		uploaded.frame = tile_frame
+		drawTile(uploaded.texture, tile.Source)
+	}
+	evictTiles()
+}
+
+/** Copy a tile of a pyramid level to a new texture, with the color adjustments.
+ * @param pixels The tile pixels, they are not modified.
+ * @return The texture,
+ * @return the SDL error if it could not be created.
+ */
+func uploadTile(pixels *image.NRGBA) (*sdl.Texture, error) {
+	area := pixels.Rect
+	if !vp.recipe.IsZero() {
+		pixels = adjusted(pixels)
+	}
+	tile, err := renderer.CreateTexture(uint32(sdl.PIXELFORMAT_RGBA32), sdl.TEXTUREACCESS_STATIC, int32(area.Dx()), int32(area.Dy()))
+	if err != nil {
+		return nil, err
+	}
+	if err = tile.Update(nil, pixels.Pix, pixels.Stride); err != nil {
+		tile.Destroy()
+		return nil, err
+	}
+	tile.SetBlendMode(sdl.BLENDMODE_BLEND)
+	return tile, nil
+}
+
+/** Copy pixels with the color adjustments applied.
+ * @param pixels The pixels to copy.
+ * @return The adjusted copy.
+ */
+func adjusted(pixels *image.NRGBA) *image.NRGBA {
+	copied := image.NewNRGBA(pixels.Rect)
+	for y := pixels.Rect.Min.Y; y < pixels.Rect.Max.Y; y++ {
+		copy(copied.Pix[copied.PixOffset(pixels.Rect.Min.X, y):copied.PixOffset(pixels.Rect.Max.X, y)], pixels.Pix[pixels.PixOffset(pixels.Rect.Min.X, y):])
+	}
+	vp.recipe.ApplyTo(copied)
+	return copied
+}
+
+/** Draw a texture over the part of the image it holds, through the zoom, the letterbox borders, the rotation and the flipping.
+ * @param tile The texture to draw.
+ * @param area The image part it holds, in loaded image pixels.
+ */
+func drawTile(tile *sdl.Texture, area image.Rectangle) {
+	X1, Y1 := imageToWindow(float64(area.Min.X), float64(area.Min.Y))
+	X2, Y2 := imageToWindow(float64(area.Max.X), float64(area.Max.Y))
+
+	// The destination rectangle is given before rotation, the rotation is done around its center
+	Width, Height := math.Abs(X2-X1), math.Abs(Y2-Y1)
+	if vp.rotation == ROTATION_ID_90 || vp.rotation == ROTATION_ID_270 {
+		Width, Height = Height, Width
+	}
+	dstRect := sdl.FRect{X: float32((X1 + X2 - Width) / 2), Y: float32((Y1 + Y2 - Height) / 2), W: float32(Width), H: float32(Height)}
+	renderer.CopyExF(tile, nil, &dstRect, 90*float64(vp.rotation), nil, vp.flip_mode)
+}
+
+/** Get the part of the loaded image visible in the window.
+ * @return The visible area in loaded image pixels, possibly empty.
+ */
+func visibleArea() image.Rectangle {
+	Min_A, Min_B, Max_A, Max_B := math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+	for _, corner := range [4][2]float64{{0, 0}, {float64(vp.width), 0}, {0, float64(vp.height)}, {float64(vp.width), float64(vp.height)}} {
+		a, b, ok := windowToImage(corner[0], corner[1])
+		if !ok {
+			return image.Rectangle{}
+		}
+		Min_A, Min_B, Max_A, Max_B = math.Min(Min_A, a), math.Min(Min_B, b), math.Max(Max_A, a), math.Max(Max_B, b)
+	}
+	area := image.Rect(int(math.Floor(Min_A)), int(math.Floor(Min_B)), int(math.Ceil(Max_A)), int(math.Ceil(Max_B)))
+	return area.Intersect(ImageBounds())
+}
+
+/** Destroy the least recently drawn tile textures beyond VIEWPORT_MAXIMUM_TILE_TEXTURES, keeping the ones of the last frame. */
+func evictTiles() {
+	if len(tile_textures) <= VIEWPORT_MAXIMUM_TILE_TEXTURES {
+		return
+	}
+	keys := make([]tileKey, 0, len(tile_textures))
+	for key := range tile_textures {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return tile_textures[keys[i]].frame < tile_textures[keys[j]].frame })
+	for _, key := range keys[:len(keys)-VIEWPORT_MAXIMUM_TILE_TEXTURES] {
+		if tile_textures[key].frame == tile_frame {
+			break
+		}
+		tile_textures[key].texture.Destroy()
+		delete(tile_textures, key)
+	}
+}
+
+/** Copy a surface pixels to a Go image.
+ * @param surface The surface to copy.
+ * @return The surface pixels as non premultiplied RGBA.
+ */
+func surfaceToImage(surface *sdl.Surface) (*image.NRGBA, error) {
+	converted, err := surface.ConvertFormat(uint32(sdl.PIXELFORMAT_RGBA32), 0)
+	if err != nil {
+		return nil, err
+	}
+	defer converted.Free()
+
+	pixels := image.NewNRGBA(image.Rect(0, 0, int(converted.W), int(converted.H)))
+	converted.Lock()
+	data := converted.Pixels()
+	for y := 0; y < int(converted.H); y++ {
+		copy(pixels.Pix[y*pixels.Stride:(y+1)*pixels.Stride], data[y*int(converted.Pitch):])
+	}
+	converted.Unlock()
+	return pixels, nil
+}
+
+/** Convert a color to hue, saturation and value.
+ * @return The hue in degrees, the saturation and value in percents.
+ */
+func toHSV(c color.NRGBA) (h float64, s float64, v float64) {
+	r, g, b := float64(c.R)/255, float64(c.G)/255, float64(c.B)/255
+	max := math.Max(r, math.Max(g, b))
+	min := math.Min(r, math.Min(g, b))
+	delta := max - min
+	switch {
+	case delta == 0:
+		h = 0
+	case max == r:
+		h = 60 * math.Mod((g-b)/delta, 6)
+	case max == g:
+		h = 60 * ((b-r)/delta + 2)
+	default:
+		h = 60 * ((r-g)/delta + 4)
+	}
+	if h < 0 {
+		h += 360
+	}
+	if max > 0 {
+		s = 100 * delta / max
+	}
+	return h, s, 100 * max
+}
+
+//-------------------------------------------------------------------------------------------------
+// Public functions
+//-------------------------------------------------------------------------------------------------
+
+func ScreenCenter() (X int32, Y int32) {
+	X = renderer.GetViewport().W / 2
+	Y = renderer.GetViewport().H / 2
+	return
+}
+
+/** Choose the size of the window created by the first Initialize() call.
+ * @param width The window width in pixels.
+ * @param height The window height in pixels.
+ */
+func SetWindowSize(width int32, height int32) {
+	if width < VIEWPORT_MINIMUM_WINDOW_WIDTH {
+		width = VIEWPORT_MINIMUM_WINDOW_WIDTH
+	}
+	if height < VIEWPORT_MINIMUM_WINDOW_HEIGHT {
+		height = VIEWPORT_MINIMUM_WINDOW_HEIGHT
+	}
+	vp.window_width = width
+	vp.window_height = height
+	// An explicit size is not maximized
+	vp.window_flags &^= sdl.WINDOW_MAXIMIZED
+}
+
+/** Choose whether the window created by the first Initialize() call covers the whole desktop.
+ * @param fullscreen Set to true to create a fullscreen window.
+ */
+func SetFullscreen(fullscreen bool) {
+	if fullscreen {
+		vp.window_flags |= sdl.WINDOW_FULLSCREEN_DESKTOP
+	} else {
+		vp.window_flags &^= sdl.WINDOW_FULLSCREEN_DESKTOP
+	}
+}
+
+/** Choose the color of the borders added around the image to keep its ratio. It is used the next time the image is adapted to the viewport.
+ * @param background The border color.
+ */
+func SetBackgroundColor(background sdl.Color) {
+	vp.background = background
+}
+
+/** Limit the texture size, images with a larger side being drawn in tiles as when the renderer can not create a texture large enough. It is used from the next Initialize() call.
+ * @param size The largest texture side in pixels, 0 to follow the renderer limit.
+ */
+func SetMaximumTextureSize(size int32) {
+	maximum_texture_size = size
+}
+
+/** Draw into an offscreen surface with the SDL software renderer instead of a window, so the viewport runs without a display nor a GPU, in CI for instance. It must be called before the first Initialize(), SDL does not need to be initialized.
+ * @param width The surface width in pixels.
+ * @param height The surface height in pixels.
+ * @return nil if the function succeeded,
+ * @return the SDL error if the surface or the renderer could not be created.
+ */
+func SetHeadless(width int32, height int32) (err error) {
+	if target, err = sdl.CreateRGBSurfaceWithFormat(0, width, height, 32, uint32(sdl.PIXELFORMAT_RGBA32)); err != nil {
+		return err
+	}
+	if renderer, err = sdl.CreateSoftwareRenderer(target); err != nil {
 		return err
 	}
+	vp.window_width = width
+	vp.window_height = height
+	// No window event will tell the viewport size
+	vp.width = width
+	vp.height = height
+	return nil
+}
 
-	// Do not allow the window to be too small because it can prevent the texture rendering from working
-	window.SetMinimumSize(VIEWPORT_MINIMUM_WINDOW_WIDTH, VIEWPORT_MINIMUM_WINDOW_HEIGHT)
+func Initialize(title string, image *sdl.Surface) (err error) {
+	if err = createRenderer(title); err != nil {
+		return err
+	}
+	releaseImage()
+	// Images larger than a texture are drawn in tiles
+	tiled := IsTooLarge(image.W, image.H)
+	if !tiled {
+		// Convert the image surface to a texture
+		if texture, err = renderer.CreateTextureFromSurface(image); err != nil {
+			log.Fatal(err)
+			return err
+		}
+	}
 
 	// Cache original image dimensions
-	if vp.original_pixel_format, _, vp.original_width, vp.original_height, err = texture.Query(); err != nil {
+	if tiled {
+		vp.original_pixel_format, vp.original_width, vp.original_height = uint32(sdl.PIXELFORMAT_RGBA32), image.W, image.H
+	} else if vp.original_pixel_format, _, vp.original_width, vp.original_height, err = texture.Query(); err != nil {
 		log.Fatal(err)
 		return err
 	}
-	//fmt.Printf("Initialize():  Image W=%d, H=%d\n", vp.original_image_width, vp.original_image_height)
+
+	// Keep the pixels to analyse them, the texture can not be read back
+	pixels, err := surfaceToImage(image)
+	if err != nil {
+		log.Fatal(err)
+		return err
+	}
+	vp.source, vp.displayed = pixels, pixels
+	if tiled {
+		// The pyramid keeps the pixels, its preview is ready at once and the finer levels come in the background
+		tileSize, previewSize := pyramidSizes()
+		tiles = pyramid.New(pixels, tileSize, previewSize)
+		vp.source, vp.displayed = nil, nil
+	}
+	imageLoaded()
 
 	return nil
 }
 
+/** Display an image file too large for a texture, see IsTooLarge(). It is decoded in the background and drawn in tiles as they come, a JPEG file showing its lower resolutions long before the full one.
+ * @param title The window title.
+ * @param path The image file.
+ * @return nil if the function succeeded,
+ * @return the error if the file header could not be read, the previous image being kept.
+ */
+func InitializeTiled(title string, path string) (err error) {
+	if err = createRenderer(title); err != nil {
+		return err
+	}
+	tileSize, previewSize := pyramidSizes()
+	opened, err := pyramid.Open(path, tileSize, previewSize)
+	if err != nil {
+		return err
+	}
+	releaseImage()
+	tiles = opened
+	size := tiles.Size()
+	vp.original_pixel_format, vp.original_width, vp.original_height = uint32(sdl.PIXELFORMAT_RGBA32), int32(size.X), int32(size.Y)
+	vp.source, vp.displayed = nil, nil
+	imageLoaded()
+	return nil
+}
+
+/** Reset what depends on the previous image and display the new one. */
+func imageLoaded() {
+	vp.recipe = adjust.Recipe{}
+	// The histogram of the previous image is obsolete
+	SetOverlay(OVERLAY_ID_HISTOGRAM, nil, ANCHOR_ID_BOTTOM_RIGHT)
+
+	// Replace the previous image, the first one waits for the window size to be known
+	if vp.width > 0 && vp.height > 0 {
+		adaptImage(displayedSize())
+	}
+	//fmt.Printf("Initialize():  Image W=%d, H=%d\n", vp.original_image_width, vp.original_image_height)
+}
+
+/** Tell whether an image is too large for a texture, so it must be drawn in tiles. It creates the window the first time, to query the renderer.
+ * @param width The image width in pixels.
+ * @param height The image height in pixels.
+ * @return true when a side is larger than the largest texture.
+ */
+func IsTooLarge(width int32, height int32) bool {
+	if renderer == nil && createRenderer("-") != nil {
+		return false
+	}
+	Maximum_Width, Maximum_Height := maximumTextureSize()
+	return (Maximum_Width > 0 && width > Maximum_Width) || (Maximum_Height > 0 && height > Maximum_Height)
+}
+
 func DrawImage() {
-	renderer.Copy(adapted_texture, &vp.srcRect, nil)
+	drawFrame()
 	renderer.Present()
 }
 
+/** Draw the image and everything displayed over it, without presenting them. */
+func drawFrame() {
+	if tiles != nil {
+		drawTiles()
+	} else {
+		renderer.Copy(adapted_texture, &vp.srcRect, nil)
+	}
+	drawCropRectangle()
+	updateHud()
+	updateHistogram()
+	drawOverlays()
+}
+
+/** Draw the current frame and read it back, to save a screenshot or compare the rendering with reference images.
+ * @return The frame pixels, opaque,
+ * @return the SDL error if they could not be read.
+ */
+func Snapshot() (*image.NRGBA, error) {
+	// Large images are read once every visible tile is built and uploaded
+	if tiles != nil {
+		tiles.Wait()
+	}
+	for drawFrame(); tiles != nil && tiles_missing; drawFrame() {
+	}
+	area := renderer.GetViewport()
+	pixels := image.NewNRGBA(image.Rect(0, 0, int(area.W), int(area.H)))
+	if len(pixels.Pix) == 0 {
+		return pixels, nil
+	}
+	if err := renderer.ReadPixels(nil, uint32(sdl.PIXELFORMAT_RGBA32), unsafe.Pointer(&pixels.Pix[0]), pixels.Stride); err != nil {
+		return nil, err
+	}
+	// The background alpha is not meaningful, the window being opaque
+	for i := 3; i < len(pixels.Pix); i += 4 {
+		pixels.Pix[i] = 255
+	}
+	return pixels, nil
+}
+
+/** Draw the overlays on top of the image. */
+func drawOverlays() {
+	var dstRect sdl.Rect
+
+	for i := range overlays {
+		o := &overlays[i]
+		if o.texture == nil {
+			continue
+		}
+		dstRect.W = o.width
+		dstRect.H = o.height
+		switch o.anchor {
+		case ANCHOR_ID_TOP_LEFT:
+			dstRect.X, dstRect.Y = VIEWPORT_OVERLAY_MARGIN, VIEWPORT_OVERLAY_MARGIN
+		case ANCHOR_ID_TOP_RIGHT:
+			dstRect.X, dstRect.Y = vp.width-o.width-VIEWPORT_OVERLAY_MARGIN, VIEWPORT_OVERLAY_MARGIN
+		case ANCHOR_ID_BOTTOM_LEFT:
+			dstRect.X, dstRect.Y = VIEWPORT_OVERLAY_MARGIN, vp.height-o.height-VIEWPORT_OVERLAY_MARGIN
+		case ANCHOR_ID_BOTTOM_RIGHT:
+			dstRect.X, dstRect.Y = vp.width-o.width-VIEWPORT_OVERLAY_MARGIN, vp.height-o.height-VIEWPORT_OVERLAY_MARGIN
+		default:
+			dstRect.X, dstRect.Y = (vp.width-o.width)/2, (vp.height-o.height)/2
+		}
+		renderer.Copy(o.texture, nil, &dstRect)
+	}
+}
+
+/** Display a panel over the image, or remove it.
+ * @param id The overlay to set.
+ * @param panel The panel pixels, nil to remove the overlay.
+ * @param anchor Where to place the panel in the window.
+ * @return nil if the function succeeded,
+ * @return the SDL error if the texture could not be created.
+ */
+func SetOverlay(id TViewportOverlayID, panel image.Image, anchor TViewportAnchorID) (err error) {
+	o := &overlays[id]
+	if o.texture != nil {
+		o.texture.Destroy()
+		o.texture = nil
+	}
+	if panel == nil {
+		return nil
+	}
+
+	// SDL expects non premultiplied alpha
+	bounds := panel.Bounds()
+	pixels := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(pixels, pixels.Bounds(), panel, bounds.Min, draw.Src)
+
+	o.width = int32(bounds.Dx())
+	o.height = int32(bounds.Dy())
+	o.anchor = anchor
+	if o.texture, err = renderer.CreateTexture(uint32(sdl.PIXELFORMAT_RGBA32), sdl.TEXTUREACCESS_STATIC, o.width, o.height); err != nil {
+		return err
+	}
+	if err = o.texture.Update(nil, pixels.Pix, pixels.Stride); err != nil {
+		return err
+	}
+	return o.texture.SetBlendMode(sdl.BLENDMODE_BLEND)
+}
+
+/** Get how many displayed pixels an image pixel takes, as a percentage.
+ * @return 100 when the image is displayed pixel for pixel.
+ */
+func ZoomPercentage() int32 {
+	Image_Width, _ := displayedSize()
+	if Image_Width == 0 || vp.srcRect.W < 0 {
+		return 0
+	}
+	return int32(math.Round(100 * float64(vp.image_rect.W) * float64(vp.width) / (float64(Image_Width) * float64(vp.srcRect.W+1))))
+}
+
+/** Describe the flipping and rotation applied to the image.
+ * @return A short text like "flip horizontal, 90°".
+ */
+func TransformDescription() string {
+	var flip string
+	switch vp.flip_mode {
+	case sdl.FLIP_HORIZONTAL:
+		flip = "flip horizontal"
+	case sdl.FLIP_VERTICAL:
+		flip = "flip vertical"
+	case sdl.FLIP_HORIZONTAL | sdl.FLIP_VERTICAL:
+		flip = "flip both"
+	default:
+		flip = "no flip"
+	}
+	return fmt.Sprintf("%s, %d°", flip, 90*int(vp.rotation))
+}
+
+/** Find an anchor from its configuration name (center, top-left, top-right, bottom-left or bottom-right).
+ * @param name The anchor name.
+ * @return The anchor and true if the name is known.
+ */
+func AnchorFromName(name string) (TViewportAnchorID, bool) {
+	anchor, ok := anchor_names[strings.ToLower(name)]
+	return anchor, ok
+}
+
+/** Choose where the heads-up display is drawn and how much it hides the image.
+ * @param anchor The window corner (or center) the panel sticks to.
+ * @param opacity The panel background opacity, from 0 (transparent) to 1 (opaque).
+ */
+func SetHudStyle(anchor TViewportAnchorID, opacity float64) {
+	hd.anchor = anchor
+	hd.opacity = math.Max(0, math.Min(1, opacity))
+	hd.state = "" // Render it again
+}
+
+/** Set the image information displayed by the heads-up display, the viewport appends the zoom, flipping and rotation state.
+ * @param lines The information lines (index, path, dimensions...).
+ */
+func SetHudInfo(lines []string) {
+	hd.lines = lines
+	hd.state = ""
+}
+
+/** Show or hide the heads-up display.
+ * @return true if the heads-up display is now visible.
+ */
+func ToggleHud() bool {
+	ShowHud(!hd.visible)
+	return hd.visible
+}
+
+func ShowHud(visible bool) {
+	hd.visible = visible
+	hd.state = ""
+	if !visible {
+		SetOverlay(OVERLAY_ID_HUD, nil, hd.anchor)
+	}
+}
+
+/** Render the heads-up display again when its content changed. */
+func updateHud() {
+	if !hd.visible {
+		return
+	}
+	lines := append(append([]string{}, hd.lines...), fmt.Sprintf("zoom %d%%  %s", ZoomPercentage(), TransformDescription()))
+	if !vp.recipe.IsZero() {
+		lines = append(lines, vp.recipe.String())
+	}
+	state := strings.Join(lines, "\n")
+	if state == hd.state {
+		return
+	}
+	hd.state = state
+
+	style := overlay.DefaultStyle()
+	style.Background.A = uint8(255 * hd.opacity) // Black, so premultiplying keeps the color components at 0
+	if err := SetOverlay(OVERLAY_ID_HUD, overlay.Text(lines, style), hd.anchor); err != nil {
+		log.Println(err)
+	}
+}
+
+/** Get the loaded image pixels.
+ * @return The image as loaded, before flipping and rotation, nil while a large image is decoded.
+ */
+func SourceImage() image.Image {
+	if tiles != nil {
+		if decoded, ok := tiles.Image(); ok {
+			return decoded
+		}
+		return nil
+	}
+	if vp.source == nil {
+		return nil
+	}
+	return vp.source
+}
+
+/** Change the color adjustments applied to the loaded image. They are computed on the CPU, so the texture is uploaded again, or the tiles of a large image as they are drawn.
+ * @param recipe The adjustments, the zero value displays the image as loaded.
+ * @return nil if the function succeeded,
+ * @return the SDL error if the texture could not be created.
+ */
+func SetAdjustment(recipe adjust.Recipe) (err error) {
+	if vp.source == nil && tiles == nil {
+		return nil
+	}
+	vp.recipe = recipe
+
+	if tiles != nil {
+		// The tiles are adjusted as they are uploaded
+		releaseTileTextures()
+	} else {
+		vp.displayed = vp.source
+		if !recipe.IsZero() {
+			vp.displayed = image.NewNRGBA(vp.source.Rect)
+			copy(vp.displayed.Pix, vp.source.Pix)
+			recipe.ApplyTo(vp.displayed)
+		}
+
+		// Replace the loaded image texture
+		if texture != nil {
+			texture.Destroy()
+		}
+		if texture, err = renderer.CreateTexture(uint32(sdl.PIXELFORMAT_RGBA32), sdl.TEXTUREACCESS_STATIC, vp.original_width, vp.original_height); err != nil {
+			return err
+		}
+		if err = texture.Update(nil, vp.displayed.Pix, vp.displayed.Stride); err != nil {
+			return err
+		}
+		texture.SetBlendMode(sdl.BLENDMODE_BLEND)
+	}
+
+	// The histogram follows what is displayed
+	SetOverlay(OVERLAY_ID_HISTOGRAM, nil, ANCHOR_ID_BOTTOM_RIGHT)
+	hd.state = ""
+
+	// Redraw the image with the new colors
+	if vp.width > 0 && vp.height > 0 {
+		err = adaptImage(displayedSize())
+	}
+	return err
+}
+
+/** Get the color adjustments applied to the loaded image. */
+func Adjustment() adjust.Recipe {
+	return vp.recipe
+}
+
+/** Map a window position to the loaded image, through the zoom, the letterbox borders, the rotation and the flipping.
+ * @param Window_X The window horizontal coordinate.
+ * @param Window_Y The window vertical coordinate.
+ * @return The position in loaded image pixels, possibly outside of the image, and false when the viewport is not ready.
+ */
+func windowToImage(Window_X float64, Window_Y float64) (a float64, b float64, ok bool) {
+	if vp.width <= 0 || vp.height <= 0 || vp.image_rect.W <= 0 || vp.image_rect.H <= 0 || vp.srcRect.W <= 0 || vp.srcRect.H <= 0 {
+		return 0, 0, false
+	}
+
+	// The zoomed area of the adapted texture is stretched to the whole window
+	Texture_X := float64(vp.srcRect.X) + Window_X*float64(vp.srcRect.W)/float64(vp.width)
+	Texture_Y := float64(vp.srcRect.Y) + Window_Y*float64(vp.srcRect.H)/float64(vp.height)
+
+	// Remove the letterbox borders and the scaling done by adaptImage()
+	Displayed_Width, Displayed_Height := displayedSize()
+	u := (Texture_X - float64(vp.image_rect.X)) * float64(Displayed_Width) / float64(vp.image_rect.W)
+	v := (Texture_Y - float64(vp.image_rect.Y)) * float64(Displayed_Height) / float64(vp.image_rect.H)
+
+	// Undo the clockwise rotation, SDL rotates the flipped image
+	w, h := float64(vp.original_width), float64(vp.original_height)
+	switch vp.rotation {
+	case ROTATION_ID_90:
+		a, b = v, h-u
+	case ROTATION_ID_180:
+		a, b = w-u, h-v
+	case ROTATION_ID_270:
+		a, b = w-v, u
+	default:
+		a, b = u, v
+	}
+
+	// Undo the flipping
+	if vp.flip_mode&sdl.FLIP_HORIZONTAL != 0 {
+		a = w - a
+	}
+	if vp.flip_mode&sdl.FLIP_VERTICAL != 0 {
+		b = h - b
+	}
+	return a, b, true
+}
+
+/** Map a loaded image position to the window, the reverse of windowToImage().
+ * @param a The image horizontal coordinate, in pixels.
+ * @param b The image vertical coordinate, in pixels.
+ * @return The window position.
+ */
+func imageToWindow(a float64, b float64) (Window_X float64, Window_Y float64) {
+	w, h := float64(vp.original_width), float64(vp.original_height)
+	if vp.flip_mode&sdl.FLIP_HORIZONTAL != 0 {
+		a = w - a
+	}
+	if vp.flip_mode&sdl.FLIP_VERTICAL != 0 {
+		b = h - b
+	}
+
+	var u, v float64
+	switch vp.rotation {
+	case ROTATION_ID_90:
+		u, v = h-b, a
+	case ROTATION_ID_180:
+		u, v = w-a, h-b
+	case ROTATION_ID_270:
+		u, v = b, w-a
+	default:
+		u, v = a, b
+	}
+
+	Displayed_Width, Displayed_Height := displayedSize()
+	Texture_X := float64(vp.image_rect.X) + u*float64(vp.image_rect.W)/float64(Displayed_Width)
+	Texture_Y := float64(vp.image_rect.Y) + v*float64(vp.image_rect.H)/float64(Displayed_Height)
+	return (Texture_X - float64(vp.srcRect.X)) * float64(vp.width) / float64(vp.srcRect.W), (Texture_Y - float64(vp.srcRect.Y)) * float64(vp.height) / float64(vp.srcRect.H)
+}
+
+/** Find the image pixel displayed at a window position, through the zoom, the letterbox borders, the rotation and the flipping.
+ * @param Window_X The window horizontal coordinate.
+ * @param Window_Y The window vertical coordinate.
+ * @return The loaded image pixel coordinates and true, or false when the position is not over the image.
+ */
+func WindowToImage(Window_X int32, Window_Y int32) (Image_X int32, Image_Y int32, ok bool) {
+	// Aim at the pixel center
+	a, b, ok := windowToImage(float64(Window_X)+0.5, float64(Window_Y)+0.5)
+	if !ok || a < 0 || b < 0 || a >= float64(vp.original_width) || b >= float64(vp.original_height) {
+		return 0, 0, false
+	}
+	return int32(a), int32(b), true
+}
+
+/** Find the image point nearest to a window position, to follow the mouse even when it leaves the image.
+ * @param Window_X The window horizontal coordinate.
+ * @param Window_Y The window vertical coordinate.
+ * @return The loaded image point, between (0, 0) and the image size included.
+ */
+func WindowToImageClamped(Window_X int32, Window_Y int32) image.Point {
+	a, b, _ := windowToImage(float64(Window_X), float64(Window_Y))
+	return image.Pt(int(math.Round(math.Max(0, math.Min(float64(vp.original_width), a)))), int(math.Round(math.Max(0, math.Min(float64(vp.original_height), b)))))
+}
+
+/** Get the loaded image bounds. */
+func ImageBounds() image.Rectangle {
+	return image.Rect(0, 0, int(vp.original_width), int(vp.original_height))
+}
+
+/** Get the current rotation, so the applications can orient what depends on the displayed image ratio. */
+func Rotation() TViewportRotationID {
+	return vp.rotation
+}
+
+/** Display a crop rectangle over the image, the area outside of it being darkened.
+ * @param area The rectangle in loaded image pixels, an empty one removes the crop rectangle.
+ */
+func SetCropRectangle(area image.Rectangle) {
+	crop_area = area
+}
+
+/** Draw the crop rectangle, if any. */
+func drawCropRectangle() {
+	if crop_area.Empty() {
+		return
+	}
+
+	// Both corners are mapped because rotation and flipping can swap them
+	x1, y1 := imageToWindow(float64(crop_area.Min.X), float64(crop_area.Min.Y))
+	x2, y2 := imageToWindow(float64(crop_area.Max.X), float64(crop_area.Max.Y))
+	area := sdl.Rect{X: int32(math.Round(math.Min(x1, x2))), Y: int32(math.Round(math.Min(y1, y2)))}
+	area.W = int32(math.Round(math.Max(x1, x2))) - area.X
+	area.H = int32(math.Round(math.Max(y1, y2))) - area.Y
+
+	// Darken everything around the rectangle
+	renderer.SetDrawBlendMode(sdl.BLENDMODE_BLEND)
+	renderer.SetDrawColor(0, 0, 0, VIEWPORT_CROP_SHADE)
+	renderer.FillRects([]sdl.Rect{
+		{X: 0, Y: 0, W: vp.width, H: area.Y},
+		{X: 0, Y: area.Y + area.H, W: vp.width, H: vp.height - area.Y - area.H},
+		{X: 0, Y: area.Y, W: area.X, H: area.H},
+		{X: area.X + area.W, Y: area.Y, W: vp.width - area.X - area.W, H: area.H},
+	})
+
+	// Outline it with the rule of thirds guides
+	renderer.SetDrawColor(255, 255, 255, 255)
+	renderer.DrawRect(&area)
+	renderer.SetDrawColor(255, 255, 255, 96)
+	for i := int32(1); i < 3; i++ {
+		renderer.DrawLine(area.X+i*area.W/3, area.Y, area.X+i*area.W/3, area.Y+area.H)
+		renderer.DrawLine(area.X, area.Y+i*area.H/3, area.X+area.W, area.Y+i*area.H/3)
+	}
+	renderer.SetDrawBlendMode(sdl.BLENDMODE_NONE)
+}
+
+/** Show or hide the histogram of the loaded image.
+ * @return true if the histogram is now visible.
+ */
+func ToggleHistogram() bool {
+	histogram_visible = !histogram_visible
+	if !histogram_visible {
+		SetOverlay(OVERLAY_ID_HISTOGRAM, nil, ANCHOR_ID_BOTTOM_RIGHT)
+	}
+	return histogram_visible
+}
+
+/** Compute the histogram panel when it is visible and not computed yet. */
+func updateHistogram() {
+	if !histogram_visible || overlays[OVERLAY_ID_HISTOGRAM].texture != nil {
+		return
+	}
+	pixels := vp.displayed
+	if tiles != nil {
+		// The preview of a large image is enough for the distribution
+		preview, final := tiles.Preview()
+		if !final {
+			return
+		}
+		pixels = preview
+		if !vp.recipe.IsZero() {
+			pixels = adjusted(preview)
+		}
+	}
+	if pixels == nil {
+		return
+	}
+	if err := SetOverlay(OVERLAY_ID_HISTOGRAM, histogram.Compute(pixels).Image(histogram.DefaultStyle()), ANCHOR_ID_BOTTOM_RIGHT); err != nil {
+		log.Println(err)
+	}
+}
+
+/** Show or hide the pixel inspector.
+ * @return true if the pixel inspector is now visible.
+ */
+func ToggleInspector() bool {
+	inspector_visible = !inspector_visible
+	if !inspector_visible {
+		SetOverlay(OVERLAY_ID_INSPECTOR, nil, ANCHOR_ID_BOTTOM_LEFT)
+	}
+	return inspector_visible
+}
+
+/** Display the values of the image pixel under a window position in the pixel inspector.
+ * @param Window_X The window horizontal coordinate, usually the mouse one.
+ * @param Window_Y The window vertical coordinate.
+ */
+func InspectPixel(Window_X int32, Window_Y int32) {
+	if !inspector_visible || (vp.source == nil && tiles == nil) {
+		return
+	}
+	var lines []string
+	if x, y, ok := WindowToImage(Window_X, Window_Y); !ok {
+		lines = []string{"outside of the image"}
+	} else if c, decoded := displayedPixel(int(x), int(y)); !decoded {
+		lines = []string{fmt.Sprintf("x %d  y %d", x, y), "not decoded yet"}
+	} else {
+		h, s, v := toHSV(c)
+		lines = []string{
+			fmt.Sprintf("x %d  y %d", x, y),
+			fmt.Sprintf("RGBA %d, %d, %d, %d  #%02x%02x%02x", c.R, c.G, c.B, c.A, c.R, c.G, c.B),
+			fmt.Sprintf("HSV %.0f°, %.0f%%, %.0f%%  luma %d", h, s, v, histogram.Luma(c.R, c.G, c.B)),
+		}
+	}
+	if err := SetOverlay(OVERLAY_ID_INSPECTOR, overlay.Text(lines, overlay.DefaultStyle()), ANCHOR_ID_BOTTOM_LEFT); err != nil {
+		log.Println(err)
+	}
+}
+
+/** Get the displayed color of an image pixel, with the color adjustments.
+ * @param x The pixel column in loaded image pixels.
+ * @param y The pixel row.
+ * @return The color, and false when a large image is not decoded enough to tell it.
+ */
+func displayedPixel(x int, y int) (color.NRGBA, bool) {
+	if tiles == nil {
+		return vp.displayed.NRGBAAt(x, y), true
+	}
+	c, ok := tiles.At(x, y)
+	if !ok || vp.recipe.IsZero() {
+		return c, ok
+	}
+	pixel := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	pixel.SetNRGBA(0, 0, c)
+	vp.recipe.ApplyTo(pixel)
+	return pixel.NRGBAAt(0, 0), true
+}
+
+/** Get the current viewport dimensions.
+ * @return The viewport width and height in pixels.
+ */
+func WindowSize() (width int32, height int32) {
+	return vp.width, vp.height
+}
+
+/** Switch between windowed and fullscreen display. */
+func ToggleFullscreen() {
+	if window == nil {
+		return
+	}
+	if window.GetFlags()&sdl.WINDOW_FULLSCREEN_DESKTOP != 0 {
+		window.SetFullscreen(0)
+	} else {
+		window.SetFullscreen(sdl.WINDOW_FULLSCREEN_DESKTOP)
+	}
+}
+
 func SetDimensions(new_width int32, new_height int32) {
 	// Store new viewport dimensions
 	vp.width = new_width
 	vp.height = new_height
 
 	// Add additional borders to the image to keep its ratio
-	adaptImage(vp.original_width, vp.original_height)
+	adaptImage(displayedSize())
 }
 
 var Previous_Zoom_Level_Rectangle_X = int32(0)
@@ -278,36 +1396,61 @@ func SetFlippingMode(mode TViewportFlippingModeID) {
 	}
 
 	// Redraw the image with the newly selected flipping mode
-	adaptImage(vp.original_width, vp.original_height)
+	adaptImage(displayedSize())
+}
+
+/** Get the current flipping mode. */
+func FlippingMode() TViewportFlippingModeID {
+	switch vp.flip_mode {
+	case sdl.FLIP_HORIZONTAL:
+		return FLIPPING_MODE_ID_HORIZONTAL
+	case sdl.FLIP_VERTICAL:
+		return FLIPPING_MODE_ID_VERTICAL
+	case sdl.FLIP_HORIZONTAL | sdl.FLIP_VERTICAL:
+		return FLIPPING_MODE_ID_HORIZONTAL_AND_VERTICAL
+	}
+	return FLIPPING_MODE_ID_NORMAL
+}
+
+func SetRotation(rotation TViewportRotationID) {
+	if rotation < ROTATION_ID_0 || rotation >= ROTATION_IDS_COUNT {
+		log.Fatal("Error : bad rotation ID provided")
+		return
+	}
+	vp.rotation = rotation
+
+	// Redraw the image with the new rotation, the image ratio may have changed
+	adaptImage(displayedSize())
 }
 
 func ScaleImage() {
 	var Horizontally_Scaled_Pixels_Count int32
 	var Vertically_Scaled_Pixels_Count int32
 	var Scaling_Percentage int32
+	var Image_Width, Image_Height = displayedSize()
 
 	// Always reset the zoom to ease the following computations
 	SetZoomedArea(0, 0, 1)
 
 	// Make the image fit the viewport if it is smaller
-	if (vp.original_width < vp.width) && (vp.original_height < vp.height) {
+	if (Image_Width < vp.width) && (Image_Height < vp.height) {
 		// Determine the amount of pixels not used by the image and keep the smallest one to make sure the image ratio is not modified
-		Horizontally_Scaled_Pixels_Count = vp.width - vp.original_width
-		Vertically_Scaled_Pixels_Count = vp.height - vp.original_height
+		Horizontally_Scaled_Pixels_Count = vp.width - Image_Width
+		Vertically_Scaled_Pixels_Count = vp.height - Image_Height
 		if Horizontally_Scaled_Pixels_Count < Vertically_Scaled_Pixels_Count {
 			// Compute how many percents the image will be horizontally scaled
-			Scaling_Percentage = (100 * (vp.original_width + Horizontally_Scaled_Pixels_Count)) / vp.original_width
+			Scaling_Percentage = (100 * (Image_Width + Horizontally_Scaled_Pixels_Count)) / Image_Width
 
 			// Scale the "camera"
-			vp.srcRect.W = vp.original_width + Horizontally_Scaled_Pixels_Count
-			vp.srcRect.H = (vp.original_height * Scaling_Percentage) / 100 // Use the percentage computed right before to scale the vertical direction with the same proportion
+			vp.srcRect.W = Image_Width + Horizontally_Scaled_Pixels_Count
+			vp.srcRect.H = (Image_Height * Scaling_Percentage) / 100 // Use the percentage computed right before to scale the vertical direction with the same proportion
 		} else {
 			// Compute how many percents the image will be horizontally scaled
-			Scaling_Percentage = (100 * (vp.original_height + Vertically_Scaled_Pixels_Count)) / vp.original_height
+			Scaling_Percentage = (100 * (Image_Height + Vertically_Scaled_Pixels_Count)) / Image_Height
 
 			// Scale the "camera"
-			vp.srcRect.W = (vp.original_width * Scaling_Percentage) / 100
-			vp.srcRect.H = vp.original_height + Vertically_Scaled_Pixels_Count
+			vp.srcRect.W = (Image_Width * Scaling_Percentage) / 100
+			vp.srcRect.H = Image_Height + Vertically_Scaled_Pixels_Count
 		}
 
 		// Make sure the camera will display the whole image
@@ -0,0 +1,280 @@
+package viewport
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/veandco/go-sdl2/sdl"
+
+	"github.com/nicky-ayoub/imagination/internal/pkg/adjust"
+	"github.com/nicky-ayoub/imagination/internal/pkg/imageio"
+)
+
+// The rendering pipeline runs without a display, through the SDL software
+// renderer, and each scene is compared with a reference PNG:
+//
+//	go test ./internal/pkg/viewport                     compare
+//	go test ./internal/pkg/viewport -update             write the reference images
+//	go test ./internal/pkg/viewport -out /tmp/frames    keep the frames that differ
+//
+// It needs the SDL library, but no window, GPU nor video driver, so it runs in CI.
+
+var update = flag.Bool("update", false, "Write the reference images instead of comparing with them")
+var out = flag.String("out", "", "Directory receiving the rendered frames of the scenes that differ")
+var tolerance = flag.Int("tolerance", 0, "Largest difference allowed on a color component, from 0 to 255")
+
+/** Size of the offscreen viewport, in pixels. */
+const (
+	GOLDEN_WIDTH  = 320
+	GOLDEN_HEIGHT = 240
+)
+
+/** Largest texture side of the tiled scenes, so the large image has 4 levels. */
+const GOLDEN_TEXTURE_SIZE = 128
+
+/** Directory of the reference images, from the package directory. */
+const GOLDEN_DIRECTORY = "../../../test/testdata/viewport"
+
+// scene is a state of the viewport, reached from a freshly loaded image.
+type scene struct {
+	name  string
+	image image.Image
+	setup func()
+	tiled string // How a tiled image is loaded: from a "surface", or from a "png" or "jpeg" file, empty for a texture
+}
+
+// scenes returns the scenes, each one named after its reference image. They
+// cover fitting, ScaleImage, the flipping modes, the rotations,
+// SetZoomedArea, the crop rectangle, the color adjustments and the heads-up
+// display, on a generated image, and the tiles of images too large for a
+// texture.
+func scenes() []scene {
+	small := pattern(160, 100)
+	large := pattern(800, 500)
+	return []scene{
+		{"fit-small", small, func() {}, ""},
+		{"fit-large", large, func() {}, ""},
+		{"scale", small, ScaleImage, ""},
+		{"flip-horizontal", small, func() { SetFlippingMode(FLIPPING_MODE_ID_HORIZONTAL) }, ""},
+		{"flip-vertical", small, func() { SetFlippingMode(FLIPPING_MODE_ID_VERTICAL) }, ""},
+		{"flip-both", small, func() { SetFlippingMode(FLIPPING_MODE_ID_HORIZONTAL_AND_VERTICAL) }, ""},
+		{"rotate-90", large, func() { SetRotation(ROTATION_ID_90) }, ""},
+		{"rotate-180", large, func() { SetRotation(ROTATION_ID_180) }, ""},
+		{"rotate-270", large, func() { SetRotation(ROTATION_ID_270) }, ""},
+		{"rotate-90-flip", large, func() {
+			SetFlippingMode(FLIPPING_MODE_ID_HORIZONTAL)
+			SetRotation(ROTATION_ID_90)
+		}, ""},
+		{"zoom-2", large, func() { zoom(2) }, ""},
+		{"zoom-8", large, func() { zoom(8) }, ""},
+		{"zoom-corner", large, func() { SetZoomedArea(GOLDEN_WIDTH*3/4, GOLDEN_HEIGHT*3/4, 2) }, ""},
+		{"crop", large, func() { SetCropRectangle(image.Rect(100, 50, 500, 350)) }, ""},
+		{"adjust", large, func() {
+			SetAdjustment(adjust.Recipe{Brightness: 2 * adjust.BRIGHTNESS_STEP, Contrast: adjust.CONTRAST_STEP, Grayscale: true})
+		}, ""},
+		{"hud", small, func() {
+			SetHudInfo([]string{"1/1  pattern.png", "160x100"})
+			ShowHud(true)
+		}, ""},
+		{"tiled-surface", large, func() {}, "surface"},
+		{"tiled-png", large, func() {}, "png"},
+		{"tiled-png-zoom-8", large, func() { zoom(8) }, "png"},
+		{"tiled-png-rotate-90-flip", large, func() {
+			SetFlippingMode(FLIPPING_MODE_ID_HORIZONTAL)
+			SetRotation(ROTATION_ID_90)
+		}, "png"},
+		{"tiled-png-adjust", large, func() {
+			SetAdjustment(adjust.Recipe{Brightness: 2 * adjust.BRIGHTNESS_STEP, Contrast: adjust.CONTRAST_STEP, Grayscale: true})
+		}, "png"},
+		// The first preview of the JPEG file comes from its block averages, the frames are drawn once it is decoded
+		{"tiled-jpeg", large, func() {}, "jpeg"},
+		{"tiled-jpeg-zoom-8", large, func() { zoom(8) }, "jpeg"},
+	}
+}
+
+func TestGolden(t *testing.T) {
+	if *tolerance < 0 || *tolerance > 255 {
+		t.Fatalf("tolerance %d out of range 0-255", *tolerance)
+	}
+	if err := SetHeadless(GOLDEN_WIDTH, GOLDEN_HEIGHT); err != nil {
+		t.Fatal(err)
+	}
+	SetBackgroundColor(sdl.Color{R: 192, G: 192, B: 192, A: 255})
+
+	// The scenes share the viewport, they run one after the other
+	for _, s := range scenes() {
+		s := s
+		t.Run(s.name, func(t *testing.T) {
+			frame, err := render(t, s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			file := filepath.Join(GOLDEN_DIRECTORY, s.name+".png")
+			if *update {
+				if err = save(file, frame); err != nil {
+					t.Fatal(err)
+				}
+				t.Log("wrote", file)
+				return
+			}
+			message, err := compare(file, frame, *tolerance)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if message == "" {
+				return
+			}
+			if *out != "" {
+				if err = save(filepath.Join(*out, s.name+".png"), frame); err != nil {
+					t.Log(err)
+				}
+			}
+			t.Error(message)
+		})
+	}
+}
+
+// zoom zooms on the viewport center by successive doublings, as the viewer does.
+func zoom(factor int32) {
+	x, y := ScreenCenter()
+	for f := int32(2); f <= factor; f *= 2 {
+		SetZoomedArea(x, y, f)
+	}
+}
+
+// render loads the image of a scene in a reset viewport and returns the frame once the scene is set up.
+func render(t *testing.T, s scene) (*image.NRGBA, error) {
+	SetMaximumTextureSize(0)
+	if s.tiled != "" {
+		SetMaximumTextureSize(GOLDEN_TEXTURE_SIZE)
+	}
+	if s.tiled == "png" || s.tiled == "jpeg" {
+		path := filepath.Join(t.TempDir(), s.name+"."+s.tiled)
+		if err := imageio.Save(path, s.image, 95); err != nil {
+			return nil, err
+		}
+		if err := InitializeTiled(s.name, path); err != nil {
+			return nil, err
+		}
+	} else {
+		surface, err := toSurface(s.image)
+		if err != nil {
+			return nil, err
+		}
+		defer surface.Free()
+		if err = Initialize(s.name, surface); err != nil {
+			return nil, err
+		}
+	}
+	// Undo what the previous scene set, once the new image is loaded
+	ShowHud(false)
+	SetCropRectangle(image.Rectangle{})
+	SetFlippingMode(FLIPPING_MODE_ID_NORMAL)
+	SetRotation(ROTATION_ID_0)
+	s.setup()
+	return Snapshot()
+}
+
+// pattern returns an image telling its sides and corners apart: colored
+// quadrants shaded along both axes, with a white mark in the top left corner.
+func pattern(width int, height int) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	quadrants := [4]color.NRGBA{{220, 40, 40, 255}, {40, 180, 40, 255}, {40, 80, 220, 255}, {230, 200, 30, 255}}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			q := quadrants[2*(2*y/height)+2*x/width]
+			shade := 155 + 100*(x+y)/(width+height)
+			c := color.NRGBA{uint8(int(q.R) * shade / 255), uint8(int(q.G) * shade / 255), uint8(int(q.B) * shade / 255), 255}
+			if x < width/8 && y < height/8 && (x+y)%4 < 2 {
+				c = color.NRGBA{255, 255, 255, 255}
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+// toSurface copies an image to an SDL surface.
+func toSurface(img image.Image) (*sdl.Surface, error) {
+	b := img.Bounds()
+	surface, err := sdl.CreateRGBSurfaceWithFormat(0, int32(b.Dx()), int32(b.Dy()), 32, uint32(sdl.PIXELFORMAT_RGBA32))
+	if err != nil {
+		return nil, err
+	}
+	surface.Lock()
+	pixels := surface.Pixels()
+	for y := 0; y < b.Dy(); y++ {
+		row := pixels[y*int(surface.Pitch):]
+		for x := 0; x < b.Dx(); x++ {
+			c := color.NRGBAModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.NRGBA)
+			copy(row[4*x:], []byte{c.R, c.G, c.B, c.A})
+		}
+	}
+	surface.Unlock()
+	return surface, nil
+}
+
+// compare tells how a frame differs from a reference image, an empty message when it matches.
+func compare(file string, frame *image.NRGBA, tolerance int) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("no reference image %s, run with -update to write it", file)
+		}
+		return "", err
+	}
+	defer f.Close()
+	golden, err := png.Decode(f)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", file, err)
+	}
+	if golden.Bounds().Size() != frame.Rect.Size() {
+		return fmt.Sprintf("size %v, want %v", frame.Rect.Size(), golden.Bounds().Size()), nil
+	}
+
+	differing, largest := 0, 0
+	b := golden.Bounds()
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			want := color.NRGBAModel.Convert(golden.At(b.Min.X+x, b.Min.Y+y)).(color.NRGBA)
+			got := frame.NRGBAAt(x, y)
+			d := max(abs(int(got.R)-int(want.R)), max(abs(int(got.G)-int(want.G)), abs(int(got.B)-int(want.B))))
+			if d > tolerance {
+				differing++
+			}
+			largest = max(largest, d)
+		}
+	}
+	if differing == 0 {
+		return "", nil
+	}
+	return fmt.Sprintf("%d pixels differ, by up to %d", differing, largest), nil
+}
+
+func save(file string, img image.Image) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if err = png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func abs(a int) int {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
@@ -0,0 +1,342 @@
+// Package watermark stamps exported images with a watermark, a PNG picture or
+// a text, and with caption bars whose text is built from the image metadata.
+//
+// Sizes are relative to the image, so a watermark covers the same part of a
+// thumbnail and of the full resolution image.
+package watermark
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+
+	"github.com/nicky-ayoub/imagination/internal/pkg/exif"
+	"github.com/nicky-ayoub/imagination/internal/pkg/imageio"
+	"github.com/nicky-ayoub/imagination/internal/pkg/resize"
+)
+
+// Position is where the watermark is drawn.
+type Position int
+
+const (
+	POSITION_BOTTOM_RIGHT Position = iota
+	POSITION_BOTTOM_LEFT
+	POSITION_TOP_RIGHT
+	POSITION_TOP_LEFT
+	POSITION_CENTER
+	POSITIONS_COUNT
+)
+
+var positions = [POSITIONS_COUNT]string{"bottom-right", "bottom-left", "top-right", "top-left", "center"}
+
+func (p Position) String() string {
+	if p < 0 || p >= POSITIONS_COUNT {
+		return fmt.Sprintf("Position(%d)", int(p))
+	}
+	return positions[p]
+}
+
+// ParsePosition finds a position from its name: bottom-right, bottom-left, top-right, top-left or center.
+func ParsePosition(s string) (Position, error) {
+	for p := Position(0); p < POSITIONS_COUNT; p++ {
+		if strings.EqualFold(s, positions[p]) {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown watermark position %q (want bottom-right, bottom-left, top-right, top-left or center)", s)
+}
+
+// Mark is a watermark.
+type Mark struct {
+	Image    image.Image // Picture drawn, the Text is used when nil
+	Text     string
+	Position Position
+	Margin   float64     // Distance to the image edges, as a fraction of the shorter image edge
+	Opacity  float64     // From 0 (invisible) to 1 (opaque)
+	Size     float64     // Watermark width, as a fraction of the image width
+	Color    color.Color // Text color, white when nil
+}
+
+// Caption describes the bars added above and below images.
+type Caption struct {
+	Top        string  // Template of the bar above the image, no bar when empty
+	Bottom     string  // Template of the bar below the image, no bar when empty
+	Size       float64 // Bar height, as a fraction of the image height
+	Background color.Color
+	Foreground color.Color
+}
+
+// Stamp is what is added to exported images, either part being optional.
+type Stamp struct {
+	Mark    *Mark
+	Caption *Caption
+}
+
+// Settings are the stamp settings as written in configuration files and on
+// command lines. config.Watermark converts to it.
+type Settings struct {
+	Image         string  // PNG watermark file
+	Text          string  // Watermark text, used when there is no Image
+	Position      string  // bottom-right, bottom-left, top-right, top-left or center
+	Margin        float64 // Fraction of the shorter image edge
+	Opacity       float64 // From 0 to 1
+	Size          float64 // Fraction of the image width
+	Caption       string  // Template of the bar below the image
+	CaptionTop    string  // Template of the bar above the image
+	CaptionHeight float64 // Fraction of the image height
+}
+
+// New builds the stamp described by settings, nil when they ask for nothing.
+func New(s Settings) (*Stamp, error) {
+	stamp := &Stamp{}
+	if s.Image != "" || s.Text != "" {
+		position, err := ParsePosition(s.Position)
+		if err != nil {
+			return nil, err
+		}
+		stamp.Mark = &Mark{Text: s.Text, Position: position, Margin: s.Margin, Opacity: s.Opacity, Size: s.Size}
+		if s.Image != "" {
+			if stamp.Mark.Image, _, err = imageio.Load(s.Image); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if s.Caption != "" || s.CaptionTop != "" {
+		stamp.Caption = &Caption{Top: s.CaptionTop, Bottom: s.Caption, Size: s.CaptionHeight}
+	}
+	if stamp.Mark == nil && stamp.Caption == nil {
+		return nil, nil
+	}
+	return stamp, nil
+}
+
+// Apply returns img with the watermark drawn and the caption bars added.
+// fields are the values of the caption templates, see Fields.
+func (s *Stamp) Apply(img image.Image, fields map[string]string) image.Image {
+	if s == nil {
+		return img
+	}
+	if s.Mark != nil {
+		img = s.Mark.Draw(img)
+	}
+	if s.Caption != nil {
+		img = s.Caption.Draw(img, fields)
+	}
+	return img
+}
+
+// Draw returns a copy of img with the watermark.
+func (m *Mark) Draw(img image.Image) image.Image {
+	b := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+	if m.Opacity <= 0 || m.Size <= 0 || (m.Image == nil && m.Text == "") {
+		return dst
+	}
+	width := int(math.Round(m.Size * float64(b.Dx())))
+	margin := int(math.Round(m.Margin * float64(min(b.Dx(), b.Dy()))))
+	opacity := uint8(math.Round(math.Min(m.Opacity, 1) * 255))
+
+	if m.Image != nil {
+		mb := m.Image.Bounds()
+		height := max(1, width*mb.Dy()/max(1, mb.Dx()))
+		scaled := resize.Resize(m.Image, image.Pt(max(1, width), height), resize.CATMULL_ROM)
+		at := place(dst.Bounds(), image.Pt(max(1, width), height), m.Position, margin)
+		draw.DrawMask(dst, image.Rectangle{at, at.Add(scaled.Bounds().Size())}, scaled, scaled.Bounds().Min,
+			image.NewUniform(color.Alpha{opacity}), image.Point{}, draw.Over)
+		return dst
+	}
+
+	face := fitFace(m.Text, float64(width), math.Inf(1))
+	defer face.Close()
+	size := textSize(face, m.Text)
+	at := place(dst.Bounds(), size, m.Position, margin)
+	baseline := at.Y + face.Metrics().Ascent.Ceil()
+	// A soft shadow keeps the text readable on light and dark areas
+	shadow := max(1, size.Y/24)
+	drawText(dst, face, m.Text, image.Pt(at.X+shadow, baseline+shadow), color.NRGBA{0, 0, 0, opacity / 2})
+	r, g, bl, _ := colorOr(m.Color, color.White).RGBA()
+	drawText(dst, face, m.Text, image.Pt(at.X, baseline), color.NRGBA{uint8(r >> 8), uint8(g >> 8), uint8(bl >> 8), opacity})
+	return dst
+}
+
+// Draw returns img with the caption bars, the template fields replaced by their values.
+func (c *Caption) Draw(img image.Image, fields map[string]string) image.Image {
+	b := img.Bounds()
+	height := max(12, int(math.Round(c.Size*float64(b.Dy()))))
+	top, bottom := 0, 0
+	if c.Top != "" {
+		top = height
+	}
+	if c.Bottom != "" {
+		bottom = height
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()+top+bottom))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(colorOr(c.Background, color.Black)), image.Point{}, draw.Src)
+	draw.Draw(dst, image.Rect(0, top, b.Dx(), top+b.Dy()), img, b.Min, draw.Src)
+
+	bar := func(template string, y int) {
+		text := Expand(template, fields)
+		if text == "" {
+			return
+		}
+		padding := float64(height) * 0.4
+		face := fitFace(text, float64(b.Dx())-2*padding, float64(height)*0.55)
+		defer face.Close()
+		metrics := face.Metrics()
+		baseline := y + (height+metrics.Ascent.Ceil()-metrics.Descent.Ceil())/2
+		drawText(dst, face, text, image.Pt(int(padding), baseline), colorOr(c.Foreground, color.White))
+	}
+	if top > 0 {
+		bar(c.Top, 0)
+	}
+	if bottom > 0 {
+		bar(c.Bottom, top+b.Dy())
+	}
+	return dst
+}
+
+// place returns the top left corner of a mark of the given size.
+func place(bounds image.Rectangle, size image.Point, p Position, margin int) image.Point {
+	left, top := bounds.Min.X+margin, bounds.Min.Y+margin
+	right, bottom := bounds.Max.X-margin-size.X, bounds.Max.Y-margin-size.Y
+	switch p {
+	case POSITION_BOTTOM_LEFT:
+		return image.Pt(left, bottom)
+	case POSITION_TOP_RIGHT:
+		return image.Pt(right, top)
+	case POSITION_TOP_LEFT:
+		return image.Pt(left, top)
+	case POSITION_CENTER:
+		return image.Pt((bounds.Min.X+bounds.Max.X-size.X)/2, (bounds.Min.Y+bounds.Max.Y-size.Y)/2)
+	}
+	return image.Pt(right, bottom)
+}
+
+var (
+	regular     *opentype.Font
+	regularOnce sync.Once
+)
+
+// face returns the Go regular font at a size in pixels.
+func face(size float64) font.Face {
+	regularOnce.Do(func() {
+		var err error
+		if regular, err = opentype.Parse(goregular.TTF); err != nil {
+			panic(err)
+		}
+	})
+	f, err := opentype.NewFace(regular, &opentype.FaceOptions{Size: math.Max(size, 1), DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+// fitFace returns the largest face, up to maxSize pixels, drawing text at most width pixels wide.
+func fitFace(text string, width float64, maxSize float64) font.Face {
+	const reference = 100
+	f := face(reference)
+	advance := float64(font.MeasureString(f, text)) / 64
+	f.Close()
+	size := maxSize
+	if advance > 0 {
+		size = math.Min(size, reference*width/advance)
+	}
+	return face(size)
+}
+
+// textSize returns the size of the box holding text.
+func textSize(f font.Face, text string) image.Point {
+	m := f.Metrics()
+	return image.Pt(font.MeasureString(f, text).Ceil(), (m.Ascent + m.Descent).Ceil())
+}
+
+func drawText(dst draw.Image, f font.Face, text string, dot image.Point, c color.Color) {
+	d := font.Drawer{Dst: dst, Src: image.NewUniform(c), Face: f, Dot: fixed.P(dot.X, dot.Y)}
+	d.DrawString(text)
+}
+
+func colorOr(c color.Color, fallback color.Color) color.Color {
+	if c == nil {
+		return fallback
+	}
+	return c
+}
+
+var placeholder = regexp.MustCompile(`\{([a-z]+)\}`)
+
+/** Characters of the texts separating the fields of a template. */
+const SEPARATORS = " \t-—–|·,;:/"
+
+// Expand replaces the {field} placeholders of a template by their values.
+// Unknown and empty fields are removed along with a separator next to them,
+// so "{date} — {camera} — {lens}" becomes "2021-06-01 12:00 — 50mm" for
+// images without camera, and separators left at the ends are trimmed.
+func Expand(template string, fields map[string]string) string {
+	var parts []string
+	separator := func(text string) bool { return strings.Trim(text, SEPARATORS) == "" }
+	dropNext := false // An empty field kept the separator after it
+	at := 0
+	for _, loc := range placeholder.FindAllStringSubmatchIndex(template, -1) {
+		if text := template[at:loc[0]]; !dropNext || !separator(text) {
+			parts = append(parts, text)
+		}
+		dropNext, at = false, loc[1]
+		if value := fields[template[loc[2]:loc[3]]]; value != "" {
+			parts = append(parts, value)
+		} else if n := len(parts); n > 1 && separator(parts[n-1]) {
+			parts = parts[:n-1]
+		} else {
+			dropNext = true
+		}
+	}
+	if text := template[at:]; !dropNext || !separator(text) {
+		parts = append(parts, text)
+	}
+	return strings.Trim(strings.Join(parts, ""), SEPARATORS)
+}
+
+// Fields returns the values of the caption template fields for an image file:
+// {name}, {file}, {date}, {year}, {camera}, {lens}, {exposure}, {artist},
+// {copyright}, {description}, {width} and {height}, size being the size of
+// the stamped image.
+func Fields(path string, size image.Point) map[string]string {
+	base := filepath.Base(path)
+	fields := map[string]string{
+		"name":   strings.TrimSuffix(base, filepath.Ext(base)),
+		"file":   base,
+		"width":  strconv.Itoa(size.X),
+		"height": strconv.Itoa(size.Y),
+	}
+	e, err := exif.Read(path)
+	if err != nil {
+		return fields
+	}
+	if t, err := time.Parse(exif.DATE_LAYOUT, e.Date()); err == nil {
+		fields["date"] = t.Format("2006-01-02 15:04")
+		fields["year"] = strconv.Itoa(t.Year())
+	} else {
+		fields["date"] = e.Date()
+	}
+	fields["camera"] = e.Camera()
+	fields["lens"] = e.LensModel
+	fields["exposure"] = e.Exposure()
+	fields["artist"] = e.Artist
+	fields["copyright"] = e.Copyright
+	fields["description"] = e.Description
+	return fields
+}
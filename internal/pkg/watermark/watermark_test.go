@@ -0,0 +1,34 @@
+package watermark
+
+import "testing"
+
+func TestExpand(t *testing.T) {
+	fields := map[string]string{"date": "2021-06-01 12:00", "camera": "X100V", "lens": "23mm", "artist": "Ann", "year": "2021"}
+	for _, c := range []struct {
+		template string
+		missing  string
+		want     string
+	}{
+		{"{date} — {camera} — {lens}", "", "2021-06-01 12:00 — X100V — 23mm"},
+		{"{date} — {camera} — {lens}", "camera", "2021-06-01 12:00 — 23mm"},
+		{"{date} — {camera} — {lens}", "date", "X100V — 23mm"},
+		{"{date} — {camera} — {lens}", "lens", "2021-06-01 12:00 — X100V"},
+		{"{date} | {unknown} | {lens}", "", "2021-06-01 12:00 | 23mm"},
+		{"© {artist}, {year}", "artist", "© 2021"},
+		{"© {artist}, {year}", "year", "© Ann"},
+		{"{camera}{lens} / {year}", "lens", "X100V / 2021"},
+		{"Shot with {camera}", "", "Shot with X100V"},
+		{"{camera}", "camera", ""},
+		{"- {date} -", "", "2021-06-01 12:00"},
+	} {
+		values := map[string]string{}
+		for k, v := range fields {
+			if k != c.missing {
+				values[k] = v
+			}
+		}
+		if got := Expand(c.template, values); got != c.want {
+			t.Errorf("%q without %q: %q, want %q", c.template, c.missing, got, c.want)
+		}
+	}
+}
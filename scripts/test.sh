@@ -0,0 +1,11 @@
+#!/bin/sh
+# Vet and test every package. The viewport tests render golden images with
+# the SDL software renderer, so they need the SDL development library but no
+# display.
+#
+#	scripts/test.sh                                  compare with test/testdata/viewport
+#	go test ./internal/pkg/viewport -update          write the reference images again
+set -e
+cd "$(dirname "$0")/.."
+go vet ./...
+go test ./... "$@"
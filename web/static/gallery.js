@@ -0,0 +1,79 @@
+// Lightbox of the gallery pages: a click on a thumbnail shows the original
+// full screen. Left and Right (or Page Up and Page Down, or Space) move to
+// the previous and next images, Home and End to the first and last, Escape
+// closes.
+(function () {
+	"use strict";
+
+	var links = Array.prototype.slice.call(document.querySelectorAll(".grid a"));
+	var box = document.getElementById("lightbox");
+	if (!links.length || !box) {
+		return;
+	}
+	var img = box.querySelector("img");
+	var caption = box.querySelector(".caption");
+	var current = -1;
+
+	function show(i) {
+		current = (i + links.length) % links.length;
+		var link = links[current];
+		img.src = link.href;
+		img.alt = link.dataset.name;
+		caption.textContent = link.dataset.name + " (" + (current + 1) + " / " + links.length + ")";
+		box.hidden = false;
+		// Fetch the neighbours, so moving to them is immediate
+		[current - 1, current + 1].forEach(function (n) {
+			new Image().src = links[(n + links.length) % links.length].href;
+		});
+	}
+
+	function close() {
+		box.hidden = true;
+		img.removeAttribute("src");
+		links[current].focus();
+	}
+
+	links.forEach(function (link, i) {
+		link.addEventListener("click", function (event) {
+			if (event.ctrlKey || event.metaKey || event.shiftKey || event.button !== 0) {
+				return; // Let the browser open the original
+			}
+			event.preventDefault();
+			show(i);
+		});
+	});
+
+	box.querySelector(".previous").addEventListener("click", function () { show(current - 1); });
+	box.querySelector(".next").addEventListener("click", function () { show(current + 1); });
+	box.querySelector(".close").addEventListener("click", close);
+	img.addEventListener("click", function () { show(current + 1); });
+
+	document.addEventListener("keydown", function (event) {
+		if (box.hidden || event.altKey || event.ctrlKey || event.metaKey) {
+			return;
+		}
+		switch (event.key) {
+		case "ArrowLeft":
+		case "PageUp":
+			show(current - 1);
+			break;
+		case "ArrowRight":
+		case "PageDown":
+		case " ":
+			show(current + 1);
+			break;
+		case "Home":
+			show(0);
+			break;
+		case "End":
+			show(links.length - 1);
+			break;
+		case "Escape":
+			close();
+			break;
+		default:
+			return;
+		}
+		event.preventDefault();
+	});
+})();
@@ -0,0 +1,10 @@
+// Package web embeds the web assets of the suite: the server side templates
+// of web/template and the static files of web/static, served as they are.
+package web
+
+import "embed"
+
+// FS holds the template and static directories.
+//
+//go:embed template static
+var FS embed.FS